@@ -1,7 +1,16 @@
 // Package automation provides interfaces for cloud automation operations.
 package automation
 
-import "github.com/dirien/minectl-sdk/model"
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dirien/minectl-sdk/common"
+	"github.com/dirien/minectl-sdk/model"
+	"golang.org/x/crypto/ssh"
+)
 
 // Automation defines the interface for cloud provider operations.
 type Automation interface {
@@ -13,6 +22,46 @@ type Automation interface {
 	GetServer(id string, args ServerArgs) (*ResourceResults, error)
 }
 
+// ContextAutomation is the context-aware variant of Automation. Implementations
+// pass ctx to every cloud API call and stop polling as soon as ctx is done.
+type ContextAutomation interface {
+	CreateServerContext(ctx context.Context, args ServerArgs) (*ResourceResults, error)
+	DeleteServerContext(ctx context.Context, id string, args ServerArgs) error
+	ListServerContext(ctx context.Context) ([]ResourceResults, error)
+	UpdateServerContext(ctx context.Context, id string, args ServerArgs) error
+	UploadPluginContext(ctx context.Context, id string, args ServerArgs, plugin, destination string) error
+	GetServerContext(ctx context.Context, id string, args ServerArgs) (*ResourceResults, error)
+}
+
+// VolumeSnapshotter is implemented by providers that can snapshot the volume
+// holding the world of a server, the data volume created for
+// Spec.Server.VolumeSize. Snapshots are tagged with common.InstanceTag and
+// outlive DeleteServer. Set ServerArgs.VolumeSnapshotID to create a server
+// from one.
+type VolumeSnapshotter interface {
+	CreateVolumeSnapshot(ctx context.Context, id string, args ServerArgs) (*VolumeSnapshot, error)
+	ListVolumeSnapshots(ctx context.Context) ([]VolumeSnapshot, error)
+	DeleteVolumeSnapshot(ctx context.Context, snapshotID string) error
+}
+
+// VolumeSnapshotCopier is implemented by providers that can copy a volume
+// snapshot to another region, to clone a world into a server there.
+type VolumeSnapshotCopier interface {
+	CopyVolumeSnapshot(ctx context.Context, snapshotID, region string) (*VolumeSnapshot, error)
+}
+
+// VolumeSnapshot is a provider snapshot of the volume of a server.
+type VolumeSnapshot struct {
+	ID   string
+	Name string
+	// Server is the name of the server the volume belonged to.
+	Server    string
+	Region    string
+	SizeGB    int
+	CreatedAt time.Time
+	Labels    map[string]string
+}
+
 // Rcon represents RCON configuration for server management.
 type Rcon struct {
 	Password  string
@@ -26,13 +75,169 @@ type ServerArgs struct {
 	ID                string
 	MinecraftResource *model.MinecraftResource
 	SSHPrivateKeyPath string
+	// SSHPrivateKey is the PEM encoded private key, for keys that are not
+	// stored in a file like the ones held in a secrets manager. It is used
+	// instead of SSHPrivateKeyPath.
+	SSHPrivateKey []byte
+	// SSHPassphrase is called for the passphrase if the private key is
+	// encrypted.
+	SSHPassphrase PassphraseFunc
+	// SSHAgent authenticates with the keys of the ssh-agent listening on
+	// SSH_AUTH_SOCK, in addition to the private key if one is set.
+	SSHAgent bool
+	// SSHForwardAgent forwards the ssh-agent to the server, so commands run
+	// there can use the local keys. It implies SSHAgent.
+	SSHForwardAgent bool
+	// SSHHostKey is installed as the host key of a new server, so its
+	// identity is known before the first connection. See update.GenerateHostKey.
+	SSHHostKey *HostKey
+	// HostKeyCallback verifies the host key on every SSH connection to the
+	// server. If nil, the key is trusted on first use and checked against
+	// update.DefaultKnownHostsFile afterwards. See update.HostKeyCallback.
+	HostKeyCallback ssh.HostKeyCallback
+	// InsecureIgnoreHostKey turns off the verification of host keys when
+	// HostKeyCallback is nil. It is meant for tests and throwaway servers.
+	InsecureIgnoreHostKey bool
+	// VolumeSnapshotID creates the volume of a new server from this snapshot
+	// instead of an empty one, see VolumeSnapshotter. The snapshot must be in
+	// the region of the server.
+	VolumeSnapshotID string
+}
+
+// PassphraseFunc returns the passphrase of an encrypted private key, for
+// example by prompting the user.
+type PassphraseFunc func() ([]byte, error)
+
+// HostKey is a pre-generated ed25519 SSH host key.
+type HostKey struct {
+	// PrivateKey is the PEM encoded OpenSSH private key.
+	PrivateKey string
+	// PublicKey is the public key in authorized_keys format.
+	PublicKey string
 }
 
+// Status is the provider independent lifecycle state of a server.
+type Status string
+
+// Lifecycle states reported in ResourceResults.
+const (
+	StatusUnknown  Status = "unknown"
+	StatusPending  Status = "pending"
+	StatusRunning  Status = "running"
+	StatusStopping Status = "stopping"
+	StatusStopped  Status = "stopped"
+	StatusDeleting Status = "deleting"
+)
+
+// CPU architectures reported in ResourceResults.
+const (
+	ArchAMD64 = "amd64"
+	ArchARM64 = "arm64"
+)
+
 // ResourceResults contains the results of a server operation.
 type ResourceResults struct {
 	ID       string
 	Name     string
 	Region   string
 	PublicIP string
-	Tags     string
+	// Tags is the comma separated list of provider tags.
+	//
+	// Deprecated: the format differs between providers, use Labels instead.
+	Tags       string
+	Status     Status
+	PublicIPv6 string
+	PrivateIP  string
+	Size       string
+	Arch       string
+	CreatedAt  time.Time
+	VolumeIDs  []string
+	// Edition and Version are the Minecraft edition and version recorded in
+	// the labels of the server when it was created.
+	Edition string
+	Version string
+	Labels  map[string]string
+}
+
+// Label keys of the Minecraft settings recorded on new servers.
+const (
+	LabelEdition = "edition"
+	LabelVersion = "version"
+)
+
+// ServerTags returns the tags of a new server for the resource in args:
+// common.InstanceTag, the edition as a bare tag and the version as
+// version:<value>, see VersionLabelValue.
+func ServerTags(args ServerArgs) []string {
+	return []string{
+		common.InstanceTag,
+		args.MinecraftResource.GetEdition(),
+		LabelVersion + ":" + VersionLabelValue(args.MinecraftResource.GetVersion()),
+	}
+}
+
+// ServerLabels is ServerTags for providers with key/value labels.
+func ServerLabels(args ServerArgs) map[string]string {
+	return map[string]string{
+		common.InstanceTag:                  "true",
+		args.MinecraftResource.GetEdition(): "true",
+		LabelVersion:                        VersionLabelValue(args.MinecraftResource.GetVersion()),
+	}
+}
+
+// VersionLabelValue encodes a Minecraft version as the value of a tag or
+// label. Dots become underscores, as not every provider allows them.
+func VersionLabelValue(version string) string {
+	return strings.ReplaceAll(version, ".", "_")
+}
+
+// VersionFromLabels returns the Minecraft version recorded in labels.
+func VersionFromLabels(labels map[string]string) string {
+	return strings.ReplaceAll(labels[LabelVersion], "_", ".")
+}
+
+// LabelsFromTags converts a list of provider tags into labels. Tags in the
+// form key=value or key:value keep their value, all other tags get the value
+// "true".
+func LabelsFromTags(tags []string) map[string]string {
+	labels := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		key, value, ok := strings.Cut(tag, "=")
+		if !ok {
+			key, value, ok = strings.Cut(tag, ":")
+		}
+		if !ok {
+			value = "true"
+		}
+		labels[key] = value
+	}
+	return labels
+}
+
+// EditionFromLabels returns the Minecraft edition recorded in labels. Providers
+// store it either as an "edition" label or as a bare edition tag next to
+// common.InstanceTag.
+func EditionFromLabels(labels map[string]string) string {
+	if edition, ok := labels[LabelEdition]; ok {
+		return edition
+	}
+	keys := make([]string, 0, len(labels))
+	for key, value := range labels {
+		if key != common.InstanceTag && value == "true" {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	sort.Strings(keys)
+	return keys[0]
+}
+
+// ArchFromArgs returns the architecture requested in args.
+func ArchFromArgs(args ServerArgs) string {
+	if args.MinecraftResource.IsArm() {
+		return ArchARM64
+	}
+	return ArchAMD64
 }
@@ -0,0 +1,46 @@
+package automation
+
+import (
+	"testing"
+
+	"github.com/dirien/minectl-sdk/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLabelsFromTags(t *testing.T) {
+	assert.Equal(t, map[string]string{"minectl": "true", "java": "true", "edition": "bedrock", "version": "1_20_4"},
+		LabelsFromTags([]string{"minectl", "java", "edition=bedrock", "version:1_20_4"}))
+	assert.Empty(t, LabelsFromTags(nil))
+}
+
+func TestEditionFromLabels(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   string
+	}{
+		{name: "edition label", labels: map[string]string{"minectl": "true", "edition": "papermc"}, want: "papermc"},
+		{name: "bare tag", labels: map[string]string{"minectl": "true", "bedrock": "true"}, want: "bedrock"},
+		{name: "only instance tag", labels: map[string]string{"minectl": "true"}, want: ""},
+		{name: "nil", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, EditionFromLabels(tt.labels))
+		})
+	}
+}
+
+func TestServerTagsAndLabels(t *testing.T) {
+	args := ServerArgs{MinecraftResource: &model.MinecraftResource{Spec: model.Spec{
+		Minecraft: model.Minecraft{Edition: "forge", Version: "1.20.4-49.0.30"},
+	}}}
+	tags := ServerTags(args)
+	assert.Equal(t, []string{"minectl", "forge", "version:1_20_4-49_0_30"}, tags)
+	assert.Equal(t, ServerLabels(args), LabelsFromTags(tags))
+
+	labels := LabelsFromTags(tags)
+	assert.Equal(t, "forge", EditionFromLabels(labels))
+	assert.Equal(t, "1.20.4-49.0.30", VersionFromLabels(labels))
+	assert.Empty(t, VersionFromLabels(map[string]string{"minectl": "true"}))
+}
@@ -0,0 +1,360 @@
+// Package backup archives the /minecraft directory of a server into a Storage
+// and restores it from there.
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dirien/minectl-sdk/automation"
+	"github.com/dirien/minectl-sdk/update"
+	"go.uber.org/zap"
+)
+
+// DefaultDir is the directory of the Minecraft server that is backed up.
+const DefaultDir = "/minecraft"
+
+// snapshotTimeFormat is the timestamp in snapshot names.
+const snapshotTimeFormat = "20060102T150405Z"
+
+// Directories inside the server directory that Restore unpacks the archive
+// to and moves the old content aside to. They are on the same file system as
+// the world, which may be a mounted volume, so moving is cheap.
+const (
+	restoreDir = ".minectl-restore"
+	oldDir     = ".minectl-old"
+)
+
+// resumeTimeout bounds turning saving back on, which happens even if the
+// context of the backup is done.
+const resumeTimeout = 30 * time.Second
+
+// Snapshot is a backup of a server in a Storage.
+type Snapshot struct {
+	// Name is the name of the archive in the Storage, e.g.
+	// "my-server/20261016T120000Z.tar.gz".
+	Name      string
+	Server    string
+	CreatedAt time.Time
+	Size      int64
+}
+
+// Retention decides which snapshots of a server are kept. A snapshot is kept
+// if any rule keeps it. With all rules zero, every snapshot is kept.
+type Retention struct {
+	// KeepLast keeps the newest snapshots.
+	KeepLast int
+	// KeepDaily keeps the newest snapshot of each of the last days that have
+	// snapshots.
+	KeepDaily int
+}
+
+// Apply splits snapshots into the ones to keep and the ones to remove.
+func (r Retention) Apply(snapshots []Snapshot) (keep, remove []Snapshot) {
+	if r.KeepLast <= 0 && r.KeepDaily <= 0 {
+		return snapshots, nil
+	}
+	sorted := append([]Snapshot(nil), snapshots...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].CreatedAt.After(sorted[j].CreatedAt)
+	})
+	days := map[string]bool{}
+	for i, s := range sorted {
+		day := s.CreatedAt.UTC().Format("2006-01-02")
+		newestOfDay := !days[day] && len(days) < r.KeepDaily
+		if newestOfDay {
+			days[day] = true
+		}
+		if i < r.KeepLast || newestOfDay {
+			keep = append(keep, s)
+		} else {
+			remove = append(remove, s)
+		}
+	}
+	return keep, remove
+}
+
+// remote runs commands on a server, see update.RemoteServer.
+type remote interface {
+	Execute(ctx context.Context, cmd string, port int, opts update.ExecOptions) (*update.ExecResult, error)
+	Close() error
+}
+
+// rconClient sends console commands to a server, see update.RconClient.
+type rconClient interface {
+	Execute(ctx context.Context, command string) (string, error)
+	Close() error
+}
+
+// Manager backs up and restores the servers of a provider.
+type Manager struct {
+	automation automation.Automation
+	storage    Storage
+	// Retention prunes the snapshots of a server after each backup.
+	Retention Retention
+	// Login is the SSH user of the servers. If not set, the login of the
+	// provider is used, see update.LoginProvider, or update.RootLogin.
+	Login update.Login
+	// Dir is the directory that is backed up, DefaultDir if not set.
+	Dir string
+
+	connect func(args automation.ServerArgs, ip string, login update.Login) remote
+	rcon    func(address, password string) rconClient
+	now     func() time.Time
+}
+
+// NewManager creates a Manager for the servers of a provider that stores
+// the backups in storage.
+func NewManager(a automation.Automation, storage Storage) *Manager {
+	return &Manager{
+		automation: a,
+		storage:    storage,
+		connect: func(args automation.ServerArgs, ip string, login update.Login) remote {
+			return update.NewRemoteServerFromArgs(args, ip, login)
+		},
+		rcon: func(address, password string) rconClient {
+			return update.NewRconClient(address, password)
+		},
+		now: time.Now,
+	}
+}
+
+// Backup archives the directory of the server id and stores it as a new
+// snapshot. If RCON is enabled, saving is turned off and the world flushed to
+// disk during the backup, so the archive is consistent. Afterwards the
+// snapshots of the server are pruned according to Retention.
+func (m *Manager) Backup(ctx context.Context, id string, args automation.ServerArgs) (*Snapshot, error) {
+	server, err := m.getServer(ctx, id, args)
+	if err != nil {
+		return nil, err
+	}
+	remote := m.connect(args, server.PublicIP, m.login())
+	defer func() { _ = remote.Close() }()
+
+	resource := args.MinecraftResource
+	if resource.HasRCON() {
+		rcon := m.rcon(net.JoinHostPort(server.PublicIP, strconv.Itoa(resource.GetRCONPort())), resource.GetRCONPassword())
+		defer func() { _ = rcon.Close() }()
+		if err := pauseSaving(ctx, rcon); err != nil {
+			return nil, err
+		}
+		defer resumeSaving(rcon)
+	} else {
+		zap.S().Warnw("RCON is disabled, the backup may contain a world that is being written", "name", resource.GetName())
+	}
+
+	snapshot := &Snapshot{
+		Server:    resource.GetName(),
+		CreatedAt: m.now().UTC().Truncate(time.Second),
+	}
+	snapshot.Name = fmt.Sprintf("%s/%s.tar.gz", snapshot.Server, snapshot.CreatedAt.Format(snapshotTimeFormat))
+
+	reader, writer := io.Pipe()
+	counter := &countingReader{r: reader}
+	var stderr bytes.Buffer
+	tarCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tar := fmt.Sprintf("tar -C %s --exclude=./%s --exclude=./%s -czf - .", m.dir(), restoreDir, oldDir)
+		_, err := remote.Execute(tarCtx, tar, resource.GetSSHPort(), update.ExecOptions{
+			Stdout: writer,
+			Stderr: &stderr,
+			AsRoot: true,
+		})
+		if err != nil {
+			err = commandError(err, &stderr)
+		}
+		_ = writer.CloseWithError(err)
+	}()
+	err = m.storage.Put(ctx, snapshot.Name, counter)
+	// stop the command if the storage gave up, and wait for it before the
+	// connection is closed and saving turned on again
+	_ = reader.CloseWithError(io.ErrClosedPipe)
+	if err != nil {
+		cancel()
+	}
+	<-done
+	if err != nil {
+		return nil, fmt.Errorf("backing up %s: %w", snapshot.Server, err)
+	}
+	snapshot.Size = counter.n
+	zap.S().Infow("Minecraft server backed up", "name", snapshot.Server, "snapshot", snapshot.Name, "size", snapshot.Size)
+
+	if _, err := m.Prune(ctx, snapshot.Server); err != nil {
+		return snapshot, err
+	}
+	return snapshot, nil
+}
+
+// Restore replaces the directory of the server id with the content of
+// snapshot. The Minecraft server is stopped during the restore. The archive
+// is unpacked into the directory first, so a broken archive leaves the
+// server untouched. The old content is moved aside and only deleted once the
+// new one is in place, and moved back if the swap fails.
+func (m *Manager) Restore(ctx context.Context, id string, args automation.ServerArgs, snapshot string) error {
+	server, err := m.getServer(ctx, id, args)
+	if err != nil {
+		return err
+	}
+	archive, err := m.storage.Get(ctx, snapshot)
+	if err != nil {
+		return fmt.Errorf("restoring %s: %w", snapshot, err)
+	}
+	defer func() { _ = archive.Close() }()
+
+	remote := m.connect(args, server.PublicIP, m.login())
+	defer func() { _ = remote.Close() }()
+	port := args.MinecraftResource.GetSSHPort()
+
+	if err := run(ctx, remote, "systemctl stop minecraft.service", port, nil); err != nil {
+		return err
+	}
+	dir := m.dir()
+	staging := path.Join(dir, restoreDir)
+	old := path.Join(dir, oldDir)
+	// the content of dir, without the staging and the old directory
+	content := fmt.Sprintf("find %s -mindepth 1 -maxdepth 1 ! -name %s ! -name %s", dir, restoreDir, oldDir)
+	moveBack := fmt.Sprintf("find %s -mindepth 1 -maxdepth 1 -exec mv -t %s {} +", old, dir)
+	restore := strings.Join([]string{
+		fmt.Sprintf("rm -rf %s %s", staging, old),
+		fmt.Sprintf("mkdir -p %s %s", staging, old),
+		fmt.Sprintf("tar -C %s -xzf -", staging),
+		fmt.Sprintf("{ %s -exec mv -t %s {} + || { %s; false; }; }", content, old, moveBack),
+		fmt.Sprintf("{ find %s -mindepth 1 -maxdepth 1 -exec mv -t %s {} + || { %s -exec rm -rf {} +; %s; false; }; }", staging, dir, content, moveBack),
+		fmt.Sprintf("rm -rf %s %s", staging, old),
+	}, " && ")
+	err = run(ctx, remote, restore, port, archive)
+	// start the server again, even if the restore failed and the old world
+	// is still in place
+	if startErr := run(ctx, remote, "systemctl start minecraft.service", port, nil); startErr != nil {
+		err = errors.Join(err, startErr)
+	}
+	if err != nil {
+		return fmt.Errorf("restoring %s: %w", snapshot, err)
+	}
+	zap.S().Infow("Minecraft server restored", "name", args.MinecraftResource.GetName(), "snapshot", snapshot)
+	return nil
+}
+
+// List returns the snapshots of server, oldest first.
+func (m *Manager) List(ctx context.Context, server string) ([]Snapshot, error) {
+	objects, err := m.storage.List(ctx, server+"/")
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]Snapshot, 0, len(objects))
+	for _, o := range objects {
+		base := path.Base(o.Name)
+		if !strings.HasSuffix(base, ".tar.gz") {
+			continue
+		}
+		createdAt, err := time.Parse(snapshotTimeFormat, strings.TrimSuffix(base, ".tar.gz"))
+		if err != nil {
+			createdAt = o.ModTime
+		}
+		snapshots = append(snapshots, Snapshot{Name: o.Name, Server: server, CreatedAt: createdAt, Size: o.Size})
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.Before(snapshots[j].CreatedAt)
+	})
+	return snapshots, nil
+}
+
+// Prune deletes the snapshots of server that Retention does not keep and
+// returns them.
+func (m *Manager) Prune(ctx context.Context, server string) ([]Snapshot, error) {
+	snapshots, err := m.List(ctx, server)
+	if err != nil {
+		return nil, err
+	}
+	_, remove := m.Retention.Apply(snapshots)
+	for _, s := range remove {
+		if err := m.storage.Delete(ctx, s.Name); err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		zap.S().Infow("Backup pruned", "name", server, "snapshot", s.Name)
+	}
+	return remove, nil
+}
+
+func pauseSaving(ctx context.Context, rcon rconClient) error {
+	if _, err := rcon.Execute(ctx, "save-off"); err != nil {
+		return err
+	}
+	if _, err := rcon.Execute(ctx, "save-all flush"); err != nil {
+		resumeSaving(rcon)
+		return err
+	}
+	return nil
+}
+
+func resumeSaving(rcon rconClient) {
+	ctx, cancel := context.WithTimeout(context.Background(), resumeTimeout)
+	defer cancel()
+	if _, err := rcon.Execute(ctx, "save-on"); err != nil {
+		zap.S().Errorw("Turning saving back on failed, run save-on on the server console", "error", err)
+	}
+}
+
+func run(ctx context.Context, remote remote, cmd string, port int, stdin io.Reader) error {
+	var stderr bytes.Buffer
+	_, err := remote.Execute(ctx, cmd, port, update.ExecOptions{Stdin: stdin, Stderr: &stderr, AsRoot: true})
+	if err != nil {
+		return commandError(err, &stderr)
+	}
+	return nil
+}
+
+func commandError(err error, stderr *bytes.Buffer) error {
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return fmt.Errorf("%w: %s", err, msg)
+	}
+	return err
+}
+
+func (m *Manager) login() update.Login {
+	if m.Login.User != "" {
+		return m.Login
+	}
+	if p, ok := m.automation.(update.LoginProvider); ok {
+		return p.SSHLogin()
+	}
+	return update.RootLogin
+}
+
+func (m *Manager) dir() string {
+	if m.Dir == "" {
+		return DefaultDir
+	}
+	return m.Dir
+}
+
+func (m *Manager) getServer(ctx context.Context, id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
+	if a, ok := m.automation.(automation.ContextAutomation); ok {
+		return a.GetServerContext(ctx, id, args)
+	}
+	return m.automation.GetServer(id, args)
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,257 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dirien/minectl-sdk/automation"
+	"github.com/dirien/minectl-sdk/cloud/fake"
+	"github.com/dirien/minectl-sdk/model"
+	"github.com/dirien/minectl-sdk/update"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// localRemote runs commands with the local shell instead of over SSH.
+// systemctl commands are only recorded.
+type localRemote struct {
+	mu       sync.Mutex
+	commands []string
+}
+
+func (l *localRemote) Execute(ctx context.Context, cmd string, _ int, opts update.ExecOptions) (*update.ExecResult, error) {
+	l.mu.Lock()
+	l.commands = append(l.commands, cmd)
+	l.mu.Unlock()
+	if strings.HasPrefix(cmd, "systemctl ") {
+		return &update.ExecResult{}, nil
+	}
+	c := exec.CommandContext(ctx, "sh", "-c", cmd) //nolint:gosec // test commands
+	c.Stdin = opts.Stdin
+	c.Stdout = opts.Stdout
+	c.Stderr = opts.Stderr
+	var exitErr *exec.ExitError
+	if err := c.Run(); errors.As(err, &exitErr) {
+		return &update.ExecResult{ExitStatus: exitErr.ExitCode()}, &ssh.ExitError{}
+	} else if err != nil {
+		return nil, err
+	}
+	return &update.ExecResult{}, nil
+}
+
+func (l *localRemote) Close() error {
+	return nil
+}
+
+type fakeRcon struct {
+	mu       sync.Mutex
+	commands []string
+}
+
+func (f *fakeRcon) Execute(_ context.Context, command string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commands = append(f.commands, command)
+	return "", nil
+}
+
+func (f *fakeRcon) Close() error {
+	return nil
+}
+
+func newTestManager(t *testing.T, dir string) (*Manager, *localRemote, *fakeRcon, automation.ServerArgs, string) {
+	t.Helper()
+	f, err := fake.NewFake()
+	require.NoError(t, err)
+	keyFile := filepath.Join(t.TempDir(), "id_rsa.pub")
+	require.NoError(t, os.WriteFile(keyFile, []byte("ssh-rsa AAAA test"), 0o600))
+	args := automation.ServerArgs{MinecraftResource: &model.MinecraftResource{
+		Metadata: model.Metadata{Name: "minecraft-server"},
+		Spec: model.Spec{
+			Server: model.Server{Cloud: fake.ProviderFake, Region: "local", Size: "small", SSH: model.SSH{Port: 22, PublicKeyFile: keyFile}},
+			Minecraft: model.Minecraft{
+				Edition: "java",
+				Version: "1.20.4",
+				Java:    model.Java{Rcon: model.Rcon{Enabled: true, Port: 25575, Password: "secret"}},
+			},
+		},
+	}}
+	server, err := f.CreateServer(args)
+	require.NoError(t, err)
+
+	local := &localRemote{}
+	rcon := &fakeRcon{}
+	m := NewManager(f, NewLocalStorage(t.TempDir()))
+	m.Dir = dir
+	m.connect = func(automation.ServerArgs, string, update.Login) remote { return local }
+	m.rcon = func(string, string) rconClient { return rcon }
+	return m, local, rcon, args, server.ID
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "minecraft")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "world", "region"), 0o750))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "world", "level.dat"), []byte("v1"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".hidden"), []byte("kept"), 0o600))
+	m, remote, rcon, args, id := newTestManager(t, dir)
+	ctx := context.Background()
+
+	snapshot, err := m.Backup(ctx, id, args)
+	require.NoError(t, err)
+	assert.Equal(t, "minecraft-server", snapshot.Server)
+	assert.True(t, strings.HasPrefix(snapshot.Name, "minecraft-server/"))
+	assert.Positive(t, snapshot.Size)
+	assert.Equal(t, []string{"save-off", "save-all flush", "save-on"}, rcon.commands)
+
+	snapshots, err := m.List(ctx, "minecraft-server")
+	require.NoError(t, err)
+	require.Len(t, snapshots, 1)
+	assert.Equal(t, *snapshot, snapshots[0])
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "world", "level.dat"), []byte("v2"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "world", "griefed.dat"), []byte("x"), 0o600))
+	remote.commands = nil
+	require.NoError(t, m.Restore(ctx, id, args, snapshot.Name))
+
+	data, err := os.ReadFile(filepath.Join(dir, "world", "level.dat"))
+	require.NoError(t, err)
+	assert.Equal(t, "v1", string(data))
+	assert.NoFileExists(t, filepath.Join(dir, "world", "griefed.dat"))
+	assert.FileExists(t, filepath.Join(dir, ".hidden"))
+	assert.NoDirExists(t, filepath.Join(dir, restoreDir))
+	assert.NoDirExists(t, filepath.Join(dir, oldDir))
+	require.Len(t, remote.commands, 3)
+	assert.Equal(t, "systemctl stop minecraft.service", remote.commands[0])
+	assert.Equal(t, "systemctl start minecraft.service", remote.commands[2])
+
+	err = m.Restore(ctx, id, args, "minecraft-server/missing.tar.gz")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestRestoreBrokenArchiveKeepsWorld(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "minecraft")
+	require.NoError(t, os.MkdirAll(dir, 0o750))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "level.dat"), []byte("v1"), 0o600))
+	m, remote, _, args, id := newTestManager(t, dir)
+	ctx := context.Background()
+	require.NoError(t, m.storage.Put(ctx, "minecraft-server/broken.tar.gz", strings.NewReader("not a tarball")))
+
+	require.Error(t, m.Restore(ctx, id, args, "minecraft-server/broken.tar.gz"))
+	data, err := os.ReadFile(filepath.Join(dir, "level.dat"))
+	require.NoError(t, err)
+	assert.Equal(t, "v1", string(data))
+	// the server is started again
+	assert.Equal(t, "systemctl start minecraft.service", remote.commands[len(remote.commands)-1])
+}
+
+func TestRestoreFailedSwapKeepsWorld(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "minecraft")
+	require.NoError(t, os.MkdirAll(dir, 0o750))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "level.dat"), []byte("v1"), 0o600))
+	m, _, _, args, id := newTestManager(t, dir)
+	ctx := context.Background()
+	snapshot, err := m.Backup(ctx, id, args)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "level.dat"), []byte("v2"), 0o600))
+
+	// mv fails the first time it moves the unpacked archive into dir
+	mv, err := exec.LookPath("mv")
+	require.NoError(t, err)
+	bin := t.TempDir()
+	script := fmt.Sprintf("#!/bin/sh\nif [ \"$2\" = %q ] && [ ! -e %q ]; then touch %q; exit 1; fi\nexec %s \"$@\"\n",
+		dir, filepath.Join(bin, "failed"), filepath.Join(bin, "failed"), mv)
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "mv"), []byte(script), 0o700)) //nolint:gosec // test executable
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	require.Error(t, m.Restore(ctx, id, args, snapshot.Name))
+	assert.FileExists(t, filepath.Join(bin, "failed"))
+	data, err := os.ReadFile(filepath.Join(dir, "level.dat"))
+	require.NoError(t, err)
+	assert.Equal(t, "v2", string(data))
+}
+
+func TestBackupPrunes(t *testing.T) {
+	dir := t.TempDir()
+	m, _, _, args, id := newTestManager(t, dir)
+	m.Retention = Retention{KeepLast: 2}
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	m.now = func() time.Time {
+		now = now.Add(time.Hour)
+		return now
+	}
+	ctx := context.Background()
+	for range 4 {
+		_, err := m.Backup(ctx, id, args)
+		require.NoError(t, err)
+	}
+	snapshots, err := m.List(ctx, "minecraft-server")
+	require.NoError(t, err)
+	require.Len(t, snapshots, 2)
+	assert.Equal(t, "minecraft-server/20261016T150000Z.tar.gz", snapshots[0].Name)
+	assert.Equal(t, "minecraft-server/20261016T160000Z.tar.gz", snapshots[1].Name)
+}
+
+// ubuntuProvider is a provider whose servers are reached as ubuntu.
+type ubuntuProvider struct {
+	automation.Automation
+}
+
+func (ubuntuProvider) SSHLogin() update.Login {
+	return update.UbuntuLogin
+}
+
+func TestManagerLogin(t *testing.T) {
+	f, err := fake.NewFake()
+	require.NoError(t, err)
+	tests := []struct {
+		name     string
+		provider automation.Automation
+		login    update.Login
+		want     update.Login
+	}{
+		{name: "default", provider: f, want: update.RootLogin},
+		{name: "provider", provider: ubuntuProvider{f}, want: update.UbuntuLogin},
+		{name: "override", provider: ubuntuProvider{f}, login: update.Login{User: "admin", Sudo: true}, want: update.Login{User: "admin", Sudo: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager(tt.provider, NewLocalStorage(t.TempDir()))
+			m.Login = tt.login
+			assert.Equal(t, tt.want, m.login())
+		})
+	}
+}
+
+func TestRetention(t *testing.T) {
+	day := func(d, h int) Snapshot {
+		at := time.Date(2026, 10, d, h, 0, 0, 0, time.UTC)
+		return Snapshot{Name: at.Format(snapshotTimeFormat), CreatedAt: at}
+	}
+	snapshots := []Snapshot{day(10, 1), day(10, 9), day(11, 1), day(12, 1), day(12, 9), day(12, 18)}
+	tests := []struct {
+		name      string
+		retention Retention
+		keep      []Snapshot
+	}{
+		{name: "no rules keep everything", keep: snapshots},
+		{name: "last", retention: Retention{KeepLast: 2}, keep: []Snapshot{day(12, 18), day(12, 9)}},
+		{name: "daily", retention: Retention{KeepDaily: 2}, keep: []Snapshot{day(12, 18), day(11, 1)}},
+		{name: "last and daily", retention: Retention{KeepLast: 2, KeepDaily: 3}, keep: []Snapshot{day(12, 18), day(12, 9), day(11, 1), day(10, 9)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keep, remove := tt.retention.Apply(snapshots)
+			assert.Equal(t, tt.keep, keep)
+			assert.Len(t, remove, len(snapshots)-len(tt.keep))
+		})
+	}
+}
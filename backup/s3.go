@@ -0,0 +1,140 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// S3Config configures an S3Storage.
+type S3Config struct {
+	Bucket string
+	// Prefix is put in front of every object name, e.g. "minectl/".
+	Prefix string
+	Region string
+	// Endpoint is the URL of an S3 compatible service like MinIO. Empty means
+	// AWS S3.
+	Endpoint string
+	// AccessKeyID and SecretAccessKey are used if set, otherwise the
+	// credentials are read from the environment and the AWS config files.
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+// S3Storage is a Storage in a bucket of S3 or an S3 compatible service.
+type S3Storage struct {
+	client   *s3.Client
+	uploader *manager.Uploader
+	bucket   string
+	prefix   string
+}
+
+// NewS3Storage creates an S3Storage from cfg. Requests to a custom Endpoint
+// use path-style addressing, which MinIO and most S3 compatible services
+// expect.
+func NewS3Storage(ctx context.Context, cfg S3Config) (*S3Storage, error) {
+	var options []func(*config.LoadOptions) error
+	if cfg.Region != "" {
+		options = append(options, config.WithRegion(cfg.Region))
+	}
+	if cfg.AccessKeyID != "" {
+		options = append(options, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(cfg.AccessKeyID, cfg.SecretAccessKey, ""),
+		))
+	}
+	awsCfg, err := config.LoadDefaultConfig(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+			o.UsePathStyle = true
+			// not every S3 compatible service supports the checksums the SDK
+			// adds by default
+			o.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+			o.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+		}
+	})
+	return NewS3StorageFromClient(client, cfg.Bucket, cfg.Prefix), nil
+}
+
+// NewS3StorageFromClient creates an S3Storage that uses client.
+func NewS3StorageFromClient(client *s3.Client, bucket, prefix string) *S3Storage {
+	uploader := manager.NewUploader(client, func(u *manager.Uploader) {
+		u.RequestChecksumCalculation = client.Options().RequestChecksumCalculation
+	})
+	return &S3Storage{client: client, uploader: uploader, bucket: bucket, prefix: prefix}
+}
+
+// Put uploads the content of r as name. The content is streamed in parts of
+// manager.DefaultUploadPartSize, so archives of any size are uploaded
+// without buffering them on disk. Small archives take a single request.
+func (s *S3Storage) Put(ctx context.Context, name string, r io.Reader) error {
+	_, err := s.uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + name),
+		Body:   r,
+	})
+	return err
+}
+
+// Get downloads the object name.
+func (s *S3Storage) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + name),
+	})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	return out.Body, nil
+}
+
+// List returns the objects whose name starts with prefix.
+func (s *S3Storage) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.prefix + prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, s3Error(err)
+		}
+		for _, o := range page.Contents {
+			objects = append(objects, Object{
+				Name:    strings.TrimPrefix(aws.ToString(o.Key), s.prefix),
+				Size:    aws.ToInt64(o.Size),
+				ModTime: aws.ToTime(o.LastModified),
+			})
+		}
+	}
+	return objects, nil
+}
+
+// Delete removes the object name.
+func (s *S3Storage) Delete(ctx context.Context, name string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + name),
+	})
+	return s3Error(err)
+}
+
+func s3Error(err error) error {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && (apiErr.ErrorCode() == "NoSuchKey" || apiErr.ErrorCode() == "NotFound") {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,139 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by a Storage for objects that do not exist.
+var ErrNotFound = errors.New("backup: object not found")
+
+// Object is a file in a Storage.
+type Object struct {
+	// Name is the slash separated name of the object.
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage stores backup archives.
+type Storage interface {
+	// Put stores the content of r as name, replacing an existing object.
+	Put(ctx context.Context, name string, r io.Reader) error
+	// Get opens the object name. The caller must close it.
+	Get(ctx context.Context, name string) (io.ReadCloser, error)
+	// List returns the objects whose name starts with prefix, sorted by name.
+	List(ctx context.Context, prefix string) ([]Object, error)
+	// Delete removes the object name.
+	Delete(ctx context.Context, name string) error
+}
+
+// LocalStorage is a Storage in a local directory.
+type LocalStorage struct {
+	dir string
+}
+
+// NewLocalStorage creates a LocalStorage in dir. The directory is created on
+// the first Put.
+func NewLocalStorage(dir string) *LocalStorage {
+	return &LocalStorage{dir: dir}
+}
+
+func (l *LocalStorage) path(name string) string {
+	return filepath.Join(l.dir, filepath.FromSlash(name))
+}
+
+// Put stores the content of r as name. The object only appears once it is
+// complete.
+func (l *LocalStorage) Put(ctx context.Context, name string, r io.Reader) error {
+	path := l.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := io.Copy(tmp, contextReader{ctx: ctx, r: r}); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Get opens the object name.
+func (l *LocalStorage) Get(_ context.Context, name string) (io.ReadCloser, error) {
+	f, err := os.Open(l.path(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// List returns the objects whose name starts with prefix.
+func (l *LocalStorage) List(_ context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	err := filepath.WalkDir(l.dir, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// skip directories and unfinished uploads
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		rel, err := filepath.Rel(l.dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, Object{Name: name, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Name < objects[j].Name
+	})
+	return objects, err
+}
+
+// Delete removes the object name.
+func (l *LocalStorage) Delete(_ context.Context, name string) error {
+	err := os.Remove(l.path(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// contextReader stops reading once ctx is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,219 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3 is the subset of the S3 API the S3Storage uses, with path-style
+// addressing like MinIO.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	// uploads are the parts of the multipart uploads in progress.
+	uploads map[string]map[int][]byte
+	// parts counts the uploaded parts.
+	parts int
+}
+
+type s3Object struct {
+	Key          string `xml:"Key"`
+	Size         int64  `xml:"Size"`
+	LastModified string `xml:"LastModified"`
+}
+
+type listBucketResult struct {
+	XMLName  xml.Name   `xml:"ListBucketResult"`
+	Name     string     `xml:"Name"`
+	Prefix   string     `xml:"Prefix"`
+	KeyCount int        `xml:"KeyCount"`
+	Contents []s3Object `xml:"Contents"`
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+	Bucket  string   `xml:"Bucket"`
+	Key     string   `xml:"Key"`
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		uploadID = strconv.Itoa(len(f.uploads) + 1)
+		f.uploads[uploadID] = map[int][]byte{}
+		w.Header().Set("Content-Type", "application/xml")
+		_ = xml.NewEncoder(w).Encode(initiateMultipartUploadResult{Bucket: bucket, Key: key, UploadID: uploadID})
+	case r.Method == http.MethodPut && uploadID != "":
+		number, err := strconv.Atoi(query.Get("partNumber"))
+		data, readErr := io.ReadAll(r.Body)
+		if err != nil || readErr != nil || f.uploads[uploadID] == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.uploads[uploadID][number] = data
+		f.parts++
+		w.Header().Set("ETag", fmt.Sprintf(`"%d"`, number))
+	case r.Method == http.MethodPost && uploadID != "":
+		parts := f.uploads[uploadID]
+		var data []byte
+		for number := 1; number <= len(parts); number++ {
+			data = append(data, parts[number]...)
+		}
+		f.objects[key] = data
+		delete(f.uploads, uploadID)
+		w.Header().Set("Content-Type", "application/xml")
+		_ = xml.NewEncoder(w).Encode(completeMultipartUploadResult{Bucket: bucket, Key: key})
+	case r.Method == http.MethodDelete && uploadID != "":
+		delete(f.uploads, uploadID)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && key == "" && r.URL.Query().Get("list-type") == "2":
+		result := listBucketResult{Name: bucket, Prefix: r.URL.Query().Get("prefix")}
+		keys := make([]string, 0, len(f.objects))
+		for k := range f.objects {
+			if strings.HasPrefix(k, result.Prefix) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			result.Contents = append(result.Contents, s3Object{Key: k, Size: int64(len(f.objects[k])), LastModified: time.Now().UTC().Format(time.RFC3339)})
+		}
+		result.KeyCount = len(keys)
+		w.Header().Set("Content-Type", "application/xml")
+		_ = xml.NewEncoder(w).Encode(result)
+	case r.Method == http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = data
+	case r.Method == http.MethodGet:
+		data, ok := f.objects[key]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`)
+			return
+		}
+		_, _ = w.Write(data)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func newS3Storage(t *testing.T) (*S3Storage, *fakeS3) {
+	t.Helper()
+	// keep the AWS config of the machine out of the test
+	dir := t.TempDir()
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	fake := &fakeS3{objects: map[string][]byte{}, uploads: map[string]map[int][]byte{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	storage, err := NewS3Storage(context.Background(), S3Config{
+		Bucket:          "backups",
+		Prefix:          "minectl/",
+		Region:          "us-east-1",
+		Endpoint:        server.URL,
+		AccessKeyID:     "minioadmin",
+		SecretAccessKey: "minioadmin",
+	})
+	require.NoError(t, err)
+	return storage, fake
+}
+
+func TestStorage(t *testing.T) {
+	storages := map[string]func(t *testing.T) Storage{
+		"local": func(t *testing.T) Storage { return NewLocalStorage(t.TempDir()) },
+		"s3": func(t *testing.T) Storage {
+			storage, _ := newS3Storage(t)
+			return storage
+		},
+	}
+	for name, newStorage := range storages {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			storage := newStorage(t)
+
+			objects, err := storage.List(ctx, "")
+			require.NoError(t, err)
+			assert.Empty(t, objects)
+
+			// a reader that cannot seek, like the output of tar
+			require.NoError(t, storage.Put(ctx, "a/1.tar.gz", io.MultiReader(strings.NewReader("first"))))
+			require.NoError(t, storage.Put(ctx, "a/2.tar.gz", strings.NewReader("second")))
+			require.NoError(t, storage.Put(ctx, "b/1.tar.gz", strings.NewReader("other")))
+
+			objects, err = storage.List(ctx, "a/")
+			require.NoError(t, err)
+			require.Len(t, objects, 2)
+			assert.Equal(t, "a/1.tar.gz", objects[0].Name)
+			assert.Equal(t, int64(5), objects[0].Size)
+			assert.Equal(t, "a/2.tar.gz", objects[1].Name)
+
+			r, err := storage.Get(ctx, "a/2.tar.gz")
+			require.NoError(t, err)
+			data, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.NoError(t, r.Close())
+			assert.Equal(t, "second", string(data))
+
+			require.NoError(t, storage.Delete(ctx, "a/1.tar.gz"))
+			_, err = storage.Get(ctx, "a/1.tar.gz")
+			assert.ErrorIs(t, err, ErrNotFound)
+			objects, err = storage.List(ctx, "")
+			require.NoError(t, err)
+			assert.Len(t, objects, 2)
+		})
+	}
+}
+
+func TestS3StorageMultipart(t *testing.T) {
+	storage, fake := newS3Storage(t)
+	ctx := context.Background()
+
+	// larger than two parts, from a reader that cannot seek
+	content := bytes.Repeat([]byte("minecraft"), int(2*manager.DefaultUploadPartSize/9+1000))
+	require.NoError(t, storage.Put(ctx, "big.tar.gz", io.MultiReader(bytes.NewReader(content))))
+	assert.Equal(t, 3, fake.parts)
+	assert.Empty(t, fake.uploads)
+
+	r, err := storage.Get(ctx, "big.tar.gz")
+	require.NoError(t, err)
+	defer func() { _ = r.Close() }()
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+}
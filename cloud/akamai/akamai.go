@@ -5,7 +5,6 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -13,6 +12,7 @@ import (
 	"github.com/dirien/minectl-sdk/automation"
 	"github.com/dirien/minectl-sdk/cloud"
 	"github.com/dirien/minectl-sdk/common"
+	"github.com/dirien/minectl-sdk/model"
 	minctlTemplate "github.com/dirien/minectl-sdk/template"
 	"github.com/dirien/minectl-sdk/update"
 	"github.com/linode/linodego"
@@ -20,14 +20,30 @@ import (
 	"golang.org/x/oauth2"
 )
 
+// sshLogin is the user minectl logs in as on Akamai servers.
+var sshLogin = update.RootLogin
+
 // Akamai implements the Automation interface for Akamai cloud provider.
 type Akamai struct {
-	client linodego.Client
-	tmpl   *minctlTemplate.Template
+	client          linodego.Client
+	tmpl            *minctlTemplate.Template
+	templateOptions []minctlTemplate.Option
+}
+
+func init() {
+	cloud.Register(cloud.Provider{
+		Code: model.ProviderAkamai,
+		Credentials: []cloud.CredentialField{
+			{Name: cloud.CredentialToken, EnvVar: "LINODE_TOKEN", Description: "Akamai (Linode) API token", Required: true},
+		},
+		Factory: func(_ context.Context, creds cloud.Credentials, opts []minctlTemplate.Option) (automation.Automation, error) {
+			return NewAkamai(creds[cloud.CredentialToken], opts...)
+		},
+	})
 }
 
 // NewAkamai creates a new Akamai instance.
-func NewAkamai(apiToken string) (*Akamai, error) {
+func NewAkamai(apiToken string, opts ...minctlTemplate.Option) (*Akamai, error) {
 	tokenSource := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: apiToken})
 
 	oauth2Client := &http.Client{
@@ -37,25 +53,31 @@ func NewAkamai(apiToken string) (*Akamai, error) {
 	}
 
 	linodeClient := linodego.NewClient(oauth2Client)
-	tmpl, err := minctlTemplate.NewTemplateBash()
+	tmpl, err := minctlTemplate.NewTemplateBash(opts...)
 	if err != nil {
 		return nil, err
 	}
 	linode := &Akamai{
-		client: linodeClient,
-		tmpl:   tmpl,
+		client:          linodeClient,
+		tmpl:            tmpl,
+		templateOptions: opts,
 	}
 	return linode, nil
 }
 
 // CreateServer creates a new Minecraft server on Akamai.
 func (l *Akamai) CreateServer(args automation.ServerArgs) (*automation.ResourceResults, error) {
+	return l.CreateServerContext(context.Background(), args)
+}
+
+// CreateServerContext is like CreateServer but honors the cancellation and deadline of ctx.
+func (l *Akamai) CreateServerContext(ctx context.Context, args automation.ServerArgs) (*automation.ResourceResults, error) {
 	ubuntuImage := "linode/ubuntu22.04"
 	publicKey, err := cloud.GetSSHPublicKey(args)
 	if err != nil {
 		return nil, err
 	}
-	key, err := l.client.CreateSSHKey(context.Background(), linodego.SSHKeyCreateOptions{
+	key, err := l.client.CreateSSHKey(ctx, linodego.SSHKeyCreateOptions{
 		SSHKey: *publicKey,
 		Label:  fmt.Sprintf("%s-ssh", args.MinecraftResource.GetName()),
 	})
@@ -66,7 +88,7 @@ func (l *Akamai) CreateServer(args automation.ServerArgs) (*automation.ResourceR
 	var volume *linodego.Volume
 	var mount string
 	if args.MinecraftResource.GetVolumeSize() > 0 {
-		volume, err = l.client.CreateVolume(context.Background(), linodego.VolumeCreateOptions{
+		volume, err = l.client.CreateVolume(ctx, linodego.VolumeCreateOptions{
 			Label:  fmt.Sprintf("%s-vol", args.MinecraftResource.GetName()),
 			Size:   args.MinecraftResource.GetVolumeSize(),
 			Region: args.MinecraftResource.GetRegion(),
@@ -77,12 +99,12 @@ func (l *Akamai) CreateServer(args automation.ServerArgs) (*automation.ResourceR
 		return nil, err
 	}
 
-	userData, err := l.tmpl.GetTemplate(args.MinecraftResource, &minctlTemplate.CreateUpdateTemplateArgs{Mount: mount, Name: minctlTemplate.GetTemplateBashName(args.MinecraftResource.IsProxyServer())})
+	userData, err := l.tmpl.GetTemplateContext(ctx, args.MinecraftResource, &minctlTemplate.CreateUpdateTemplateArgs{Mount: mount, SSHHostKey: args.SSHHostKey, Name: minctlTemplate.GetTemplateBashName(args.MinecraftResource.IsProxyServer())})
 	if err != nil {
 		return nil, err
 	}
 
-	stackscript, err := l.client.CreateStackscript(context.Background(), linodego.StackscriptCreateOptions{
+	stackscript, err := l.client.CreateStackscript(ctx, linodego.StackscriptCreateOptions{
 		IsPublic: false,
 		Label:    fmt.Sprintf("%s-stackscript", args.MinecraftResource.GetName()),
 		Images:   []string{ubuntuImage},
@@ -95,7 +117,7 @@ func (l *Akamai) CreateServer(args automation.ServerArgs) (*automation.ResourceR
 	if err != nil {
 		return nil, err
 	}
-	instance, err := l.client.CreateInstance(context.Background(), linodego.InstanceCreateOptions{
+	instance, err := l.client.CreateInstance(ctx, linodego.InstanceCreateOptions{
 		Label:          args.MinecraftResource.GetName(),
 		Region:         args.MinecraftResource.GetRegion(),
 		Image:          ubuntuImage,
@@ -103,14 +125,14 @@ func (l *Akamai) CreateServer(args automation.ServerArgs) (*automation.ResourceR
 		AuthorizedKeys: []string{key.SSHKey},
 		StackScriptID:  stackscript.ID,
 		RootPass:       rootPassword,
-		Tags:           []string{common.InstanceTag, args.MinecraftResource.GetEdition()},
+		Tags:           automation.ServerTags(args),
 	})
 	if err != nil {
 		return nil, err
 	}
 
 	if args.MinecraftResource.GetVolumeSize() > 0 {
-		_, err = l.client.AttachVolume(context.Background(), volume.ID, &linodego.VolumeAttachOptions{
+		_, err = l.client.AttachVolume(ctx, volume.ID, &linodego.VolumeAttachOptions{
 			LinodeID: instance.ID,
 		})
 		if err != nil {
@@ -118,59 +140,70 @@ func (l *Akamai) CreateServer(args automation.ServerArgs) (*automation.ResourceR
 		}
 	}
 
-	_, err = l.client.WaitForInstanceStatus(context.Background(), instance.ID, linodego.InstanceRunning, 600)
+	instance, err = l.client.WaitForInstanceStatus(ctx, instance.ID, linodego.InstanceRunning, 600)
+	if err != nil {
+		return nil, err
+	}
+	result, err := l.instanceToResourceResults(ctx, instance)
 	if err != nil {
 		return nil, err
 	}
-	return &automation.ResourceResults{
-		ID:       strconv.Itoa(instance.ID),
-		Name:     instance.Label,
-		Region:   instance.Region,
-		PublicIP: instance.IPv4[0].String(),
-		Tags:     strings.Join(instance.Tags, ","),
-	}, err
+	return result, nil
+}
+
+// SSHLogin returns the user minectl logs in as on the servers, see
+// update.LoginProvider.
+func (l *Akamai) SSHLogin() update.Login {
+	return sshLogin
 }
 
 // DeleteServer deletes a Minecraft server on Akamai.
 func (l *Akamai) DeleteServer(id string, args automation.ServerArgs) error {
-	keys, err := l.client.ListSSHKeys(context.Background(), nil)
+	return l.DeleteServerContext(context.Background(), id, args)
+}
+
+// DeleteServerContext is like DeleteServer but honors the cancellation and deadline of ctx.
+func (l *Akamai) DeleteServerContext(ctx context.Context, id string, args automation.ServerArgs) error {
+	keys, err := l.client.ListSSHKeys(ctx, nil)
 	if err != nil {
 		return err
 	}
 	for _, key := range keys {
 		if key.Label == fmt.Sprintf("%s-ssh", args.MinecraftResource.GetName()) {
-			err := l.client.DeleteSSHKey(context.Background(), key.ID)
+			err := l.client.DeleteSSHKey(ctx, key.ID)
 			if err != nil {
 				return err
 			}
 		}
 	}
-	volumes, err := l.client.ListVolumes(context.Background(), nil)
+	volumes, err := l.client.ListVolumes(ctx, nil)
 	if err != nil {
 		return err
 	}
 	for _, volume := range volumes {
 		if volume.Label == fmt.Sprintf("%s-vol", args.MinecraftResource.GetName()) {
-			err := l.client.DetachVolume(context.Background(), volume.ID)
+			err := l.client.DetachVolume(ctx, volume.ID)
 			if err != nil {
 				return err
 			}
 			// wait 40 secs for detach volume, to be sure.
-			time.Sleep(40 * time.Second)
-			err = l.client.DeleteVolume(context.Background(), volume.ID)
+			if err := common.Sleep(ctx, 40*time.Second); err != nil {
+				return err
+			}
+			err = l.client.DeleteVolume(ctx, volume.ID)
 			if err != nil {
 				return err
 			}
 		}
 	}
 
-	stackscripts, err := l.client.ListStackscripts(context.Background(), &linodego.ListOptions{Filter: "{\"mine\":true}"})
+	stackscripts, err := l.client.ListStackscripts(ctx, &linodego.ListOptions{Filter: "{\"mine\":true}"})
 	if err != nil {
 		return err
 	}
 	for _, stackscript := range stackscripts {
 		if stackscript.Label == fmt.Sprintf("%s-stackscript", args.MinecraftResource.GetName()) {
-			err := l.client.DeleteStackscript(context.Background(), stackscript.ID)
+			err := l.client.DeleteStackscript(ctx, stackscript.ID)
 			if err != nil {
 				return err
 			}
@@ -180,42 +213,105 @@ func (l *Akamai) DeleteServer(id string, args automation.ServerArgs) error {
 	if err != nil {
 		return err
 	}
-	err = l.client.DeleteInstance(context.Background(), intID)
+	err = l.client.DeleteInstance(ctx, intID)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+func akamaiStatus(status linodego.InstanceStatus) automation.Status {
+	switch status {
+	case linodego.InstanceProvisioning, linodego.InstanceBooting, linodego.InstanceRebooting, linodego.InstanceMigrating,
+		linodego.InstanceRebuilding, linodego.InstanceCloning, linodego.InstanceRestoring, linodego.InstanceResizing:
+		return automation.StatusPending
+	case linodego.InstanceRunning:
+		return automation.StatusRunning
+	case linodego.InstanceShuttingDown:
+		return automation.StatusStopping
+	case linodego.InstanceOffline:
+		return automation.StatusStopped
+	case linodego.InstanceDeleting:
+		return automation.StatusDeleting
+	default:
+		return automation.StatusUnknown
+	}
+}
+
+func (l *Akamai) instanceToResourceResults(ctx context.Context, instance *linodego.Instance) (*automation.ResourceResults, error) {
+	labels := automation.LabelsFromTags(instance.Tags)
+	result := &automation.ResourceResults{
+		ID:         strconv.Itoa(instance.ID),
+		Name:       instance.Label,
+		Region:     instance.Region,
+		PublicIP:   instance.IPv4[0].String(),
+		Tags:       strings.Join(instance.Tags, ","),
+		Status:     akamaiStatus(instance.Status),
+		PublicIPv6: strings.Split(instance.IPv6, "/")[0],
+		Size:       instance.Type,
+		Arch:       automation.ArchAMD64,
+		Labels:     labels,
+		Edition:    automation.EditionFromLabels(labels),
+		Version:    automation.VersionFromLabels(labels),
+	}
+	for _, ip := range instance.IPv4 {
+		if ip.IsPrivate() {
+			result.PrivateIP = ip.String()
+			break
+		}
+	}
+	if instance.Created != nil {
+		result.CreatedAt = *instance.Created
+	}
+	volumes, err := l.client.ListInstanceVolumes(ctx, instance.ID, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, volume := range volumes {
+		result.VolumeIDs = append(result.VolumeIDs, strconv.Itoa(volume.ID))
+	}
+	return result, nil
+}
+
 // ListServer lists all Minecraft servers on Akamai.
 func (l *Akamai) ListServer() ([]automation.ResourceResults, error) {
-	servers, err := l.client.ListInstances(context.Background(), linodego.NewListOptions(0, "{\"tags\":\"minectl\"}"))
+	return l.ListServerContext(context.Background())
+}
+
+// ListServerContext is like ListServer but honors the cancellation and deadline of ctx.
+func (l *Akamai) ListServerContext(ctx context.Context) ([]automation.ResourceResults, error) {
+	servers, err := l.client.ListInstances(ctx, linodego.NewListOptions(0, "{\"tags\":\"minectl\"}"))
 	if err != nil {
 		return nil, err
 	}
 	var result []automation.ResourceResults
 	for _, server := range servers {
-		result = append(result, automation.ResourceResults{
-			ID:       strconv.Itoa(server.ID),
-			Name:     server.Label,
-			Region:   server.Region,
-			PublicIP: server.IPv4[0].String(),
-			Tags:     strings.Join(server.Tags, ","),
-		})
+		server := server
+		instance, err := l.instanceToResourceResults(ctx, &server)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *instance)
 	}
 	return result, nil
 }
 
 // UpdateServer updates a Minecraft server on Akamai.
 func (l *Akamai) UpdateServer(id string, args automation.ServerArgs) error {
+	return l.UpdateServerContext(context.Background(), id, args)
+}
+
+// UpdateServerContext is like UpdateServer but honors the cancellation and deadline of ctx.
+func (l *Akamai) UpdateServerContext(ctx context.Context, id string, args automation.ServerArgs) error {
 	intID, _ := strconv.Atoi(id)
-	instance, err := l.client.GetInstance(context.Background(), intID)
+	instance, err := l.client.GetInstance(ctx, intID)
 	if err != nil {
 		return err
 	}
 
-	remoteCommand := update.NewRemoteServer(args.SSHPrivateKeyPath, instance.IPv4[0].String(), "root")
-	err = remoteCommand.UpdateServer(args.MinecraftResource)
+	remoteCommand := update.NewRemoteServerFromArgs(args, instance.IPv4[0].String(), sshLogin).WithTemplateOptions(l.templateOptions...)
+	defer func() { _ = remoteCommand.Close() }()
+	_, err = remoteCommand.UpdateServerStream(ctx, args.MinecraftResource, update.ExecOptions{})
 	if err != nil {
 		return err
 	}
@@ -224,18 +320,20 @@ func (l *Akamai) UpdateServer(id string, args automation.ServerArgs) error {
 
 // UploadPlugin uploads a plugin to a Minecraft server on Akamai.
 func (l *Akamai) UploadPlugin(id string, args automation.ServerArgs, plugin, destination string) error {
+	return l.UploadPluginContext(context.Background(), id, args, plugin, destination)
+}
+
+// UploadPluginContext is like UploadPlugin but honors the cancellation and deadline of ctx.
+func (l *Akamai) UploadPluginContext(ctx context.Context, id string, args automation.ServerArgs, plugin, destination string) error {
 	intID, _ := strconv.Atoi(id)
-	instance, err := l.client.GetInstance(context.Background(), intID)
+	instance, err := l.client.GetInstance(ctx, intID)
 	if err != nil {
 		return err
 	}
 
-	remoteCommand := update.NewRemoteServer(args.SSHPrivateKeyPath, instance.IPv4[0].String(), "root")
-	err = remoteCommand.TransferFile(plugin, filepath.Join(destination, filepath.Base(plugin)), args.MinecraftResource.GetSSHPort())
-	if err != nil {
-		return err
-	}
-	_, err = remoteCommand.ExecuteCommand("systemctl restart minecraft.service", args.MinecraftResource.GetSSHPort())
+	remoteCommand := update.NewRemoteServerFromArgs(args, instance.IPv4[0].String(), sshLogin)
+	defer func() { _ = remoteCommand.Close() }()
+	err = remoteCommand.UploadPluginContext(ctx, plugin, destination, args.MinecraftResource.GetSSHPort())
 	if err != nil {
 		return err
 	}
@@ -243,17 +341,20 @@ func (l *Akamai) UploadPlugin(id string, args automation.ServerArgs, plugin, des
 }
 
 // GetServer gets a Minecraft server on Akamai.
-func (l *Akamai) GetServer(id string, _ automation.ServerArgs) (*automation.ResourceResults, error) {
+func (l *Akamai) GetServer(id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
+	return l.GetServerContext(context.Background(), id, args)
+}
+
+// GetServerContext is like GetServer but honors the cancellation and deadline of ctx.
+func (l *Akamai) GetServerContext(ctx context.Context, id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
 	intID, _ := strconv.Atoi(id)
-	instance, err := l.client.GetInstance(context.Background(), intID)
+	instance, err := l.client.GetInstance(ctx, intID)
+	if err != nil {
+		return nil, err
+	}
+	result, err := l.instanceToResourceResults(ctx, instance)
 	if err != nil {
 		return nil, err
 	}
-	return &automation.ResourceResults{
-		ID:       strconv.Itoa(instance.ID),
-		Name:     instance.Label,
-		Region:   instance.Region,
-		PublicIP: instance.IPv4[0].String(),
-		Tags:     strings.Join(instance.Tags, ","),
-	}, err
+	return result, nil
 }
@@ -0,0 +1,24 @@
+// Package all registers every built-in cloud provider with the cloud package.
+// Import it for its side effects to make all providers available to cloud.New:
+//
+//	import _ "github.com/dirien/minectl-sdk/cloud/all"
+package all
+
+import (
+	// register the built-in providers
+	_ "github.com/dirien/minectl-sdk/cloud/akamai"
+	_ "github.com/dirien/minectl-sdk/cloud/aws"
+	_ "github.com/dirien/minectl-sdk/cloud/azure"
+	_ "github.com/dirien/minectl-sdk/cloud/civo"
+	_ "github.com/dirien/minectl-sdk/cloud/do"
+	_ "github.com/dirien/minectl-sdk/cloud/exoscale"
+	_ "github.com/dirien/minectl-sdk/cloud/fuga"
+	_ "github.com/dirien/minectl-sdk/cloud/gce"
+	_ "github.com/dirien/minectl-sdk/cloud/hetzner"
+	_ "github.com/dirien/minectl-sdk/cloud/multipass"
+	_ "github.com/dirien/minectl-sdk/cloud/oci"
+	_ "github.com/dirien/minectl-sdk/cloud/ovh"
+	_ "github.com/dirien/minectl-sdk/cloud/scaleway"
+	_ "github.com/dirien/minectl-sdk/cloud/vexxhost"
+	_ "github.com/dirien/minectl-sdk/cloud/vultr"
+)
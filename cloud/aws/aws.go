@@ -5,7 +5,6 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
-	"path/filepath"
 	"sort"
 	"strings"
 	"time"
@@ -17,6 +16,7 @@ import (
 	"github.com/dirien/minectl-sdk/automation"
 	"github.com/dirien/minectl-sdk/cloud"
 	"github.com/dirien/minectl-sdk/common"
+	"github.com/dirien/minectl-sdk/model"
 	minctlTemplate "github.com/dirien/minectl-sdk/template"
 	"github.com/dirien/minectl-sdk/update"
 	"github.com/google/uuid"
@@ -24,17 +24,33 @@ import (
 	"go.uber.org/zap"
 )
 
+// sshLogin is the user minectl logs in as on AWS servers.
+var sshLogin = update.UbuntuLogin
+
 const instanceNameTag = "Name"
 
 // Aws implements the Automation interface for AWS.
 type Aws struct {
-	client *ec2.Client
-	tmpl   *minctlTemplate.Template
-	region string
+	client          *ec2.Client
+	tmpl            *minctlTemplate.Template
+	templateOptions []minctlTemplate.Option
+	region          string
+}
+
+func init() {
+	cloud.Register(cloud.Provider{
+		Code: model.ProviderAws,
+		Credentials: []cloud.CredentialField{
+			{Name: cloud.CredentialRegion, EnvVar: "AWS_REGION", Description: "AWS region", Required: true},
+		},
+		Factory: func(_ context.Context, creds cloud.Credentials, opts []minctlTemplate.Option) (automation.Automation, error) {
+			return NewAWS(creds[cloud.CredentialRegion], opts...)
+		},
+	})
 }
 
 // NewAWS creates an Aws and initialises an EC2 client
-func NewAWS(region string) (*Aws, error) {
+func NewAWS(region string, opts ...minctlTemplate.Option) (*Aws, error) {
 	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
 	if err != nil {
 		return nil, err
@@ -46,21 +62,91 @@ func NewAWS(region string) (*Aws, error) {
 
 	ec2Svc := ec2.NewFromConfig(cfg)
 
-	tmpl, err := minctlTemplate.NewTemplateCloudConfig()
+	tmpl, err := minctlTemplate.NewTemplateCloudConfig(opts...)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Aws{
-		client: ec2Svc,
-		region: region,
-		tmpl:   tmpl,
+		client:          ec2Svc,
+		region:          region,
+		tmpl:            tmpl,
+		templateOptions: opts,
 	}, err
 }
 
+func awsStatus(state *types.InstanceState) automation.Status {
+	if state == nil {
+		return automation.StatusUnknown
+	}
+	switch state.Name {
+	case types.InstanceStateNamePending:
+		return automation.StatusPending
+	case types.InstanceStateNameRunning:
+		return automation.StatusRunning
+	case types.InstanceStateNameStopping:
+		return automation.StatusStopping
+	case types.InstanceStateNameStopped:
+		return automation.StatusStopped
+	case types.InstanceStateNameShuttingDown, types.InstanceStateNameTerminated:
+		return automation.StatusDeleting
+	default:
+		return automation.StatusUnknown
+	}
+}
+
+func awsInstanceToResourceResults(region string, i types.Instance) *automation.ResourceResults {
+	var tags []string
+	labels := map[string]string{}
+	for _, v := range i.Tags {
+		tags = append(tags, fmt.Sprintf("%s=%s", *v.Key, *v.Value))
+		labels[*v.Key] = *v.Value
+	}
+	result := &automation.ResourceResults{
+		ID:      *i.InstanceId,
+		Name:    labels[instanceNameTag],
+		Region:  region,
+		Tags:    strings.Join(tags, ","),
+		Status:  awsStatus(i.State),
+		Size:    string(i.InstanceType),
+		Arch:    automation.ArchAMD64,
+		Labels:  labels,
+		Edition: automation.EditionFromLabels(labels),
+		Version: automation.VersionFromLabels(labels),
+	}
+	if i.Architecture == types.ArchitectureValuesArm64 {
+		result.Arch = automation.ArchARM64
+	}
+	if i.PublicIpAddress != nil {
+		result.PublicIP = *i.PublicIpAddress
+	}
+	if i.PrivateIpAddress != nil {
+		result.PrivateIP = *i.PrivateIpAddress
+	}
+	if i.Ipv6Address != nil {
+		result.PublicIPv6 = *i.Ipv6Address
+	}
+	if i.LaunchTime != nil {
+		result.CreatedAt = *i.LaunchTime
+	}
+	for _, mapping := range i.BlockDeviceMappings {
+		if i.RootDeviceName != nil && mapping.DeviceName != nil && *mapping.DeviceName == *i.RootDeviceName {
+			continue
+		}
+		if mapping.Ebs != nil && mapping.Ebs.VolumeId != nil {
+			result.VolumeIDs = append(result.VolumeIDs, *mapping.Ebs.VolumeId)
+		}
+	}
+	return result
+}
+
 // ListServer lists all Minecraft servers on AWS.
 func (a *Aws) ListServer() ([]automation.ResourceResults, error) {
-	ctx := context.TODO()
+	return a.ListServerContext(context.Background())
+}
+
+// ListServerContext is like ListServer but honors the cancellation and deadline of ctx.
+func (a *Aws) ListServerContext(ctx context.Context) ([]automation.ResourceResults, error) {
 	var result []automation.ResourceResults
 	var nextToken *string
 
@@ -83,28 +169,7 @@ func (a *Aws) ListServer() ([]automation.ResourceResults, error) {
 		for _, r := range instances.Reservations {
 			for _, i := range r.Instances {
 				if i.State.Name != types.InstanceStateNameTerminated {
-					arr := automation.ResourceResults{
-						ID:     *i.InstanceId,
-						Region: a.region,
-					}
-
-					if i.PublicIpAddress != nil {
-						arr.PublicIP = *i.PublicIpAddress
-					}
-
-					var tags []string
-					var instanceName string
-					for _, v := range i.Tags {
-						tags = append(tags, fmt.Sprintf("%s=%s", *v.Key, *v.Value))
-						if *v.Key == instanceNameTag {
-							instanceName = *v.Value
-						}
-					}
-
-					arr.Tags = strings.Join(tags, ",")
-					arr.Name = instanceName
-
-					result = append(result, arr)
+					result = append(result, *awsInstanceToResourceResults(a.region, i))
 				}
 			}
 		}
@@ -136,7 +201,7 @@ func (a *Aws) addNetworkInterfaces(ctx context.Context, vpc *ec2.CreateVpcOutput
 	var secGroups []string
 	var groupID *string
 	var err error
-	if args.MinecraftResource.GetEdition() == "bedrock" || args.MinecraftResource.GetEdition() == "nukkit" || args.MinecraftResource.GetEdition() == "powernukkit" {
+	if args.MinecraftResource.GetProtocol() == model.ProtocolUDP {
 		groupID, err = a.createEC2SecurityGroup(ctx, vpc.Vpc.VpcId, "udp", args.MinecraftResource.GetPort())
 		if err != nil {
 			return nil, err
@@ -182,9 +247,13 @@ func (a *Aws) addNetworkInterfaces(ctx context.Context, vpc *ec2.CreateVpcOutput
 func addTags(args automation.ServerArgs) []types.Tag {
 	return []types.Tag{
 		{
-			Key:   aws.String("edition"),
+			Key:   aws.String(automation.LabelEdition),
 			Value: aws.String(args.MinecraftResource.GetEdition()),
 		},
+		{
+			Key:   aws.String(automation.LabelVersion),
+			Value: aws.String(automation.VersionLabelValue(args.MinecraftResource.GetVersion())),
+		},
 		{
 			Key:   aws.String(instanceNameTag),
 			Value: aws.String(args.MinecraftResource.GetName()),
@@ -205,9 +274,16 @@ func addTagSpecifications(args automation.ServerArgs, resourceType types.Resourc
 	}
 }
 
-// CreateServer TODO: https://github.com/dirien/minectl/issues/298
-func (a *Aws) CreateServer(args automation.ServerArgs) (*automation.ResourceResults, error) { //nolint: gocyclo
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+// CreateServer creates a new Minecraft server on AWS.
+func (a *Aws) CreateServer(args automation.ServerArgs) (*automation.ResourceResults, error) {
+	return a.CreateServerContext(context.Background(), args)
+}
+
+// CreateServerContext is like CreateServer but honors the cancellation and deadline of ctx.
+// Creation is additionally capped at 30 minutes.
+// TODO: https://github.com/dirien/minectl/issues/298
+func (a *Aws) CreateServerContext(ctx context.Context, args automation.ServerArgs) (*automation.ResourceResults, error) { //nolint: gocyclo
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Minute)
 	defer cancel()
 
 	publicKey, err := cloud.GetSSHPublicKey(args)
@@ -216,7 +292,22 @@ func (a *Aws) CreateServer(args automation.ServerArgs) (*automation.ResourceResu
 	}
 
 	var imageAMI *string
-	if args.MinecraftResource.IsArm() {
+	volumeSize := args.MinecraftResource.GetVolumeSize()
+	if args.VolumeSnapshotID != "" {
+		var snapshotSize int
+		imageAMI, snapshotSize, err = a.registerSnapshotImage(ctx, args)
+		if err != nil {
+			return nil, err
+		}
+		// the image is only needed to launch the instance
+		defer func() {
+			_, err := a.client.DeregisterImage(context.WithoutCancel(ctx), &ec2.DeregisterImageInput{ImageId: imageAMI})
+			if err != nil {
+				zap.S().Warnw("Deregistering the image of the volume snapshot failed", "image", aws.ToString(imageAMI), "error", err)
+			}
+		}()
+		volumeSize = max(volumeSize, snapshotSize)
+	} else if args.MinecraftResource.IsArm() {
 		imageAMI, err = a.lookupAMI(ctx, "ubuntu-minimal/images/hvm-ssd/ubuntu-jammy-22.04*", "arm64")
 		if err != nil {
 			return nil, err
@@ -291,7 +382,7 @@ func (a *Aws) CreateServer(args automation.ServerArgs) (*automation.ResourceResu
 		return nil, err
 	}
 
-	userData, err := a.tmpl.GetTemplate(args.MinecraftResource, &minctlTemplate.CreateUpdateTemplateArgs{Name: minctlTemplate.GetTemplateCloudConfigName(args.MinecraftResource.IsProxyServer())})
+	userData, err := a.tmpl.GetTemplateContext(ctx, args.MinecraftResource, &minctlTemplate.CreateUpdateTemplateArgs{SSHHostKey: args.SSHHostKey, Name: minctlTemplate.GetTemplateCloudConfigName(args.MinecraftResource.IsProxyServer())})
 	if err != nil {
 		return nil, err
 	}
@@ -304,7 +395,7 @@ func (a *Aws) CreateServer(args automation.ServerArgs) (*automation.ResourceResu
 				ImageId:             imageAMI,
 				KeyName:             key.KeyName,
 				InstanceType:        types.InstanceType(args.MinecraftResource.GetSize()),
-				BlockDeviceMappings: addBlockDevice(args.MinecraftResource.GetVolumeSize()),
+				BlockDeviceMappings: addBlockDevice(volumeSize),
 				UserData:            aws.String(base64.StdEncoding.EncodeToString([]byte(userData))),
 			},
 			TagSpecifications: addTagSpecifications(args, types.ResourceTypeSpotInstancesRequest),
@@ -322,7 +413,7 @@ func (a *Aws) CreateServer(args automation.ServerArgs) (*automation.ResourceResu
 		for {
 			select {
 			case <-ctx.Done():
-				return nil, errors.New("timed out while creating the aws instance")
+				return nil, errors.Wrap(ctx.Err(), "aborted while creating the aws instance")
 			case <-time.After(10 * time.Second):
 				spotInstanceRequests, err := a.client.DescribeSpotInstanceRequests(ctx, &ec2.DescribeSpotInstanceRequestsInput{
 					SpotInstanceRequestIds: []string{*result.SpotInstanceRequests[0].SpotInstanceRequestId},
@@ -350,23 +441,9 @@ func (a *Aws) CreateServer(args automation.ServerArgs) (*automation.ResourceResu
 					if err != nil {
 						return nil, err
 					}
-					var tags []string
-					var instanceName string
-					for _, v := range i.Reservations[0].Instances[0].Tags {
-						tags = append(tags, fmt.Sprintf("%s=%s", *v.Key, *v.Value))
-
-						if *v.Key == instanceNameTag {
-							instanceName = *v.Value
-						}
-					}
-
-					return &automation.ResourceResults{
-						ID:       fmt.Sprintf("%s#%s", *i.Reservations[0].Instances[0].InstanceId, *result.SpotInstanceRequests[0].SpotInstanceRequestId),
-						Name:     instanceName,
-						Region:   a.region,
-						PublicIP: *i.Reservations[0].Instances[0].PublicIpAddress,
-						Tags:     strings.Join(tags, ","),
-					}, nil
+					instance := awsInstanceToResourceResults(a.region, i.Reservations[0].Instances[0])
+					instance.ID = fmt.Sprintf("%s#%s", instance.ID, *result.SpotInstanceRequests[0].SpotInstanceRequestId)
+					return instance, nil
 				}
 			}
 		}
@@ -380,7 +457,7 @@ func (a *Aws) CreateServer(args automation.ServerArgs) (*automation.ResourceResu
 			MaxCount:            aws.Int32(1),
 			UserData:            aws.String(base64.StdEncoding.EncodeToString([]byte(userData))),
 			TagSpecifications:   addTagSpecifications(args, types.ResourceTypeInstance),
-			BlockDeviceMappings: addBlockDevice(args.MinecraftResource.GetVolumeSize()),
+			BlockDeviceMappings: addBlockDevice(volumeSize),
 		}
 
 		instanceInput.NetworkInterfaces, err = a.addNetworkInterfaces(ctx, vpc, args, subnet.Subnet.SubnetId)
@@ -396,7 +473,7 @@ func (a *Aws) CreateServer(args automation.ServerArgs) (*automation.ResourceResu
 		for {
 			select {
 			case <-ctx.Done():
-				return nil, errors.New("timed out while creating the aws instance")
+				return nil, errors.Wrap(ctx.Err(), "aborted while creating the aws instance")
 			case <-time.After(10 * time.Second):
 				describeInstanceStatus, err := a.client.DescribeInstanceStatus(ctx, &ec2.DescribeInstanceStatusInput{
 					InstanceIds: []string{*result.Instances[0].InstanceId},
@@ -412,23 +489,7 @@ func (a *Aws) CreateServer(args automation.ServerArgs) (*automation.ResourceResu
 						if err != nil {
 							return nil, err
 						}
-						var tags []string
-						var instanceName string
-						for _, v := range i.Reservations[0].Instances[0].Tags {
-							tags = append(tags, fmt.Sprintf("%s=%s", *v.Key, *v.Value))
-
-							if *v.Key == instanceNameTag {
-								instanceName = *v.Value
-							}
-						}
-
-						return &automation.ResourceResults{
-							ID:       *i.Reservations[0].Instances[0].InstanceId,
-							Name:     instanceName,
-							Region:   a.region,
-							PublicIP: *i.Reservations[0].Instances[0].PublicIpAddress,
-							Tags:     strings.Join(tags, ","),
-						}, nil
+						return awsInstanceToResourceResults(a.region, i.Reservations[0].Instances[0]), nil
 					}
 				}
 			}
@@ -438,7 +499,11 @@ func (a *Aws) CreateServer(args automation.ServerArgs) (*automation.ResourceResu
 
 // UpdateServer updates a Minecraft server on AWS.
 func (a *Aws) UpdateServer(id string, args automation.ServerArgs) error {
-	ctx := context.TODO()
+	return a.UpdateServerContext(context.Background(), id, args)
+}
+
+// UpdateServerContext is like UpdateServer but honors the cancellation and deadline of ctx.
+func (a *Aws) UpdateServerContext(ctx context.Context, id string, args automation.ServerArgs) error {
 	ids, _, _ := strings.Cut(id, "#")
 	i, err := a.client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
 		InstanceIds: []string{ids},
@@ -447,8 +512,9 @@ func (a *Aws) UpdateServer(id string, args automation.ServerArgs) error {
 		return err
 	}
 
-	remoteCommand := update.NewRemoteServer(args.SSHPrivateKeyPath, *i.Reservations[0].Instances[0].PublicIpAddress, "ubuntu")
-	err = remoteCommand.UpdateServer(args.MinecraftResource)
+	remoteCommand := update.NewRemoteServerFromArgs(args, *i.Reservations[0].Instances[0].PublicIpAddress, sshLogin).WithTemplateOptions(a.templateOptions...)
+	defer func() { _ = remoteCommand.Close() }()
+	_, err = remoteCommand.UpdateServerStream(ctx, args.MinecraftResource, update.ExecOptions{})
 	if err != nil {
 		return err
 	}
@@ -456,10 +522,19 @@ func (a *Aws) UpdateServer(id string, args automation.ServerArgs) error {
 	return nil
 }
 
+// SSHLogin returns the user minectl logs in as on the servers, see
+// update.LoginProvider.
+func (a *Aws) SSHLogin() update.Login {
+	return sshLogin
+}
+
 // DeleteServer deletes a Minecraft server on AWS.
 func (a *Aws) DeleteServer(id string, args automation.ServerArgs) error {
-	ctx := context.TODO()
+	return a.DeleteServerContext(context.Background(), id, args)
+}
 
+// DeleteServerContext is like DeleteServer but honors the cancellation and deadline of ctx.
+func (a *Aws) DeleteServerContext(ctx context.Context, id string, args automation.ServerArgs) error {
 	ids, spotID, _ := strings.Cut(id, "#")
 	if args.MinecraftResource.IsSpot() {
 		_, err := a.client.CancelSpotInstanceRequests(ctx, &ec2.CancelSpotInstanceRequestsInput{
@@ -497,9 +572,11 @@ func (a *Aws) DeleteServer(id string, args automation.ServerArgs) error {
 
 		if *status.TerminatingInstances[0].CurrentState.Code == 48 {
 			stillDeleting = false
-			time.Sleep(15 * time.Second)
-		} else {
-			time.Sleep(2 * time.Second)
+			if err := common.Sleep(ctx, 15*time.Second); err != nil {
+				return err
+			}
+		} else if err := common.Sleep(ctx, 2*time.Second); err != nil {
+			return err
 		}
 
 	}
@@ -599,7 +676,11 @@ func (a *Aws) DeleteServer(id string, args automation.ServerArgs) error {
 
 // UploadPlugin uploads a plugin to a Minecraft server on AWS.
 func (a *Aws) UploadPlugin(id string, args automation.ServerArgs, plugin, destination string) error {
-	ctx := context.TODO()
+	return a.UploadPluginContext(context.Background(), id, args, plugin, destination)
+}
+
+// UploadPluginContext is like UploadPlugin but honors the cancellation and deadline of ctx.
+func (a *Aws) UploadPluginContext(ctx context.Context, id string, args automation.ServerArgs, plugin, destination string) error {
 	ids, _, _ := strings.Cut(id, "#")
 	i, err := a.client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
 		InstanceIds: []string{ids},
@@ -608,14 +689,10 @@ func (a *Aws) UploadPlugin(id string, args automation.ServerArgs, plugin, destin
 		return err
 	}
 
-	remoteCommand := update.NewRemoteServer(args.SSHPrivateKeyPath, *i.Reservations[0].Instances[0].PublicIpAddress, "ubuntu")
-
-	err = remoteCommand.TransferFile(plugin, filepath.Join(destination, filepath.Base(plugin)), args.MinecraftResource.GetSSHPort())
-	if err != nil {
-		return err
-	}
+	remoteCommand := update.NewRemoteServerFromArgs(args, *i.Reservations[0].Instances[0].PublicIpAddress, sshLogin)
+	defer func() { _ = remoteCommand.Close() }()
 
-	_, err = remoteCommand.ExecuteCommand("systemctl restart minecraft.service", args.MinecraftResource.GetSSHPort())
+	err = remoteCommand.UploadPluginContext(ctx, plugin, destination, args.MinecraftResource.GetSSHPort())
 	if err != nil {
 		return err
 	}
@@ -624,8 +701,12 @@ func (a *Aws) UploadPlugin(id string, args automation.ServerArgs, plugin, destin
 }
 
 // GetServer gets a Minecraft server on AWS.
-func (a *Aws) GetServer(id string, _ automation.ServerArgs) (*automation.ResourceResults, error) {
-	ctx := context.TODO()
+func (a *Aws) GetServer(id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
+	return a.GetServerContext(context.Background(), id, args)
+}
+
+// GetServerContext is like GetServer but honors the cancellation and deadline of ctx.
+func (a *Aws) GetServerContext(ctx context.Context, id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
 	ids, _, _ := strings.Cut(id, "#")
 	i, err := a.client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
 		InstanceIds: []string{ids},
@@ -634,23 +715,7 @@ func (a *Aws) GetServer(id string, _ automation.ServerArgs) (*automation.Resourc
 		return nil, err
 	}
 
-	var tags []string
-	var instanceName string
-	for _, v := range i.Reservations[0].Instances[0].Tags {
-		tags = append(tags, fmt.Sprintf("%s=%s", *v.Key, *v.Value))
-
-		if *v.Key == instanceNameTag {
-			instanceName = *v.Value
-		}
-	}
-
-	return &automation.ResourceResults{
-		ID:       *i.Reservations[0].Instances[0].InstanceId,
-		Name:     instanceName,
-		Region:   a.region,
-		PublicIP: *i.Reservations[0].Instances[0].PublicIpAddress,
-		Tags:     strings.Join(tags, ","),
-	}, err
+	return awsInstanceToResourceResults(a.region, i.Reservations[0].Instances[0]), err
 }
 
 func (a *Aws) createEC2SecurityGroup(ctx context.Context, vpcID *string, protocol string, controlPort int) (*string, error) {
@@ -742,3 +807,177 @@ func (a *Aws) lookupAMI(ctx context.Context, name, architecture string) (*string
 	})
 	return images.Images[0].ImageId, nil
 }
+
+func snapshotToVolumeSnapshot(region string, s types.Snapshot) *automation.VolumeSnapshot {
+	labels := map[string]string{}
+	for _, v := range s.Tags {
+		labels[aws.ToString(v.Key)] = aws.ToString(v.Value)
+	}
+	return &automation.VolumeSnapshot{
+		ID:        aws.ToString(s.SnapshotId),
+		Name:      aws.ToString(s.Description),
+		Server:    labels[instanceNameTag],
+		Region:    region,
+		SizeGB:    int(aws.ToInt32(s.VolumeSize)),
+		CreatedAt: aws.ToTime(s.StartTime),
+		Labels:    labels,
+	}
+}
+
+// waitForSnapshot polls the snapshot until it is completed.
+func waitForSnapshot(ctx context.Context, client *ec2.Client, snapshotID string) (*types.Snapshot, error) {
+	for {
+		snapshots, err := client.DescribeSnapshots(ctx, &ec2.DescribeSnapshotsInput{
+			SnapshotIds: []string{snapshotID},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(snapshots.Snapshots) == 0 {
+			return nil, fmt.Errorf("snapshot %s not found", snapshotID)
+		}
+		snapshot := snapshots.Snapshots[0]
+		switch snapshot.State {
+		case types.SnapshotStateCompleted:
+			return &snapshot, nil
+		case types.SnapshotStateError:
+			return nil, fmt.Errorf("snapshot %s failed: %s", snapshotID, aws.ToString(snapshot.StateMessage))
+		}
+		if err := common.Sleep(ctx, 10*time.Second); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// CreateVolumeSnapshot snapshots the root volume of a Minecraft server on AWS,
+// which holds the world, and waits until the snapshot is completed.
+func (a *Aws) CreateVolumeSnapshot(ctx context.Context, id string, args automation.ServerArgs) (*automation.VolumeSnapshot, error) {
+	ids, _, _ := strings.Cut(id, "#")
+	i, err := a.client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{ids},
+	})
+	if err != nil {
+		return nil, err
+	}
+	instance := i.Reservations[0].Instances[0]
+	var volumeID *string
+	for _, mapping := range instance.BlockDeviceMappings {
+		if mapping.Ebs != nil && aws.ToString(mapping.DeviceName) == aws.ToString(instance.RootDeviceName) {
+			volumeID = mapping.Ebs.VolumeId
+		}
+	}
+	if volumeID == nil {
+		return nil, fmt.Errorf("instance %s has no EBS root volume", ids)
+	}
+	snapshot, err := a.client.CreateSnapshot(ctx, &ec2.CreateSnapshotInput{
+		VolumeId:          volumeID,
+		Description:       aws.String(fmt.Sprintf("%s-vol-%s", args.MinecraftResource.GetName(), time.Now().UTC().Format("20060102-150405"))),
+		TagSpecifications: addTagSpecifications(args, types.ResourceTypeSnapshot),
+	})
+	if err != nil {
+		return nil, err
+	}
+	zap.S().Infow("Waiting for volume snapshot", "name", args.MinecraftResource.GetName(), "snapshot", aws.ToString(snapshot.SnapshotId))
+	completed, err := waitForSnapshot(ctx, a.client, aws.ToString(snapshot.SnapshotId))
+	if err != nil {
+		return nil, err
+	}
+	return snapshotToVolumeSnapshot(a.region, *completed), nil
+}
+
+// ListVolumeSnapshots lists all volume snapshots of Minecraft servers in the region.
+func (a *Aws) ListVolumeSnapshots(ctx context.Context) ([]automation.VolumeSnapshot, error) {
+	var result []automation.VolumeSnapshot
+	paginator := ec2.NewDescribeSnapshotsPaginator(a.client, &ec2.DescribeSnapshotsInput{
+		OwnerIds: []string{"self"},
+		Filters: []types.Filter{
+			{
+				Name:   aws.String(fmt.Sprintf("tag:%s", common.InstanceTag)),
+				Values: []string{"true"},
+			},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, snapshot := range page.Snapshots {
+			result = append(result, *snapshotToVolumeSnapshot(a.region, snapshot))
+		}
+	}
+	return result, nil
+}
+
+// DeleteVolumeSnapshot deletes a volume snapshot on AWS.
+func (a *Aws) DeleteVolumeSnapshot(ctx context.Context, snapshotID string) error {
+	_, err := a.client.DeleteSnapshot(ctx, &ec2.DeleteSnapshotInput{
+		SnapshotId: aws.String(snapshotID),
+	})
+	return err
+}
+
+// CopyVolumeSnapshot copies a volume snapshot to region, with its tags, and
+// waits until the copy is completed.
+func (a *Aws) CopyVolumeSnapshot(ctx context.Context, snapshotID, region string) (*automation.VolumeSnapshot, error) {
+	source, err := waitForSnapshot(ctx, a.client, snapshotID)
+	if err != nil {
+		return nil, err
+	}
+	client := ec2.New(a.client.Options(), func(o *ec2.Options) {
+		o.Region = region
+	})
+	snapshot, err := client.CopySnapshot(ctx, &ec2.CopySnapshotInput{
+		SourceRegion:     aws.String(a.region),
+		SourceSnapshotId: aws.String(snapshotID),
+		Description:      source.Description,
+		TagSpecifications: []types.TagSpecification{
+			{
+				ResourceType: types.ResourceTypeSnapshot,
+				Tags:         source.Tags,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	completed, err := waitForSnapshot(ctx, client, aws.ToString(snapshot.SnapshotId))
+	if err != nil {
+		return nil, err
+	}
+	return snapshotToVolumeSnapshot(region, *completed), nil
+}
+
+// registerSnapshotImage registers an AMI that boots from the snapshot in
+// args. The returned size is the size the root volume needs at least.
+func (a *Aws) registerSnapshotImage(ctx context.Context, args automation.ServerArgs) (*string, int, error) {
+	snapshot, err := waitForSnapshot(ctx, a.client, args.VolumeSnapshotID)
+	if err != nil {
+		return nil, 0, err
+	}
+	architecture := types.ArchitectureValuesX8664
+	if args.MinecraftResource.IsArm() {
+		architecture = types.ArchitectureValuesArm64
+	}
+	image, err := a.client.RegisterImage(ctx, &ec2.RegisterImageInput{
+		Name:               aws.String(fmt.Sprintf("%s-%s", args.MinecraftResource.GetName(), args.VolumeSnapshotID)),
+		Description:        aws.String("minectl server restored from a volume snapshot"),
+		Architecture:       architecture,
+		RootDeviceName:     aws.String("/dev/sda1"),
+		VirtualizationType: aws.String("hvm"),
+		EnaSupport:         aws.Bool(true),
+		BlockDeviceMappings: []types.BlockDeviceMapping{
+			{
+				DeviceName: aws.String("/dev/sda1"),
+				Ebs: &types.EbsBlockDevice{
+					SnapshotId:          aws.String(args.VolumeSnapshotID),
+					DeleteOnTermination: aws.Bool(true),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return image.ImageId, int(aws.ToInt32(snapshot.VolumeSize)), nil
+}
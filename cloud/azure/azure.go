@@ -6,7 +6,6 @@ import (
 	"encoding/base64"
 	"fmt"
 	"os"
-	"path/filepath"
 	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
@@ -17,16 +16,30 @@ import (
 	"github.com/dirien/minectl-sdk/automation"
 	"github.com/dirien/minectl-sdk/cloud"
 	"github.com/dirien/minectl-sdk/common"
+	"github.com/dirien/minectl-sdk/model"
 	minctlTemplate "github.com/dirien/minectl-sdk/template"
 	"github.com/dirien/minectl-sdk/update"
 	"go.uber.org/zap"
 )
 
+// sshLogin is the user minectl logs in as on Azure servers.
+var sshLogin = update.UbuntuLogin
+
 // Azure implements the Automation interface for Azure.
 type Azure struct {
-	subscriptionID string
-	credential     *azidentity.DefaultAzureCredential
-	tmpl           *minctlTemplate.Template
+	subscriptionID  string
+	credential      *azidentity.DefaultAzureCredential
+	tmpl            *minctlTemplate.Template
+	templateOptions []minctlTemplate.Option
+}
+
+func init() {
+	cloud.Register(cloud.Provider{
+		Code: model.ProviderAzure,
+		Factory: func(_ context.Context, _ cloud.Credentials, opts []minctlTemplate.Option) (automation.Automation, error) {
+			return NewAzure(opts...)
+		},
+	})
 }
 
 // NewAzure creates a new Azure instance using DefaultAzureCredential.
@@ -39,28 +52,30 @@ type Azure struct {
 //
 // Required environment variables:
 // - AZURE_SUBSCRIPTION_ID: The Azure subscription ID
-func NewAzure() (*Azure, error) {
+func NewAzure(opts ...minctlTemplate.Option) (*Azure, error) {
 	cred, err := azidentity.NewDefaultAzureCredential(nil)
 	if err != nil {
 		return nil, err
 	}
-	tmpl, err := minctlTemplate.NewTemplateCloudConfig()
+	tmpl, err := minctlTemplate.NewTemplateCloudConfig(opts...)
 	if err != nil {
 		return nil, err
 	}
 	zap.S().Infow("Azure set cloud-config template", "name", tmpl.Template.Name())
 	return &Azure{
-		subscriptionID: os.Getenv("AZURE_SUBSCRIPTION_ID"),
-		credential:     cred,
-		tmpl:           tmpl,
+		subscriptionID:  os.Getenv("AZURE_SUBSCRIPTION_ID"),
+		credential:      cred,
+		tmpl:            tmpl,
+		templateOptions: opts,
 	}, nil
 }
 
-func getTags(edition string) map[string]*string {
-	return map[string]*string{
-		common.InstanceTag: to.Ptr("true"),
-		edition:            to.Ptr("true"),
+func getTags(args automation.ServerArgs) map[string]*string {
+	tags := map[string]*string{}
+	for key, value := range automation.ServerLabels(args) {
+		tags[key] = to.Ptr(value)
 	}
+	return tags
 }
 
 func getTagKeys(tags map[string]*string) []string {
@@ -73,7 +88,11 @@ func getTagKeys(tags map[string]*string) []string {
 
 // CreateServer creates a new Minecraft server on Azure.
 func (a *Azure) CreateServer(args automation.ServerArgs) (*automation.ResourceResults, error) {
-	ctx := context.Background()
+	return a.CreateServerContext(context.Background(), args)
+}
+
+// CreateServerContext is like CreateServer but honors the cancellation and deadline of ctx.
+func (a *Azure) CreateServerContext(ctx context.Context, args automation.ServerArgs) (*automation.ResourceResults, error) {
 	resourceGroupsClient, err := armresources.NewResourceGroupsClient(a.subscriptionID, a.credential, nil)
 	if err != nil {
 		return nil, err
@@ -100,7 +119,7 @@ func (a *Azure) CreateServer(args automation.ServerArgs) (*automation.ResourceRe
 		armnetwork.VirtualNetwork{
 			Name:     to.Ptr(fmt.Sprintf("%s-vnet", args.MinecraftResource.GetName())),
 			Location: to.Ptr(args.MinecraftResource.GetRegion()),
-			Tags:     getTags(args.MinecraftResource.GetEdition()),
+			Tags:     getTags(args),
 			Properties: &armnetwork.VirtualNetworkPropertiesFormat{
 				AddressSpace: &armnetwork.AddressSpace{
 					AddressPrefixes: []*string{to.Ptr("10.0.0.0/8")},
@@ -141,7 +160,7 @@ func (a *Azure) CreateServer(args automation.ServerArgs) (*automation.ResourceRe
 				PublicIPAddressVersion:   to.Ptr(armnetwork.IPVersionIPv4),
 				PublicIPAllocationMethod: to.Ptr(armnetwork.IPAllocationMethodStatic),
 			},
-			Tags: getTags(args.MinecraftResource.GetEdition()),
+			Tags: getTags(args),
 		}, nil,
 	)
 	if err != nil {
@@ -159,7 +178,7 @@ func (a *Azure) CreateServer(args automation.ServerArgs) (*automation.ResourceRe
 	}
 
 	interfacesPoller, err := interfacesClient.BeginCreateOrUpdate(
-		context.Background(),
+		ctx,
 		*group.Name,
 		fmt.Sprintf("%s-nic", args.MinecraftResource.GetName()),
 		armnetwork.Interface{
@@ -177,7 +196,7 @@ func (a *Azure) CreateServer(args automation.ServerArgs) (*automation.ResourceRe
 					},
 				},
 			},
-			Tags: getTags(args.MinecraftResource.GetEdition()),
+			Tags: getTags(args),
 		}, nil)
 	if err != nil {
 		return nil, err
@@ -195,7 +214,7 @@ func (a *Azure) CreateServer(args automation.ServerArgs) (*automation.ResourceRe
 			return nil, err
 		}
 		diskPoller, err := disksClient.BeginCreateOrUpdate(
-			context.Background(),
+			ctx,
 			*group.Name,
 			fmt.Sprintf("%s-vol", args.MinecraftResource.GetName()),
 			armcompute.Disk{
@@ -227,7 +246,7 @@ func (a *Azure) CreateServer(args automation.ServerArgs) (*automation.ResourceRe
 		return nil, err
 	}
 
-	userData, err := a.tmpl.GetTemplate(args.MinecraftResource, &minctlTemplate.CreateUpdateTemplateArgs{Mount: mount, Name: minctlTemplate.GetTemplateCloudConfigName(args.MinecraftResource.IsProxyServer())})
+	userData, err := a.tmpl.GetTemplateContext(ctx, args.MinecraftResource, &minctlTemplate.CreateUpdateTemplateArgs{Mount: mount, SSHHostKey: args.SSHHostKey, Name: minctlTemplate.GetTemplateCloudConfigName(args.MinecraftResource.IsProxyServer())})
 	if err != nil {
 		return nil, err
 	}
@@ -285,7 +304,7 @@ func (a *Azure) CreateServer(args automation.ServerArgs) (*automation.ResourceRe
 				},
 			},
 		},
-		Tags: getTags(args.MinecraftResource.GetEdition()),
+		Tags: getTags(args),
 	}
 	if args.MinecraftResource.GetVolumeSize() > 0 {
 		vmOptions.Properties.StorageProfile.DataDisks = []*armcompute.DataDisk{{
@@ -298,7 +317,7 @@ func (a *Azure) CreateServer(args automation.ServerArgs) (*automation.ResourceRe
 	}
 
 	vmPoller, err := virtualMachinesClient.BeginCreateOrUpdate(
-		context.Background(),
+		ctx,
 		*group.Name,
 		args.MinecraftResource.GetName(),
 		vmOptions,
@@ -328,18 +347,26 @@ func (a *Azure) CreateServer(args automation.ServerArgs) (*automation.ResourceRe
 
 	zap.S().Infow("Azure virtual machine started", "name", instance.Name, "ip", *ip.Properties.IPAddress, "id", instance.Name)
 
-	return &automation.ResourceResults{
-		ID:       *instance.Name,
-		Name:     *instance.Name,
-		Region:   *group.Location,
-		PublicIP: *ip.Properties.IPAddress,
-		Tags:     strings.Join(getTagKeys(instance.Tags), ","),
-	}, err
+	result, err := a.instanceToResourceResults(ctx, virtualMachinesClient, &instance.VirtualMachine)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// SSHLogin returns the user minectl logs in as on the servers, see
+// update.LoginProvider.
+func (a *Azure) SSHLogin() update.Login {
+	return sshLogin
 }
 
 // DeleteServer deletes a Minecraft server on Azure.
-func (a *Azure) DeleteServer(_ string, args automation.ServerArgs) error {
-	ctx := context.Background()
+func (a *Azure) DeleteServer(id string, args automation.ServerArgs) error {
+	return a.DeleteServerContext(context.Background(), id, args)
+}
+
+// DeleteServerContext is like DeleteServer but honors the cancellation and deadline of ctx.
+func (a *Azure) DeleteServerContext(ctx context.Context, _ string, args automation.ServerArgs) error {
 	resourceGroupName := fmt.Sprintf("%s-rg", args.MinecraftResource.GetName())
 	resourceGroupsClient, err := armresources.NewResourceGroupsClient(a.subscriptionID, a.credential, nil)
 	if err != nil {
@@ -361,9 +388,81 @@ func (a *Azure) DeleteServer(_ string, args automation.ServerArgs) error {
 	return nil
 }
 
+func azureStatus(statuses []*armcompute.InstanceViewStatus) automation.Status {
+	for _, status := range statuses {
+		if status.Code == nil {
+			continue
+		}
+		switch *status.Code {
+		case "PowerState/starting":
+			return automation.StatusPending
+		case "PowerState/running":
+			return automation.StatusRunning
+		case "PowerState/stopping", "PowerState/deallocating":
+			return automation.StatusStopping
+		case "PowerState/stopped", "PowerState/deallocated":
+			return automation.StatusStopped
+		}
+	}
+	return automation.StatusUnknown
+}
+
+func (a *Azure) instanceToResourceResults(ctx context.Context, virtualMachinesClient *armcompute.VirtualMachinesClient, instance *armcompute.VirtualMachine) (*automation.ResourceResults, error) {
+	resourceGroup := fmt.Sprintf("%s-rg", *instance.Name)
+	publicIPAddressesClient, err := armnetwork.NewPublicIPAddressesClient(a.subscriptionID, a.credential, nil)
+	if err != nil {
+		return nil, err
+	}
+	ip, err := publicIPAddressesClient.Get(ctx, resourceGroup, fmt.Sprintf("%s-ip", *instance.Name), nil)
+	if err != nil {
+		return nil, err
+	}
+	instanceView, err := virtualMachinesClient.InstanceView(ctx, resourceGroup, *instance.Name, nil)
+	if err != nil {
+		return nil, err
+	}
+	labels := map[string]string{}
+	for key, value := range instance.Tags {
+		if value != nil {
+			labels[key] = *value
+		}
+	}
+	result := &automation.ResourceResults{
+		ID:       *instance.Name,
+		Name:     *instance.Name,
+		Region:   *instance.Location,
+		PublicIP: *ip.Properties.IPAddress,
+		Tags:     strings.Join(getTagKeys(instance.Tags), ","),
+		Status:   azureStatus(instanceView.Statuses),
+		Labels:   labels,
+		Edition:  automation.EditionFromLabels(labels),
+		Version:  automation.VersionFromLabels(labels),
+	}
+	if instance.Properties != nil {
+		if instance.Properties.TimeCreated != nil {
+			result.CreatedAt = *instance.Properties.TimeCreated
+		}
+		if instance.Properties.HardwareProfile != nil && instance.Properties.HardwareProfile.VMSize != nil {
+			result.Size = string(*instance.Properties.HardwareProfile.VMSize)
+		}
+		if instance.Properties.StorageProfile != nil {
+			for _, disk := range instance.Properties.StorageProfile.DataDisks {
+				if disk.ManagedDisk != nil && disk.ManagedDisk.ID != nil {
+					result.VolumeIDs = append(result.VolumeIDs, *disk.ManagedDisk.ID)
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
 // ListServer lists all Minecraft servers on Azure.
 func (a *Azure) ListServer() ([]automation.ResourceResults, error) {
-	ctx := context.Background()
+	return a.ListServerContext(context.Background())
+}
+
+// ListServerContext is like ListServer but honors the cancellation and deadline of ctx.
+func (a *Azure) ListServerContext(ctx context.Context) ([]automation.ResourceResults, error) {
 	virtualMachinesClient, err := armcompute.NewVirtualMachinesClient(a.subscriptionID, a.credential, nil)
 	if err != nil {
 		return nil, err
@@ -379,25 +478,11 @@ func (a *Azure) ListServer() ([]automation.ResourceResults, error) {
 			for key := range instance.Tags {
 				if key == common.InstanceTag {
 
-					publicIPAddressesClient, err := armnetwork.NewPublicIPAddressesClient(a.subscriptionID, a.credential, nil)
+					server, err := a.instanceToResourceResults(ctx, virtualMachinesClient, instance)
 					if err != nil {
 						return nil, err
 					}
-					ip, err := publicIPAddressesClient.Get(
-						context.Background(),
-						fmt.Sprintf("%s-rg", *instance.Name),
-						fmt.Sprintf("%s-ip", *instance.Name),
-						nil)
-					if err != nil {
-						return nil, err
-					}
-					result = append(result, automation.ResourceResults{
-						ID:       *instance.Name,
-						Name:     *instance.Name,
-						Region:   *instance.Location,
-						PublicIP: *ip.Properties.IPAddress,
-						Tags:     strings.Join(getTagKeys(instance.Tags), ","),
-					})
+					result = append(result, *server)
 				}
 			}
 		}
@@ -412,12 +497,18 @@ func (a *Azure) ListServer() ([]automation.ResourceResults, error) {
 
 // UpdateServer updates a Minecraft server on Azure.
 func (a *Azure) UpdateServer(id string, args automation.ServerArgs) error {
-	server, err := a.GetServer(id, args)
+	return a.UpdateServerContext(context.Background(), id, args)
+}
+
+// UpdateServerContext is like UpdateServer but honors the cancellation and deadline of ctx.
+func (a *Azure) UpdateServerContext(ctx context.Context, id string, args automation.ServerArgs) error {
+	server, err := a.GetServerContext(ctx, id, args)
 	if err != nil {
 		return err
 	}
-	remoteCommand := update.NewRemoteServer(args.SSHPrivateKeyPath, server.PublicIP, "ubuntu")
-	err = remoteCommand.UpdateServer(args.MinecraftResource)
+	remoteCommand := update.NewRemoteServerFromArgs(args, server.PublicIP, sshLogin).WithTemplateOptions(a.templateOptions...)
+	defer func() { _ = remoteCommand.Close() }()
+	_, err = remoteCommand.UpdateServerStream(ctx, args.MinecraftResource, update.ExecOptions{})
 	if err != nil {
 		return err
 	}
@@ -427,19 +518,18 @@ func (a *Azure) UpdateServer(id string, args automation.ServerArgs) error {
 
 // UploadPlugin uploads a plugin to a Minecraft server on Azure.
 func (a *Azure) UploadPlugin(id string, args automation.ServerArgs, plugin, destination string) error {
-	server, err := a.GetServer(id, args)
-	if err != nil {
-		return err
-	}
-	remoteCommand := update.NewRemoteServer(args.SSHPrivateKeyPath, server.PublicIP, "ubuntu")
+	return a.UploadPluginContext(context.Background(), id, args, plugin, destination)
+}
 
-	// as we are not allowed to login via root user, we need to add sudo to the command
-	source := filepath.Join("/tmp", filepath.Base(plugin))
-	err = remoteCommand.TransferFile(plugin, source, args.MinecraftResource.GetSSHPort())
+// UploadPluginContext is like UploadPlugin but honors the cancellation and deadline of ctx.
+func (a *Azure) UploadPluginContext(ctx context.Context, id string, args automation.ServerArgs, plugin, destination string) error {
+	server, err := a.GetServerContext(ctx, id, args)
 	if err != nil {
 		return err
 	}
-	_, err = remoteCommand.ExecuteCommand(fmt.Sprintf("sudo mv %s %s\nsudo systemctl restart minecraft.service", source, destination), args.MinecraftResource.GetSSHPort())
+	remoteCommand := update.NewRemoteServerFromArgs(args, server.PublicIP, sshLogin)
+	defer func() { _ = remoteCommand.Close() }()
+	err = remoteCommand.UploadPluginContext(ctx, plugin, destination, args.MinecraftResource.GetSSHPort())
 	if err != nil {
 		return err
 	}
@@ -449,12 +539,17 @@ func (a *Azure) UploadPlugin(id string, args automation.ServerArgs, plugin, dest
 
 // GetServer gets a Minecraft server on Azure.
 func (a *Azure) GetServer(id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
+	return a.GetServerContext(context.Background(), id, args)
+}
+
+// GetServerContext is like GetServer but honors the cancellation and deadline of ctx.
+func (a *Azure) GetServerContext(ctx context.Context, id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
 	virtualMachinesClient, err := armcompute.NewVirtualMachinesClient(a.subscriptionID, a.credential, nil)
 	if err != nil {
 		return nil, err
 	}
 	instance, err := virtualMachinesClient.Get(
-		context.Background(),
+		ctx,
 		fmt.Sprintf("%s-rg", args.MinecraftResource.GetName()),
 		id,
 		&armcompute.VirtualMachinesClientGetOptions{Expand: nil},
@@ -462,23 +557,9 @@ func (a *Azure) GetServer(id string, args automation.ServerArgs) (*automation.Re
 	if err != nil {
 		return nil, err
 	}
-	publicIPAddressesClient, err := armnetwork.NewPublicIPAddressesClient(a.subscriptionID, a.credential, nil)
-	if err != nil {
-		return nil, err
-	}
-	ip, err := publicIPAddressesClient.Get(
-		context.Background(),
-		fmt.Sprintf("%s-rg", *instance.Name),
-		fmt.Sprintf("%s-ip", *instance.Name),
-		nil)
+	result, err := a.instanceToResourceResults(ctx, virtualMachinesClient, &instance.VirtualMachine)
 	if err != nil {
 		return nil, err
 	}
-	return &automation.ResourceResults{
-		ID:       *instance.Name,
-		Name:     *instance.Name,
-		Region:   *instance.Location,
-		PublicIP: *ip.Properties.IPAddress,
-		Tags:     strings.Join(getTagKeys(instance.Tags), ","),
-	}, err
+	return result, nil
 }
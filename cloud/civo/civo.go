@@ -2,8 +2,8 @@
 package civo
 
 import (
+	"context"
 	"fmt"
-	"path/filepath"
 	"strings"
 	"time"
 
@@ -11,36 +11,60 @@ import (
 	"github.com/dirien/minectl-sdk/automation"
 	"github.com/dirien/minectl-sdk/cloud"
 	"github.com/dirien/minectl-sdk/common"
+	"github.com/dirien/minectl-sdk/model"
 	minctlTemplate "github.com/dirien/minectl-sdk/template"
 	"github.com/dirien/minectl-sdk/update"
 	"go.uber.org/zap"
 )
 
+// sshLogin is the user minectl logs in as on Civo servers.
+var sshLogin = update.RootLogin
+
 // Civo implements the Automation interface for Civo.
 type Civo struct {
-	client *civogo.Client
-	tmpl   *minctlTemplate.Template
+	client          *civogo.Client
+	tmpl            *minctlTemplate.Template
+	templateOptions []minctlTemplate.Option
+}
+
+func init() {
+	cloud.Register(cloud.Provider{
+		Code: model.ProviderCivo,
+		Credentials: []cloud.CredentialField{
+			{Name: cloud.CredentialToken, EnvVar: "CIVO_TOKEN", Description: "Civo API key", Required: true},
+			{Name: cloud.CredentialRegion, EnvVar: "CIVO_REGION", Description: "Civo region", Required: true},
+		},
+		Factory: func(_ context.Context, creds cloud.Credentials, opts []minctlTemplate.Option) (automation.Automation, error) {
+			return NewCivo(creds[cloud.CredentialToken], creds[cloud.CredentialRegion], opts...)
+		},
+	})
 }
 
 // NewCivo creates a new Civo instance.
-func NewCivo(apiKey, region string) (*Civo, error) {
+func NewCivo(apiKey, region string, opts ...minctlTemplate.Option) (*Civo, error) {
 	client, err := civogo.NewClient(apiKey, region)
 	if err != nil {
 		return nil, err
 	}
-	tmpl, err := minctlTemplate.NewTemplateBash()
+	tmpl, err := minctlTemplate.NewTemplateBash(opts...)
 	if err != nil {
 		return nil, err
 	}
 	do := &Civo{
-		client: client,
-		tmpl:   tmpl,
+		client:          client,
+		tmpl:            tmpl,
+		templateOptions: opts,
 	}
 	return do, nil
 }
 
 // CreateServer creates a new Minecraft server on Civo.
 func (c *Civo) CreateServer(args automation.ServerArgs) (*automation.ResourceResults, error) {
+	return c.CreateServerContext(context.Background(), args)
+}
+
+// CreateServerContext is like CreateServer but honors the cancellation and deadline of ctx.
+func (c *Civo) CreateServerContext(ctx context.Context, args automation.ServerArgs) (*automation.ResourceResults, error) {
 	publicKey, err := cloud.GetSSHPublicKey(args)
 	if err != nil {
 		return nil, err
@@ -72,9 +96,9 @@ func (c *Civo) CreateServer(args automation.ServerArgs) (*automation.ResourceRes
 	config.SSHKeyID = sshPubKey.ID
 	config.PublicIPRequired = "create"
 	config.InitialUser = "root"
-	config.Tags = []string{common.InstanceTag, args.MinecraftResource.GetEdition()}
+	config.Tags = automation.ServerTags(args)
 
-	script, err := c.tmpl.GetTemplate(args.MinecraftResource, &minctlTemplate.CreateUpdateTemplateArgs{Name: minctlTemplate.GetTemplateBashName(args.MinecraftResource.IsProxyServer())})
+	script, err := c.tmpl.GetTemplateContext(ctx, args.MinecraftResource, &minctlTemplate.CreateUpdateTemplateArgs{SSHHostKey: args.SSHHostKey, Name: minctlTemplate.GetTemplateBashName(args.MinecraftResource.IsProxyServer())})
 	if err != nil {
 		return nil, err
 	}
@@ -86,7 +110,7 @@ func (c *Civo) CreateServer(args automation.ServerArgs) (*automation.ResourceRes
 	}
 	zap.S().Infow("Civo create instance", "instance", instance)
 
-	if args.MinecraftResource.GetEdition() == "bedrock" || args.MinecraftResource.GetEdition() == "nukkit" || args.MinecraftResource.GetEdition() == "powernukkit" {
+	if args.MinecraftResource.GetProtocol() == model.ProtocolUDP {
 		createRule := true
 
 		firewallConfig := civogo.FirewallConfig{
@@ -129,9 +153,9 @@ func (c *Civo) CreateServer(args automation.ServerArgs) (*automation.ResourceRes
 		}
 		if instance.Status == "ACTIVE" {
 			stillCreating = false
-			time.Sleep(2 * time.Second)
-		} else {
-			time.Sleep(2 * time.Second)
+		}
+		if err := common.Sleep(ctx, 2*time.Second); err != nil {
+			return nil, err
 		}
 	}
 	instance, err = c.client.FindInstance(instance.ID)
@@ -139,21 +163,22 @@ func (c *Civo) CreateServer(args automation.ServerArgs) (*automation.ResourceRes
 		return nil, err
 	}
 	zap.S().Infow("Civo instance ready", "instance", instance)
-	region := c.client.Region
-	if len(instance.Region) > 0 {
-		region = instance.Region
-	}
-	return &automation.ResourceResults{
-		ID:       instance.ID,
-		Name:     instance.Hostname,
-		Region:   region,
-		PublicIP: instance.PublicIP,
-		Tags:     strings.Join(instance.Tags, ","),
-	}, err
+	return c.instanceToResourceResults(instance), err
+}
+
+// SSHLogin returns the user minectl logs in as on the servers, see
+// update.LoginProvider.
+func (c *Civo) SSHLogin() update.Login {
+	return sshLogin
 }
 
 // DeleteServer deletes a Minecraft server on Civo.
 func (c *Civo) DeleteServer(id string, args automation.ServerArgs) error {
+	return c.DeleteServerContext(context.Background(), id, args)
+}
+
+// DeleteServerContext is like DeleteServer but honors the cancellation and deadline of ctx.
+func (c *Civo) DeleteServerContext(ctx context.Context, id string, args automation.ServerArgs) error {
 	_, err := c.client.DeleteInstance(id)
 	if err != nil {
 		return err
@@ -168,7 +193,7 @@ func (c *Civo) DeleteServer(id string, args automation.ServerArgs) error {
 		return err
 	}
 	zap.S().Infow("Civo delete ssh key", "pubKeyFile", pubKeyFile)
-	if args.MinecraftResource.GetEdition() == "bedrock" || args.MinecraftResource.GetEdition() == "nukkit" || args.MinecraftResource.GetEdition() == "powernukkit" {
+	if args.MinecraftResource.GetProtocol() == model.ProtocolUDP {
 		firewall, err := c.client.FindFirewall(fmt.Sprintf("%s-fw", args.MinecraftResource.GetName()))
 		if err != nil {
 			return err
@@ -182,8 +207,58 @@ func (c *Civo) DeleteServer(id string, args automation.ServerArgs) error {
 	return nil
 }
 
+func civoStatus(status string) automation.Status {
+	switch status {
+	case "BUILDING", "BUILD_PENDING", "STARTING", "REBOOTING", "MIGRATING", "UPGRADING":
+		return automation.StatusPending
+	case "ACTIVE":
+		return automation.StatusRunning
+	case "STOPPING", "SHUTTING_DOWN":
+		return automation.StatusStopping
+	case "SHUTOFF", "STOPPED":
+		return automation.StatusStopped
+	case "DELETING":
+		return automation.StatusDeleting
+	default:
+		return automation.StatusUnknown
+	}
+}
+
+func (c *Civo) instanceToResourceResults(instance *civogo.Instance) *automation.ResourceResults {
+	region := c.client.Region
+	if len(instance.Region) > 0 {
+		region = instance.Region
+	}
+	labels := automation.LabelsFromTags(instance.Tags)
+	result := &automation.ResourceResults{
+		ID:         instance.ID,
+		Name:       instance.Hostname,
+		Region:     region,
+		PublicIP:   instance.PublicIP,
+		Tags:       strings.Join(instance.Tags, ","),
+		Status:     civoStatus(instance.Status),
+		PublicIPv6: instance.IPv6,
+		PrivateIP:  instance.PrivateIP,
+		Size:       instance.Size,
+		Arch:       automation.ArchAMD64,
+		CreatedAt:  instance.CreatedAt,
+		Labels:     labels,
+		Edition:    automation.EditionFromLabels(labels),
+		Version:    automation.VersionFromLabels(labels),
+	}
+	for _, volume := range instance.AttachedVolumes {
+		result.VolumeIDs = append(result.VolumeIDs, volume.ID)
+	}
+	return result
+}
+
 // ListServer lists all Minecraft servers on Civo.
 func (c *Civo) ListServer() ([]automation.ResourceResults, error) {
+	return c.ListServerContext(context.Background())
+}
+
+// ListServerContext is like ListServer but honors the cancellation and deadline of ctx.
+func (c *Civo) ListServerContext(ctx context.Context) ([]automation.ResourceResults, error) {
 	var result []automation.ResourceResults
 	instances, err := c.client.ListAllInstances()
 	if err != nil {
@@ -193,17 +268,8 @@ func (c *Civo) ListServer() ([]automation.ResourceResults, error) {
 		if instance.Tags[0] == common.InstanceTag {
 			for _, tag := range instance.Tags {
 				if tag == common.InstanceTag {
-					region := c.client.Region
-					if len(instance.Region) > 0 {
-						region = instance.Region
-					}
-					result = append(result, automation.ResourceResults{
-						ID:       instance.ID,
-						PublicIP: instance.PublicIP,
-						Name:     instance.Hostname,
-						Region:   region,
-						Tags:     strings.Join(instance.Tags, ","),
-					})
+					instance := instance
+					result = append(result, *c.instanceToResourceResults(&instance))
 				}
 			}
 		}
@@ -218,13 +284,19 @@ func (c *Civo) ListServer() ([]automation.ResourceResults, error) {
 
 // UpdateServer updates a Minecraft server on Civo.
 func (c *Civo) UpdateServer(id string, args automation.ServerArgs) error {
+	return c.UpdateServerContext(context.Background(), id, args)
+}
+
+// UpdateServerContext is like UpdateServer but honors the cancellation and deadline of ctx.
+func (c *Civo) UpdateServerContext(ctx context.Context, id string, args automation.ServerArgs) error {
 	instance, err := c.client.GetInstance(id)
 	if err != nil {
 		return err
 	}
 
-	remoteCommand := update.NewRemoteServer(args.SSHPrivateKeyPath, instance.PublicIP, "root")
-	err = remoteCommand.UpdateServer(args.MinecraftResource)
+	remoteCommand := update.NewRemoteServerFromArgs(args, instance.PublicIP, sshLogin).WithTemplateOptions(c.templateOptions...)
+	defer func() { _ = remoteCommand.Close() }()
+	_, err = remoteCommand.UpdateServerStream(ctx, args.MinecraftResource, update.ExecOptions{})
 	if err != nil {
 		return err
 	}
@@ -234,17 +306,19 @@ func (c *Civo) UpdateServer(id string, args automation.ServerArgs) error {
 
 // UploadPlugin uploads a plugin to a Minecraft server on Civo.
 func (c *Civo) UploadPlugin(id string, args automation.ServerArgs, plugin, destination string) error {
+	return c.UploadPluginContext(context.Background(), id, args, plugin, destination)
+}
+
+// UploadPluginContext is like UploadPlugin but honors the cancellation and deadline of ctx.
+func (c *Civo) UploadPluginContext(ctx context.Context, id string, args automation.ServerArgs, plugin, destination string) error {
 	instance, err := c.client.GetInstance(id)
 	if err != nil {
 		return err
 	}
 
-	remoteCommand := update.NewRemoteServer(args.SSHPrivateKeyPath, instance.PublicIP, "root")
-	err = remoteCommand.TransferFile(plugin, filepath.Join(destination, filepath.Base(plugin)), args.MinecraftResource.GetSSHPort())
-	if err != nil {
-		return err
-	}
-	_, err = remoteCommand.ExecuteCommand("systemctl restart minecraft.service", args.MinecraftResource.GetSSHPort())
+	remoteCommand := update.NewRemoteServerFromArgs(args, instance.PublicIP, sshLogin)
+	defer func() { _ = remoteCommand.Close() }()
+	err = remoteCommand.UploadPluginContext(ctx, plugin, destination, args.MinecraftResource.GetSSHPort())
 	if err != nil {
 		return err
 	}
@@ -253,20 +327,15 @@ func (c *Civo) UploadPlugin(id string, args automation.ServerArgs, plugin, desti
 }
 
 // GetServer gets a Minecraft server on Civo.
-func (c *Civo) GetServer(id string, _ automation.ServerArgs) (*automation.ResourceResults, error) {
+func (c *Civo) GetServer(id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
+	return c.GetServerContext(context.Background(), id, args)
+}
+
+// GetServerContext is like GetServer but honors the cancellation and deadline of ctx.
+func (c *Civo) GetServerContext(ctx context.Context, id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
 	instance, err := c.client.GetInstance(id)
 	if err != nil {
 		return nil, err
 	}
-	region := c.client.Region
-	if len(instance.Region) > 0 {
-		region = instance.Region
-	}
-	return &automation.ResourceResults{
-		ID:       instance.ID,
-		Name:     instance.Hostname,
-		Region:   region,
-		PublicIP: instance.PublicIP,
-		Tags:     strings.Join(instance.Tags, ","),
-	}, err
+	return c.instanceToResourceResults(instance), err
 }
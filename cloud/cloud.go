@@ -10,34 +10,18 @@ import (
 	"github.com/dirien/minectl-sdk/common"
 )
 
-// CloudProvider mapping cloud provider from short name to full name
-var cloudProvider = map[string]string{
-	"do":        "DigitalOcean",
-	"civo":      "Civo",
-	"scaleway":  "Scaleway",
-	"hetzner":   "Hetzner",
-	"akamai":    "Akamai Connected Cloud",
-	"ovh":       "OVHcloud",
-	"gce":       "Google Compute Engine",
-	"vultr":     "vultr",
-	"azure":     "Azure",
-	"oci":       "Oracle Cloud Infrastructure",
-	"aws":       "Amazon Web Services",
-	"vexxhost":  "VEXXHOST",
-	"exoscale":  "Exoscale",
-	"multipass": "Ubuntu Multipass",
-	"fuga":      "Fuga Cloud",
-}
-
 // GetCloudProviderFullName returns the full name for a cloud provider code.
 func GetCloudProviderFullName(cloud string) string {
-	return cloudProvider[cloud]
+	p, _ := GetProvider(cloud)
+	return p.FullName
 }
 
 // GetCloudProviderCode returns the code for a cloud provider full name.
 func GetCloudProviderCode(fullName string) string {
-	for code, name := range cloudProvider {
-		if name == fullName {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	for code, p := range providers {
+		if p.FullName == fullName {
 			return code
 		}
 	}
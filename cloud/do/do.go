@@ -4,7 +4,7 @@ package do
 import (
 	"context"
 	"fmt"
-	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -13,15 +13,21 @@ import (
 	"github.com/dirien/minectl-sdk/automation"
 	"github.com/dirien/minectl-sdk/cloud"
 	"github.com/dirien/minectl-sdk/common"
+	"github.com/dirien/minectl-sdk/model"
 	minctlTemplate "github.com/dirien/minectl-sdk/template"
 	"github.com/dirien/minectl-sdk/update"
+	"go.uber.org/zap"
 	"golang.org/x/oauth2"
 )
 
+// sshLogin is the user minectl logs in as on DigitalOcean servers.
+var sshLogin = update.RootLogin
+
 // DigitalOcean implements the Automation interface for DigitalOcean.
 type DigitalOcean struct {
-	client *godo.Client
-	tmpl   *minctlTemplate.Template
+	client          *godo.Client
+	tmpl            *minctlTemplate.Template
+	templateOptions []minctlTemplate.Option
 }
 
 // TokenSource contains an access token
@@ -37,57 +43,115 @@ func (t *TokenSource) Token() (*oauth2.Token, error) {
 	return token, nil
 }
 
+func init() {
+	cloud.Register(cloud.Provider{
+		Code: model.ProviderDigitalocean,
+		Credentials: []cloud.CredentialField{
+			{Name: cloud.CredentialToken, EnvVar: "DIGITALOCEAN_TOKEN", Description: "DigitalOcean API token", Required: true},
+		},
+		Factory: func(_ context.Context, creds cloud.Credentials, opts []minctlTemplate.Option) (automation.Automation, error) {
+			return NewDigitalOcean(creds[cloud.CredentialToken], opts...)
+		},
+	})
+}
+
 // NewDigitalOcean creates a new DigitalOcean instance.
-func NewDigitalOcean(apiKey string) (*DigitalOcean, error) {
+func NewDigitalOcean(apiKey string, opts ...minctlTemplate.Option) (*DigitalOcean, error) {
 	tokenSource := &TokenSource{
 		AccessToken: apiKey,
 	}
 	oauthClient := oauth2.NewClient(context.Background(), tokenSource)
 	client := godo.NewClient(oauthClient)
-	tmpl, err := minctlTemplate.NewTemplateCloudConfig()
+	tmpl, err := minctlTemplate.NewTemplateCloudConfig(opts...)
 	if err != nil {
 		return nil, err
 	}
 	do := &DigitalOcean{
-		client: client,
-		tmpl:   tmpl,
+		client:          client,
+		tmpl:            tmpl,
+		templateOptions: opts,
 	}
 	return do, nil
 }
 
+func dropletStatus(status string) automation.Status {
+	switch status {
+	case "new":
+		return automation.StatusPending
+	case "active":
+		return automation.StatusRunning
+	case "off", "archive":
+		return automation.StatusStopped
+	default:
+		return automation.StatusUnknown
+	}
+}
+
+func dropletToResourceResults(droplet *godo.Droplet) *automation.ResourceResults {
+	ipv4, _ := droplet.PublicIPv4()
+	ipv6, _ := droplet.PublicIPv6()
+	privateIPv4, _ := droplet.PrivateIPv4()
+	labels := automation.LabelsFromTags(droplet.Tags)
+	result := &automation.ResourceResults{
+		ID:         strconv.Itoa(droplet.ID),
+		Name:       droplet.Name,
+		Region:     droplet.Region.Slug,
+		PublicIP:   ipv4,
+		Tags:       strings.Join(droplet.Tags, ","),
+		Status:     dropletStatus(droplet.Status),
+		PublicIPv6: ipv6,
+		PrivateIP:  privateIPv4,
+		Size:       droplet.SizeSlug,
+		Arch:       automation.ArchAMD64,
+		VolumeIDs:  droplet.VolumeIDs,
+		Labels:     labels,
+		Edition:    automation.EditionFromLabels(labels),
+		Version:    automation.VersionFromLabels(labels),
+	}
+	if created, err := time.Parse(time.RFC3339, droplet.Created); err == nil {
+		result.CreatedAt = created
+	}
+	return result
+}
+
 // ListServer lists all Minecraft servers on DigitalOcean.
 func (d *DigitalOcean) ListServer() ([]automation.ResourceResults, error) {
-	droplets, _, err := d.client.Droplets.ListByTag(context.Background(), common.InstanceTag, nil)
+	return d.ListServerContext(context.Background())
+}
+
+// ListServerContext is like ListServer but honors the cancellation and deadline of ctx.
+func (d *DigitalOcean) ListServerContext(ctx context.Context) ([]automation.ResourceResults, error) {
+	droplets, _, err := d.client.Droplets.ListByTag(ctx, common.InstanceTag, nil)
 	if err != nil {
 		return nil, err
 	}
 	var result []automation.ResourceResults
 	for _, droplet := range droplets {
-		ipv4, _ := droplet.PublicIPv4()
-		result = append(result, automation.ResourceResults{
-			ID:       strconv.Itoa(droplet.ID),
-			PublicIP: ipv4,
-			Name:     droplet.Name,
-			Region:   droplet.Region.Slug,
-			Tags:     strings.Join(droplet.Tags, ","),
-		})
+		droplet := droplet
+		result = append(result, *dropletToResourceResults(&droplet))
 	}
 	return result, nil
 }
 
 // UpdateServer updates a Minecraft server on DigitalOcean.
 func (d *DigitalOcean) UpdateServer(id string, args automation.ServerArgs) error {
+	return d.UpdateServerContext(context.Background(), id, args)
+}
+
+// UpdateServerContext is like UpdateServer but honors the cancellation and deadline of ctx.
+func (d *DigitalOcean) UpdateServerContext(ctx context.Context, id string, args automation.ServerArgs) error {
 	intID, err := strconv.Atoi(id)
 	if err != nil {
 		return err
 	}
-	droplet, _, err := d.client.Droplets.Get(context.Background(), intID)
+	droplet, _, err := d.client.Droplets.Get(ctx, intID)
 	if err != nil {
 		return err
 	}
 	ipv4, _ := droplet.PublicIPv4()
-	remoteCommand := update.NewRemoteServer(args.SSHPrivateKeyPath, ipv4, "root")
-	err = remoteCommand.UpdateServer(args.MinecraftResource)
+	remoteCommand := update.NewRemoteServerFromArgs(args, ipv4, sshLogin).WithTemplateOptions(d.templateOptions...)
+	defer func() { _ = remoteCommand.Close() }()
+	_, err = remoteCommand.UpdateServerStream(ctx, args.MinecraftResource, update.ExecOptions{})
 	if err != nil {
 		return err
 	}
@@ -97,6 +161,11 @@ func (d *DigitalOcean) UpdateServer(id string, args automation.ServerArgs) error
 
 // CreateServer creates a new Minecraft server on DigitalOcean.
 func (d *DigitalOcean) CreateServer(args automation.ServerArgs) (*automation.ResourceResults, error) {
+	return d.CreateServerContext(context.Background(), args)
+}
+
+// CreateServerContext is like CreateServer but honors the cancellation and deadline of ctx.
+func (d *DigitalOcean) CreateServerContext(ctx context.Context, args automation.ServerArgs) (*automation.ResourceResults, error) {
 	publicKey, err := cloud.GetSSHPublicKey(args)
 	if err != nil {
 		return nil, err
@@ -105,30 +174,21 @@ func (d *DigitalOcean) CreateServer(args automation.ServerArgs) (*automation.Res
 		Name:      fmt.Sprintf("%s-ssh", args.MinecraftResource.GetName()),
 		PublicKey: *publicKey,
 	}
-	key, _, err := d.client.Keys.Create(context.Background(), keyRequest)
+	key, _, err := d.client.Keys.Create(ctx, keyRequest)
 	if err != nil {
 		return nil, err
 	}
 
-	var volume *godo.Volume
-
 	var mount string
-	if args.MinecraftResource.GetVolumeSize() > 0 {
-		volumeRequest := &godo.VolumeCreateRequest{
-			Name:           fmt.Sprintf("%s-vol", args.MinecraftResource.GetName()),
-			Region:         args.MinecraftResource.GetRegion(),
-			Description:    "volume for storing the minecraft data",
-			FilesystemType: "ext4",
-			SizeGigaBytes:  int64(args.MinecraftResource.GetVolumeSize()),
-		}
-		volume, _, err = d.client.Storage.CreateVolume(context.Background(), volumeRequest)
-		if err != nil {
-			return nil, err
-		}
+	volume, err := d.volume(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	if volume != nil {
 		mount = "sda"
 	}
 
-	userData, err := d.tmpl.GetTemplate(args.MinecraftResource, &minctlTemplate.CreateUpdateTemplateArgs{Mount: mount, Name: minctlTemplate.GetTemplateCloudConfigName(args.MinecraftResource.IsProxyServer())})
+	userData, err := d.tmpl.GetTemplateContext(ctx, args.MinecraftResource, &minctlTemplate.CreateUpdateTemplateArgs{Mount: mount, SSHHostKey: args.SSHHostKey, Name: minctlTemplate.GetTemplateCloudConfigName(args.MinecraftResource.IsProxyServer())})
 	if err != nil {
 		return nil, err
 	}
@@ -146,9 +206,9 @@ func (d *DigitalOcean) CreateServer(args automation.ServerArgs) (*automation.Res
 			Slug: "ubuntu-22-04-x64",
 		},
 		UserData: userData,
-		Tags:     []string{common.InstanceTag, args.MinecraftResource.GetEdition()},
+		Tags:     automation.ServerTags(args),
 	}
-	if args.MinecraftResource.GetVolumeSize() > 0 {
+	if volume != nil {
 		createRequest.Volumes = []godo.DropletCreateVolume{
 			{
 				ID: volume.ID,
@@ -156,73 +216,133 @@ func (d *DigitalOcean) CreateServer(args automation.ServerArgs) (*automation.Res
 		}
 	}
 
-	droplet, _, err := d.client.Droplets.Create(context.Background(), createRequest)
+	droplet, _, err := d.client.Droplets.Create(ctx, createRequest)
 	if err != nil {
 		return nil, err
 	}
 
 	stillCreating := true
 	for stillCreating {
-		droplet, _, err = d.client.Droplets.Get(context.Background(), droplet.ID)
+		droplet, _, err = d.client.Droplets.Get(ctx, droplet.ID)
 		if err != nil {
 			return nil, err
 		}
 		if droplet.Status == "active" {
 			stillCreating = false
-		} else {
-			time.Sleep(2 * time.Second)
+		} else if err := common.Sleep(ctx, 2*time.Second); err != nil {
+			return nil, err
 		}
 	}
-	ipv4, _ := droplet.PublicIPv4()
+	return dropletToResourceResults(droplet), err
+}
+
+// volume returns the volume retained from a deleted droplet with the same name,
+// or creates a new one, from args.VolumeSnapshotID if set. A retained volume
+// is not replaced by the snapshot, restoring fails while it exists. It
+// returns nil if the server has no volume.
+func (d *DigitalOcean) volume(ctx context.Context, args automation.ServerArgs) (*godo.Volume, error) {
+	name := fmt.Sprintf("%s-vol", args.MinecraftResource.GetName())
+	volumeSize := args.MinecraftResource.GetVolumeSize()
+	if volumeSize == 0 && args.VolumeSnapshotID == "" {
+		return nil, nil
+	}
+	volumes, _, err := d.client.Storage.ListVolumes(ctx, &godo.ListVolumeParams{
+		Name:   name,
+		Region: args.MinecraftResource.GetRegion(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(volumes) > 0 {
+		if len(volumes[0].DropletIDs) > 0 {
+			return nil, fmt.Errorf("volume %s is attached to another droplet", name)
+		}
+		if args.VolumeSnapshotID != "" {
+			// the restored volume would get the same name
+			return nil, fmt.Errorf("volume %s retained from a deleted droplet already exists, delete it to restore volume snapshot %s", name, args.VolumeSnapshotID)
+		}
+		zap.S().Infow("Attaching existing volume", "name", args.MinecraftResource.GetName(), "volume", name)
+		return &volumes[0], nil
+	}
+	if args.VolumeSnapshotID != "" {
+		snapshot, _, err := d.client.Snapshots.Get(ctx, args.VolumeSnapshotID)
+		if err != nil {
+			return nil, err
+		}
+		volumeSize = max(volumeSize, snapshot.MinDiskSize)
+	}
+	volumeRequest := &godo.VolumeCreateRequest{
+		Name:           name,
+		Region:         args.MinecraftResource.GetRegion(),
+		Description:    "volume for storing the minecraft data",
+		FilesystemType: "ext4",
+		SizeGigaBytes:  int64(volumeSize),
+	}
+	if args.VolumeSnapshotID != "" {
+		// the volume keeps the file system of the snapshot
+		volumeRequest.SnapshotID = args.VolumeSnapshotID
+		volumeRequest.FilesystemType = ""
+	}
+	volume, _, err := d.client.Storage.CreateVolume(ctx, volumeRequest)
+	return volume, err
+}
 
-	return &automation.ResourceResults{
-		ID:       strconv.Itoa(droplet.ID),
-		Name:     droplet.Name,
-		Region:   droplet.Region.Slug,
-		PublicIP: ipv4,
-		Tags:     strings.Join(droplet.Tags, ","),
-	}, err
+// SSHLogin returns the user minectl logs in as on the servers, see
+// update.LoginProvider.
+func (d *DigitalOcean) SSHLogin() update.Login {
+	return sshLogin
 }
 
 // DeleteServer deletes a Minecraft server on DigitalOcean.
 func (d *DigitalOcean) DeleteServer(id string, args automation.ServerArgs) error {
-	list, _, err := d.client.Keys.List(context.Background(), nil)
+	return d.DeleteServerContext(context.Background(), id, args)
+}
+
+// DeleteServerContext is like DeleteServer but honors the cancellation and deadline of ctx.
+func (d *DigitalOcean) DeleteServerContext(ctx context.Context, id string, args automation.ServerArgs) error {
+	list, _, err := d.client.Keys.List(ctx, nil)
 	if err != nil {
 		return err
 	}
 	for _, key := range list {
 		if key.Name == fmt.Sprintf("%s-ssh", args.MinecraftResource.GetName()) {
-			_, err := d.client.Keys.DeleteByID(context.Background(), key.ID)
+			_, err := d.client.Keys.DeleteByID(ctx, key.ID)
 			if err != nil {
 				return err
 			}
 		}
 	}
 	intID, _ := strconv.Atoi(id)
-	_, err = d.client.Droplets.Delete(context.Background(), intID)
+	_, err = d.client.Droplets.Delete(ctx, intID)
 	if err != nil {
 		return err
 	}
 	stillDeleting := true
 
 	for stillDeleting {
-		_, _, err := d.client.Droplets.Get(context.Background(), intID)
+		_, _, err := d.client.Droplets.Get(ctx, intID)
 		if err != nil {
 			stillDeleting = false
-			time.Sleep(15 * time.Second)
-		} else {
-			time.Sleep(2 * time.Second)
+			if err := common.Sleep(ctx, 15*time.Second); err != nil {
+				return err
+			}
+		} else if err := common.Sleep(ctx, 2*time.Second); err != nil {
+			return err
 		}
 	}
 
-	volumes, _, err := d.client.Storage.ListVolumes(context.Background(), &godo.ListVolumeParams{
+	if args.MinecraftResource.RetainVolume() {
+		zap.S().Infow("Keeping volume", "name", args.MinecraftResource.GetName(), "volume", fmt.Sprintf("%s-vol", args.MinecraftResource.GetName()))
+		return nil
+	}
+	volumes, _, err := d.client.Storage.ListVolumes(ctx, &godo.ListVolumeParams{
 		Name: fmt.Sprintf("%s-vol", args.MinecraftResource.GetName()),
 	})
 	if err != nil {
 		return err
 	}
 	for _, volume := range volumes {
-		_, err = d.client.Storage.DeleteVolume(context.Background(), volume.ID)
+		_, err = d.client.Storage.DeleteVolume(ctx, volume.ID)
 		if err != nil {
 			return err
 		}
@@ -232,22 +352,24 @@ func (d *DigitalOcean) DeleteServer(id string, args automation.ServerArgs) error
 
 // UploadPlugin uploads a plugin to a Minecraft server on DigitalOcean.
 func (d *DigitalOcean) UploadPlugin(id string, args automation.ServerArgs, plugin, destination string) error {
+	return d.UploadPluginContext(context.Background(), id, args, plugin, destination)
+}
+
+// UploadPluginContext is like UploadPlugin but honors the cancellation and deadline of ctx.
+func (d *DigitalOcean) UploadPluginContext(ctx context.Context, id string, args automation.ServerArgs, plugin, destination string) error {
 	intID, err := strconv.Atoi(id)
 	if err != nil {
 		return err
 	}
-	droplet, _, err := d.client.Droplets.Get(context.Background(), intID)
+	droplet, _, err := d.client.Droplets.Get(ctx, intID)
 	if err != nil {
 		return err
 	}
 	ipv4, _ := droplet.PublicIPv4()
-	remoteCommand := update.NewRemoteServer(args.SSHPrivateKeyPath, ipv4, "root")
+	remoteCommand := update.NewRemoteServerFromArgs(args, ipv4, sshLogin)
+	defer func() { _ = remoteCommand.Close() }()
 
-	err = remoteCommand.TransferFile(plugin, filepath.Join(destination, filepath.Base(plugin)), args.MinecraftResource.GetSSHPort())
-	if err != nil {
-		return err
-	}
-	_, err = remoteCommand.ExecuteCommand("systemctl restart minecraft.service", args.MinecraftResource.GetSSHPort())
+	err = remoteCommand.UploadPluginContext(ctx, plugin, destination, args.MinecraftResource.GetSSHPort())
 	if err != nil {
 		return err
 	}
@@ -255,21 +377,98 @@ func (d *DigitalOcean) UploadPlugin(id string, args automation.ServerArgs, plugi
 }
 
 // GetServer gets a Minecraft server on DigitalOcean.
-func (d *DigitalOcean) GetServer(id string, _ automation.ServerArgs) (*automation.ResourceResults, error) {
+func (d *DigitalOcean) GetServer(id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
+	return d.GetServerContext(context.Background(), id, args)
+}
+
+// GetServerContext is like GetServer but honors the cancellation and deadline of ctx.
+func (d *DigitalOcean) GetServerContext(ctx context.Context, id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
 	intID, err := strconv.Atoi(id)
 	if err != nil {
 		return nil, err
 	}
-	droplet, _, err := d.client.Droplets.Get(context.Background(), intID)
+	droplet, _, err := d.client.Droplets.Get(ctx, intID)
 	if err != nil {
 		return nil, err
 	}
-	ipv4, _ := droplet.PublicIPv4()
-	return &automation.ResourceResults{
-		ID:       strconv.Itoa(droplet.ID),
-		Name:     droplet.Name,
-		Region:   droplet.Region.Slug,
-		PublicIP: ipv4,
-		Tags:     strings.Join(droplet.Tags, ","),
-	}, err
+	return dropletToResourceResults(droplet), err
+}
+
+func snapshotToVolumeSnapshot(snapshot *godo.Snapshot) *automation.VolumeSnapshot {
+	result := &automation.VolumeSnapshot{
+		ID:     snapshot.ID,
+		Name:   snapshot.Name,
+		SizeGB: snapshot.MinDiskSize,
+		Labels: automation.LabelsFromTags(snapshot.Tags),
+	}
+	// the names are <server>-vol-<time>, see CreateVolumeSnapshot
+	if i := strings.LastIndex(snapshot.Name, "-vol-"); i > 0 {
+		result.Server = snapshot.Name[:i]
+	}
+	if len(snapshot.Regions) > 0 {
+		result.Region = snapshot.Regions[0]
+	}
+	if created, err := time.Parse(time.RFC3339, snapshot.Created); err == nil {
+		result.CreatedAt = created
+	}
+	return result
+}
+
+// CreateVolumeSnapshot snapshots the volume of a Minecraft server on DigitalOcean.
+// DigitalOcean keeps volume snapshots in the region of the volume.
+func (d *DigitalOcean) CreateVolumeSnapshot(ctx context.Context, id string, args automation.ServerArgs) (*automation.VolumeSnapshot, error) {
+	intID, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, err
+	}
+	droplet, _, err := d.client.Droplets.Get(ctx, intID)
+	if err != nil {
+		return nil, err
+	}
+	if len(droplet.VolumeIDs) == 0 {
+		return nil, fmt.Errorf("droplet %s has no volume", droplet.Name)
+	}
+	snapshot, _, err := d.client.Storage.CreateSnapshot(ctx, &godo.SnapshotCreateRequest{
+		VolumeID:    droplet.VolumeIDs[0],
+		Name:        fmt.Sprintf("%s-vol-%s", droplet.Name, time.Now().UTC().Format("20060102-150405")),
+		Description: "snapshot of the minecraft data",
+		Tags:        automation.ServerTags(args),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snapshotToVolumeSnapshot(snapshot), nil
+}
+
+// ListVolumeSnapshots lists all volume snapshots of Minecraft servers on DigitalOcean.
+func (d *DigitalOcean) ListVolumeSnapshots(ctx context.Context) ([]automation.VolumeSnapshot, error) {
+	var result []automation.VolumeSnapshot
+	opt := &godo.ListOptions{PerPage: 200}
+	for {
+		snapshots, resp, err := d.client.Snapshots.ListVolume(ctx, opt)
+		if err != nil {
+			return nil, err
+		}
+		for _, snapshot := range snapshots {
+			if !slices.Contains(snapshot.Tags, common.InstanceTag) {
+				continue
+			}
+			result = append(result, *snapshotToVolumeSnapshot(&snapshot))
+		}
+		if resp.Links == nil || resp.Links.IsLastPage() {
+			break
+		}
+		page, err := resp.Links.CurrentPage()
+		if err != nil {
+			return nil, err
+		}
+		opt.Page = page + 1
+	}
+	return result, nil
+}
+
+// DeleteVolumeSnapshot deletes a volume snapshot on DigitalOcean.
+func (d *DigitalOcean) DeleteVolumeSnapshot(ctx context.Context, snapshotID string) error {
+	_, err := d.client.Storage.DeleteSnapshot(ctx, snapshotID)
+	return err
 }
@@ -4,22 +4,28 @@ package exoscale
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
-	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/dirien/minectl-sdk/automation"
 	"github.com/dirien/minectl-sdk/cloud"
+	"github.com/dirien/minectl-sdk/common"
+	"github.com/dirien/minectl-sdk/model"
 	minctlTemplate "github.com/dirien/minectl-sdk/template"
 	"github.com/dirien/minectl-sdk/update"
-	"github.com/exoscale/egoscale"
 	v2 "github.com/exoscale/egoscale/v2"
+	exoapi "github.com/exoscale/egoscale/v2/api"
+	"github.com/exoscale/egoscale/v2/oapi"
 	"github.com/hashicorp/go-cleanhttp"
 )
 
+// sshLogin is the user minectl logs in as on Exoscale servers.
+var sshLogin = update.RootLogin
+
 var userAgent string
 
 // String returns a pointer to the given string.
@@ -29,9 +35,9 @@ func String(v string) *string {
 
 // Exoscale implements the Automation interface for Exoscale.
 type Exoscale struct {
-	client   *egoscale.Client
-	clientv2 *v2.Client
-	tmpl     *minctlTemplate.Template
+	clientv2        *v2.Client
+	tmpl            *minctlTemplate.Template
+	templateOptions []minctlTemplate.Option
 }
 
 type defaultTransport struct {
@@ -49,133 +55,176 @@ func (t *defaultTransport) RoundTrip(req *http.Request) (*http.Response, error)
 	return resp, nil
 }
 
+func init() {
+	cloud.Register(cloud.Provider{
+		Code: model.ProviderExoscale,
+		Credentials: []cloud.CredentialField{
+			{Name: "apiKey", EnvVar: "EXOSCALE_API_KEY", Description: "Exoscale API key", Required: true},
+			{Name: "apiSecret", EnvVar: "EXOSCALE_API_SECRET", Description: "Exoscale API secret", Required: true},
+		},
+		Factory: func(_ context.Context, creds cloud.Credentials, opts []minctlTemplate.Option) (automation.Automation, error) {
+			return NewExoscale(creds["apiKey"], creds["apiSecret"], opts...)
+		},
+	})
+}
+
 // NewExoscale creates a new Exoscale instance.
-func NewExoscale(apiKey, apiSecret string) (*Exoscale, error) {
+func NewExoscale(apiKey, apiSecret string, opts ...minctlTemplate.Option) (*Exoscale, error) {
+	return newExoscale(apiKey, apiSecret, opts)
+}
+
+// newExoscale creates a new Exoscale instance, clientOpts override the
+// defaults of the API client.
+func newExoscale(apiKey, apiSecret string, opts []minctlTemplate.Option, clientOpts ...v2.ClientOpt) (*Exoscale, error) {
 	httpClient := cleanhttp.DefaultPooledClient()
 	httpClient.Transport = &defaultTransport{next: httpClient.Transport}
 
-	client := egoscale.NewClient("https://api.exoscale.com/v1", apiKey, apiSecret,
-		egoscale.WithHTTPClient(httpClient),
-		egoscale.WithoutV2Client())
-
-	clientv2, err := v2.NewClient(apiKey, apiSecret,
+	clientv2, err := v2.NewClient(apiKey, apiSecret, append([]v2.ClientOpt{
 		v2.ClientOptWithAPIEndpoint("https://api.exoscale.com/v1"),
-		v2.ClientOptWithTimeout(5*time.Minute))
+		v2.ClientOptWithHTTPClient(httpClient),
+		v2.ClientOptWithTimeout(5 * time.Minute),
+	}, clientOpts...)...)
 	if err != nil {
 		return nil, err
 	}
-	tmpl, err := minctlTemplate.NewTemplateCloudConfig()
+	tmpl, err := minctlTemplate.NewTemplateCloudConfig(opts...)
 	if err != nil {
 		return nil, err
 	}
 	es := &Exoscale{
-		client:   client,
-		clientv2: clientv2,
-		tmpl:     tmpl,
+		clientv2:        clientv2,
+		tmpl:            tmpl,
+		templateOptions: opts,
 	}
 	return es, nil
 }
 
-// CreateServer creates a new Minecraft server on Exoscale.
-func (e *Exoscale) CreateServer(args automation.ServerArgs) (*automation.ResourceResults, error) {
-	ctx := context.Background()
-
+func (e *Exoscale) authorizeIngress(ctx context.Context, zone string, securityGroup *v2.SecurityGroup, protocol string, port int) error {
 	_, cidr, err := net.ParseCIDR("0.0.0.0/0")
 	if err != nil {
-		return nil, err
+		return err
 	}
+	_, err = e.clientv2.CreateSecurityGroupRule(ctx, zone, securityGroup, &v2.SecurityGroupRule{
+		FlowDirection: String("ingress"),
+		Protocol:      String(protocol),
+		Network:       cidr,
+		StartPort:     uint16Ptr(port),
+		EndPort:       uint16Ptr(port),
+	})
+	return err
+}
+
+func uint16Ptr(v int) *uint16 {
+	port := uint16(v) //nolint:gosec // port is validated
+	return &port
+}
 
-	_, err = e.client.Request(egoscale.CreateSecurityGroup{
-		Name: fmt.Sprintf("%s-sg", args.MinecraftResource.GetName()),
+func exoscaleLabelsToTags(labels *map[string]string) string {
+	if labels == nil {
+		return ""
+	}
+	var tags []string
+	for key := range *labels {
+		tags = append(tags, key)
+	}
+	return strings.Join(tags, ",")
+}
+
+func exoscaleStatus(state *string) automation.Status {
+	if state == nil {
+		return automation.StatusUnknown
+	}
+	switch oapi.InstanceState(*state) {
+	case oapi.InstanceStateStarting, oapi.InstanceStateMigrating:
+		return automation.StatusPending
+	case oapi.InstanceStateRunning:
+		return automation.StatusRunning
+	case oapi.InstanceStateStopping:
+		return automation.StatusStopping
+	case oapi.InstanceStateStopped:
+		return automation.StatusStopped
+	case oapi.InstanceStateDestroying, oapi.InstanceStateExpunging:
+		return automation.StatusDeleting
+	default:
+		return automation.StatusUnknown
+	}
+}
+
+func exoscaleInstanceToResourceResults(instance *v2.Instance) automation.ResourceResults {
+	result := automation.ResourceResults{
+		ID:     *instance.ID,
+		Name:   *instance.Name,
+		Region: *instance.Zone,
+		Tags:   exoscaleLabelsToTags(instance.Labels),
+		Status: exoscaleStatus(instance.State),
+		Arch:   automation.ArchAMD64,
+	}
+	if instance.PublicIPAddress != nil {
+		result.PublicIP = instance.PublicIPAddress.String()
+	}
+	if instance.IPv6Address != nil {
+		result.PublicIPv6 = instance.IPv6Address.String()
+	}
+	if instance.CreatedAt != nil {
+		result.CreatedAt = *instance.CreatedAt
+	}
+	if instance.Labels != nil {
+		result.Labels = *instance.Labels
+		result.Edition = automation.EditionFromLabels(result.Labels)
+		result.Version = automation.VersionFromLabels(result.Labels)
+	}
+	return result
+}
+
+// CreateServer creates a new Minecraft server on Exoscale.
+func (e *Exoscale) CreateServer(args automation.ServerArgs) (*automation.ResourceResults, error) {
+	return e.CreateServerContext(context.Background(), args)
+}
+
+// CreateServerContext is like CreateServer but honors the cancellation and deadline of ctx.
+func (e *Exoscale) CreateServerContext(ctx context.Context, args automation.ServerArgs) (*automation.ResourceResults, error) {
+	zone := args.MinecraftResource.GetRegion()
+	securityGroup, err := e.clientv2.CreateSecurityGroup(ctx, zone, &v2.SecurityGroup{
+		Name: String(fmt.Sprintf("%s-sg", args.MinecraftResource.GetName())),
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = e.client.Request(egoscale.AuthorizeSecurityGroupIngress{
-		SecurityGroupName: fmt.Sprintf("%s-sg", args.MinecraftResource.GetName()),
-		Protocol:          "TCP",
-		StartPort:         uint16(args.MinecraftResource.GetSSHPort()), //nolint:gosec // port is validated
-		EndPort:           uint16(args.MinecraftResource.GetSSHPort()), //nolint:gosec // port is validated
-		CIDRList: []egoscale.CIDR{
-			{
-				IPNet: *cidr,
-			},
-		},
-	})
+	err = e.authorizeIngress(ctx, zone, securityGroup, "tcp", args.MinecraftResource.GetSSHPort())
 	if err != nil {
 		return nil, err
 	}
-	if args.MinecraftResource.GetEdition() == "bedrock" || args.MinecraftResource.GetEdition() == "nukkit" || args.MinecraftResource.GetEdition() == "powernukkit" {
-		_, err = e.client.Request(egoscale.AuthorizeSecurityGroupIngress{
-			SecurityGroupName: fmt.Sprintf("%s-sg", args.MinecraftResource.GetName()),
-			Protocol:          "UDP",
-			StartPort:         uint16(args.MinecraftResource.GetPort()), //nolint:gosec // port is validated
-			EndPort:           uint16(args.MinecraftResource.GetPort()), //nolint:gosec // port is validated
-			CIDRList: []egoscale.CIDR{
-				{
-					IPNet: *cidr,
-				},
-			},
-		})
+	if args.MinecraftResource.GetProtocol() == model.ProtocolUDP {
+		err = e.authorizeIngress(ctx, zone, securityGroup, "udp", args.MinecraftResource.GetPort())
 		if err != nil {
 			return nil, err
 		}
 	} else {
-		_, err = e.client.Request(egoscale.AuthorizeSecurityGroupIngress{
-			SecurityGroupName: fmt.Sprintf("%s-sg", args.MinecraftResource.GetName()),
-			Protocol:          "TCP",
-			StartPort:         uint16(args.MinecraftResource.GetPort()), //nolint:gosec // port is validated
-			EndPort:           uint16(args.MinecraftResource.GetPort()), //nolint:gosec // port is validated
-			CIDRList: []egoscale.CIDR{
-				{
-					IPNet: *cidr,
-				},
-			},
-		})
+		err = e.authorizeIngress(ctx, zone, securityGroup, "tcp", args.MinecraftResource.GetPort())
 		if err != nil {
 			return nil, err
 		}
 		if args.MinecraftResource.HasRCON() {
-			_, err = e.client.Request(egoscale.AuthorizeSecurityGroupIngress{
-				SecurityGroupName: fmt.Sprintf("%s-sg", args.MinecraftResource.GetName()),
-				Protocol:          "TCP",
-				StartPort:         uint16(args.MinecraftResource.GetRCONPort()), //nolint:gosec // port is validated
-				EndPort:           uint16(args.MinecraftResource.GetRCONPort()), //nolint:gosec // port is validated
-				CIDRList: []egoscale.CIDR{
-					{
-						IPNet: *cidr,
-					},
-				},
-			})
+			err = e.authorizeIngress(ctx, zone, securityGroup, "tcp", args.MinecraftResource.GetRCONPort())
 			if err != nil {
 				return nil, err
 			}
 		}
 	}
 	if args.MinecraftResource.HasMonitoring() {
-		_, err = e.client.Request(egoscale.AuthorizeSecurityGroupIngress{
-			SecurityGroupName: fmt.Sprintf("%s-sg", args.MinecraftResource.GetName()),
-			Protocol:          "TCP",
-			StartPort:         uint16(9090),
-			EndPort:           uint16(9090),
-			CIDRList: []egoscale.CIDR{
-				{
-					IPNet: *cidr,
-				},
-			},
-		})
+		err = e.authorizeIngress(ctx, zone, securityGroup, "tcp", 9090)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	script, err := e.tmpl.GetTemplate(args.MinecraftResource, &minctlTemplate.CreateUpdateTemplateArgs{Name: minctlTemplate.GetTemplateCloudConfigName(args.MinecraftResource.IsProxyServer())})
+	script, err := e.tmpl.GetTemplateContext(ctx, args.MinecraftResource, &minctlTemplate.CreateUpdateTemplateArgs{SSHHostKey: args.SSHHostKey, Name: minctlTemplate.GetTemplateCloudConfigName(args.MinecraftResource.IsProxyServer())})
 	if err != nil {
 		return nil, err
 	}
 
-	listTemplates, err := e.clientv2.ListTemplates(ctx, args.MinecraftResource.GetRegion(), v2.ListTemplatesWithFamily("ubuntu"))
+	listTemplates, err := e.clientv2.ListTemplates(ctx, zone, v2.ListTemplatesWithFamily("ubuntu"))
 	if err != nil {
 		return nil, err
 	}
@@ -186,7 +235,7 @@ func (e *Exoscale) CreateServer(args automation.ServerArgs) (*automation.Resourc
 			break
 		}
 	}
-	instanceTypes, err := e.clientv2.ListInstanceTypes(ctx, args.MinecraftResource.GetRegion())
+	instanceTypes, err := e.clientv2.ListInstanceTypes(ctx, zone)
 	if err != nil {
 		return nil, err
 	}
@@ -198,116 +247,120 @@ func (e *Exoscale) CreateServer(args automation.ServerArgs) (*automation.Resourc
 		}
 	}
 
-	zones, err := e.client.ListWithContext(ctx, &egoscale.Zone{})
-	if err != nil {
-		return nil, err
-	}
-	var zoneID *egoscale.UUID
-	for _, value := range zones {
-		zone := value.(*egoscale.Zone)
-		if zone.Name == args.MinecraftResource.GetRegion() {
-			zoneID = zone.ID
-			break
-		}
-	}
-
 	publicKey, err := cloud.GetSSHPublicKey(args)
 	if err != nil {
 		return nil, err
 	}
-	sshPubKey, err := e.clientv2.RegisterSSHKey(ctx, args.MinecraftResource.GetRegion(), fmt.Sprintf("%s-ssh", args.MinecraftResource.GetName()), *publicKey)
+	sshPubKey, err := e.clientv2.RegisterSSHKey(ctx, zone, fmt.Sprintf("%s-ssh", args.MinecraftResource.GetName()), *publicKey)
 	if err != nil {
 		return nil, err
 	}
 
-	groups, err := e.clientv2.ListSecurityGroups(ctx, args.MinecraftResource.GetRegion())
-	if err != nil {
-		return nil, err
-	}
-	var groupID string
-	for _, group := range groups {
-		if *group.Name == fmt.Sprintf("%s-sg", args.MinecraftResource.GetName()) {
-			groupID = *group.ID
-			break
-		}
-	}
-
-	virtualMachine := egoscale.DeployVirtualMachine{
-		Name:              args.MinecraftResource.GetName(),
-		TemplateID:        egoscale.MustParseUUID(templateID),
-		ServiceOfferingID: egoscale.MustParseUUID(instanceTypeID),
-		RootDiskSize:      50,
-		SecurityGroupIDs: []egoscale.UUID{
-			*egoscale.MustParseUUID(groupID),
-		},
-		UserData: base64.StdEncoding.EncodeToString([]byte(script)),
-		KeyPair:  *sshPubKey.Name,
-		ZoneID:   zoneID,
-	}
-	resp, err := e.client.Request(virtualMachine)
+	diskSize := int64(50)
+	labels := automation.ServerLabels(args)
+	instance, err := e.clientv2.CreateInstance(ctx, zone, &v2.Instance{
+		Name:             String(args.MinecraftResource.GetName()),
+		TemplateID:       String(templateID),
+		InstanceTypeID:   String(instanceTypeID),
+		DiskSize:         &diskSize,
+		SecurityGroupIDs: &[]string{*securityGroup.ID},
+		UserData:         String(base64.StdEncoding.EncodeToString([]byte(script))),
+		SSHKey:           sshPubKey.Name,
+		Labels:           &labels,
+	})
 	if err != nil {
 		return nil, err
 	}
+	result := exoscaleInstanceToResourceResults(instance)
+	return &result, nil
+}
 
-	vm := resp.(*egoscale.VirtualMachine)
-	return &automation.ResourceResults{
-		ID:       vm.ID.String(),
-		Name:     vm.Name,
-		Region:   vm.ZoneName,
-		PublicIP: vm.PublicIP,
-	}, err
+// SSHLogin returns the user minectl logs in as on the servers, see
+// update.LoginProvider.
+func (e *Exoscale) SSHLogin() update.Login {
+	return sshLogin
 }
 
 // DeleteServer deletes a Minecraft server on Exoscale.
 func (e *Exoscale) DeleteServer(id string, args automation.ServerArgs) error {
-	ctx := context.Background()
+	return e.DeleteServerContext(context.Background(), id, args)
+}
 
-	virtualMachine := egoscale.DestroyVirtualMachine{
-		ID: egoscale.MustParseUUID(id),
-	}
-	_, err := e.client.Request(virtualMachine)
+// DeleteServerContext is like DeleteServer but honors the cancellation and deadline of ctx.
+func (e *Exoscale) DeleteServerContext(ctx context.Context, id string, args automation.ServerArgs) error {
+	zone := args.MinecraftResource.GetRegion()
+	err := e.clientv2.DeleteInstance(ctx, zone, &v2.Instance{ID: String(id)})
 	if err != nil {
 		return err
 	}
-	securityGroups, err := e.client.ListWithContext(ctx, &egoscale.SecurityGroup{
-		Name: fmt.Sprintf("%s-sg", args.MinecraftResource.GetName()),
-	})
-	if err != nil || len(securityGroups) == 0 {
-		return err
-	}
-	securityGroup := securityGroups[0].(*egoscale.SecurityGroup)
 
-	err = e.clientv2.DeleteSecurityGroup(ctx, args.MinecraftResource.GetRegion(), &v2.SecurityGroup{
-		ID: String(securityGroup.ID.String()),
-	})
-	if err != nil {
+	// The security group and the SSH key are cleaned up independently, so a
+	// missing one does not leak the other.
+	securityGroup, err := e.clientv2.FindSecurityGroup(ctx, zone, fmt.Sprintf("%s-sg", args.MinecraftResource.GetName()))
+	switch {
+	case errors.Is(err, exoapi.ErrNotFound):
+	case err != nil:
 		return err
+	default:
+		err = e.clientv2.DeleteSecurityGroup(ctx, zone, securityGroup)
+		if err != nil {
+			return err
+		}
 	}
 
-	sshKey, err := e.clientv2.GetSSHKey(ctx, args.MinecraftResource.GetRegion(), fmt.Sprintf("%s-ssh", args.MinecraftResource.GetName()))
-	if err != nil {
-		return err
+	sshKey, err := e.clientv2.GetSSHKey(ctx, zone, fmt.Sprintf("%s-ssh", args.MinecraftResource.GetName()))
+	if errors.Is(err, exoapi.ErrNotFound) {
+		return nil
 	}
-	err = e.clientv2.DeleteSSHKey(ctx, args.MinecraftResource.GetRegion(), sshKey)
 	if err != nil {
 		return err
 	}
-	return nil
+	return e.clientv2.DeleteSSHKey(ctx, zone, sshKey)
 }
 
 // ListServer lists all Minecraft servers on Exoscale.
 func (e *Exoscale) ListServer() ([]automation.ResourceResults, error) {
-	panic("List Server is not possible with Exoscale, as it does not support labels in v1")
+	return e.ListServerContext(context.Background())
+}
+
+// ListServerContext is like ListServer but honors the cancellation and deadline of ctx.
+func (e *Exoscale) ListServerContext(ctx context.Context) ([]automation.ResourceResults, error) {
+	zones, err := e.clientv2.ListZones(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var result []automation.ResourceResults
+	for _, zone := range zones {
+		instances, err := e.clientv2.ListInstances(ctx, zone)
+		if err != nil {
+			return nil, err
+		}
+		for _, instance := range instances {
+			if instance.Labels == nil {
+				continue
+			}
+			if _, ok := (*instance.Labels)[common.InstanceTag]; ok {
+				result = append(result, exoscaleInstanceToResourceResults(instance))
+			}
+		}
+	}
+	return result, nil
 }
 
 // UpdateServer updates a Minecraft server on Exoscale.
 func (e *Exoscale) UpdateServer(id string, args automation.ServerArgs) error {
-	instance, err := e.GetServer(id, args)
+	return e.UpdateServerContext(context.Background(), id, args)
+}
+
+// UpdateServerContext is like UpdateServer but honors the cancellation and deadline of ctx.
+func (e *Exoscale) UpdateServerContext(ctx context.Context, id string, args automation.ServerArgs) error {
+	instance, err := e.GetServerContext(ctx, id, args)
 	if err != nil {
 		return err
 	}
-	remoteCommand := update.NewRemoteServer(args.SSHPrivateKeyPath, instance.PublicIP, "root")
-	err = remoteCommand.UpdateServer(args.MinecraftResource)
+	remoteCommand := update.NewRemoteServerFromArgs(args, instance.PublicIP, sshLogin).WithTemplateOptions(e.templateOptions...)
+	defer func() { _ = remoteCommand.Close() }()
+	_, err = remoteCommand.UpdateServerStream(ctx, args.MinecraftResource, update.ExecOptions{})
 	if err != nil {
 		return err
 	}
@@ -316,16 +369,18 @@ func (e *Exoscale) UpdateServer(id string, args automation.ServerArgs) error {
 
 // UploadPlugin uploads a plugin to a Minecraft server on Exoscale.
 func (e *Exoscale) UploadPlugin(id string, args automation.ServerArgs, plugin, destination string) error {
-	instance, err := e.GetServer(id, args)
-	if err != nil {
-		return err
-	}
-	remoteCommand := update.NewRemoteServer(args.SSHPrivateKeyPath, instance.PublicIP, "root")
-	err = remoteCommand.TransferFile(plugin, filepath.Join(destination, filepath.Base(plugin)), args.MinecraftResource.GetSSHPort())
+	return e.UploadPluginContext(context.Background(), id, args, plugin, destination)
+}
+
+// UploadPluginContext is like UploadPlugin but honors the cancellation and deadline of ctx.
+func (e *Exoscale) UploadPluginContext(ctx context.Context, id string, args automation.ServerArgs, plugin, destination string) error {
+	instance, err := e.GetServerContext(ctx, id, args)
 	if err != nil {
 		return err
 	}
-	_, err = remoteCommand.ExecuteCommand("systemctl restart minecraft.service", args.MinecraftResource.GetSSHPort())
+	remoteCommand := update.NewRemoteServerFromArgs(args, instance.PublicIP, sshLogin)
+	defer func() { _ = remoteCommand.Close() }()
+	err = remoteCommand.UploadPluginContext(ctx, plugin, destination, args.MinecraftResource.GetSSHPort())
 	if err != nil {
 		return err
 	}
@@ -334,17 +389,15 @@ func (e *Exoscale) UploadPlugin(id string, args automation.ServerArgs, plugin, d
 
 // GetServer gets a Minecraft server on Exoscale.
 func (e *Exoscale) GetServer(id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
-	ctx := context.Background()
+	return e.GetServerContext(context.Background(), id, args)
+}
 
+// GetServerContext is like GetServer but honors the cancellation and deadline of ctx.
+func (e *Exoscale) GetServerContext(ctx context.Context, id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
 	instance, err := e.clientv2.GetInstance(ctx, args.MinecraftResource.GetRegion(), id)
 	if err != nil {
 		return nil, err
 	}
-
-	return &automation.ResourceResults{
-		ID:       *instance.ID,
-		Name:     *instance.Name,
-		Region:   *instance.Zone,
-		PublicIP: instance.PublicIPAddress.String(),
-	}, err
+	result := exoscaleInstanceToResourceResults(instance)
+	return &result, nil
 }
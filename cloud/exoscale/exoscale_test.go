@@ -0,0 +1,312 @@
+package exoscale
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dirien/minectl-sdk/automation"
+	"github.com/dirien/minectl-sdk/common"
+	"github.com/dirien/minectl-sdk/internal/testutil"
+	"github.com/dirien/minectl-sdk/model"
+	v2 "github.com/exoscale/egoscale/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAPI is an in-memory Exoscale API for a single zone. Every operation
+// succeeds at once.
+type fakeAPI struct {
+	mu             sync.Mutex
+	next           int
+	securityGroups map[string]map[string]any
+	sshKeys        map[string]map[string]any
+	instances      map[string]map[string]any
+}
+
+func newFakeAPI(t *testing.T) (*fakeAPI, *Exoscale) {
+	t.Helper()
+	api := &fakeAPI{
+		securityGroups: map[string]map[string]any{},
+		sshKeys:        map[string]map[string]any{},
+		instances:      map[string]map[string]any{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v2/zone", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, map[string]any{"zones": []any{map[string]any{"name": "ch-gva-2"}}})
+	})
+	mux.HandleFunc("GET /v2/operation/{id}", func(w http.ResponseWriter, r *http.Request) {
+		ref := strings.SplitN(r.PathValue("id"), ":", 2)[1]
+		writeJSON(w, map[string]any{"id": r.PathValue("id"), "state": "success", "reference": map[string]any{"id": ref}})
+	})
+	mux.HandleFunc("GET /v2/template", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, map[string]any{"templates": []any{
+			map[string]any{"id": "tpl-2004", "name": "Linux Ubuntu 20.04 LTS 64-bit"},
+			map[string]any{"id": "tpl-2204", "name": "Linux Ubuntu 22.04 LTS 64-bit"},
+		}})
+	})
+	mux.HandleFunc("GET /v2/instance-type", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, map[string]any{"instance-types": []any{
+			map[string]any{"id": "it-tiny", "size": "tiny", "family": "standard"},
+			map[string]any{"id": "it-medium", "size": "medium", "family": "standard"},
+		}})
+	})
+	mux.HandleFunc("POST /v2/security-group", func(w http.ResponseWriter, r *http.Request) {
+		body := readJSON(t, r)
+		api.mu.Lock()
+		defer api.mu.Unlock()
+		id := api.id("sg")
+		api.securityGroups[id] = map[string]any{"id": id, "name": body["name"], "rules": []any{}}
+		writeOperation(w, id)
+	})
+	mux.HandleFunc("GET /v2/security-group", func(w http.ResponseWriter, _ *http.Request) {
+		api.mu.Lock()
+		defer api.mu.Unlock()
+		groups := []any{}
+		for _, sg := range api.securityGroups {
+			groups = append(groups, sg)
+		}
+		writeJSON(w, map[string]any{"security-groups": groups})
+	})
+	mux.HandleFunc("GET /v2/security-group/{id}", func(w http.ResponseWriter, r *http.Request) {
+		api.mu.Lock()
+		defer api.mu.Unlock()
+		writeResource(w, api.securityGroups[r.PathValue("id")])
+	})
+	mux.HandleFunc("DELETE /v2/security-group/{id}", func(w http.ResponseWriter, r *http.Request) {
+		api.mu.Lock()
+		defer api.mu.Unlock()
+		delete(api.securityGroups, r.PathValue("id"))
+		writeOperation(w, r.PathValue("id"))
+	})
+	mux.HandleFunc("POST /v2/security-group/{id}/rules", func(w http.ResponseWriter, r *http.Request) {
+		rule := readJSON(t, r)
+		api.mu.Lock()
+		defer api.mu.Unlock()
+		sg, ok := api.securityGroups[r.PathValue("id")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		rule["id"] = api.id("rule")
+		sg["rules"] = append(sg["rules"].([]any), rule)
+		writeOperation(w, r.PathValue("id"))
+	})
+	mux.HandleFunc("POST /v2/ssh-key", func(w http.ResponseWriter, r *http.Request) {
+		body := readJSON(t, r)
+		api.mu.Lock()
+		defer api.mu.Unlock()
+		name := body["name"].(string)
+		api.sshKeys[name] = map[string]any{"name": name, "fingerprint": "00:11"}
+		writeOperation(w, name)
+	})
+	mux.HandleFunc("GET /v2/ssh-key/{name}", func(w http.ResponseWriter, r *http.Request) {
+		api.mu.Lock()
+		defer api.mu.Unlock()
+		writeResource(w, api.sshKeys[r.PathValue("name")])
+	})
+	mux.HandleFunc("DELETE /v2/ssh-key/{name}", func(w http.ResponseWriter, r *http.Request) {
+		api.mu.Lock()
+		defer api.mu.Unlock()
+		delete(api.sshKeys, r.PathValue("name"))
+		writeOperation(w, r.PathValue("name"))
+	})
+	mux.HandleFunc("POST /v2/instance", func(w http.ResponseWriter, r *http.Request) {
+		body := readJSON(t, r)
+		api.mu.Lock()
+		defer api.mu.Unlock()
+		id := api.id("instance")
+		body["id"] = id
+		body["state"] = "running"
+		body["public-ip"] = "203.0.113.10"
+		body["created-at"] = "2024-01-02T03:04:05Z"
+		api.instances[id] = body
+		writeOperation(w, id)
+	})
+	mux.HandleFunc("GET /v2/instance", func(w http.ResponseWriter, _ *http.Request) {
+		api.mu.Lock()
+		defer api.mu.Unlock()
+		instances := []any{}
+		for _, instance := range api.instances {
+			instances = append(instances, instance)
+		}
+		writeJSON(w, map[string]any{"instances": instances})
+	})
+	mux.HandleFunc("GET /v2/instance/{id}", func(w http.ResponseWriter, r *http.Request) {
+		api.mu.Lock()
+		defer api.mu.Unlock()
+		writeResource(w, api.instances[r.PathValue("id")])
+	})
+	mux.HandleFunc("DELETE /v2/instance/{id}", func(w http.ResponseWriter, r *http.Request) {
+		api.mu.Lock()
+		defer api.mu.Unlock()
+		delete(api.instances, r.PathValue("id"))
+		writeOperation(w, r.PathValue("id"))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	e, err := newExoscale("key", "secret", nil,
+		v2.ClientOptWithAPIEndpoint(server.URL),
+		v2.ClientOptWithPollInterval(time.Millisecond))
+	require.NoError(t, err)
+	return api, e
+}
+
+// id returns a new ID, the caller holds the lock.
+func (a *fakeAPI) id(kind string) string {
+	a.next++
+	return fmt.Sprintf("%s-%d", kind, a.next)
+}
+
+func (a *fakeAPI) rules(t *testing.T) []string {
+	t.Helper()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var rules []string
+	for _, sg := range a.securityGroups {
+		for _, rule := range sg["rules"].([]any) {
+			rule := rule.(map[string]any)
+			rules = append(rules, fmt.Sprintf("%s/%v", rule["protocol"], rule["start-port"]))
+		}
+	}
+	return rules
+}
+
+func readJSON(t *testing.T, r *http.Request) map[string]any {
+	t.Helper()
+	var body map[string]any
+	require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+	return body
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeOperation answers with a finished operation, which refers to ref
+// through its ID.
+func writeOperation(w http.ResponseWriter, ref string) {
+	writeJSON(w, map[string]any{"id": "op:" + ref, "state": "success", "reference": map[string]any{"id": ref}})
+}
+
+func writeResource(w http.ResponseWriter, resource map[string]any) {
+	if resource == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"message":"not found"}`))
+		return
+	}
+	writeJSON(w, resource)
+}
+
+func makeServerArgs(t *testing.T, edition string) automation.ServerArgs {
+	t.Helper()
+	return testutil.ServerArgs(t, model.ProviderExoscale, "ch-gva-2", "medium", edition)
+}
+
+func TestCreateServer(t *testing.T) {
+	tests := []struct {
+		edition string
+		rules   []string
+	}{
+		{edition: "java", rules: []string{"tcp/22", "tcp/25565", "tcp/25575"}},
+		{edition: "bedrock", rules: []string{"tcp/22", "udp/19132"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.edition, func(t *testing.T) {
+			api, e := newFakeAPI(t)
+			args := makeServerArgs(t, tt.edition)
+
+			res, err := e.CreateServer(args)
+			require.NoError(t, err)
+			assert.Equal(t, "minecraft-server", res.Name)
+			assert.Equal(t, "ch-gva-2", res.Region)
+			assert.Equal(t, "203.0.113.10", res.PublicIP)
+			assert.Equal(t, automation.StatusRunning, res.Status)
+			assert.Equal(t, tt.edition, res.Edition)
+			assert.Equal(t, "1.20.4", res.Version)
+			assert.ElementsMatch(t, tt.rules, api.rules(t))
+
+			api.mu.Lock()
+			instance := api.instances[res.ID]
+			api.mu.Unlock()
+			require.NotNil(t, instance)
+			assert.Equal(t, map[string]any{"id": "tpl-2204"}, instance["template"])
+			assert.Equal(t, map[string]any{"id": "it-medium"}, instance["instance-type"])
+			assert.Equal(t, map[string]any{"name": "minecraft-server-ssh"}, instance["ssh-key"])
+			assert.Equal(t, "true", instance["labels"].(map[string]any)[common.InstanceTag])
+			userData, err := base64.StdEncoding.DecodeString(instance["user-data"].(string))
+			require.NoError(t, err)
+			assert.True(t, strings.HasPrefix(string(userData), "#cloud-config"))
+		})
+	}
+}
+
+func TestDeleteServer(t *testing.T) {
+	api, e := newFakeAPI(t)
+	args := makeServerArgs(t, "java")
+	res, err := e.CreateServer(args)
+	require.NoError(t, err)
+
+	require.NoError(t, e.DeleteServer(res.ID, args))
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	assert.Empty(t, api.instances)
+	assert.Empty(t, api.securityGroups)
+	assert.Empty(t, api.sshKeys)
+}
+
+func TestDeleteServerWithoutSecurityGroup(t *testing.T) {
+	api, e := newFakeAPI(t)
+	args := makeServerArgs(t, "java")
+	api.instances["instance-1"] = map[string]any{"id": "instance-1"}
+	api.sshKeys["minecraft-server-ssh"] = map[string]any{"name": "minecraft-server-ssh", "fingerprint": "00:11"}
+
+	require.NoError(t, e.DeleteServer("instance-1", args))
+	assert.Empty(t, api.instances)
+	assert.Empty(t, api.sshKeys)
+
+	api.instances["instance-2"] = map[string]any{"id": "instance-2"}
+	require.NoError(t, e.DeleteServer("instance-2", args))
+	assert.Empty(t, api.instances)
+}
+
+func TestListServer(t *testing.T) {
+	api, e := newFakeAPI(t)
+	args := makeServerArgs(t, "spigot")
+	res, err := e.CreateServer(args)
+	require.NoError(t, err)
+	api.mu.Lock()
+	api.instances["unrelated"] = map[string]any{
+		"id": "unrelated", "name": "unrelated", "state": "running",
+		"labels":        map[string]any{"team": "web"},
+		"instance-type": map[string]any{"id": "it-tiny"},
+		"template":      map[string]any{"id": "tpl-2204"},
+	}
+	api.instances["unlabeled"] = map[string]any{
+		"id": "unlabeled", "name": "unlabeled", "state": "stopped",
+		"instance-type": map[string]any{"id": "it-tiny"},
+		"template":      map[string]any{"id": "tpl-2204"},
+	}
+	api.mu.Unlock()
+
+	list, err := e.ListServer()
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, res.ID, list[0].ID)
+	assert.Equal(t, "minecraft-server", list[0].Name)
+	assert.Equal(t, "ch-gva-2", list[0].Region)
+	assert.Equal(t, "spigot", list[0].Edition)
+	assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), list[0].CreatedAt.UTC())
+
+	_, err = e.GetServer("missing", args)
+	require.Error(t, err)
+}
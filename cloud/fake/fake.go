@@ -0,0 +1,540 @@
+// Package fake implements the Automation interface with an in-memory cloud
+// provider, so code consuming automation.Automation can be tested offline.
+package fake
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dirien/minectl-sdk/automation"
+	"github.com/dirien/minectl-sdk/cloud"
+	"github.com/dirien/minectl-sdk/common"
+	minctlTemplate "github.com/dirien/minectl-sdk/template"
+)
+
+// ProviderFake is the code Register registers the fake provider under.
+const ProviderFake = "fake"
+
+// Method names used for call recording and failure/latency injection.
+const (
+	MethodCreateServer = "CreateServer"
+	MethodDeleteServer = "DeleteServer"
+	MethodListServer   = "ListServer"
+	MethodUpdateServer = "UpdateServer"
+	MethodUploadPlugin = "UploadPlugin"
+	MethodGetServer    = "GetServer"
+
+	MethodCreateVolumeSnapshot = "CreateVolumeSnapshot"
+	MethodListVolumeSnapshots  = "ListVolumeSnapshots"
+	MethodDeleteVolumeSnapshot = "DeleteVolumeSnapshot"
+	MethodCopyVolumeSnapshot   = "CopyVolumeSnapshot"
+)
+
+// Call records a single invocation of the fake provider.
+type Call struct {
+	Method      string
+	ID          string
+	Args        automation.ServerArgs
+	Plugin      string
+	Destination string
+}
+
+// Server is a server kept by the fake provider.
+type Server struct {
+	ID       string
+	Name     string
+	Region   string
+	Size     string
+	PublicIP string
+	Edition  string
+	Version  string
+	Labels   map[string]string
+	UserData string
+	Plugins  []string
+	// CreatedAt is the time CreateServer stored the server.
+	CreatedAt time.Time
+}
+
+// Volume is a block storage volume kept by the fake provider.
+type Volume struct {
+	Name     string
+	Size     int
+	ServerID string
+	// SnapshotID is the snapshot the volume was created from.
+	SnapshotID string
+}
+
+// SSHKey is an SSH public key kept by the fake provider.
+type SSHKey struct {
+	Name      string
+	PublicKey string
+}
+
+// FirewallRule is an inbound rule kept by the fake provider.
+type FirewallRule struct {
+	Protocol string
+	Port     int
+}
+
+// Fake implements the Automation interface in memory.
+type Fake struct {
+	tmpl *minctlTemplate.Template
+
+	mu        sync.Mutex
+	nextID    int
+	servers   map[string]*Server
+	volumes   map[string]*Volume
+	sshKeys   map[string]*SSHKey
+	firewalls map[string][]FirewallRule
+	snapshots map[string]*automation.VolumeSnapshot
+	calls     []Call
+	errs      map[string]error
+	latency   map[string]time.Duration
+}
+
+var registerOnce sync.Once
+
+// Register makes the fake provider available to cloud.New as ProviderFake.
+// It is not registered on import, so it does not show up next to the real
+// providers in cloud.Providers. Register may be called more than once.
+func Register() {
+	registerOnce.Do(func() {
+		cloud.Register(cloud.Provider{
+			Code:     ProviderFake,
+			FullName: "Fake",
+			Factory: func(_ context.Context, _ cloud.Credentials, opts []minctlTemplate.Option) (automation.Automation, error) {
+				return NewFake(opts...)
+			},
+		})
+	})
+}
+
+// NewFake creates a new, empty Fake instance.
+func NewFake(opts ...minctlTemplate.Option) (*Fake, error) {
+	tmpl, err := minctlTemplate.NewTemplateCloudConfig(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Fake{
+		tmpl:      tmpl,
+		servers:   map[string]*Server{},
+		volumes:   map[string]*Volume{},
+		sshKeys:   map[string]*SSHKey{},
+		firewalls: map[string][]FirewallRule{},
+		snapshots: map[string]*automation.VolumeSnapshot{},
+		errs:      map[string]error{},
+		latency:   map[string]time.Duration{},
+	}, nil
+}
+
+// InjectError makes every following call to method fail with err. Passing a
+// nil error removes the injected failure.
+func (f *Fake) InjectError(method string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		delete(f.errs, method)
+		return
+	}
+	f.errs[method] = err
+}
+
+// InjectLatency delays every following call to method by d.
+func (f *Fake) InjectLatency(method string, d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.latency[method] = d
+}
+
+// Calls returns all recorded calls in the order they were made.
+func (f *Fake) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Call(nil), f.calls...)
+}
+
+// Server returns a copy of the server with the given id.
+func (f *Fake) Server(id string) (Server, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	server, ok := f.servers[id]
+	if !ok {
+		return Server{}, false
+	}
+	return *server, true
+}
+
+// Volume returns a copy of the volume with the given name.
+func (f *Fake) Volume(name string) (Volume, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	volume, ok := f.volumes[name]
+	if !ok {
+		return Volume{}, false
+	}
+	return *volume, true
+}
+
+// SSHKey returns a copy of the SSH key with the given name.
+func (f *Fake) SSHKey(name string) (SSHKey, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key, ok := f.sshKeys[name]
+	if !ok {
+		return SSHKey{}, false
+	}
+	return *key, true
+}
+
+// FirewallRules returns the rules of the firewall with the given name.
+func (f *Fake) FirewallRules(name string) []FirewallRule {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FirewallRule(nil), f.firewalls[name]...)
+}
+
+// begin records the call and applies the injected latency and failure.
+func (f *Fake) begin(ctx context.Context, call Call) error {
+	f.mu.Lock()
+	f.calls = append(f.calls, call)
+	latency := f.latency[call.Method]
+	err := f.errs[call.Method]
+	f.mu.Unlock()
+	if latency > 0 {
+		if err := common.Sleep(ctx, latency); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// toResourceResults must be called with f.mu held.
+func (f *Fake) toResourceResults(s *Server) *automation.ResourceResults {
+	var tags []string
+	labels := make(map[string]string, len(s.Labels))
+	for key, value := range s.Labels {
+		tags = append(tags, key)
+		labels[key] = value
+	}
+	sort.Strings(tags)
+	var volumeIDs []string
+	for name, volume := range f.volumes {
+		if volume.ServerID == s.ID {
+			volumeIDs = append(volumeIDs, name)
+		}
+	}
+	sort.Strings(volumeIDs)
+	return &automation.ResourceResults{
+		ID:        s.ID,
+		Name:      s.Name,
+		Region:    s.Region,
+		PublicIP:  s.PublicIP,
+		Tags:      strings.Join(tags, ","),
+		Status:    automation.StatusRunning,
+		Size:      s.Size,
+		Arch:      automation.ArchAMD64,
+		CreatedAt: s.CreatedAt,
+		VolumeIDs: volumeIDs,
+		Edition:   s.Edition,
+		Version:   s.Version,
+		Labels:    labels,
+	}
+}
+
+func firewallRules(args automation.ServerArgs) []FirewallRule {
+	rules := []FirewallRule{
+		{Protocol: "tcp", Port: args.MinecraftResource.GetSSHPort()},
+		{Protocol: string(args.MinecraftResource.GetProtocol()), Port: args.MinecraftResource.GetPort()},
+	}
+	if args.MinecraftResource.HasRCON() {
+		rules = append(rules, FirewallRule{Protocol: "tcp", Port: args.MinecraftResource.GetRCONPort()})
+	}
+	return rules
+}
+
+// CreateServer creates a new Minecraft server in memory.
+func (f *Fake) CreateServer(args automation.ServerArgs) (*automation.ResourceResults, error) {
+	return f.CreateServerContext(context.Background(), args)
+}
+
+// CreateServerContext is like CreateServer but honors the cancellation and deadline of ctx.
+func (f *Fake) CreateServerContext(ctx context.Context, args automation.ServerArgs) (*automation.ResourceResults, error) {
+	if err := f.begin(ctx, Call{Method: MethodCreateServer, Args: args}); err != nil {
+		return nil, err
+	}
+	publicKey, err := cloud.GetSSHPublicKey(args)
+	if err != nil {
+		return nil, err
+	}
+	volumeSize := args.MinecraftResource.GetVolumeSize()
+	if args.VolumeSnapshotID != "" {
+		snapshot, err := f.volumeSnapshot(args.VolumeSnapshotID, args.MinecraftResource.GetRegion())
+		if err != nil {
+			return nil, err
+		}
+		volumeSize = max(volumeSize, snapshot.SizeGB)
+	}
+	var mount string
+	if volumeSize > 0 {
+		mount = "sdb"
+	}
+	userData, err := f.tmpl.GetTemplateContext(ctx, args.MinecraftResource, &minctlTemplate.CreateUpdateTemplateArgs{Mount: mount, SSHHostKey: args.SSHHostKey, Name: minctlTemplate.GetTemplateCloudConfigName(args.MinecraftResource.IsProxyServer())})
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := args.MinecraftResource.GetName()
+	for _, server := range f.servers {
+		if server.Name == name {
+			return nil, fmt.Errorf("server %q already exists", name)
+		}
+	}
+	if volume, ok := f.volumes[fmt.Sprintf("%s-vol", name)]; ok && (volume.ServerID != "" || args.VolumeSnapshotID != "") {
+		return nil, fmt.Errorf("volume %q already exists", volume.Name)
+	}
+	f.nextID++
+	server := &Server{
+		ID:        fmt.Sprintf("fake-%d", f.nextID),
+		Name:      name,
+		Region:    args.MinecraftResource.GetRegion(),
+		Size:      args.MinecraftResource.GetSize(),
+		PublicIP:  fmt.Sprintf("192.0.2.%d", f.nextID%254+1),
+		Edition:   args.MinecraftResource.GetEdition(),
+		Version:   args.MinecraftResource.GetVersion(),
+		Labels:    automation.ServerLabels(args),
+		UserData:  userData,
+		CreatedAt: time.Now(),
+	}
+	f.servers[server.ID] = server
+	f.sshKeys[fmt.Sprintf("%s-ssh", name)] = &SSHKey{Name: fmt.Sprintf("%s-ssh", name), PublicKey: *publicKey}
+	f.firewalls[fmt.Sprintf("%s-fw", name)] = firewallRules(args)
+	if volume, ok := f.volumes[fmt.Sprintf("%s-vol", name)]; ok && volumeSize > 0 {
+		// re-attach the volume retained from a deleted server
+		volume.ServerID = server.ID
+	} else if volumeSize > 0 {
+		f.volumes[fmt.Sprintf("%s-vol", name)] = &Volume{
+			Name:       fmt.Sprintf("%s-vol", name),
+			Size:       volumeSize,
+			ServerID:   server.ID,
+			SnapshotID: args.VolumeSnapshotID,
+		}
+	}
+	return f.toResourceResults(server), nil
+}
+
+// DeleteServer deletes a Minecraft server and its resources from memory.
+func (f *Fake) DeleteServer(id string, args automation.ServerArgs) error {
+	return f.DeleteServerContext(context.Background(), id, args)
+}
+
+// DeleteServerContext is like DeleteServer but honors the cancellation and deadline of ctx.
+func (f *Fake) DeleteServerContext(ctx context.Context, id string, args automation.ServerArgs) error {
+	if err := f.begin(ctx, Call{Method: MethodDeleteServer, ID: id, Args: args}); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	server, ok := f.servers[id]
+	if !ok {
+		return fmt.Errorf("server %q not found", id)
+	}
+	delete(f.servers, id)
+	delete(f.sshKeys, fmt.Sprintf("%s-ssh", server.Name))
+	delete(f.firewalls, fmt.Sprintf("%s-fw", server.Name))
+	if volume, ok := f.volumes[fmt.Sprintf("%s-vol", server.Name)]; ok && args.MinecraftResource.RetainVolume() {
+		volume.ServerID = ""
+	} else {
+		delete(f.volumes, fmt.Sprintf("%s-vol", server.Name))
+	}
+	return nil
+}
+
+// ListServer lists all Minecraft servers kept in memory.
+func (f *Fake) ListServer() ([]automation.ResourceResults, error) {
+	return f.ListServerContext(context.Background())
+}
+
+// ListServerContext is like ListServer but honors the cancellation and deadline of ctx.
+func (f *Fake) ListServerContext(ctx context.Context) ([]automation.ResourceResults, error) {
+	if err := f.begin(ctx, Call{Method: MethodListServer}); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var result []automation.ResourceResults
+	for _, server := range f.servers {
+		result = append(result, *f.toResourceResults(server))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+// UpdateServer updates the Minecraft version of a server kept in memory.
+func (f *Fake) UpdateServer(id string, args automation.ServerArgs) error {
+	return f.UpdateServerContext(context.Background(), id, args)
+}
+
+// UpdateServerContext is like UpdateServer but honors the cancellation and deadline of ctx.
+func (f *Fake) UpdateServerContext(ctx context.Context, id string, args automation.ServerArgs) error {
+	if err := f.begin(ctx, Call{Method: MethodUpdateServer, ID: id, Args: args}); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	server, ok := f.servers[id]
+	if !ok {
+		return fmt.Errorf("server %q not found", id)
+	}
+	server.Version = args.MinecraftResource.GetVersion()
+	return nil
+}
+
+// UploadPlugin records a plugin upload to a server kept in memory.
+func (f *Fake) UploadPlugin(id string, args automation.ServerArgs, plugin, destination string) error {
+	return f.UploadPluginContext(context.Background(), id, args, plugin, destination)
+}
+
+// UploadPluginContext is like UploadPlugin but honors the cancellation and deadline of ctx.
+func (f *Fake) UploadPluginContext(ctx context.Context, id string, args automation.ServerArgs, plugin, destination string) error {
+	if err := f.begin(ctx, Call{Method: MethodUploadPlugin, ID: id, Args: args, Plugin: plugin, Destination: destination}); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	server, ok := f.servers[id]
+	if !ok {
+		return fmt.Errorf("server %q not found", id)
+	}
+	server.Plugins = append(server.Plugins, filepath.Join(destination, filepath.Base(plugin)))
+	return nil
+}
+
+// GetServer gets a Minecraft server kept in memory.
+func (f *Fake) GetServer(id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
+	return f.GetServerContext(context.Background(), id, args)
+}
+
+// GetServerContext is like GetServer but honors the cancellation and deadline of ctx.
+func (f *Fake) GetServerContext(ctx context.Context, id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
+	if err := f.begin(ctx, Call{Method: MethodGetServer, ID: id, Args: args}); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	server, ok := f.servers[id]
+	if !ok {
+		return nil, fmt.Errorf("server %q not found", id)
+	}
+	return f.toResourceResults(server), nil
+}
+
+// volumeSnapshot returns the snapshot with the given id if it is in region.
+func (f *Fake) volumeSnapshot(id, region string) (*automation.VolumeSnapshot, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	snapshot, ok := f.snapshots[id]
+	if !ok {
+		return nil, fmt.Errorf("volume snapshot %q not found", id)
+	}
+	if snapshot.Region != region {
+		return nil, fmt.Errorf("volume snapshot %q is in region %q, not %q", id, snapshot.Region, region)
+	}
+	return snapshot, nil
+}
+
+// CreateVolumeSnapshot snapshots the volume of a server kept in memory.
+func (f *Fake) CreateVolumeSnapshot(ctx context.Context, id string, args automation.ServerArgs) (*automation.VolumeSnapshot, error) {
+	if err := f.begin(ctx, Call{Method: MethodCreateVolumeSnapshot, ID: id, Args: args}); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	server, ok := f.servers[id]
+	if !ok {
+		return nil, fmt.Errorf("server %q not found", id)
+	}
+	volume, ok := f.volumes[fmt.Sprintf("%s-vol", server.Name)]
+	if !ok {
+		return nil, fmt.Errorf("server %q has no volume", id)
+	}
+	f.nextID++
+	now := time.Now()
+	snapshot := &automation.VolumeSnapshot{
+		ID:        fmt.Sprintf("fake-snap-%d", f.nextID),
+		Name:      fmt.Sprintf("%s-%s", volume.Name, now.UTC().Format("20060102-150405")),
+		Server:    server.Name,
+		Region:    server.Region,
+		SizeGB:    volume.Size,
+		CreatedAt: now,
+		Labels:    map[string]string{common.InstanceTag: "true", server.Edition: "true"},
+	}
+	f.snapshots[snapshot.ID] = snapshot
+	return copyVolumeSnapshot(snapshot), nil
+}
+
+// ListVolumeSnapshots lists all volume snapshots kept in memory.
+func (f *Fake) ListVolumeSnapshots(ctx context.Context) ([]automation.VolumeSnapshot, error) {
+	if err := f.begin(ctx, Call{Method: MethodListVolumeSnapshots}); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var result []automation.VolumeSnapshot
+	for _, snapshot := range f.snapshots {
+		result = append(result, *copyVolumeSnapshot(snapshot))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	return result, nil
+}
+
+// DeleteVolumeSnapshot deletes a volume snapshot from memory.
+func (f *Fake) DeleteVolumeSnapshot(ctx context.Context, snapshotID string) error {
+	if err := f.begin(ctx, Call{Method: MethodDeleteVolumeSnapshot, ID: snapshotID}); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.snapshots[snapshotID]; !ok {
+		return fmt.Errorf("volume snapshot %q not found", snapshotID)
+	}
+	delete(f.snapshots, snapshotID)
+	return nil
+}
+
+// CopyVolumeSnapshot copies a volume snapshot kept in memory to region.
+func (f *Fake) CopyVolumeSnapshot(ctx context.Context, snapshotID, region string) (*automation.VolumeSnapshot, error) {
+	if err := f.begin(ctx, Call{Method: MethodCopyVolumeSnapshot, ID: snapshotID}); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	source, ok := f.snapshots[snapshotID]
+	if !ok {
+		return nil, fmt.Errorf("volume snapshot %q not found", snapshotID)
+	}
+	f.nextID++
+	snapshot := copyVolumeSnapshot(source)
+	snapshot.ID = fmt.Sprintf("fake-snap-%d", f.nextID)
+	snapshot.Region = region
+	snapshot.CreatedAt = time.Now()
+	f.snapshots[snapshot.ID] = snapshot
+	return copyVolumeSnapshot(snapshot), nil
+}
+
+func copyVolumeSnapshot(s *automation.VolumeSnapshot) *automation.VolumeSnapshot {
+	snapshot := *s
+	snapshot.Labels = maps.Clone(s.Labels)
+	return &snapshot
+}
@@ -0,0 +1,231 @@
+package fake
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dirien/minectl-sdk/automation"
+	"github.com/dirien/minectl-sdk/cloud"
+	"github.com/dirien/minectl-sdk/internal/testutil"
+	"github.com/dirien/minectl-sdk/model"
+	"github.com/dirien/minectl-sdk/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeServerArgs(t *testing.T, edition string, volumeSize int) automation.ServerArgs {
+	t.Helper()
+	args := testutil.ServerArgs(t, ProviderFake, "local", "small", edition)
+	args.MinecraftResource.Spec.Server.VolumeSize = volumeSize
+	return args
+}
+
+func TestLifecycle(t *testing.T) {
+	f, err := NewFake()
+	require.NoError(t, err)
+	args := makeServerArgs(t, "java", 10)
+
+	res, err := f.CreateServer(args)
+	require.NoError(t, err)
+	assert.Equal(t, "minecraft-server", res.Name)
+	assert.Equal(t, "local", res.Region)
+	assert.Equal(t, "java,minectl,version", res.Tags)
+	assert.Equal(t, automation.StatusRunning, res.Status)
+	assert.Equal(t, "small", res.Size)
+	assert.Equal(t, "java", res.Edition)
+	assert.Equal(t, "1.20.4", res.Version)
+	assert.Equal(t, map[string]string{"minectl": "true", "java": "true", "version": "1_20_4"}, res.Labels)
+	assert.Equal(t, []string{"minecraft-server-vol"}, res.VolumeIDs)
+	assert.False(t, res.CreatedAt.IsZero())
+
+	server, ok := f.Server(res.ID)
+	require.True(t, ok)
+	assert.Contains(t, server.UserData, "#cloud-config")
+	assert.Contains(t, server.UserData, "/dev/sdb")
+
+	volume, ok := f.Volume("minecraft-server-vol")
+	require.True(t, ok)
+	assert.Equal(t, 10, volume.Size)
+	assert.Equal(t, res.ID, volume.ServerID)
+
+	key, ok := f.SSHKey("minecraft-server-ssh")
+	require.True(t, ok)
+	assert.Equal(t, "ssh-rsa AAAA test", key.PublicKey)
+
+	assert.Equal(t, []FirewallRule{
+		{Protocol: "tcp", Port: 22},
+		{Protocol: "tcp", Port: 25565},
+		{Protocol: "tcp", Port: 25575},
+	}, f.FirewallRules("minecraft-server-fw"))
+
+	_, err = f.CreateServer(args)
+	assert.Error(t, err)
+
+	list, err := f.ListServer()
+	require.NoError(t, err)
+	assert.Len(t, list, 1)
+
+	args.MinecraftResource.Spec.Minecraft.Version = "1.21"
+	require.NoError(t, f.UpdateServer(res.ID, args))
+	require.NoError(t, f.UploadPlugin(res.ID, args, "/tmp/plugin.jar", "/minecraft/plugins"))
+	server, _ = f.Server(res.ID)
+	assert.Equal(t, "1.21", server.Version)
+	assert.Equal(t, []string{"/minecraft/plugins/plugin.jar"}, server.Plugins)
+
+	require.NoError(t, f.DeleteServer(res.ID, args))
+	_, err = f.GetServer(res.ID, args)
+	assert.Error(t, err)
+	_, ok = f.Volume("minecraft-server-vol")
+	assert.False(t, ok)
+	assert.Empty(t, f.FirewallRules("minecraft-server-fw"))
+
+	var methods []string
+	for _, call := range f.Calls() {
+		methods = append(methods, call.Method)
+	}
+	assert.Equal(t, []string{
+		MethodCreateServer, MethodCreateServer, MethodListServer, MethodUpdateServer,
+		MethodUploadPlugin, MethodDeleteServer, MethodGetServer,
+	}, methods)
+}
+
+func TestBedrockFirewallUsesUDP(t *testing.T) {
+	f, err := NewFake()
+	require.NoError(t, err)
+	args := makeServerArgs(t, "bedrock", 0)
+	args.MinecraftResource.Spec.Minecraft.Java.Rcon.Enabled = false
+
+	_, err = f.CreateServer(args)
+	require.NoError(t, err)
+	assert.Equal(t, []FirewallRule{
+		{Protocol: "tcp", Port: 22},
+		{Protocol: "udp", Port: 19132},
+	}, f.FirewallRules("minecraft-server-fw"))
+	_, ok := f.Volume("minecraft-server-vol")
+	assert.False(t, ok)
+}
+
+func TestRetainVolume(t *testing.T) {
+	f, err := NewFake()
+	require.NoError(t, err)
+	args := makeServerArgs(t, "java", 10)
+	args.MinecraftResource.Spec.Server.VolumeReclaimPolicy = model.VolumeReclaimRetain
+
+	res, err := f.CreateServer(args)
+	require.NoError(t, err)
+	require.NoError(t, f.DeleteServer(res.ID, args))
+	volume, ok := f.Volume("minecraft-server-vol")
+	require.True(t, ok)
+	assert.Empty(t, volume.ServerID)
+
+	res, err = f.CreateServer(args)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"minecraft-server-vol"}, res.VolumeIDs)
+
+	args.MinecraftResource.Spec.Server.VolumeReclaimPolicy = model.VolumeReclaimDelete
+	require.NoError(t, f.DeleteServer(res.ID, args))
+	_, ok = f.Volume("minecraft-server-vol")
+	assert.False(t, ok)
+}
+
+func TestVolumeSnapshots(t *testing.T) {
+	f, err := NewFake()
+	require.NoError(t, err)
+	ctx := context.Background()
+	args := makeServerArgs(t, "java", 10)
+	res, err := f.CreateServer(args)
+	require.NoError(t, err)
+
+	snapshot, err := f.CreateVolumeSnapshot(ctx, res.ID, args)
+	require.NoError(t, err)
+	assert.Equal(t, "minecraft-server", snapshot.Server)
+	assert.Equal(t, "local", snapshot.Region)
+	assert.Equal(t, 10, snapshot.SizeGB)
+	assert.Equal(t, "true", snapshot.Labels["minectl"])
+
+	// the snapshot survives the server
+	require.NoError(t, f.DeleteServer(res.ID, args))
+	snapshots, err := f.ListVolumeSnapshots(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []automation.VolumeSnapshot{*snapshot}, snapshots)
+
+	// a snapshot in another region must be copied first
+	clone := makeServerArgs(t, "java", 0)
+	clone.MinecraftResource.Spec.Server.Region = "remote"
+	clone.VolumeSnapshotID = snapshot.ID
+	_, err = f.CreateServer(clone)
+	require.Error(t, err)
+	copied, err := f.CopyVolumeSnapshot(ctx, snapshot.ID, "remote")
+	require.NoError(t, err)
+	assert.Equal(t, "remote", copied.Region)
+
+	clone.VolumeSnapshotID = copied.ID
+	res, err = f.CreateServer(clone)
+	require.NoError(t, err)
+	assert.Equal(t, "remote", res.Region)
+	volume, ok := f.Volume("minecraft-server-vol")
+	require.True(t, ok)
+	assert.Equal(t, 10, volume.Size)
+	assert.Equal(t, copied.ID, volume.SnapshotID)
+
+	require.NoError(t, f.DeleteVolumeSnapshot(ctx, snapshot.ID))
+	snapshots, err = f.ListVolumeSnapshots(ctx)
+	require.NoError(t, err)
+	assert.Len(t, snapshots, 1)
+
+	_, err = f.CreateVolumeSnapshot(ctx, "missing", args)
+	require.Error(t, err)
+}
+
+func TestInjectError(t *testing.T) {
+	f, err := NewFake()
+	require.NoError(t, err)
+	boom := errors.New("boom")
+
+	f.InjectError(MethodListServer, boom)
+	_, err = f.ListServer()
+	assert.ErrorIs(t, err, boom)
+
+	f.InjectError(MethodListServer, nil)
+	_, err = f.ListServer()
+	assert.NoError(t, err)
+	assert.Len(t, f.Calls(), 2)
+}
+
+func TestInjectLatency(t *testing.T) {
+	f, err := NewFake()
+	require.NoError(t, err)
+	f.InjectLatency(MethodListServer, time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = f.ListServerContext(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestRegister(t *testing.T) {
+	Register()
+	Register()
+	assert.Contains(t, cloud.Providers(), ProviderFake)
+	a, err := cloud.New(context.Background(), ProviderFake, nil)
+	require.NoError(t, err)
+	assert.IsType(t, &Fake{}, a)
+	assert.Implements(t, (*automation.ContextAutomation)(nil), a)
+	assert.Implements(t, (*automation.VolumeSnapshotter)(nil), a)
+	assert.Implements(t, (*automation.VolumeSnapshotCopier)(nil), a)
+}
+
+func TestTemplateOptions(t *testing.T) {
+	Register()
+	a, err := cloud.New(context.Background(), ProviderFake, nil,
+		template.WithExtra(template.Extra{RunCmd: []string{"touch /etc/hardened"}}))
+	require.NoError(t, err)
+	f := a.(*Fake)
+	res, err := f.CreateServer(makeServerArgs(t, "java", 0))
+	require.NoError(t, err)
+	server, ok := f.Server(res.ID)
+	require.True(t, ok)
+	assert.Contains(t, server.UserData, "touch /etc/hardened")
+}
@@ -2,8 +2,13 @@
 package fuga
 
 import (
+	"context"
+
 	"github.com/dirien/minectl-sdk/automation"
+	"github.com/dirien/minectl-sdk/cloud"
 	"github.com/dirien/minectl-sdk/cloud/openstack"
+	"github.com/dirien/minectl-sdk/model"
+	minctlTemplate "github.com/dirien/minectl-sdk/template"
 )
 
 // Fuga implements the Automation interface for Fuga.
@@ -13,9 +18,18 @@ type Fuga struct {
 
 const imageName = "Ubuntu 22.04 LTS"
 
+func init() {
+	cloud.Register(cloud.Provider{
+		Code: model.ProviderFuga,
+		Factory: func(_ context.Context, _ cloud.Credentials, opts []minctlTemplate.Option) (automation.Automation, error) {
+			return NewFuga(opts...)
+		},
+	})
+}
+
 // NewFuga creates a new Fuga instance.
-func NewFuga() (*Fuga, error) {
-	client, err := openstack.NewOpenStack(imageName)
+func NewFuga(opts ...minctlTemplate.Option) (*Fuga, error) {
+	client, err := openstack.NewOpenStack(imageName, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -26,30 +40,60 @@ func NewFuga() (*Fuga, error) {
 
 // CreateServer creates a new Minecraft server on Fuga.
 func (f *Fuga) CreateServer(args automation.ServerArgs) (*automation.ResourceResults, error) {
-	return f.openshift.CreateServer(args)
+	return f.CreateServerContext(context.Background(), args)
+}
+
+// CreateServerContext is like CreateServer but honors the cancellation and deadline of ctx.
+func (f *Fuga) CreateServerContext(ctx context.Context, args automation.ServerArgs) (*automation.ResourceResults, error) {
+	return f.openshift.CreateServerContext(ctx, args)
 }
 
 // DeleteServer deletes a Minecraft server on Fuga.
 func (f *Fuga) DeleteServer(id string, args automation.ServerArgs) error {
-	return f.openshift.DeleteServer(id, args)
+	return f.DeleteServerContext(context.Background(), id, args)
+}
+
+// DeleteServerContext is like DeleteServer but honors the cancellation and deadline of ctx.
+func (f *Fuga) DeleteServerContext(ctx context.Context, id string, args automation.ServerArgs) error {
+	return f.openshift.DeleteServerContext(ctx, id, args)
 }
 
 // ListServer lists all Minecraft servers on Fuga.
 func (f *Fuga) ListServer() ([]automation.ResourceResults, error) {
-	return f.openshift.ListServer()
+	return f.ListServerContext(context.Background())
+}
+
+// ListServerContext is like ListServer but honors the cancellation and deadline of ctx.
+func (f *Fuga) ListServerContext(ctx context.Context) ([]automation.ResourceResults, error) {
+	return f.openshift.ListServerContext(ctx)
 }
 
 // UpdateServer updates a Minecraft server on Fuga.
 func (f *Fuga) UpdateServer(id string, args automation.ServerArgs) error {
-	return f.openshift.UpdateServer(id, args)
+	return f.UpdateServerContext(context.Background(), id, args)
+}
+
+// UpdateServerContext is like UpdateServer but honors the cancellation and deadline of ctx.
+func (f *Fuga) UpdateServerContext(ctx context.Context, id string, args automation.ServerArgs) error {
+	return f.openshift.UpdateServerContext(ctx, id, args)
 }
 
 // UploadPlugin uploads a plugin to a Minecraft server on Fuga.
 func (f *Fuga) UploadPlugin(id string, args automation.ServerArgs, plugin, destination string) error {
-	return f.openshift.UploadPlugin(id, args, plugin, destination)
+	return f.UploadPluginContext(context.Background(), id, args, plugin, destination)
+}
+
+// UploadPluginContext is like UploadPlugin but honors the cancellation and deadline of ctx.
+func (f *Fuga) UploadPluginContext(ctx context.Context, id string, args automation.ServerArgs, plugin, destination string) error {
+	return f.openshift.UploadPluginContext(ctx, id, args, plugin, destination)
 }
 
 // GetServer gets a Minecraft server on Fuga.
 func (f *Fuga) GetServer(id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
-	return f.openshift.GetServer(id, args)
+	return f.GetServerContext(context.Background(), id, args)
+}
+
+// GetServerContext is like GetServer but honors the cancellation and deadline of ctx.
+func (f *Fuga) GetServerContext(ctx context.Context, id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
+	return f.openshift.GetServerContext(ctx, id, args)
 }
@@ -5,7 +5,7 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"path/filepath"
+	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -13,6 +13,7 @@ import (
 	"github.com/dirien/minectl-sdk/automation"
 	"github.com/dirien/minectl-sdk/cloud"
 	"github.com/dirien/minectl-sdk/common"
+	"github.com/dirien/minectl-sdk/model"
 	minctlTemplate "github.com/dirien/minectl-sdk/template"
 	"github.com/dirien/minectl-sdk/update"
 	"github.com/pkg/errors"
@@ -41,6 +42,19 @@ type GCE struct {
 	serviceAccountID   string
 	zone               string
 	tmpl               *minctlTemplate.Template
+	templateOptions    []minctlTemplate.Option
+}
+
+func init() {
+	cloud.Register(cloud.Provider{
+		Code: model.ProviderGce,
+		Credentials: []cloud.CredentialField{
+			{Name: "zone", EnvVar: "GOOGLE_ZONE", Description: "Compute Engine zone", Required: true},
+		},
+		Factory: func(_ context.Context, creds cloud.Credentials, opts []minctlTemplate.Option) (automation.Automation, error) {
+			return NewGCE(creds["zone"], opts...)
+		},
+	})
 }
 
 // NewGCE creates a new GCE instance using Application Default Credentials (ADC).
@@ -55,7 +69,7 @@ type GCE struct {
 //
 // Optional:
 // - GOOGLE_APPLICATION_CREDENTIALS: Path to service account JSON (if not using gcloud CLI auth)
-func NewGCE(zone string) (*GCE, error) {
+func NewGCE(zone string, opts ...minctlTemplate.Option) (*GCE, error) {
 	ctx := context.Background()
 
 	// Get project ID from environment
@@ -90,7 +104,7 @@ func NewGCE(zone string) (*GCE, error) {
 		return nil, errors.Wrap(err, "failed to create oslogin service")
 	}
 
-	tmpl, err := minctlTemplate.NewTemplateBash()
+	tmpl, err := minctlTemplate.NewTemplateBash(opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -106,17 +120,23 @@ func NewGCE(zone string) (*GCE, error) {
 		serviceAccountID:   serviceAccountID,
 		zone:               zone,
 		tmpl:               tmpl,
+		templateOptions:    opts,
 	}, nil
 }
 
 // CreateServer creates a new Minecraft server on GCE.
 func (g *GCE) CreateServer(args automation.ServerArgs) (*automation.ResourceResults, error) {
+	return g.CreateServerContext(context.Background(), args)
+}
+
+// CreateServerContext is like CreateServer but honors the cancellation and deadline of ctx.
+func (g *GCE) CreateServerContext(ctx context.Context, args automation.ServerArgs) (*automation.ResourceResults, error) {
 	imageFamily := "ubuntu-2204-lts"
 
 	if args.MinecraftResource.IsArm() {
 		imageFamily = "ubuntu-minimal-2204-lts-arm64"
 	}
-	image, err := g.client.Images.GetFromFamily("ubuntu-os-cloud", imageFamily).Context(context.Background()).Do()
+	image, err := g.client.Images.GetFromFamily("ubuntu-os-cloud", imageFamily).Context(ctx).Do()
 	if err != nil {
 		return nil, err
 	}
@@ -129,7 +149,7 @@ func (g *GCE) CreateServer(args automation.ServerArgs) (*automation.ResourceResu
 	_, err = g.user.Users.ImportSshPublicKey(fmt.Sprintf("users/%s", g.serviceAccountName), &oslogin.SshPublicKey{
 		Key:                *publicKey,
 		ExpirationTimeUsec: 0,
-	}).Context(context.Background()).Do()
+	}).Context(ctx).Do()
 	if err != nil {
 		return nil, err
 	}
@@ -141,26 +161,26 @@ func (g *GCE) CreateServer(args automation.ServerArgs) (*automation.ResourceResu
 			Name:   fmt.Sprintf("%s-vol", args.MinecraftResource.GetName()),
 			SizeGb: int64(args.MinecraftResource.GetVolumeSize()),
 			Type:   fmt.Sprintf("zones/%s/diskTypes/pd-standard", args.MinecraftResource.GetRegion()),
-		}).Context(context.Background()).Do()
+		}).Context(ctx).Do()
 		if err != nil {
 			return nil, err
 		}
 
 		for stillCreating {
-			diskInsertOps, err := g.client.ZoneOperations.Get(g.projectID, args.MinecraftResource.GetRegion(), diskInsertOp.Name).Context(context.Background()).Do()
+			diskInsertOps, err := g.client.ZoneOperations.Get(g.projectID, args.MinecraftResource.GetRegion(), diskInsertOp.Name).Context(ctx).Do()
 			if err != nil {
 				return nil, err
 			}
 			if diskInsertOps.Status == doneStatus {
 				stillCreating = false
-			} else {
-				time.Sleep(2 * time.Second)
+			} else if err := common.Sleep(ctx, 2*time.Second); err != nil {
+				return nil, err
 			}
 		}
 		mount = "sdb"
 	}
 
-	userData, err := g.tmpl.GetTemplate(args.MinecraftResource, &minctlTemplate.CreateUpdateTemplateArgs{Mount: mount, Name: minctlTemplate.GetTemplateBashName(args.MinecraftResource.IsProxyServer())})
+	userData, err := g.tmpl.GetTemplateContext(ctx, args.MinecraftResource, &minctlTemplate.CreateUpdateTemplateArgs{Mount: mount, SSHHostKey: args.SSHHostKey, Name: minctlTemplate.GetTemplateBashName(args.MinecraftResource.IsProxyServer())})
 	if err != nil {
 		return nil, err
 	}
@@ -225,6 +245,8 @@ func (g *GCE) CreateServer(args automation.ServerArgs) (*automation.ResourceResu
 		},
 		Labels: map[string]string{
 			common.InstanceTag: "true",
+			// label values must be lower case
+			automation.LabelVersion: strings.ToLower(automation.VersionLabelValue(args.MinecraftResource.GetVersion())),
 		},
 		Tags: &compute.Tags{
 			Items: []string{common.InstanceTag, args.MinecraftResource.GetEdition()},
@@ -237,21 +259,21 @@ func (g *GCE) CreateServer(args automation.ServerArgs) (*automation.ResourceResu
 		})
 	}
 
-	insertInstanceOp, err := g.client.Instances.Insert(g.projectID, args.MinecraftResource.GetRegion(), instance).Context(context.Background()).Do()
+	insertInstanceOp, err := g.client.Instances.Insert(g.projectID, args.MinecraftResource.GetRegion(), instance).Context(ctx).Do()
 	if err != nil {
 		return nil, err
 	}
 
 	stillCreating = true
 	for stillCreating {
-		insertInstanceOp, err := g.client.ZoneOperations.Get(g.projectID, args.MinecraftResource.GetRegion(), insertInstanceOp.Name).Context(context.Background()).Do()
+		insertInstanceOp, err := g.client.ZoneOperations.Get(g.projectID, args.MinecraftResource.GetRegion(), insertInstanceOp.Name).Context(ctx).Do()
 		if err != nil {
 			return nil, err
 		}
 		if insertInstanceOp.Status == doneStatus {
 			stillCreating = false
-		} else {
-			time.Sleep(2 * time.Second)
+		} else if err := common.Sleep(ctx, 2*time.Second); err != nil {
+			return nil, err
 		}
 	}
 
@@ -268,14 +290,14 @@ func (g *GCE) CreateServer(args automation.ServerArgs) (*automation.ResourceResu
 		Direction:    "INGRESS",
 		TargetTags:   []string{common.InstanceTag},
 	}
-	_, err = g.client.Firewalls.Insert(g.projectID, firewallRule).Context(context.Background()).Do()
+	_, err = g.client.Firewalls.Insert(g.projectID, firewallRule).Context(ctx).Do()
 	if err != nil {
 		return nil, err
 	}
 
 	instanceListOp, err := g.client.Instances.List(g.projectID, args.MinecraftResource.GetRegion()).
 		Filter(fmt.Sprintf("(name=%s)", args.MinecraftResource.GetName())).
-		Context(context.Background()).
+		Context(ctx).
 		Do()
 	if err != nil {
 		return nil, err
@@ -283,60 +305,58 @@ func (g *GCE) CreateServer(args automation.ServerArgs) (*automation.ResourceResu
 
 	if len(instanceListOp.Items) == 1 {
 		instance := instanceListOp.Items[0]
-		ip := instance.NetworkInterfaces[0].AccessConfigs[0].NatIP
-		return &automation.ResourceResults{
-			ID:       strconv.FormatUint(instance.Id, 10),
-			Name:     instance.Name,
-			Region:   instance.Zone,
-			PublicIP: ip,
-			Tags:     strings.Join(instance.Tags.Items, ","),
-		}, err
+		return instanceToResourceResults(instance), err
 	}
 	return nil, errors.New("no instances created")
 }
 
 // DeleteServer deletes a Minecraft server on GCE.
 func (g *GCE) DeleteServer(id string, args automation.ServerArgs) error {
-	profileGetOp, err := g.user.Users.GetLoginProfile(fmt.Sprintf("users/%s", g.serviceAccountName)).Context(context.Background()).Do()
+	return g.DeleteServerContext(context.Background(), id, args)
+}
+
+// DeleteServerContext is like DeleteServer but honors the cancellation and deadline of ctx.
+func (g *GCE) DeleteServerContext(ctx context.Context, id string, args automation.ServerArgs) error {
+	profileGetOp, err := g.user.Users.GetLoginProfile(fmt.Sprintf("users/%s", g.serviceAccountName)).Context(ctx).Do()
 	if err != nil {
 		return err
 	}
 	for _, posixAccount := range profileGetOp.PosixAccounts {
-		_, err := g.user.Users.Projects.Delete(posixAccount.Name).Context(context.Background()).Do()
+		_, err := g.user.Users.Projects.Delete(posixAccount.Name).Context(ctx).Do()
 		if err != nil {
 			return err
 		}
 	}
 	for _, publicKey := range profileGetOp.SshPublicKeys {
-		_, err = g.user.Users.SshPublicKeys.Delete(publicKey.Name).Context(context.Background()).Do()
+		_, err = g.user.Users.SshPublicKeys.Delete(publicKey.Name).Context(ctx).Do()
 		if err != nil {
 			return err
 		}
 	}
 	instancesListOp, err := g.client.Instances.List(g.projectID, args.MinecraftResource.GetRegion()).
 		Filter(fmt.Sprintf("(id=%s)", id)).
-		Context(context.Background()).
+		Context(ctx).
 		Do()
 	if err != nil {
 		return err
 	}
 	if len(instancesListOp.Items) == 1 {
 		instanceDeleteOp, err := g.client.Instances.Delete(g.projectID, args.MinecraftResource.GetRegion(), instancesListOp.Items[0].Name).
-			Context(context.Background()).
+			Context(ctx).
 			Do()
 		if err != nil {
 			return err
 		}
 		stillDeleting := true
 		for stillDeleting {
-			instanceDeleteOp, err := g.client.ZoneOperations.Get(g.projectID, args.MinecraftResource.GetRegion(), instanceDeleteOp.Name).Context(context.Background()).Do()
+			instanceDeleteOp, err := g.client.ZoneOperations.Get(g.projectID, args.MinecraftResource.GetRegion(), instanceDeleteOp.Name).Context(ctx).Do()
 			if err != nil {
 				return err
 			}
 			if instanceDeleteOp.Status == doneStatus {
 				stillDeleting = false
-			} else {
-				time.Sleep(2 * time.Second)
+			} else if err := common.Sleep(ctx, 2*time.Second); err != nil {
+				return err
 			}
 		}
 
@@ -344,24 +364,24 @@ func (g *GCE) DeleteServer(id string, args automation.ServerArgs) error {
 
 	diskListOp, err := g.client.Disks.List(g.projectID, args.MinecraftResource.GetRegion()).
 		Filter(fmt.Sprintf("(name=%s)", fmt.Sprintf("%s-vol", args.MinecraftResource.GetName()))).
-		Context(context.Background()).
+		Context(ctx).
 		Do()
 	if err != nil {
 		return err
 	}
 	for _, disk := range diskListOp.Items {
-		_, err := g.client.Disks.Delete(g.projectID, args.MinecraftResource.GetRegion(), disk.Name).Context(context.Background()).Do()
+		_, err := g.client.Disks.Delete(g.projectID, args.MinecraftResource.GetRegion(), disk.Name).Context(ctx).Do()
 		if err != nil {
 			return err
 		}
 	}
 
-	firewallListOps, err := g.client.Firewalls.List(g.projectID).Filter(fmt.Sprintf("(name=%s)", fmt.Sprintf("%s-fw", args.MinecraftResource.GetName()))).Context(context.Background()).Do()
+	firewallListOps, err := g.client.Firewalls.List(g.projectID).Filter(fmt.Sprintf("(name=%s)", fmt.Sprintf("%s-fw", args.MinecraftResource.GetName()))).Context(ctx).Do()
 	if err != nil {
 		return err
 	}
 	for _, firewall := range firewallListOps.Items {
-		_, err := g.client.Firewalls.Delete(g.projectID, firewall.Name).Context(context.Background()).Do()
+		_, err := g.client.Firewalls.Delete(g.projectID, firewall.Name).Context(ctx).Do()
 		if err != nil {
 			return err
 		}
@@ -370,31 +390,92 @@ func (g *GCE) DeleteServer(id string, args automation.ServerArgs) error {
 	return nil
 }
 
+func instanceStatus(status string) automation.Status {
+	switch status {
+	case "PROVISIONING", "STAGING", "REPAIRING":
+		return automation.StatusPending
+	case "RUNNING":
+		return automation.StatusRunning
+	case "STOPPING", "SUSPENDING":
+		return automation.StatusStopping
+	case "STOPPED", "SUSPENDED", "TERMINATED":
+		return automation.StatusStopped
+	default:
+		return automation.StatusUnknown
+	}
+}
+
+func instanceToResourceResults(instance *compute.Instance) *automation.ResourceResults {
+	var tags []string
+	if instance.Tags != nil {
+		tags = instance.Tags.Items
+	}
+	// the edition is only stored as network tag, as label values are restricted
+	labels := automation.LabelsFromTags(tags)
+	for key, value := range instance.Labels {
+		labels[key] = value
+	}
+	machineType := path.Base(instance.MachineType)
+	result := &automation.ResourceResults{
+		ID:      strconv.FormatUint(instance.Id, 10),
+		Name:    instance.Name,
+		Region:  instance.Zone,
+		Tags:    strings.Join(tags, ","),
+		Status:  instanceStatus(instance.Status),
+		Size:    machineType,
+		Arch:    automation.ArchAMD64,
+		Labels:  labels,
+		Edition: automation.EditionFromLabels(labels),
+		Version: automation.VersionFromLabels(labels),
+	}
+	if strings.HasPrefix(machineType, "t2a-") || strings.HasPrefix(machineType, "c4a-") {
+		result.Arch = automation.ArchARM64
+	}
+	if len(instance.NetworkInterfaces) > 0 {
+		networkInterface := instance.NetworkInterfaces[0]
+		result.PrivateIP = networkInterface.NetworkIP
+		if len(networkInterface.AccessConfigs) > 0 {
+			result.PublicIP = networkInterface.AccessConfigs[0].NatIP
+		}
+		if len(networkInterface.Ipv6AccessConfigs) > 0 {
+			result.PublicIPv6 = networkInterface.Ipv6AccessConfigs[0].ExternalIpv6
+		}
+	}
+	if created, err := time.Parse(time.RFC3339, instance.CreationTimestamp); err == nil {
+		result.CreatedAt = created
+	}
+	for _, disk := range instance.Disks {
+		if !disk.Boot {
+			result.VolumeIDs = append(result.VolumeIDs, path.Base(disk.Source))
+		}
+	}
+	return result
+}
+
 // ListServer lists all Minecraft servers on GCE.
 func (g *GCE) ListServer() ([]automation.ResourceResults, error) {
+	return g.ListServerContext(context.Background())
+}
+
+// ListServerContext is like ListServer but honors the cancellation and deadline of ctx.
+func (g *GCE) ListServerContext(ctx context.Context) ([]automation.ResourceResults, error) {
 	instanceListOp, err := g.client.Instances.List(g.projectID, g.zone).
 		Filter(fmt.Sprintf("(labels.%s=true)", common.InstanceTag)).
-		Context(context.Background()).Do()
+		Context(ctx).Do()
 	if err != nil {
 		return nil, err
 	}
 	var result []automation.ResourceResults
 	for _, instance := range instanceListOp.Items {
-		result = append(result, automation.ResourceResults{
-			ID:       strconv.FormatUint(instance.Id, 10),
-			Name:     instance.Name,
-			Region:   instance.Zone,
-			PublicIP: instance.NetworkInterfaces[0].AccessConfigs[0].NatIP,
-			Tags:     strings.Join(instance.Tags.Items, ","),
-		})
+		result = append(result, *instanceToResourceResults(instance))
 	}
 	return result, nil
 }
 
-func (g *GCE) getInstanceList(id, region string) ([]*compute.Instance, error) {
+func (g *GCE) getInstanceList(ctx context.Context, id, region string) ([]*compute.Instance, error) {
 	instancesListOp, err := g.client.Instances.List(g.projectID, region).
 		Filter(fmt.Sprintf("(id=%s)", id)).
-		Context(context.Background()).
+		Context(ctx).
 		Do()
 	if err != nil {
 		return nil, err
@@ -402,16 +483,29 @@ func (g *GCE) getInstanceList(id, region string) ([]*compute.Instance, error) {
 	return instancesListOp.Items, nil
 }
 
+// SSHLogin returns the OS Login user of the service account, see
+// update.LoginProvider. It is not root, so the service account needs the
+// roles/compute.osAdminLogin role for sudo.
+func (g *GCE) SSHLogin() update.Login {
+	return update.Login{User: fmt.Sprintf("sa_%s", g.serviceAccountID), Sudo: true}
+}
+
 // UpdateServer updates a Minecraft server on GCE.
 func (g *GCE) UpdateServer(id string, args automation.ServerArgs) error {
-	instancesList, err := g.getInstanceList(id, args.MinecraftResource.GetRegion())
+	return g.UpdateServerContext(context.Background(), id, args)
+}
+
+// UpdateServerContext is like UpdateServer but honors the cancellation and deadline of ctx.
+func (g *GCE) UpdateServerContext(ctx context.Context, id string, args automation.ServerArgs) error {
+	instancesList, err := g.getInstanceList(ctx, id, args.MinecraftResource.GetRegion())
 	if err != nil {
 		return err
 	}
 	if len(instancesList) == 1 {
 		instance := instancesList[0]
-		remoteCommand := update.NewRemoteServer(args.SSHPrivateKeyPath, instance.NetworkInterfaces[0].AccessConfigs[0].NatIP, fmt.Sprintf("sa_%s", g.serviceAccountID))
-		err = remoteCommand.UpdateServer(args.MinecraftResource)
+		remoteCommand := update.NewRemoteServerFromArgs(args, instance.NetworkInterfaces[0].AccessConfigs[0].NatIP, g.SSHLogin()).WithTemplateOptions(g.templateOptions...)
+		defer func() { _ = remoteCommand.Close() }()
+		_, err = remoteCommand.UpdateServerStream(ctx, args.MinecraftResource, update.ExecOptions{})
 		if err != nil {
 			return err
 		}
@@ -421,18 +515,20 @@ func (g *GCE) UpdateServer(id string, args automation.ServerArgs) error {
 
 // UploadPlugin uploads a plugin to a Minecraft server on GCE.
 func (g *GCE) UploadPlugin(id string, args automation.ServerArgs, plugin, destination string) error {
-	instancesList, err := g.getInstanceList(id, args.MinecraftResource.GetRegion())
+	return g.UploadPluginContext(context.Background(), id, args, plugin, destination)
+}
+
+// UploadPluginContext is like UploadPlugin but honors the cancellation and deadline of ctx.
+func (g *GCE) UploadPluginContext(ctx context.Context, id string, args automation.ServerArgs, plugin, destination string) error {
+	instancesList, err := g.getInstanceList(ctx, id, args.MinecraftResource.GetRegion())
 	if err != nil {
 		return err
 	}
 	if len(instancesList) == 1 {
 		instance := instancesList[0]
-		remoteCommand := update.NewRemoteServer(args.SSHPrivateKeyPath, instance.NetworkInterfaces[0].AccessConfigs[0].NatIP, fmt.Sprintf("sa_%s", g.serviceAccountID))
-		err = remoteCommand.TransferFile(plugin, filepath.Join(destination, filepath.Base(plugin)), args.MinecraftResource.GetSSHPort())
-		if err != nil {
-			return err
-		}
-		_, err = remoteCommand.ExecuteCommand("systemctl restart minecraft.service", args.MinecraftResource.GetSSHPort())
+		remoteCommand := update.NewRemoteServerFromArgs(args, instance.NetworkInterfaces[0].AccessConfigs[0].NatIP, g.SSHLogin())
+		defer func() { _ = remoteCommand.Close() }()
+		err = remoteCommand.UploadPluginContext(ctx, plugin, destination, args.MinecraftResource.GetSSHPort())
 		if err != nil {
 			return err
 		}
@@ -442,23 +538,21 @@ func (g *GCE) UploadPlugin(id string, args automation.ServerArgs, plugin, destin
 
 // GetServer gets a Minecraft server on GCE.
 func (g *GCE) GetServer(id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
+	return g.GetServerContext(context.Background(), id, args)
+}
+
+// GetServerContext is like GetServer but honors the cancellation and deadline of ctx.
+func (g *GCE) GetServerContext(ctx context.Context, id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
 	instancesListOp, err := g.client.Instances.List(g.projectID, args.MinecraftResource.GetRegion()).
 		Filter(fmt.Sprintf("(id=%s)", id)).
-		Context(context.Background()).
+		Context(ctx).
 		Do()
 	if err != nil {
 		return nil, err
 	}
 	if len(instancesListOp.Items) == 1 {
 		instance := instancesListOp.Items[0]
-		ip := instance.NetworkInterfaces[0].AccessConfigs[0].NatIP
-		return &automation.ResourceResults{
-			ID:       strconv.FormatUint(instance.Id, 10),
-			Name:     instance.Name,
-			Region:   instance.Zone,
-			PublicIP: ip,
-			Tags:     strings.Join(instance.Tags.Items, ","),
-		}, err
+		return instanceToResourceResults(instance), err
 	}
 	return nil, nil
 }
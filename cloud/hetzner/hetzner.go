@@ -4,7 +4,6 @@ package hetzner
 import (
 	"context"
 	"fmt"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -12,38 +11,62 @@ import (
 	"github.com/dirien/minectl-sdk/automation"
 	"github.com/dirien/minectl-sdk/cloud"
 	"github.com/dirien/minectl-sdk/common"
+	"github.com/dirien/minectl-sdk/model"
 	minctlTemplate "github.com/dirien/minectl-sdk/template"
 	"github.com/dirien/minectl-sdk/update"
 	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"go.uber.org/zap"
 )
 
+// sshLogin is the user minectl logs in as on Hetzner servers.
+var sshLogin = update.RootLogin
+
 // Hetzner implements the Automation interface for Hetzner.
 type Hetzner struct {
-	client *hcloud.Client
-	tmpl   *minctlTemplate.Template
+	client          *hcloud.Client
+	tmpl            *minctlTemplate.Template
+	templateOptions []minctlTemplate.Option
+}
+
+func init() {
+	cloud.Register(cloud.Provider{
+		Code: model.ProviderHetzner,
+		Credentials: []cloud.CredentialField{
+			{Name: cloud.CredentialToken, EnvVar: "HCLOUD_TOKEN", Description: "Hetzner Cloud API token", Required: true},
+		},
+		Factory: func(_ context.Context, creds cloud.Credentials, opts []minctlTemplate.Option) (automation.Automation, error) {
+			return NewHetzner(creds[cloud.CredentialToken], opts...)
+		},
+	})
 }
 
 // NewHetzner creates a new Hetzner instance.
-func NewHetzner(apiKey string) (*Hetzner, error) {
+func NewHetzner(apiKey string, opts ...minctlTemplate.Option) (*Hetzner, error) {
 	client := hcloud.NewClient(hcloud.WithToken(apiKey))
-	tmpl, err := minctlTemplate.NewTemplateCloudConfig()
+	tmpl, err := minctlTemplate.NewTemplateCloudConfig(opts...)
 	if err != nil {
 		return nil, err
 	}
 	hetzner := &Hetzner{
-		client: client,
-		tmpl:   tmpl,
+		client:          client,
+		tmpl:            tmpl,
+		templateOptions: opts,
 	}
 	return hetzner, nil
 }
 
 // CreateServer creates a new Minecraft server on Hetzner.
 func (h *Hetzner) CreateServer(args automation.ServerArgs) (*automation.ResourceResults, error) {
+	return h.CreateServerContext(context.Background(), args)
+}
+
+// CreateServerContext is like CreateServer but honors the cancellation and deadline of ctx.
+func (h *Hetzner) CreateServerContext(ctx context.Context, args automation.ServerArgs) (*automation.ResourceResults, error) {
 	publicKey, err := cloud.GetSSHPublicKey(args)
 	if err != nil {
 		return nil, err
 	}
-	key, _, err := h.client.SSHKey.Create(context.Background(), hcloud.SSHKeyCreateOpts{
+	key, _, err := h.client.SSHKey.Create(ctx, hcloud.SSHKeyCreateOpts{
 		Name:      fmt.Sprintf("%s-ssh", args.MinecraftResource.GetName()),
 		PublicKey: *publicKey,
 	})
@@ -51,26 +74,21 @@ func (h *Hetzner) CreateServer(args automation.ServerArgs) (*automation.Resource
 		return nil, err
 	}
 
-	location, _, err := h.client.Location.Get(context.Background(), args.MinecraftResource.GetRegion())
+	location, _, err := h.client.Location.Get(ctx, args.MinecraftResource.GetRegion())
 	if err != nil {
 		return nil, err
 	}
 
-	var volume hcloud.VolumeCreateResult
+	var volume *hcloud.Volume
 	var mount string
 	if args.MinecraftResource.GetVolumeSize() > 0 {
-		volume, _, err = h.client.Volume.Create(context.Background(), hcloud.VolumeCreateOpts{
-			Name:     fmt.Sprintf("%s-vol", args.MinecraftResource.GetName()),
-			Size:     args.MinecraftResource.GetVolumeSize(),
-			Location: location,
-			Format:   hcloud.Ptr("ext4"),
-		})
+		volume, err = h.volume(ctx, args, location)
 		if err != nil {
 			return nil, err
 		}
 		mount = "sdb"
 	}
-	userData, err := h.tmpl.GetTemplate(args.MinecraftResource, &minctlTemplate.CreateUpdateTemplateArgs{Mount: mount, Name: minctlTemplate.GetTemplateCloudConfigName(args.MinecraftResource.IsProxyServer())})
+	userData, err := h.tmpl.GetTemplateContext(ctx, args.MinecraftResource, &minctlTemplate.CreateUpdateTemplateArgs{Mount: mount, SSHHostKey: args.SSHHostKey, Name: minctlTemplate.GetTemplateCloudConfigName(args.MinecraftResource.IsProxyServer())})
 	if err != nil {
 		return nil, err
 	}
@@ -78,12 +96,12 @@ func (h *Hetzner) CreateServer(args automation.ServerArgs) (*automation.Resource
 	if args.MinecraftResource.IsArm() {
 		arch = hcloud.ArchitectureARM
 	}
-	image, _, err := h.client.Image.GetByNameAndArchitecture(context.Background(), "ubuntu-22.04", arch)
+	image, _, err := h.client.Image.GetByNameAndArchitecture(ctx, "ubuntu-22.04", arch)
 	if err != nil {
 		return nil, err
 	}
 
-	plan, _, err := h.client.ServerType.GetByName(context.Background(), args.MinecraftResource.GetSize())
+	plan, _, err := h.client.ServerType.GetByName(ctx, args.MinecraftResource.GetSize())
 	if err != nil {
 		return nil, err
 	}
@@ -95,15 +113,15 @@ func (h *Hetzner) CreateServer(args automation.ServerArgs) (*automation.Resource
 		Location:   location,
 		SSHKeys:    []*hcloud.SSHKey{key},
 		UserData:   userData,
-		Labels:     map[string]string{common.InstanceTag: "true", args.MinecraftResource.GetEdition(): "true"},
+		Labels:     automation.ServerLabels(args),
 	}
 
 	if args.MinecraftResource.GetVolumeSize() > 0 {
-		requestOpts.Volumes = []*hcloud.Volume{volume.Volume}
+		requestOpts.Volumes = []*hcloud.Volume{volume}
 		requestOpts.Automount = hcloud.Ptr(true)
 	}
 
-	serverCreateReq, _, err := h.client.Server.Create(context.Background(), requestOpts)
+	serverCreateReq, _, err := h.client.Server.Create(ctx, requestOpts)
 	if err != nil {
 		return nil, err
 	}
@@ -111,70 +129,106 @@ func (h *Hetzner) CreateServer(args automation.ServerArgs) (*automation.Resource
 	stillCreating := true
 
 	for stillCreating {
-		server, _, err := h.client.Server.GetByID(context.Background(), server.ID)
+		server, _, err = h.client.Server.GetByID(ctx, server.ID)
 		if err != nil {
 			return nil, err
 		}
 		if server.Status == hcloud.ServerStatusRunning {
 			stillCreating = false
-		} else {
-			time.Sleep(2 * time.Second)
+		} else if err := common.Sleep(ctx, 2*time.Second); err != nil {
+			return nil, err
+		}
+	}
+	return hetznerServerToResourceResults(server), err
+}
+
+// volume returns the volume retained from a deleted server with the same name,
+// or creates a new one.
+func (h *Hetzner) volume(ctx context.Context, args automation.ServerArgs, location *hcloud.Location) (*hcloud.Volume, error) {
+	name := fmt.Sprintf("%s-vol", args.MinecraftResource.GetName())
+	volume, _, err := h.client.Volume.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if volume != nil {
+		if volume.Server != nil {
+			return nil, fmt.Errorf("volume %s is attached to another server", name)
 		}
+		if volume.Location.Name != location.Name {
+			return nil, fmt.Errorf("volume %s is in location %s, not %s", name, volume.Location.Name, location.Name)
+		}
+		zap.S().Infow("Attaching existing volume", "name", args.MinecraftResource.GetName(), "volume", name)
+		return volume, nil
+	}
+	result, _, err := h.client.Volume.Create(ctx, hcloud.VolumeCreateOpts{
+		Name:     name,
+		Size:     args.MinecraftResource.GetVolumeSize(),
+		Location: location,
+		Format:   hcloud.Ptr("ext4"),
+	})
+	if err != nil {
+		return nil, err
 	}
-	return &automation.ResourceResults{
-		ID:       strconv.FormatInt(server.ID, 10),
-		Name:     server.Name,
-		Region:   server.Location.Name,
-		PublicIP: server.PublicNet.IPv4.IP.String(),
-		Tags:     hetznerLabelsToTags(server.Labels),
-	}, err
+	return result.Volume, nil
+}
+
+// SSHLogin returns the user minectl logs in as on the servers, see
+// update.LoginProvider.
+func (h *Hetzner) SSHLogin() update.Login {
+	return sshLogin
 }
 
 // DeleteServer deletes a Minecraft server on Hetzner.
 func (h *Hetzner) DeleteServer(id string, args automation.ServerArgs) error {
+	return h.DeleteServerContext(context.Background(), id, args)
+}
+
+// DeleteServerContext is like DeleteServer but honors the cancellation and deadline of ctx.
+func (h *Hetzner) DeleteServerContext(ctx context.Context, id string, args automation.ServerArgs) error {
 	serverID, _ := strconv.ParseInt(id, 10, 64)
-	server, _, err := h.client.Server.GetByID(context.Background(), serverID)
+	server, _, err := h.client.Server.GetByID(ctx, serverID)
 	if err != nil {
 		return err
 	}
 
-	volume, _, err := h.client.Volume.Get(context.Background(), fmt.Sprintf("%s-vol", args.MinecraftResource.GetName()))
+	volume, _, err := h.client.Volume.Get(ctx, fmt.Sprintf("%s-vol", args.MinecraftResource.GetName()))
 	if err != nil {
 		return err
 	}
 
 	if volume != nil {
-		res, _, err := h.client.Volume.Detach(context.Background(), volume)
+		res, _, err := h.client.Volume.Detach(ctx, volume)
 		if err != nil {
 			return err
 		}
 		stillDetatching := true
 		for stillDetatching {
-			action, _, err := h.client.Action.GetByID(context.Background(), res.ID)
+			action, _, err := h.client.Action.GetByID(ctx, res.ID)
 			if err != nil {
 				return err
 			}
 			if action.Status == "success" {
 				stillDetatching = false
-			} else {
-				time.Sleep(2 * time.Second)
+			} else if err := common.Sleep(ctx, 2*time.Second); err != nil {
+				return err
 			}
 		}
-		_, err = h.client.Volume.Delete(context.Background(), volume)
-		if err != nil {
+		if args.MinecraftResource.RetainVolume() {
+			zap.S().Infow("Keeping volume", "name", args.MinecraftResource.GetName(), "volume", volume.Name)
+		} else if _, err = h.client.Volume.Delete(ctx, volume); err != nil {
 			return err
 		}
 	}
-	_, _, err = h.client.Server.DeleteWithResult(context.Background(), server)
+	_, _, err = h.client.Server.DeleteWithResult(ctx, server)
 	if err != nil {
 		return err
 	}
 
-	key, _, err := h.client.SSHKey.Get(context.Background(), fmt.Sprintf("%s-ssh", args.MinecraftResource.GetName()))
+	key, _, err := h.client.SSHKey.Get(ctx, fmt.Sprintf("%s-ssh", args.MinecraftResource.GetName()))
 	if err != nil {
 		return err
 	}
-	_, err = h.client.SSHKey.Delete(context.Background(), key)
+	_, err = h.client.SSHKey.Delete(ctx, key)
 	if err != nil {
 		return err
 	}
@@ -189,9 +243,63 @@ func hetznerLabelsToTags(label map[string]string) string {
 	return strings.Join(tags, ",")
 }
 
+func hetznerStatus(status hcloud.ServerStatus) automation.Status {
+	switch status {
+	case hcloud.ServerStatusInitializing, hcloud.ServerStatusStarting, hcloud.ServerStatusMigrating, hcloud.ServerStatusRebuilding:
+		return automation.StatusPending
+	case hcloud.ServerStatusRunning:
+		return automation.StatusRunning
+	case hcloud.ServerStatusStopping:
+		return automation.StatusStopping
+	case hcloud.ServerStatusOff:
+		return automation.StatusStopped
+	case hcloud.ServerStatusDeleting:
+		return automation.StatusDeleting
+	default:
+		return automation.StatusUnknown
+	}
+}
+
+func hetznerServerToResourceResults(server *hcloud.Server) *automation.ResourceResults {
+	result := &automation.ResourceResults{
+		ID:        strconv.FormatInt(server.ID, 10),
+		Name:      server.Name,
+		Region:    server.Location.Name,
+		PublicIP:  server.PublicNet.IPv4.IP.String(),
+		Tags:      hetznerLabelsToTags(server.Labels),
+		Status:    hetznerStatus(server.Status),
+		CreatedAt: server.Created,
+		Labels:    server.Labels,
+		Edition:   automation.EditionFromLabels(server.Labels),
+		Version:   automation.VersionFromLabels(server.Labels),
+	}
+	if !server.PublicNet.IPv6.IsUnspecified() {
+		result.PublicIPv6 = server.PublicNet.IPv6.IP.String()
+	}
+	if len(server.PrivateNet) > 0 {
+		result.PrivateIP = server.PrivateNet[0].IP.String()
+	}
+	if server.ServerType != nil {
+		result.Size = server.ServerType.Name
+		result.Arch = automation.ArchAMD64
+		if server.ServerType.Architecture == hcloud.ArchitectureARM {
+			result.Arch = automation.ArchARM64
+		}
+	}
+	for _, volume := range server.Volumes {
+		result.VolumeIDs = append(result.VolumeIDs, strconv.FormatInt(volume.ID, 10))
+	}
+	return result
+}
+
 // ListServer lists all Minecraft servers on Hetzner.
 func (h *Hetzner) ListServer() ([]automation.ResourceResults, error) {
-	servers, err := h.client.Server.All(context.Background())
+	return h.ListServerContext(context.Background())
+}
+
+// ListServerContext is like ListServer but honors the cancellation and deadline of ctx.
+func (h *Hetzner) ListServerContext(ctx context.Context) ([]automation.ResourceResults, error) {
+	servers, err := h.client.Server.All(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -199,13 +307,7 @@ func (h *Hetzner) ListServer() ([]automation.ResourceResults, error) {
 	for _, server := range servers {
 		for key := range server.Labels {
 			if key == common.InstanceTag {
-				result = append(result, automation.ResourceResults{
-					ID:       strconv.FormatInt(server.ID, 10),
-					Name:     server.Name,
-					Region:   server.Location.Name,
-					PublicIP: server.PublicNet.IPv4.IP.String(),
-					Tags:     hetznerLabelsToTags(server.Labels),
-				})
+				result = append(result, *hetznerServerToResourceResults(server))
 			}
 		}
 	}
@@ -214,14 +316,20 @@ func (h *Hetzner) ListServer() ([]automation.ResourceResults, error) {
 
 // UpdateServer updates a Minecraft server on Hetzner.
 func (h *Hetzner) UpdateServer(id string, args automation.ServerArgs) error {
+	return h.UpdateServerContext(context.Background(), id, args)
+}
+
+// UpdateServerContext is like UpdateServer but honors the cancellation and deadline of ctx.
+func (h *Hetzner) UpdateServerContext(ctx context.Context, id string, args automation.ServerArgs) error {
 	intID, _ := strconv.ParseInt(id, 10, 64)
-	instance, _, err := h.client.Server.GetByID(context.Background(), intID)
+	instance, _, err := h.client.Server.GetByID(ctx, intID)
 	if err != nil {
 		return err
 	}
 
-	remoteCommand := update.NewRemoteServer(args.SSHPrivateKeyPath, instance.PublicNet.IPv4.IP.String(), "root")
-	err = remoteCommand.UpdateServer(args.MinecraftResource)
+	remoteCommand := update.NewRemoteServerFromArgs(args, instance.PublicNet.IPv4.IP.String(), sshLogin).WithTemplateOptions(h.templateOptions...)
+	defer func() { _ = remoteCommand.Close() }()
+	_, err = remoteCommand.UpdateServerStream(ctx, args.MinecraftResource, update.ExecOptions{})
 	if err != nil {
 		return err
 	}
@@ -230,18 +338,20 @@ func (h *Hetzner) UpdateServer(id string, args automation.ServerArgs) error {
 
 // UploadPlugin uploads a plugin to a Minecraft server on Hetzner.
 func (h *Hetzner) UploadPlugin(id string, args automation.ServerArgs, plugin, destination string) error {
+	return h.UploadPluginContext(context.Background(), id, args, plugin, destination)
+}
+
+// UploadPluginContext is like UploadPlugin but honors the cancellation and deadline of ctx.
+func (h *Hetzner) UploadPluginContext(ctx context.Context, id string, args automation.ServerArgs, plugin, destination string) error {
 	intID, _ := strconv.ParseInt(id, 10, 64)
-	instance, _, err := h.client.Server.GetByID(context.Background(), intID)
+	instance, _, err := h.client.Server.GetByID(ctx, intID)
 	if err != nil {
 		return err
 	}
 
-	remoteCommand := update.NewRemoteServer(args.SSHPrivateKeyPath, instance.PublicNet.IPv4.IP.String(), "root")
-	err = remoteCommand.TransferFile(plugin, filepath.Join(destination, filepath.Base(plugin)), args.MinecraftResource.GetSSHPort())
-	if err != nil {
-		return err
-	}
-	_, err = remoteCommand.ExecuteCommand("systemctl restart minecraft.service", args.MinecraftResource.GetSSHPort())
+	remoteCommand := update.NewRemoteServerFromArgs(args, instance.PublicNet.IPv4.IP.String(), sshLogin)
+	defer func() { _ = remoteCommand.Close() }()
+	err = remoteCommand.UploadPluginContext(ctx, plugin, destination, args.MinecraftResource.GetSSHPort())
 	if err != nil {
 		return err
 	}
@@ -249,17 +359,16 @@ func (h *Hetzner) UploadPlugin(id string, args automation.ServerArgs, plugin, de
 }
 
 // GetServer gets a Minecraft server on Hetzner.
-func (h *Hetzner) GetServer(id string, _ automation.ServerArgs) (*automation.ResourceResults, error) {
+func (h *Hetzner) GetServer(id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
+	return h.GetServerContext(context.Background(), id, args)
+}
+
+// GetServerContext is like GetServer but honors the cancellation and deadline of ctx.
+func (h *Hetzner) GetServerContext(ctx context.Context, id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
 	intID, _ := strconv.ParseInt(id, 10, 64)
-	instance, _, err := h.client.Server.GetByID(context.Background(), intID)
+	instance, _, err := h.client.Server.GetByID(ctx, intID)
 	if err != nil {
 		return nil, err
 	}
-	return &automation.ResourceResults{
-		ID:       strconv.FormatInt(instance.ID, 10),
-		Name:     instance.Name,
-		Region:   instance.Location.Name,
-		PublicIP: instance.PublicNet.IPv4.IP.String(),
-		Tags:     hetznerLabelsToTags(instance.Labels),
-	}, err
+	return hetznerServerToResourceResults(instance), err
 }
@@ -3,47 +3,267 @@ package multipass
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/dirien/minectl-sdk/automation"
 	"github.com/dirien/minectl-sdk/cloud"
+	"github.com/dirien/minectl-sdk/common"
+	"github.com/dirien/minectl-sdk/model"
 	minctlTemplate "github.com/dirien/minectl-sdk/template"
 	"github.com/dirien/minectl-sdk/update"
+	"github.com/gofrs/flock"
 )
 
+// sshLogin is the user minectl logs in as on Multipass servers.
+var sshLogin = update.UbuntuLogin
+
 const (
 	multipassBinary = "multipass"
 )
 
 // Multipass implements the Automation interface for Ubuntu Multipass.
 type Multipass struct {
-	tmpl *minctlTemplate.Template
+	tmpl            *minctlTemplate.Template
+	templateOptions []minctlTemplate.Option
+	// metadataFile records the VMs created by minectl, as Multipass has no labels.
+	metadataFile string
+}
+
+// instanceMetadata is what is persisted per VM in the metadata file.
+type instanceMetadata struct {
+	Edition string `json:"edition"`
+	Version string `json:"version,omitempty"`
+}
+
+type infoOutput struct {
+	Info map[string]struct {
+		IPv4  []string `json:"ipv4"`
+		State string   `json:"state"`
+	} `json:"info"`
+}
+
+type listOutput struct {
+	List []struct {
+		Name  string   `json:"name"`
+		IPv4  []string `json:"ipv4"`
+		State string   `json:"state"`
+	} `json:"list"`
+}
+
+func init() {
+	cloud.Register(cloud.Provider{
+		Code: model.ProviderMultipass,
+		Factory: func(_ context.Context, _ cloud.Credentials, opts []minctlTemplate.Option) (automation.Automation, error) {
+			return NewMultipass(opts...)
+		},
+	})
 }
 
 // NewMultipass creates a new Multipass instance.
-func NewMultipass() (*Multipass, error) {
-	tmpl, err := minctlTemplate.NewTemplateCloudConfig()
+func NewMultipass(opts ...minctlTemplate.Option) (*Multipass, error) {
+	tmpl, err := minctlTemplate.NewTemplateCloudConfig(opts...)
+	if err != nil {
+		return nil, err
+	}
+	configDir, err := os.UserConfigDir()
 	if err != nil {
 		return nil, err
 	}
 	return &Multipass{
-		tmpl: tmpl,
+		tmpl:            tmpl,
+		templateOptions: opts,
+		metadataFile:    filepath.Join(configDir, "minectl", "multipass.json"),
 	}, nil
 }
 
+func (m *Multipass) readMetadata() (map[string]instanceMetadata, error) {
+	metadata := map[string]instanceMetadata{}
+	data, err := os.ReadFile(m.metadataFile)
+	if os.IsNotExist(err) {
+		return metadata, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &metadata)
+	if err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// lockMetadata locks the metadata file against other minectl processes,
+// exclusively to change it or shared to read it, and returns the unlock
+// function.
+func (m *Multipass) lockMetadata(ctx context.Context, exclusive bool) (func(), error) {
+	err := os.MkdirAll(filepath.Dir(m.metadataFile), 0o700)
+	if err != nil {
+		return nil, err
+	}
+	lock := flock.New(m.metadataFile + ".lock")
+	tryLock := lock.TryRLockContext
+	if exclusive {
+		tryLock = lock.TryLockContext
+	}
+	if _, err := tryLock(ctx, 50*time.Millisecond); err != nil {
+		return nil, err
+	}
+	return func() { _ = lock.Unlock() }, nil
+}
+
+// loadMetadata reads the metadata file under a shared lock.
+func (m *Multipass) loadMetadata(ctx context.Context) (map[string]instanceMetadata, error) {
+	unlock, err := m.lockMetadata(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return m.readMetadata()
+}
+
+// updateMetadata applies fn to the metadata file under an exclusive lock, so
+// concurrent creates and deletes do not lose each other's changes.
+func (m *Multipass) updateMetadata(ctx context.Context, fn func(metadata map[string]instanceMetadata)) error {
+	unlock, err := m.lockMetadata(ctx, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	metadata, err := m.readMetadata()
+	if err != nil {
+		return err
+	}
+	fn(metadata)
+	return m.writeMetadata(metadata)
+}
+
+func (m *Multipass) writeMetadata(metadata map[string]instanceMetadata) error {
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(m.metadataFile), 0o700)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(m.metadataFile, data, 0o600)
+}
+
+func multipassTags(metadata instanceMetadata) string {
+	return strings.Join([]string{common.InstanceTag, metadata.Edition}, ",")
+}
+
+func multipassStatus(state string) automation.Status {
+	switch state {
+	case "Starting", "Restarting":
+		return automation.StatusPending
+	case "Running":
+		return automation.StatusRunning
+	case "Delayed Shutdown", "Suspending":
+		return automation.StatusStopping
+	case "Stopped", "Suspended":
+		return automation.StatusStopped
+	case "Deleted":
+		return automation.StatusDeleting
+	default:
+		return automation.StatusUnknown
+	}
+}
+
+func multipassResourceResults(name, ip, state string, metadata instanceMetadata) *automation.ResourceResults {
+	result := &automation.ResourceResults{
+		ID:       name,
+		Name:     name,
+		Region:   multipassBinary,
+		PublicIP: ip,
+		Status:   multipassStatus(state),
+	}
+	if metadata.Edition != "" {
+		result.Tags = multipassTags(metadata)
+		result.Labels = automation.LabelsFromTags(strings.Split(result.Tags, ","))
+		result.Edition = metadata.Edition
+		result.Version = metadata.Version
+	}
+	return result
+}
+
+// parseList returns the VMs from the output of multipass list that are
+// recorded in metadata.
+func parseList(data []byte, metadata map[string]instanceMetadata) ([]automation.ResourceResults, error) {
+	var output listOutput
+	err := json.Unmarshal(data, &output)
+	if err != nil {
+		return nil, err
+	}
+	var result []automation.ResourceResults
+	for _, instance := range output.List {
+		meta, ok := metadata[instance.Name]
+		if !ok {
+			continue
+		}
+		var ip string
+		if len(instance.IPv4) > 0 {
+			ip = instance.IPv4[0]
+		}
+		result = append(result, *multipassResourceResults(instance.Name, ip, instance.State, meta))
+	}
+	return result, nil
+}
+
+// parseInfo returns the first IPv4 address and the state of the VM name from
+// the output of multipass info. The address is empty if the VM has none, as
+// when it is stopped.
+func parseInfo(data []byte, name string) (string, string, error) {
+	var output infoOutput
+	err := json.Unmarshal(data, &output)
+	if err != nil {
+		return "", "", err
+	}
+	info, ok := output.Info[name]
+	if !ok {
+		return "", "", fmt.Errorf("multipass: no info for VM %s", name)
+	}
+	var ip string
+	if len(info.IPv4) > 0 {
+		ip = info.IPv4[0]
+	}
+	return ip, info.State, nil
+}
+
+// remoteServer connects to the VM id, which must be running to have an IPv4
+// address.
+func (m Multipass) remoteServer(ctx context.Context, id string, args automation.ServerArgs) (*update.RemoteServer, error) {
+	instance, err := m.GetServerContext(ctx, id, args)
+	if err != nil {
+		return nil, err
+	}
+	if instance.PublicIP == "" {
+		return nil, fmt.Errorf("multipass: VM %s has no IPv4 address, it is %s", instance.Name, instance.Status)
+	}
+	return update.NewRemoteServerFromArgs(args, instance.PublicIP, sshLogin).WithTemplateOptions(m.templateOptions...), nil
+}
+
 // CreateServer creates a new Multipass VM.
 func (m *Multipass) CreateServer(args automation.ServerArgs) (*automation.ResourceResults, error) {
+	return m.CreateServerContext(context.Background(), args)
+}
+
+// CreateServerContext is like CreateServer but honors the cancellation and deadline of ctx.
+func (m *Multipass) CreateServerContext(ctx context.Context, args automation.ServerArgs) (*automation.ResourceResults, error) {
 	publicKey, err := cloud.GetSSHPublicKey(args)
 	if err != nil {
 		return nil, err
 	}
-	script, err := m.tmpl.GetTemplate(args.MinecraftResource, &minctlTemplate.CreateUpdateTemplateArgs{
+	script, err := m.tmpl.GetTemplateContext(ctx, args.MinecraftResource, &minctlTemplate.CreateUpdateTemplateArgs{
 		SSHPublicKey: *publicKey,
+		SSHHostKey:   args.SSHHostKey,
 		Name:         minctlTemplate.GetTemplateCloudConfigName(args.MinecraftResource.IsProxyServer()),
 	})
 	if err != nil {
@@ -67,7 +287,7 @@ func (m *Multipass) CreateServer(args automation.ServerArgs) (*automation.Resour
 	arg7 := "-m"
 	arg8 := v
 
-	cmd := exec.Command(app, arg0, arg1, arg2, arg3, arg4, arg5, arg6, arg7, arg8) //nolint:gosec // multipass command with validated inputs
+	cmd := exec.CommandContext(ctx, app, arg0, arg1, arg2, arg3, arg4, arg5, arg6, arg7, arg8) //nolint:gosec // multipass command with validated inputs
 	cmdOutput := &bytes.Buffer{}
 	cmd.Stdout = cmdOutput
 	err = cmd.Run()
@@ -75,42 +295,89 @@ func (m *Multipass) CreateServer(args automation.ServerArgs) (*automation.Resour
 		return nil, err
 	}
 
-	return m.GetServer(args.MinecraftResource.GetName(), args)
+	err = m.updateMetadata(ctx, func(metadata map[string]instanceMetadata) {
+		metadata[args.MinecraftResource.GetName()] = instanceMetadata{
+			Edition: args.MinecraftResource.GetEdition(),
+			Version: args.MinecraftResource.GetVersion(),
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m.GetServerContext(ctx, args.MinecraftResource.GetName(), args)
+}
+
+// SSHLogin returns the user minectl logs in as on the servers, see
+// update.LoginProvider.
+func (m *Multipass) SSHLogin() update.Login {
+	return sshLogin
 }
 
 // DeleteServer deletes a Minecraft server on Multipass.
-func (m *Multipass) DeleteServer(id string, _ automation.ServerArgs) error {
-	cmd := exec.Command(multipassBinary, "delete", id)
+func (m *Multipass) DeleteServer(id string, args automation.ServerArgs) error {
+	return m.DeleteServerContext(context.Background(), id, args)
+}
+
+// DeleteServerContext is like DeleteServer but honors the cancellation and deadline of ctx.
+func (m *Multipass) DeleteServerContext(ctx context.Context, id string, _ automation.ServerArgs) error {
+	cmd := exec.CommandContext(ctx, multipassBinary, "delete", id)
 	cmdOutput := &bytes.Buffer{}
 	cmd.Stdout = cmdOutput
 	err := cmd.Run()
 	if err != nil {
 		return err
 	}
-	cmd = exec.Command(multipassBinary, "purge")
+	cmd = exec.CommandContext(ctx, multipassBinary, "purge")
 	cmdOutput = &bytes.Buffer{}
 	cmd.Stdout = cmdOutput
 	err = cmd.Run()
 	if err != nil {
 		return err
 	}
-	return nil
+
+	return m.updateMetadata(ctx, func(metadata map[string]instanceMetadata) {
+		delete(metadata, id)
+	})
 }
 
 // ListServer lists all Minecraft servers on Multipass.
 func (m *Multipass) ListServer() ([]automation.ResourceResults, error) {
-	panic("List Server is not possible with Multipass, as it does not support labels")
+	return m.ListServerContext(context.Background())
+}
+
+// ListServerContext is like ListServer but honors the cancellation and deadline of ctx.
+func (m *Multipass) ListServerContext(ctx context.Context) ([]automation.ResourceResults, error) {
+	metadata, err := m.loadMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(metadata) == 0 {
+		return nil, nil
+	}
+	cmd := exec.CommandContext(ctx, multipassBinary, "list", "--format", "json")
+	cmdOutput := &bytes.Buffer{}
+	cmd.Stdout = cmdOutput
+	err = cmd.Run()
+	if err != nil {
+		return nil, err
+	}
+	return parseList(cmdOutput.Bytes(), metadata)
 }
 
 // UpdateServer updates a Minecraft server on Multipass.
 func (m Multipass) UpdateServer(id string, args automation.ServerArgs) error {
-	instance, err := m.GetServer(id, args)
+	return m.UpdateServerContext(context.Background(), id, args)
+}
+
+// UpdateServerContext is like UpdateServer but honors the cancellation and deadline of ctx.
+func (m Multipass) UpdateServerContext(ctx context.Context, id string, args automation.ServerArgs) error {
+	remoteCommand, err := m.remoteServer(ctx, id, args)
 	if err != nil {
 		return err
 	}
-
-	remoteCommand := update.NewRemoteServer(args.SSHPrivateKeyPath, instance.PublicIP, "ubuntu")
-	err = remoteCommand.UpdateServer(args.MinecraftResource)
+	defer func() { _ = remoteCommand.Close() }()
+	_, err = remoteCommand.UpdateServerStream(ctx, args.MinecraftResource, update.ExecOptions{})
 	if err != nil {
 		return err
 	}
@@ -119,17 +386,17 @@ func (m Multipass) UpdateServer(id string, args automation.ServerArgs) error {
 
 // UploadPlugin uploads a plugin to a Minecraft server on Multipass.
 func (m Multipass) UploadPlugin(id string, args automation.ServerArgs, plugin, destination string) error {
-	instance, err := m.GetServer(id, args)
-	if err != nil {
-		return err
-	}
+	return m.UploadPluginContext(context.Background(), id, args, plugin, destination)
+}
 
-	remoteCommand := update.NewRemoteServer(args.SSHPrivateKeyPath, instance.PublicIP, "root")
-	err = remoteCommand.TransferFile(plugin, filepath.Join(destination, filepath.Base(plugin)), args.MinecraftResource.GetSSHPort())
+// UploadPluginContext is like UploadPlugin but honors the cancellation and deadline of ctx.
+func (m Multipass) UploadPluginContext(ctx context.Context, id string, args automation.ServerArgs, plugin, destination string) error {
+	remoteCommand, err := m.remoteServer(ctx, id, args)
 	if err != nil {
 		return err
 	}
-	_, err = remoteCommand.ExecuteCommand("systemctl restart minecraft.service", args.MinecraftResource.GetSSHPort())
+	defer func() { _ = remoteCommand.Close() }()
+	err = remoteCommand.UploadPluginContext(ctx, plugin, destination, args.MinecraftResource.GetSSHPort())
 	if err != nil {
 		return err
 	}
@@ -137,23 +404,26 @@ func (m Multipass) UploadPlugin(id string, args automation.ServerArgs, plugin, d
 }
 
 // GetServer gets a Minecraft server on Multipass.
-func (m Multipass) GetServer(_ string, args automation.ServerArgs) (*automation.ResourceResults, error) {
-	cmd := exec.Command(multipassBinary, "info", "--format", "json", args.MinecraftResource.GetName()) //nolint: gosec
+func (m Multipass) GetServer(id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
+	return m.GetServerContext(context.Background(), id, args)
+}
+
+// GetServerContext is like GetServer but honors the cancellation and deadline of ctx.
+func (m Multipass) GetServerContext(ctx context.Context, _ string, args automation.ServerArgs) (*automation.ResourceResults, error) {
+	cmd := exec.CommandContext(ctx, multipassBinary, "info", "--format", "json", args.MinecraftResource.GetName()) //nolint: gosec
 	cmdOutput := &bytes.Buffer{}
 	cmd.Stdout = cmdOutput
 	err := cmd.Run()
 	if err != nil {
 		return nil, err
 	}
-	var result map[string]interface{}
-	err = json.Unmarshal(cmdOutput.Bytes(), &result)
-	server := result["info"].(map[string]interface{})[args.MinecraftResource.GetName()].(map[string]interface{})
-	ip := server["ipv4"].([]interface{})[0].(string)
-	return &automation.ResourceResults{
-		ID:       args.MinecraftResource.GetName(),
-		Name:     args.MinecraftResource.GetName(),
-		Region:   multipassBinary,
-		PublicIP: ip,
-		Tags:     "",
-	}, err
+	ip, state, err := parseInfo(cmdOutput.Bytes(), args.MinecraftResource.GetName())
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := m.loadMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return multipassResourceResults(args.MinecraftResource.GetName(), ip, state, metadata[args.MinecraftResource.GetName()]), nil
 }
@@ -0,0 +1,124 @@
+package multipass
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/dirien/minectl-sdk/automation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const listJSON = `{
+    "list": [
+        {"ipv4": ["192.168.64.5"], "name": "minecraft-java", "release": "22.04 LTS", "state": "Running"},
+        {"ipv4": [], "name": "minecraft-bedrock", "release": "22.04 LTS", "state": "Stopped"},
+        {"ipv4": ["192.168.64.7"], "name": "unrelated", "release": "22.04 LTS", "state": "Running"}
+    ]
+}`
+
+func TestMetadataRoundTrip(t *testing.T) {
+	m := &Multipass{metadataFile: filepath.Join(t.TempDir(), "minectl", "multipass.json")}
+
+	metadata, err := m.readMetadata()
+	require.NoError(t, err)
+	assert.Empty(t, metadata)
+
+	metadata["minecraft-java"] = instanceMetadata{Edition: "java"}
+	require.NoError(t, m.writeMetadata(metadata))
+
+	metadata, err = m.readMetadata()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]instanceMetadata{"minecraft-java": {Edition: "java"}}, metadata)
+}
+
+func TestParseList(t *testing.T) {
+	result, err := parseList([]byte(listJSON), map[string]instanceMetadata{
+		"minecraft-java":    {Edition: "java"},
+		"minecraft-bedrock": {Edition: "bedrock"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []automation.ResourceResults{
+		{
+			ID: "minecraft-java", Name: "minecraft-java", Region: "multipass", PublicIP: "192.168.64.5", Tags: "minectl,java",
+			Status: automation.StatusRunning, Edition: "java", Labels: map[string]string{"minectl": "true", "java": "true"},
+		},
+		{
+			ID: "minecraft-bedrock", Name: "minecraft-bedrock", Region: "multipass", Tags: "minectl,bedrock",
+			Status: automation.StatusStopped, Edition: "bedrock", Labels: map[string]string{"minectl": "true", "bedrock": "true"},
+		},
+	}, result)
+}
+
+func TestUpdateMetadataConcurrently(t *testing.T) {
+	m := &Multipass{metadataFile: filepath.Join(t.TempDir(), "minectl", "multipass.json")}
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			other := &Multipass{metadataFile: m.metadataFile}
+			assert.NoError(t, other.updateMetadata(context.Background(), func(metadata map[string]instanceMetadata) {
+				metadata[fmt.Sprintf("minecraft-%d", i)] = instanceMetadata{Edition: "java"}
+			}))
+		}()
+	}
+	wg.Wait()
+
+	metadata, err := m.loadMetadata(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, metadata, 20)
+}
+
+func TestParseInfo(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		ip    string
+		state string
+		err   bool
+	}{
+		{
+			name:  "running",
+			data:  `{"info": {"minecraft-java": {"ipv4": ["192.168.64.5", "10.0.0.2"], "state": "Running"}}}`,
+			ip:    "192.168.64.5",
+			state: "Running",
+		},
+		{
+			name:  "stopped",
+			data:  `{"info": {"minecraft-java": {"ipv4": [], "state": "Stopped"}}}`,
+			state: "Stopped",
+		},
+		{
+			name:  "without addresses",
+			data:  `{"info": {"minecraft-java": {"state": "Starting"}}}`,
+			state: "Starting",
+		},
+		{
+			name: "other VM",
+			data: `{"info": {"unrelated": {"ipv4": ["192.168.64.7"], "state": "Running"}}}`,
+			err:  true,
+		},
+		{
+			name: "unexpected types",
+			data: `{"info": {"minecraft-java": {"ipv4": "192.168.64.5"}}}`,
+			err:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip, state, err := parseInfo([]byte(tt.data), "minecraft-java")
+			if tt.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.ip, ip)
+			assert.Equal(t, tt.state, state)
+		})
+	}
+}
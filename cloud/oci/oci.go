@@ -6,12 +6,12 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"path/filepath"
 	"strings"
 
 	"github.com/dirien/minectl-sdk/automation"
 	"github.com/dirien/minectl-sdk/cloud"
 	common2 "github.com/dirien/minectl-sdk/common"
+	"github.com/dirien/minectl-sdk/model"
 	minctlTemplate "github.com/dirien/minectl-sdk/template"
 	"github.com/dirien/minectl-sdk/update"
 	"github.com/oracle/oci-go-sdk/v65/common"
@@ -21,21 +21,34 @@ import (
 	"go.uber.org/zap"
 )
 
+// sshLogin is the user minectl logs in as on OCI servers.
+var sshLogin = update.UbuntuLogin
+
 // OCI implements the Automation interface for Oracle Cloud Infrastructure.
 type OCI struct {
-	compute  core.ComputeClient
-	identity identity.IdentityClient
-	network  core.VirtualNetworkClient
-	tmpl     *minctlTemplate.Template
+	compute         core.ComputeClient
+	identity        identity.IdentityClient
+	network         core.VirtualNetworkClient
+	tmpl            *minctlTemplate.Template
+	templateOptions []minctlTemplate.Option
+}
+
+func init() {
+	cloud.Register(cloud.Provider{
+		Code: model.ProviderOci,
+		Factory: func(_ context.Context, _ cloud.Credentials, opts []minctlTemplate.Option) (automation.Automation, error) {
+			return NewOCI(opts...)
+		},
+	})
 }
 
 // NewOCI creates a new OCI instance.
-func NewOCI() (*OCI, error) {
+func NewOCI(opts ...minctlTemplate.Option) (*OCI, error) {
 	c, err := core.NewComputeClientWithConfigurationProvider(common.DefaultConfigProvider())
 	if err != nil {
 		return nil, err
 	}
-	tmpl, err := minctlTemplate.NewTemplateCloudConfig()
+	tmpl, err := minctlTemplate.NewTemplateCloudConfig(opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -48,20 +61,14 @@ func NewOCI() (*OCI, error) {
 		return nil, err
 	}
 	return &OCI{
-		compute:  c,
-		identity: i,
-		network:  n,
-		tmpl:     tmpl,
+		compute:         c,
+		identity:        i,
+		network:         n,
+		tmpl:            tmpl,
+		templateOptions: opts,
 	}, nil
 }
 
-func getTags(edition string) map[string]string {
-	return map[string]string{
-		common2.InstanceTag: "true",
-		edition:             "true",
-	}
-}
-
 func getTagKeys(tags map[string]string) []string {
 	var keys []string
 	for key := range tags {
@@ -72,8 +79,11 @@ func getTagKeys(tags map[string]string) []string {
 
 // CreateServer creates a new Minecraft server on OCI.
 func (o *OCI) CreateServer(args automation.ServerArgs) (*automation.ResourceResults, error) {
-	ctx := context.Background()
+	return o.CreateServerContext(context.Background(), args)
+}
 
+// CreateServerContext is like CreateServer but honors the cancellation and deadline of ctx.
+func (o *OCI) CreateServerContext(ctx context.Context, args automation.ServerArgs) (*automation.ResourceResults, error) {
 	tenancyOCID, err := common.DefaultConfigProvider().TenancyOCID()
 	if err != nil {
 		return nil, err
@@ -84,7 +94,7 @@ func (o *OCI) CreateServer(args automation.ServerArgs) (*automation.ResourceResu
 			CompartmentId: common.String(tenancyOCID),
 			Name:          common.String(args.MinecraftResource.GetName()),
 			Description:   common.String(fmt.Sprintf("Compartment for %s", args.MinecraftResource.GetName())),
-			FreeformTags:  getTags(args.MinecraftResource.GetEdition()),
+			FreeformTags:  automation.ServerLabels(args),
 		},
 	}
 	compartment, err := o.identity.CreateCompartment(ctx, compartmentRequest)
@@ -97,7 +107,7 @@ func (o *OCI) CreateServer(args automation.ServerArgs) (*automation.ResourceResu
 		CompartmentId: compartment.Id,
 	}
 
-	availabilityDomains, err := o.identity.ListAvailabilityDomains(context.Background(), request)
+	availabilityDomains, err := o.identity.ListAvailabilityDomains(ctx, request)
 	if err != nil {
 		return nil, err
 	}
@@ -134,7 +144,7 @@ func (o *OCI) CreateServer(args automation.ServerArgs) (*automation.ResourceResu
 			CompartmentId: compartment.Id,
 			DisplayName:   common.String(fmt.Sprintf("%s-vcn", args.MinecraftResource.GetName())),
 			DnsLabel:      common.String(fmt.Sprintf("vcn%s", common2.InstanceTag)),
-			FreeformTags:  getTags(args.MinecraftResource.GetEdition()),
+			FreeformTags:  automation.ServerLabels(args),
 		},
 		RequestMetadata: helpers.GetRequestMetadataWithDefaultRetryPolicy(),
 	}
@@ -158,7 +168,7 @@ func (o *OCI) CreateServer(args automation.ServerArgs) (*automation.ResourceResu
 			},
 		},
 	}
-	if args.MinecraftResource.GetEdition() == "bedrock" || args.MinecraftResource.GetEdition() == "nukkit" || args.MinecraftResource.GetEdition() == "powernukkit" {
+	if args.MinecraftResource.GetProtocol() == model.ProtocolUDP {
 		// Options are supported only for ICMP ("1"), TCP ("6"), UDP ("17"), and ICMPv6 ("58").
 		minecraftIngressSecurityRule.Protocol = common.String("17")
 		minecraftIngressSecurityRule.TcpOptions = nil
@@ -222,7 +232,7 @@ func (o *OCI) CreateServer(args automation.ServerArgs) (*automation.ResourceResu
 			VcnId:         vcn.Id,
 			CompartmentId: compartment.Id,
 			DisplayName:   common.String(fmt.Sprintf("%s-sl", args.MinecraftResource.GetName())),
-			FreeformTags:  getTags(args.MinecraftResource.GetEdition()),
+			FreeformTags:  automation.ServerLabels(args),
 			EgressSecurityRules: []core.EgressSecurityRule{
 				{
 					Protocol:    common.String("all"),
@@ -243,7 +253,7 @@ func (o *OCI) CreateServer(args automation.ServerArgs) (*automation.ResourceResu
 			CidrBlock:     common.String("10.0.0.0/24"),
 			CompartmentId: compartment.Id,
 			VcnId:         vcn.Id,
-			FreeformTags:  getTags(args.MinecraftResource.GetEdition()),
+			FreeformTags:  automation.ServerLabels(args),
 			SecurityListIds: []string{
 				*vcn.DefaultSecurityListId,
 				*securityList.Id,
@@ -267,7 +277,7 @@ func (o *OCI) CreateServer(args automation.ServerArgs) (*automation.ResourceResu
 			CompartmentId: compartment.Id,
 			VcnId:         vcn.Id,
 			DisplayName:   common.String(fmt.Sprintf("%s-gw", args.MinecraftResource.GetName())),
-			FreeformTags:  getTags(args.MinecraftResource.GetEdition()),
+			FreeformTags:  automation.ServerLabels(args),
 		},
 	}
 	internetGateway, err := o.network.CreateInternetGateway(ctx, internetGatewayRequest)
@@ -300,7 +310,7 @@ func (o *OCI) CreateServer(args automation.ServerArgs) (*automation.ResourceResu
 	}
 	zap.S().Infow("Oracle Route Table updated", "updateRouteTable", updateRouteTable)
 
-	userData, err := o.tmpl.GetTemplate(args.MinecraftResource, &minctlTemplate.CreateUpdateTemplateArgs{Name: minctlTemplate.GetTemplateCloudConfigName(args.MinecraftResource.IsProxyServer())})
+	userData, err := o.tmpl.GetTemplateContext(ctx, args.MinecraftResource, &minctlTemplate.CreateUpdateTemplateArgs{SSHHostKey: args.SSHHostKey, Name: minctlTemplate.GetTemplateCloudConfigName(args.MinecraftResource.IsProxyServer())})
 	if err != nil {
 		return nil, err
 	}
@@ -318,11 +328,11 @@ func (o *OCI) CreateServer(args automation.ServerArgs) (*automation.ResourceResu
 				ImageId: image.Id,
 			},
 			DisplayName:  common.String(args.MinecraftResource.GetName()),
-			FreeformTags: getTags(args.MinecraftResource.GetEdition()),
+			FreeformTags: automation.ServerLabels(args),
 			CreateVnicDetails: &core.CreateVnicDetails{
 				AssignPublicIp: common.Bool(true),
 				SubnetId:       subnet.Id,
-				FreeformTags:   getTags(args.MinecraftResource.GetEdition()),
+				FreeformTags:   automation.ServerLabels(args),
 			},
 			Metadata: map[string]string{
 				"user_data":           base64.StdEncoding.EncodeToString([]byte(userData)),
@@ -378,22 +388,29 @@ func (o *OCI) CreateServer(args automation.ServerArgs) (*automation.ResourceResu
 		}
 		zap.S().Infow("Oracle get vnic", "vnic", vnic)
 		if *vnic.IsPrimary {
-			return &automation.ResourceResults{
-				ID:       *instance.Id,
-				Name:     *instance.DisplayName,
-				Region:   *instance.Region,
-				PublicIP: *vnic.PublicIp,
-				Tags:     strings.Join(getTagKeys(instance.FreeformTags), ","),
-			}, err
+			result, err := o.instanceToResourceResults(ctx, instance.Instance, vnic.Vnic)
+			if err != nil {
+				return nil, err
+			}
+			return result, nil
 		}
 	}
 	return nil, errors.New("no instance created")
 }
 
+// SSHLogin returns the user minectl logs in as on the servers, see
+// update.LoginProvider.
+func (o *OCI) SSHLogin() update.Login {
+	return sshLogin
+}
+
 // DeleteServer deletes a Minecraft server on OCI.
 func (o *OCI) DeleteServer(id string, args automation.ServerArgs) error {
-	ctx := context.Background()
+	return o.DeleteServerContext(context.Background(), id, args)
+}
 
+// DeleteServerContext is like DeleteServer but honors the cancellation and deadline of ctx.
+func (o *OCI) DeleteServerContext(ctx context.Context, id string, args automation.ServerArgs) error {
 	terminateInstance, err := o.compute.TerminateInstance(ctx, core.TerminateInstanceRequest{
 		InstanceId:         common.String(id),
 		PreserveBootVolume: common.Bool(false),
@@ -536,9 +553,74 @@ func (o *OCI) DeleteServer(id string, args automation.ServerArgs) error {
 	return nil
 }
 
+func instanceStatus(state core.InstanceLifecycleStateEnum) automation.Status {
+	switch state {
+	case core.InstanceLifecycleStateProvisioning, core.InstanceLifecycleStateStarting, core.InstanceLifecycleStateMoving,
+		core.InstanceLifecycleStateCreatingImage:
+		return automation.StatusPending
+	case core.InstanceLifecycleStateRunning:
+		return automation.StatusRunning
+	case core.InstanceLifecycleStateStopping:
+		return automation.StatusStopping
+	case core.InstanceLifecycleStateStopped:
+		return automation.StatusStopped
+	case core.InstanceLifecycleStateTerminating, core.InstanceLifecycleStateTerminated:
+		return automation.StatusDeleting
+	default:
+		return automation.StatusUnknown
+	}
+}
+
+func (o *OCI) instanceToResourceResults(ctx context.Context, instance core.Instance, vnic core.Vnic) (*automation.ResourceResults, error) {
+	result := &automation.ResourceResults{
+		ID:       *instance.Id,
+		Name:     *instance.DisplayName,
+		Region:   *instance.Region,
+		PublicIP: *vnic.PublicIp,
+		Tags:     strings.Join(getTagKeys(instance.FreeformTags), ","),
+		Status:   instanceStatus(instance.LifecycleState),
+		Arch:     automation.ArchAMD64,
+		Labels:   instance.FreeformTags,
+		Edition:  automation.EditionFromLabels(instance.FreeformTags),
+		Version:  automation.VersionFromLabels(instance.FreeformTags),
+	}
+	if vnic.PrivateIp != nil {
+		result.PrivateIP = *vnic.PrivateIp
+	}
+	if len(vnic.Ipv6Addresses) > 0 {
+		result.PublicIPv6 = vnic.Ipv6Addresses[0]
+	}
+	if instance.Shape != nil {
+		result.Size = *instance.Shape
+		if strings.Contains(*instance.Shape, ".A1.") {
+			result.Arch = automation.ArchARM64
+		}
+	}
+	if instance.TimeCreated != nil {
+		result.CreatedAt = instance.TimeCreated.Time
+	}
+	volumeAttachments, err := o.compute.ListVolumeAttachments(ctx, core.ListVolumeAttachmentsRequest{
+		CompartmentId: instance.CompartmentId,
+		InstanceId:    instance.Id,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, attachment := range volumeAttachments.Items {
+		if attachment.GetVolumeId() != nil {
+			result.VolumeIDs = append(result.VolumeIDs, *attachment.GetVolumeId())
+		}
+	}
+	return result, nil
+}
+
 // ListServer lists all Minecraft servers on OCI.
 func (o *OCI) ListServer() ([]automation.ResourceResults, error) {
-	ctx := context.Background()
+	return o.ListServerContext(context.Background())
+}
+
+// ListServerContext is like ListServer but honors the cancellation and deadline of ctx.
+func (o *OCI) ListServerContext(ctx context.Context) ([]automation.ResourceResults, error) {
 	tenancyOCID, err := common.DefaultConfigProvider().TenancyOCID()
 	if err != nil {
 		return nil, err
@@ -581,13 +663,11 @@ func (o *OCI) ListServer() ([]automation.ResourceResults, error) {
 						}
 						zap.S().Infow("Oracle get vnic", "vnic", vnic)
 						if *vnic.IsPrimary {
-							result = append(result, automation.ResourceResults{
-								ID:       *instance.Id,
-								Name:     *instance.DisplayName,
-								Region:   *instance.Region,
-								PublicIP: *vnic.PublicIp,
-								Tags:     strings.Join(getTagKeys(instance.FreeformTags), ","),
-							})
+							server, err := o.instanceToResourceResults(ctx, instance, vnic.Vnic)
+							if err != nil {
+								return nil, err
+							}
+							result = append(result, *server)
 						}
 					}
 				}
@@ -599,12 +679,18 @@ func (o *OCI) ListServer() ([]automation.ResourceResults, error) {
 
 // UpdateServer updates a Minecraft server on OCI.
 func (o *OCI) UpdateServer(id string, args automation.ServerArgs) error {
-	server, err := o.GetServer(id, args)
+	return o.UpdateServerContext(context.Background(), id, args)
+}
+
+// UpdateServerContext is like UpdateServer but honors the cancellation and deadline of ctx.
+func (o *OCI) UpdateServerContext(ctx context.Context, id string, args automation.ServerArgs) error {
+	server, err := o.GetServerContext(ctx, id, args)
 	if err != nil {
 		return err
 	}
-	remoteCommand := update.NewRemoteServer(args.SSHPrivateKeyPath, server.PublicIP, "ubuntu")
-	err = remoteCommand.UpdateServer(args.MinecraftResource)
+	remoteCommand := update.NewRemoteServerFromArgs(args, server.PublicIP, sshLogin).WithTemplateOptions(o.templateOptions...)
+	defer func() { _ = remoteCommand.Close() }()
+	_, err = remoteCommand.UpdateServerStream(ctx, args.MinecraftResource, update.ExecOptions{})
 	if err != nil {
 		return err
 	}
@@ -614,19 +700,18 @@ func (o *OCI) UpdateServer(id string, args automation.ServerArgs) error {
 
 // UploadPlugin uploads a plugin to a Minecraft server on OCI.
 func (o *OCI) UploadPlugin(id string, args automation.ServerArgs, plugin, destination string) error {
-	server, err := o.GetServer(id, args)
-	if err != nil {
-		return err
-	}
-	remoteCommand := update.NewRemoteServer(args.SSHPrivateKeyPath, server.PublicIP, "ubuntu")
+	return o.UploadPluginContext(context.Background(), id, args, plugin, destination)
+}
 
-	// as we are not allowed to login via root user, we need to add sudo to the command
-	source := filepath.Join("/tmp", filepath.Base(plugin))
-	err = remoteCommand.TransferFile(plugin, source, args.MinecraftResource.GetSSHPort())
+// UploadPluginContext is like UploadPlugin but honors the cancellation and deadline of ctx.
+func (o *OCI) UploadPluginContext(ctx context.Context, id string, args automation.ServerArgs, plugin, destination string) error {
+	server, err := o.GetServerContext(ctx, id, args)
 	if err != nil {
 		return err
 	}
-	_, err = remoteCommand.ExecuteCommand(fmt.Sprintf("sudo mv %s %s\nsudo systemctl restart minecraft.service", source, destination), args.MinecraftResource.GetSSHPort())
+	remoteCommand := update.NewRemoteServerFromArgs(args, server.PublicIP, sshLogin)
+	defer func() { _ = remoteCommand.Close() }()
+	err = remoteCommand.UploadPluginContext(ctx, plugin, destination, args.MinecraftResource.GetSSHPort())
 	if err != nil {
 		return err
 	}
@@ -635,8 +720,12 @@ func (o *OCI) UploadPlugin(id string, args automation.ServerArgs, plugin, destin
 }
 
 // GetServer gets a Minecraft server on OCI.
-func (o *OCI) GetServer(id string, _ automation.ServerArgs) (*automation.ResourceResults, error) {
-	ctx := context.Background()
+func (o *OCI) GetServer(id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
+	return o.GetServerContext(context.Background(), id, args)
+}
+
+// GetServerContext is like GetServer but honors the cancellation and deadline of ctx.
+func (o *OCI) GetServerContext(ctx context.Context, id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
 	instance, err := o.compute.GetInstance(ctx, core.GetInstanceRequest{
 		InstanceId: common.String(id),
 	})
@@ -660,13 +749,11 @@ func (o *OCI) GetServer(id string, _ automation.ServerArgs) (*automation.Resourc
 		}
 		zap.S().Infow("Oracle get vnic", "vnic", vnic)
 		if *vnic.IsPrimary {
-			return &automation.ResourceResults{
-				ID:       *instance.Id,
-				Name:     *instance.DisplayName,
-				Region:   *instance.Region,
-				PublicIP: *vnic.PublicIp,
-				Tags:     strings.Join(getTagKeys(instance.FreeformTags), ","),
-			}, err
+			result, err := o.instanceToResourceResults(ctx, instance.Instance, vnic.Vnic)
+			if err != nil {
+				return nil, err
+			}
+			return result, nil
 		}
 	}
 	return nil, errors.New("no instance found")
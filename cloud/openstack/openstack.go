@@ -6,13 +6,13 @@ import (
 	"encoding/base64"
 	"fmt"
 	"os"
-	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/dirien/minectl-sdk/automation"
 	"github.com/dirien/minectl-sdk/cloud"
 	"github.com/dirien/minectl-sdk/common"
+	"github.com/dirien/minectl-sdk/model"
 	minctlTemplate "github.com/dirien/minectl-sdk/template"
 	"github.com/dirien/minectl-sdk/update"
 	"github.com/gophercloud/gophercloud/v2"
@@ -30,21 +30,18 @@ import (
 	"go.uber.org/zap"
 )
 
+// sshLogin is the user minectl logs in as on OpenStack servers.
+var sshLogin = update.UbuntuLogin
+
 // OpenStack implements the Automation interface for OpenStack.
 type OpenStack struct {
-	tmpl          *minctlTemplate.Template
-	computeClient *gophercloud.ServiceClient
-	networkClient *gophercloud.ServiceClient
-	imageClient   *gophercloud.ServiceClient
-	region        string
-	imageName     string
-}
-
-func getTags(edition string) map[string]string {
-	return map[string]string{
-		common.InstanceTag: "true",
-		edition:            "true",
-	}
+	tmpl            *minctlTemplate.Template
+	templateOptions []minctlTemplate.Option
+	computeClient   *gophercloud.ServiceClient
+	networkClient   *gophercloud.ServiceClient
+	imageClient     *gophercloud.ServiceClient
+	region          string
+	imageName       string
 }
 
 func getTagKeys(tags map[string]string) []string {
@@ -56,9 +53,9 @@ func getTagKeys(tags map[string]string) []string {
 }
 
 // NewOpenStack creates a new OpenStack instance.
-func NewOpenStack(imageName string) (*OpenStack, error) {
+func NewOpenStack(imageName string, opts ...minctlTemplate.Option) (*OpenStack, error) {
 	ctx := context.Background()
-	tmpl, err := minctlTemplate.NewTemplateCloudConfig()
+	tmpl, err := minctlTemplate.NewTemplateCloudConfig(opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -70,7 +67,7 @@ func NewOpenStack(imageName string) (*OpenStack, error) {
 		userID = os.Getenv("OS_USER_ID")
 	}
 
-	opts := gophercloud.AuthOptions{
+	authOpts := gophercloud.AuthOptions{
 		IdentityEndpoint: os.Getenv("OS_AUTH_URL"),
 		Username:         os.Getenv("OS_USERNAME"),
 		Password:         os.Getenv("OS_PASSWORD"),
@@ -80,7 +77,7 @@ func NewOpenStack(imageName string) (*OpenStack, error) {
 		TenantID:         os.Getenv("OS_PROJECT_ID"),
 		TenantName:       os.Getenv("OS_PROJECT_NAME"),
 	}
-	provider, err := openstack.AuthenticatedClient(ctx, opts)
+	provider, err := openstack.AuthenticatedClient(ctx, authOpts)
 	if err != nil {
 		return nil, err
 	}
@@ -103,18 +100,24 @@ func NewOpenStack(imageName string) (*OpenStack, error) {
 		return nil, err
 	}
 	return &OpenStack{
-		tmpl:          tmpl,
-		computeClient: computeClient,
-		networkClient: networkClient,
-		imageClient:   imageClient,
-		region:        os.Getenv("OS_REGION_NAME"),
-		imageName:     imageName,
+		tmpl:            tmpl,
+		templateOptions: opts,
+		computeClient:   computeClient,
+		networkClient:   networkClient,
+		imageClient:     imageClient,
+		region:          os.Getenv("OS_REGION_NAME"),
+		imageName:       imageName,
 	}, nil
 }
 
-// CreateServer TODO: https://github.com/dirien/minectl/issues/299
-func (o *OpenStack) CreateServer(args automation.ServerArgs) (*automation.ResourceResults, error) { //nolint: gocyclo
-	ctx := context.Background()
+// CreateServer creates a new Minecraft server on OpenStack.
+func (o *OpenStack) CreateServer(args automation.ServerArgs) (*automation.ResourceResults, error) {
+	return o.CreateServerContext(context.Background(), args)
+}
+
+// CreateServerContext is like CreateServer but honors the cancellation and deadline of ctx.
+// TODO: https://github.com/dirien/minectl/issues/299
+func (o *OpenStack) CreateServerContext(ctx context.Context, args automation.ServerArgs) (*automation.ResourceResults, error) { //nolint: gocyclo
 	publicKey, err := cloud.GetSSHPublicKey(args)
 	if err != nil {
 		return nil, err
@@ -184,7 +187,7 @@ func (o *OpenStack) CreateServer(args automation.ServerArgs) (*automation.Resour
 	if err != nil {
 		return nil, err
 	}
-	if args.MinecraftResource.GetEdition() == "bedrock" || args.MinecraftResource.GetEdition() == "nukkit" || args.MinecraftResource.GetEdition() == "powernukkit" {
+	if args.MinecraftResource.GetProtocol() == model.ProtocolUDP {
 		err = o.createSecurityGroup(ctx, group, args.MinecraftResource.GetPort(), "UDP")
 		if err != nil {
 			return nil, err
@@ -266,7 +269,7 @@ func (o *OpenStack) CreateServer(args automation.ServerArgs) (*automation.Resour
 	if err != nil {
 		return nil, err
 	}
-	userData, err := o.tmpl.GetTemplate(args.MinecraftResource, &minctlTemplate.CreateUpdateTemplateArgs{Name: minctlTemplate.GetTemplateCloudConfigName(args.MinecraftResource.IsProxyServer())})
+	userData, err := o.tmpl.GetTemplateContext(ctx, args.MinecraftResource, &minctlTemplate.CreateUpdateTemplateArgs{SSHHostKey: args.SSHHostKey, Name: minctlTemplate.GetTemplateCloudConfigName(args.MinecraftResource.IsProxyServer())})
 	if err != nil {
 		return nil, err
 	}
@@ -284,7 +287,7 @@ func (o *OpenStack) CreateServer(args automation.ServerArgs) (*automation.Resour
 					UUID: network.ID,
 				},
 			},
-			Metadata: getTags(args.MinecraftResource.GetEdition()),
+			Metadata: automation.ServerLabels(args),
 			UserData: []byte(base64.StdEncoding.EncodeToString([]byte(userData))),
 		},
 		KeyName: keyPair.Name,
@@ -301,8 +304,8 @@ func (o *OpenStack) CreateServer(args automation.ServerArgs) (*automation.Resour
 		}
 		if server.Status == "ACTIVE" {
 			stillCreating = false
-		} else {
-			time.Sleep(2 * time.Second)
+		} else if err := common.Sleep(ctx, 2*time.Second); err != nil {
+			return nil, err
 		}
 	}
 
@@ -334,13 +337,7 @@ func (o *OpenStack) CreateServer(args automation.ServerArgs) (*automation.Resour
 		zap.S().Warnw("Failed to associate floating IP directly, server may not have public IP", "error", err)
 	}
 
-	return &automation.ResourceResults{
-		ID:       server.ID,
-		Name:     server.Name,
-		Region:   o.region,
-		PublicIP: floatingIP.FloatingIP,
-		Tags:     strings.Join(getTagKeys(server.Metadata), ","),
-	}, nil
+	return o.serverToResourceResults(server, floatingIP.FloatingIP), nil
 }
 
 func (o *OpenStack) createSecurityGroup(ctx context.Context, group *secgroups.SecurityGroup, port int, protocol string) error {
@@ -359,9 +356,19 @@ func (o *OpenStack) createSecurityGroup(ctx context.Context, group *secgroups.Se
 	return nil
 }
 
+// SSHLogin returns the user minectl logs in as on the servers, see
+// update.LoginProvider.
+func (o *OpenStack) SSHLogin() update.Login {
+	return sshLogin
+}
+
 // DeleteServer deletes a Minecraft server on OpenStack.
 func (o *OpenStack) DeleteServer(id string, args automation.ServerArgs) error {
-	ctx := context.Background()
+	return o.DeleteServerContext(context.Background(), id, args)
+}
+
+// DeleteServerContext is like DeleteServer but honors the cancellation and deadline of ctx.
+func (o *OpenStack) DeleteServerContext(ctx context.Context, id string, args automation.ServerArgs) error {
 	server, err := servers.Get(ctx, o.computeClient, id).Extract()
 	if err != nil {
 		return err
@@ -393,8 +400,8 @@ func (o *OpenStack) DeleteServer(id string, args automation.ServerArgs) error {
 		}
 		if server != nil && server.Status == "DELETED" {
 			stillCreating = false
-		} else {
-			time.Sleep(2 * time.Second)
+		} else if err := common.Sleep(ctx, 2*time.Second); err != nil {
+			return err
 		}
 	}
 
@@ -558,9 +565,77 @@ func (o *OpenStack) getNetworkByName(ctx context.Context, args automation.Server
 	return network, nil
 }
 
+func serverStatus(status string) automation.Status {
+	switch status {
+	case "BUILD", "REBUILD", "REBOOT", "HARD_REBOOT", "MIGRATING", "RESIZE", "VERIFY_RESIZE":
+		return automation.StatusPending
+	case "ACTIVE":
+		return automation.StatusRunning
+	case "SHUTOFF", "SUSPENDED", "PAUSED", "SHELVED", "SHELVED_OFFLOADED":
+		return automation.StatusStopped
+	case "DELETED", "SOFT_DELETED":
+		return automation.StatusDeleting
+	default:
+		return automation.StatusUnknown
+	}
+}
+
+func (o *OpenStack) serverToResourceResults(server *servers.Server, publicIP string) *automation.ResourceResults {
+	result := &automation.ResourceResults{
+		ID:         server.ID,
+		Name:       server.Name,
+		Region:     o.region,
+		PublicIP:   publicIP,
+		Tags:       strings.Join(getTagKeys(server.Metadata), ","),
+		Status:     serverStatus(server.Status),
+		PublicIPv6: server.AccessIPv6,
+		CreatedAt:  server.Created,
+		Labels:     server.Metadata,
+		Edition:    automation.EditionFromLabels(server.Metadata),
+		Version:    automation.VersionFromLabels(server.Metadata),
+	}
+	for _, key := range []string{"original_name", "id"} {
+		if name, ok := server.Flavor[key].(string); ok {
+			result.Size = name
+			break
+		}
+	}
+	for _, addresses := range server.Addresses {
+		addrList, ok := addresses.([]interface{})
+		if !ok {
+			continue
+		}
+		for _, a := range addrList {
+			addr, ok := a.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			ip, _ := addr["addr"].(string)
+			if addr["OS-EXT-IPS:type"] != "fixed" {
+				continue
+			}
+			if version, _ := addr["version"].(float64); version == 6 {
+				if result.PublicIPv6 == "" {
+					result.PublicIPv6 = ip
+				}
+			} else if result.PrivateIP == "" {
+				result.PrivateIP = ip
+			}
+		}
+	}
+	for _, volume := range server.AttachedVolumes {
+		result.VolumeIDs = append(result.VolumeIDs, volume.ID)
+	}
+	return result
+}
+
 // ListServer lists all Minecraft servers on OpenStack.
 func (o *OpenStack) ListServer() ([]automation.ResourceResults, error) {
-	ctx := context.Background()
+	return o.ListServerContext(context.Background())
+}
+
+// ListServerContext is like ListServer but honors the cancellation and deadline of ctx.
+func (o *OpenStack) ListServerContext(ctx context.Context) ([]automation.ResourceResults, error) {
 	var result []automation.ResourceResults
 	pager := servers.List(o.computeClient, servers.ListOpts{})
 	err := pager.EachPage(ctx, func(ctx context.Context, page pagination.Page) (bool, error) {
@@ -579,13 +654,8 @@ func (o *OpenStack) ListServer() ([]automation.ResourceResults, error) {
 					if floatingIP != nil {
 						publicIP = floatingIP.FloatingIP
 					}
-					result = append(result, automation.ResourceResults{
-						ID:       i.ID,
-						Name:     i.Name,
-						Region:   o.region,
-						PublicIP: publicIP,
-						Tags:     strings.Join(getTagKeys(i.Metadata), ","),
-					})
+					i := i
+					result = append(result, *o.serverToResourceResults(&i, publicIP))
 				}
 			}
 		}
@@ -599,12 +669,18 @@ func (o *OpenStack) ListServer() ([]automation.ResourceResults, error) {
 
 // UpdateServer updates a Minecraft server on OpenStack.
 func (o *OpenStack) UpdateServer(id string, args automation.ServerArgs) error {
-	server, err := o.GetServer(id, args)
+	return o.UpdateServerContext(context.Background(), id, args)
+}
+
+// UpdateServerContext is like UpdateServer but honors the cancellation and deadline of ctx.
+func (o *OpenStack) UpdateServerContext(ctx context.Context, id string, args automation.ServerArgs) error {
+	server, err := o.GetServerContext(ctx, id, args)
 	if err != nil {
 		return err
 	}
-	remoteCommand := update.NewRemoteServer(args.SSHPrivateKeyPath, server.PublicIP, "ubuntu")
-	err = remoteCommand.UpdateServer(args.MinecraftResource)
+	remoteCommand := update.NewRemoteServerFromArgs(args, server.PublicIP, sshLogin).WithTemplateOptions(o.templateOptions...)
+	defer func() { _ = remoteCommand.Close() }()
+	_, err = remoteCommand.UpdateServerStream(ctx, args.MinecraftResource, update.ExecOptions{})
 	if err != nil {
 		return err
 	}
@@ -614,19 +690,18 @@ func (o *OpenStack) UpdateServer(id string, args automation.ServerArgs) error {
 
 // UploadPlugin uploads a plugin to a Minecraft server on OpenStack.
 func (o *OpenStack) UploadPlugin(id string, args automation.ServerArgs, plugin, destination string) error {
-	server, err := o.GetServer(id, args)
-	if err != nil {
-		return err
-	}
-	remoteCommand := update.NewRemoteServer(args.SSHPrivateKeyPath, server.PublicIP, "ubuntu")
+	return o.UploadPluginContext(context.Background(), id, args, plugin, destination)
+}
 
-	// as we are not allowed to login via root user, we need to add sudo to the command
-	source := filepath.Join("/tmp", filepath.Base(plugin))
-	err = remoteCommand.TransferFile(plugin, source, args.MinecraftResource.GetSSHPort())
+// UploadPluginContext is like UploadPlugin but honors the cancellation and deadline of ctx.
+func (o *OpenStack) UploadPluginContext(ctx context.Context, id string, args automation.ServerArgs, plugin, destination string) error {
+	server, err := o.GetServerContext(ctx, id, args)
 	if err != nil {
 		return err
 	}
-	_, err = remoteCommand.ExecuteCommand(fmt.Sprintf("sudo mv %s %s\nsudo systemctl restart minecraft.service", source, destination), args.MinecraftResource.GetSSHPort())
+	remoteCommand := update.NewRemoteServerFromArgs(args, server.PublicIP, sshLogin)
+	defer func() { _ = remoteCommand.Close() }()
+	err = remoteCommand.UploadPluginContext(ctx, plugin, destination, args.MinecraftResource.GetSSHPort())
 	if err != nil {
 		return err
 	}
@@ -635,8 +710,12 @@ func (o *OpenStack) UploadPlugin(id string, args automation.ServerArgs, plugin,
 }
 
 // GetServer gets a Minecraft server on OpenStack.
-func (o *OpenStack) GetServer(id string, _ automation.ServerArgs) (*automation.ResourceResults, error) {
-	ctx := context.Background()
+func (o *OpenStack) GetServer(id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
+	return o.GetServerContext(context.Background(), id, args)
+}
+
+// GetServerContext is like GetServer but honors the cancellation and deadline of ctx.
+func (o *OpenStack) GetServerContext(ctx context.Context, id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
 	server, err := servers.Get(ctx, o.computeClient, id).Extract()
 	if err != nil {
 		return nil, err
@@ -649,11 +728,5 @@ func (o *OpenStack) GetServer(id string, _ automation.ServerArgs) (*automation.R
 	if floatingIP != nil {
 		publicIP = floatingIP.FloatingIP
 	}
-	return &automation.ResourceResults{
-		ID:       server.ID,
-		Name:     server.Name,
-		Region:   o.region,
-		PublicIP: publicIP,
-		Tags:     strings.Join(getTagKeys(server.Metadata), ","),
-	}, nil
+	return o.serverToResourceResults(server, publicIP), nil
 }
@@ -4,48 +4,75 @@ package ovh
 import (
 	"context"
 	"fmt"
-	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/dirien/minectl-sdk/automation"
 	"github.com/dirien/minectl-sdk/cloud"
 	"github.com/dirien/minectl-sdk/common"
+	"github.com/dirien/minectl-sdk/model"
 	minctlTemplate "github.com/dirien/minectl-sdk/template"
 	"github.com/dirien/minectl-sdk/update"
 	ovhsdk "github.com/dirien/ovh-go-sdk/pkg/sdk"
 )
 
+// sshLogin is the user minectl logs in as on OVHcloud servers.
+var sshLogin = update.UbuntuLogin
+
 // OVHcloud implements the Automation interface for OVHcloud.
 type OVHcloud struct {
-	client *ovhsdk.OVHcloud
-	tmpl   *minctlTemplate.Template
+	client          *ovhsdk.OVHcloud
+	tmpl            *minctlTemplate.Template
+	templateOptions []minctlTemplate.Option
+}
+
+func init() {
+	cloud.Register(cloud.Provider{
+		Code: model.ProviderOvh,
+		Credentials: []cloud.CredentialField{
+			{Name: "endpoint", EnvVar: "OVH_ENDPOINT", Description: "OVHcloud API endpoint", Required: true},
+			{Name: "applicationKey", EnvVar: "OVH_APPLICATION_KEY", Description: "OVHcloud application key", Required: true},
+			{Name: "applicationSecret", EnvVar: "OVH_APPLICATION_SECRET", Description: "OVHcloud application secret", Required: true},
+			{Name: "consumerKey", EnvVar: "OVH_CONSUMER_KEY", Description: "OVHcloud consumer key", Required: true},
+			{Name: "serviceName", EnvVar: "OVH_SERVICENAME", Description: "OVHcloud Public Cloud project ID", Required: true},
+			{Name: cloud.CredentialRegion, EnvVar: "OVH_REGION", Description: "OVHcloud region", Required: true},
+		},
+		Factory: func(_ context.Context, creds cloud.Credentials, opts []minctlTemplate.Option) (automation.Automation, error) {
+			return NewOVHcloud(creds["endpoint"], creds["applicationKey"], creds["applicationSecret"], creds["consumerKey"], creds["serviceName"], creds[cloud.CredentialRegion], opts...)
+		},
+	})
 }
 
 // NewOVHcloud creates a new OVHcloud instance.
-func NewOVHcloud(endpoint, appKey, appSecret, consumerKey, serviceName, region string) (*OVHcloud, error) {
+func NewOVHcloud(endpoint, appKey, appSecret, consumerKey, serviceName, region string, opts ...minctlTemplate.Option) (*OVHcloud, error) {
 	client, err := ovhsdk.NewOVHClient(endpoint, appKey, appSecret, consumerKey, region, serviceName)
 	if err != nil {
 		return nil, err
 	}
-	tmpl, err := minctlTemplate.NewTemplateBash()
+	tmpl, err := minctlTemplate.NewTemplateBash(opts...)
 	if err != nil {
 		return nil, err
 	}
 	return &OVHcloud{
-		client: client,
-		tmpl:   tmpl,
+		client:          client,
+		tmpl:            tmpl,
+		templateOptions: opts,
 	}, nil
 }
 
 // CreateServer creates a new Minecraft server on OVHcloud.
 func (o *OVHcloud) CreateServer(args automation.ServerArgs) (*automation.ResourceResults, error) {
+	return o.CreateServerContext(context.Background(), args)
+}
+
+// CreateServerContext is like CreateServer but honors the cancellation and deadline of ctx.
+func (o *OVHcloud) CreateServerContext(ctx context.Context, args automation.ServerArgs) (*automation.ResourceResults, error) {
 	publicKey, err := cloud.GetSSHPublicKey(args)
 	if err != nil {
 		return nil, err
 	}
 
-	key, err := o.client.CreateSSHKey(context.Background(), ovhsdk.SSHKeyCreateOptions{
+	key, err := o.client.CreateSSHKey(ctx, ovhsdk.SSHKeyCreateOptions{
 		Name:      fmt.Sprintf("%s-ssh", args.MinecraftResource.GetName()),
 		PublicKey: *publicKey,
 	})
@@ -53,12 +80,12 @@ func (o *OVHcloud) CreateServer(args automation.ServerArgs) (*automation.Resourc
 		return nil, err
 	}
 
-	image, err := o.client.GetImage(context.Background(), "Ubuntu 22.04", args.MinecraftResource.GetRegion())
+	image, err := o.client.GetImage(ctx, "Ubuntu 22.04", args.MinecraftResource.GetRegion())
 	if err != nil {
 		return nil, err
 	}
 
-	flavor, err := o.client.GetFlavor(context.Background(), args.MinecraftResource.GetSize(), args.MinecraftResource.GetRegion())
+	flavor, err := o.client.GetFlavor(ctx, args.MinecraftResource.GetSize(), args.MinecraftResource.GetRegion())
 	if err != nil {
 		return nil, err
 	}
@@ -67,13 +94,13 @@ func (o *OVHcloud) CreateServer(args automation.ServerArgs) (*automation.Resourc
 	if args.MinecraftResource.GetVolumeSize() > 0 {
 		mount = "sdb"
 	}
-	userData, err := o.tmpl.GetTemplate(args.MinecraftResource, &minctlTemplate.CreateUpdateTemplateArgs{Mount: mount, Name: minctlTemplate.GetTemplateBashName(args.MinecraftResource.IsProxyServer())})
+	userData, err := o.tmpl.GetTemplateContext(ctx, args.MinecraftResource, &minctlTemplate.CreateUpdateTemplateArgs{Mount: mount, SSHHostKey: args.SSHHostKey, Name: minctlTemplate.GetTemplateBashName(args.MinecraftResource.IsProxyServer())})
 	if err != nil {
 		return nil, err
 	}
 
-	instance, err := o.client.CreateInstance(context.Background(), ovhsdk.InstanceCreateOptions{
-		Name:           common.CreateServerNameWithTags(args.MinecraftResource.GetName(), strings.Join([]string{common.InstanceTag, args.MinecraftResource.GetEdition()}, "|")),
+	instance, err := o.client.CreateInstance(ctx, ovhsdk.InstanceCreateOptions{
+		Name:           common.CreateServerNameWithTags(args.MinecraftResource.GetName(), strings.Join(automation.ServerTags(args), "|")),
 		Region:         args.MinecraftResource.GetRegion(),
 		SSHKeyID:       key.ID,
 		FlavorID:       flavor.ID,
@@ -86,20 +113,20 @@ func (o *OVHcloud) CreateServer(args automation.ServerArgs) (*automation.Resourc
 	}
 	stillCreating := true
 	for stillCreating {
-		instance, err = o.client.GetInstance(context.Background(), instance.ID)
+		instance, err = o.client.GetInstance(ctx, instance.ID)
 		if err != nil {
 			return nil, err
 		}
 		if instance.Status == ovhsdk.InstanceActive {
 			stillCreating = false
-			time.Sleep(2 * time.Second)
-		} else {
-			time.Sleep(2 * time.Second)
+		}
+		if err := common.Sleep(ctx, 2*time.Second); err != nil {
+			return nil, err
 		}
 	}
 
 	if args.MinecraftResource.GetVolumeSize() > 0 {
-		volume, err := o.client.CreateVolume(context.Background(), ovhsdk.VolumeCreateOptions{
+		volume, err := o.client.CreateVolume(ctx, ovhsdk.VolumeCreateOptions{
 			Name:   fmt.Sprintf("%s-vol", args.MinecraftResource.GetName()),
 			Size:   args.MinecraftResource.GetVolumeSize(),
 			Region: args.MinecraftResource.GetRegion(),
@@ -111,18 +138,18 @@ func (o *OVHcloud) CreateServer(args automation.ServerArgs) (*automation.Resourc
 
 		stillCreating = true
 		for stillCreating {
-			volume, err = o.client.GetVolume(context.Background(), volume.ID)
+			volume, err = o.client.GetVolume(ctx, volume.ID)
 			if err != nil {
 				return nil, err
 			}
 			if volume.Status == ovhsdk.VolumeAvailable {
 				stillCreating = false
-			} else {
-				time.Sleep(2 * time.Second)
+			} else if err := common.Sleep(ctx, 2*time.Second); err != nil {
+				return nil, err
 			}
 		}
 
-		_, err = o.client.AttachVolume(context.Background(), volume.ID, &ovhsdk.VolumeAttachOptions{
+		_, err = o.client.AttachVolume(ctx, volume.ID, &ovhsdk.VolumeAttachOptions{
 			InstanceID: instance.ID,
 		})
 		if err != nil {
@@ -130,57 +157,54 @@ func (o *OVHcloud) CreateServer(args automation.ServerArgs) (*automation.Resourc
 		}
 		stillAttaching := true
 		for stillAttaching {
-			volume, err = o.client.GetVolume(context.Background(), volume.ID)
+			volume, err = o.client.GetVolume(ctx, volume.ID)
 			if err != nil {
 				return nil, err
 			}
 			if volume.Status == ovhsdk.VolumeInUse {
 				stillAttaching = false
-			} else {
-				time.Sleep(2 * time.Second)
+			} else if err := common.Sleep(ctx, 2*time.Second); err != nil {
+				return nil, err
 			}
 		}
 	}
 
-	labels, err := common.ExtractFieldsFromServername(instance.Name)
-	if err != nil {
-		return nil, err
-	}
-	ip4, err := ovhsdk.IPv4(instance)
-	if err != nil {
-		return nil, err
-	}
-	return &automation.ResourceResults{
-		ID:       instance.ID,
-		Name:     instance.Name,
-		Region:   instance.Region,
-		PublicIP: ip4,
-		Tags:     labels,
-	}, err
+	return o.getResourceResults(ctx, instance)
+}
+
+// SSHLogin returns the user minectl logs in as on the servers, see
+// update.LoginProvider.
+func (o *OVHcloud) SSHLogin() update.Login {
+	return sshLogin
 }
 
 // DeleteServer deletes a Minecraft server on OVHcloud.
 func (o *OVHcloud) DeleteServer(id string, args automation.ServerArgs) error {
-	keys, err := o.client.ListSSHKeys(context.Background())
+	return o.DeleteServerContext(context.Background(), id, args)
+}
+
+// DeleteServerContext is like DeleteServer but honors the cancellation and deadline of ctx.
+func (o *OVHcloud) DeleteServerContext(ctx context.Context, id string, args automation.ServerArgs) error {
+	keys, err := o.client.ListSSHKeys(ctx)
 	if err != nil {
 		return err
 	}
 	for _, key := range keys {
 		if key.Name == fmt.Sprintf("%s-ssh", args.MinecraftResource.GetName()) {
-			err := o.client.DeleteSSHKey(context.Background(), key.ID)
+			err := o.client.DeleteSSHKey(ctx, key.ID)
 			if err != nil {
 				return err
 			}
 		}
 	}
-	volumes, err := o.client.ListVolumes(context.Background())
+	volumes, err := o.client.ListVolumes(ctx)
 	if err != nil {
 		return err
 	}
 	for _, volume := range volumes {
 		for _, attached := range volume.AttachedTo {
 			if attached == id {
-				detachVolume, err := o.client.DetachVolume(context.Background(), volume.ID, &ovhsdk.VolumeDetachOptions{
+				detachVolume, err := o.client.DetachVolume(ctx, volume.ID, &ovhsdk.VolumeDetachOptions{
 					InstanceID: id,
 				})
 				if err != nil {
@@ -188,33 +212,108 @@ func (o *OVHcloud) DeleteServer(id string, args automation.ServerArgs) error {
 				}
 				stillDetaching := true
 				for stillDetaching {
-					detachedVolume, err := o.client.GetVolume(context.Background(), detachVolume.ID)
+					detachedVolume, err := o.client.GetVolume(ctx, detachVolume.ID)
 					if err != nil {
 						return err
 					}
 					if detachedVolume.Status == ovhsdk.VolumeAvailable {
 						stillDetaching = false
-					} else {
-						time.Sleep(2 * time.Second)
+					} else if err := common.Sleep(ctx, 2*time.Second); err != nil {
+						return err
 					}
 				}
-				err = o.client.DeleteVolume(context.Background(), volume.ID)
+				err = o.client.DeleteVolume(ctx, volume.ID)
 				if err != nil {
 					return err
 				}
 			}
 		}
 	}
-	err = o.client.DeleteInstance(context.Background(), id)
+	err = o.client.DeleteInstance(ctx, id)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+func instanceStatus(status ovhsdk.InstanceStatus) automation.Status {
+	switch status {
+	case ovhsdk.InstanceBuild, ovhsdk.InstanceBuilding, ovhsdk.InstanceReboot, ovhsdk.InstanceRebuild, ovhsdk.InstanceResuming:
+		return automation.StatusPending
+	case ovhsdk.InstanceActive:
+		return automation.StatusRunning
+	case ovhsdk.InstanceStopped:
+		return automation.StatusStopped
+	case ovhsdk.InstanceDeleting, ovhsdk.InstanceDeleted:
+		return automation.StatusDeleting
+	default:
+		return automation.StatusUnknown
+	}
+}
+
+func instanceToResourceResults(instance *ovhsdk.Instance, tags string, volumes []ovhsdk.Volume) (*automation.ResourceResults, error) {
+	ip4, err := ovhsdk.IPv4(instance)
+	if err != nil {
+		return nil, err
+	}
+	labels := automation.LabelsFromTags(strings.Split(tags, ","))
+	size, _, _ := strings.Cut(instance.PlanCode, ".")
+	result := &automation.ResourceResults{
+		ID:        instance.ID,
+		Name:      instance.Name,
+		Region:    instance.Region,
+		PublicIP:  ip4,
+		Tags:      tags,
+		Status:    instanceStatus(instance.Status),
+		Size:      size,
+		Arch:      automation.ArchAMD64,
+		CreatedAt: instance.Created,
+		Labels:    labels,
+		Edition:   automation.EditionFromLabels(labels),
+		Version:   automation.VersionFromLabels(labels),
+	}
+	for _, ip := range instance.IPAddresses {
+		switch {
+		case ip.Version == 6 && result.PublicIPv6 == "":
+			result.PublicIPv6 = ip.IP
+		case ip.Version == 4 && ip.Type == "private" && result.PrivateIP == "":
+			result.PrivateIP = ip.IP
+		}
+	}
+	for _, volume := range volumes {
+		for _, attachedTo := range volume.AttachedTo {
+			if attachedTo == instance.ID {
+				result.VolumeIDs = append(result.VolumeIDs, volume.ID)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (o *OVHcloud) getResourceResults(ctx context.Context, instance *ovhsdk.Instance) (*automation.ResourceResults, error) {
+	labels, err := common.ExtractFieldsFromServername(instance.Name)
+	if err != nil {
+		return nil, err
+	}
+	volumes, err := o.client.ListVolumes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return instanceToResourceResults(instance, labels, volumes)
+}
+
 // ListServer lists all Minecraft servers on OVHcloud.
 func (o *OVHcloud) ListServer() ([]automation.ResourceResults, error) {
-	instances, err := o.client.ListInstance(context.Background())
+	return o.ListServerContext(context.Background())
+}
+
+// ListServerContext is like ListServer but honors the cancellation and deadline of ctx.
+func (o *OVHcloud) ListServerContext(ctx context.Context) ([]automation.ResourceResults, error) {
+	instances, err := o.client.ListInstance(ctx)
+	if err != nil {
+		return nil, err
+	}
+	volumes, err := o.client.ListVolumes(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -223,17 +322,11 @@ func (o *OVHcloud) ListServer() ([]automation.ResourceResults, error) {
 		// no error checking. could be server in the region which don't belong to minectl
 		labels, _ := common.ExtractFieldsFromServername(instance.Name)
 		if strings.Contains(labels, common.InstanceTag) {
-			ip4, err := ovhsdk.IPv4(&instances[i])
+			server, err := instanceToResourceResults(&instances[i], labels, volumes)
 			if err != nil {
 				return nil, err
 			}
-			result = append(result, automation.ResourceResults{
-				ID:       instance.ID,
-				Name:     instance.Name,
-				Region:   instance.Region,
-				PublicIP: ip4,
-				Tags:     labels,
-			})
+			result = append(result, *server)
 		}
 	}
 	return result, nil
@@ -241,7 +334,12 @@ func (o *OVHcloud) ListServer() ([]automation.ResourceResults, error) {
 
 // UpdateServer updates a Minecraft server on OVHcloud.
 func (o *OVHcloud) UpdateServer(id string, args automation.ServerArgs) error {
-	instance, err := o.client.GetInstance(context.Background(), id)
+	return o.UpdateServerContext(context.Background(), id, args)
+}
+
+// UpdateServerContext is like UpdateServer but honors the cancellation and deadline of ctx.
+func (o *OVHcloud) UpdateServerContext(ctx context.Context, id string, args automation.ServerArgs) error {
+	instance, err := o.client.GetInstance(ctx, id)
 	if err != nil {
 		return err
 	}
@@ -250,8 +348,9 @@ func (o *OVHcloud) UpdateServer(id string, args automation.ServerArgs) error {
 	if err != nil {
 		return err
 	}
-	remoteCommand := update.NewRemoteServer(args.SSHPrivateKeyPath, ip4, "ubuntu")
-	err = remoteCommand.UpdateServer(args.MinecraftResource)
+	remoteCommand := update.NewRemoteServerFromArgs(args, ip4, sshLogin).WithTemplateOptions(o.templateOptions...)
+	defer func() { _ = remoteCommand.Close() }()
+	_, err = remoteCommand.UpdateServerStream(ctx, args.MinecraftResource, update.ExecOptions{})
 	if err != nil {
 		return err
 	}
@@ -260,24 +359,23 @@ func (o *OVHcloud) UpdateServer(id string, args automation.ServerArgs) error {
 
 // UploadPlugin uploads a plugin to a Minecraft server on OVHcloud.
 func (o *OVHcloud) UploadPlugin(id string, args automation.ServerArgs, plugin, destination string) error {
-	instance, err := o.client.GetInstance(context.Background(), id)
-	if err != nil {
-		return err
-	}
+	return o.UploadPluginContext(context.Background(), id, args, plugin, destination)
+}
 
-	ip4, err := ovhsdk.IPv4(instance)
+// UploadPluginContext is like UploadPlugin but honors the cancellation and deadline of ctx.
+func (o *OVHcloud) UploadPluginContext(ctx context.Context, id string, args automation.ServerArgs, plugin, destination string) error {
+	instance, err := o.client.GetInstance(ctx, id)
 	if err != nil {
 		return err
 	}
-	remoteCommand := update.NewRemoteServer(args.SSHPrivateKeyPath, ip4, "ubuntu")
 
-	// as we are not allowed to login via root user, we need to add sudo to the command
-	source := filepath.Join("/tmp", filepath.Base(plugin))
-	err = remoteCommand.TransferFile(plugin, source, args.MinecraftResource.GetSSHPort())
+	ip4, err := ovhsdk.IPv4(instance)
 	if err != nil {
 		return err
 	}
-	_, err = remoteCommand.ExecuteCommand(fmt.Sprintf("sudo mv %s %s\nsudo systemctl restart minecraft.service", source, destination), args.MinecraftResource.GetSSHPort())
+	remoteCommand := update.NewRemoteServerFromArgs(args, ip4, sshLogin)
+	defer func() { _ = remoteCommand.Close() }()
+	err = remoteCommand.UploadPluginContext(ctx, plugin, destination, args.MinecraftResource.GetSSHPort())
 	if err != nil {
 		return err
 	}
@@ -285,25 +383,16 @@ func (o *OVHcloud) UploadPlugin(id string, args automation.ServerArgs, plugin, d
 }
 
 // GetServer gets a Minecraft server on OVHcloud.
-func (o *OVHcloud) GetServer(id string, _ automation.ServerArgs) (*automation.ResourceResults, error) {
-	instance, err := o.client.GetInstance(context.Background(), id)
-	if err != nil {
-		return nil, err
-	}
+func (o *OVHcloud) GetServer(id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
+	return o.GetServerContext(context.Background(), id, args)
+}
 
-	ip4, err := ovhsdk.IPv4(instance)
+// GetServerContext is like GetServer but honors the cancellation and deadline of ctx.
+func (o *OVHcloud) GetServerContext(ctx context.Context, id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
+	instance, err := o.client.GetInstance(ctx, id)
 	if err != nil {
 		return nil, err
 	}
-	labels, err := common.ExtractFieldsFromServername(instance.Name)
-	if err != nil {
-		return nil, err
-	}
-	return &automation.ResourceResults{
-		ID:       instance.ID,
-		Name:     instance.Name,
-		Region:   instance.Region,
-		PublicIP: ip4,
-		Tags:     labels,
-	}, err
+
+	return o.getResourceResults(ctx, instance)
 }
@@ -0,0 +1,150 @@
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dirien/minectl-sdk/automation"
+	"github.com/dirien/minectl-sdk/model"
+	"github.com/dirien/minectl-sdk/template"
+)
+
+// Well-known credential names shared by several providers.
+const (
+	CredentialToken  = "token"
+	CredentialRegion = "region"
+)
+
+// Credentials holds the values a provider factory needs to build its client,
+// keyed by the names declared in the provider's credential schema.
+type Credentials map[string]string
+
+// CredentialField describes a single value in a provider's credential schema.
+type CredentialField struct {
+	// Name is the key of the value in Credentials.
+	Name string
+	// EnvVar is read when the value is missing from Credentials.
+	EnvVar      string
+	Description string
+	Required    bool
+}
+
+// Factory creates an Automation from resolved credentials. The templates of
+// the servers are created with opts.
+type Factory func(ctx context.Context, creds Credentials, opts []template.Option) (automation.Automation, error)
+
+// Provider describes a cloud provider known to the SDK.
+type Provider struct {
+	Code        string
+	FullName    string
+	Credentials []CredentialField
+	Factory     Factory
+}
+
+var (
+	providersMu sync.RWMutex
+	// providers is seeded with the built-in providers so their names resolve
+	// even when the provider package itself is not imported.
+	providers = builtinProviders()
+)
+
+func builtinProviders() map[string]Provider {
+	providers := map[string]Provider{}
+	for code, name := range model.ProviderFullNames() {
+		providers[code] = Provider{Code: code, FullName: name}
+	}
+	return providers
+}
+
+// Register makes a provider available to New. It is meant to be called from
+// the init function of a provider package, so importing the package is enough
+// to enable it. Register panics if p has no code or factory, or if a factory
+// is already registered for the same code.
+func Register(p Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	if p.Code == "" {
+		panic("cloud: Register provider without code")
+	}
+	if p.Factory == nil {
+		panic(fmt.Sprintf("cloud: Register provider %q without factory", p.Code))
+	}
+	existing, ok := providers[p.Code]
+	if ok && existing.Factory != nil {
+		panic(fmt.Sprintf("cloud: Register called twice for provider %q", p.Code))
+	}
+	if p.FullName == "" {
+		p.FullName = existing.FullName
+	}
+	if p.FullName == "" {
+		p.FullName = p.Code
+	}
+	providers[p.Code] = p
+}
+
+// Providers returns the sorted codes of all providers with a registered factory.
+func Providers() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	var codes []string
+	for code, p := range providers {
+		if p.Factory != nil {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// GetProvider returns the provider registered for code.
+func GetProvider(code string) (Provider, bool) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	p, ok := providers[code]
+	return p, ok
+}
+
+// New creates the Automation for the provider with the given code. Missing
+// credentials are read from the environment variables declared in the
+// provider's credential schema. The options customize the cloud-config and
+// bash templates the servers are created and updated with.
+func New(ctx context.Context, code string, creds Credentials, opts ...template.Option) (automation.Automation, error) {
+	p, ok := GetProvider(code)
+	if !ok || p.Factory == nil {
+		return nil, fmt.Errorf("cloud provider %q is not registered", code)
+	}
+	resolved, err := p.resolveCredentials(creds)
+	if err != nil {
+		return nil, err
+	}
+	// avoid handing out a typed nil when a constructor fails
+	a, err := p.Factory(ctx, resolved, opts)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (p Provider) resolveCredentials(creds Credentials) (Credentials, error) {
+	resolved := make(Credentials, len(creds))
+	for k, v := range creds {
+		resolved[k] = v
+	}
+	var missing []string
+	for _, field := range p.Credentials {
+		if resolved[field.Name] == "" && field.EnvVar != "" {
+			resolved[field.Name] = os.Getenv(field.EnvVar)
+		}
+		if field.Required && resolved[field.Name] == "" {
+			missing = append(missing, field.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing credentials for cloud provider %q: %s", p.Code, strings.Join(missing, ", "))
+	}
+	return resolved, nil
+}
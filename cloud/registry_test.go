@@ -0,0 +1,74 @@
+package cloud
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dirien/minectl-sdk/automation"
+	"github.com/dirien/minectl-sdk/model"
+	"github.com/dirien/minectl-sdk/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stubAutomation struct {
+	automation.Automation
+	creds Credentials
+	opts  []template.Option
+}
+
+func TestNewResolvesCredentials(t *testing.T) {
+	Register(Provider{
+		Code:     "test-creds",
+		FullName: "Test Cloud",
+		Credentials: []CredentialField{
+			{Name: CredentialToken, EnvVar: "MINECTL_TEST_TOKEN", Required: true},
+			{Name: CredentialRegion},
+		},
+		Factory: func(_ context.Context, creds Credentials, opts []template.Option) (automation.Automation, error) {
+			return &stubAutomation{creds: creds, opts: opts}, nil
+		},
+	})
+
+	_, err := New(context.Background(), "test-creds", nil)
+	assert.EqualError(t, err, `missing credentials for cloud provider "test-creds": token`)
+
+	t.Setenv("MINECTL_TEST_TOKEN", "from-env")
+	a, err := New(context.Background(), "test-creds", Credentials{CredentialRegion: "fsn1"})
+	require.NoError(t, err)
+	creds := a.(*stubAutomation).creds
+	assert.Equal(t, "from-env", creds[CredentialToken])
+	assert.Equal(t, "fsn1", creds[CredentialRegion])
+
+	a, err = New(context.Background(), "test-creds", Credentials{CredentialToken: "explicit"}, template.WithOverrideDir("overrides"))
+	require.NoError(t, err)
+	assert.Equal(t, "explicit", a.(*stubAutomation).creds[CredentialToken])
+	assert.Len(t, a.(*stubAutomation).opts, 1)
+
+	assert.Contains(t, Providers(), "test-creds")
+	assert.Equal(t, "Test Cloud", GetCloudProviderFullName("test-creds"))
+	assert.Equal(t, "test-creds", GetCloudProviderCode("Test Cloud"))
+}
+
+func TestNewUnknownProvider(t *testing.T) {
+	_, err := New(context.Background(), "does-not-exist", nil)
+	assert.EqualError(t, err, `cloud provider "does-not-exist" is not registered`)
+
+	// built-in providers resolve their names without being registered
+	_, err = New(context.Background(), model.ProviderHetzner, nil)
+	assert.Error(t, err)
+	assert.Equal(t, "Hetzner", GetCloudProviderFullName(model.ProviderHetzner))
+	assert.Equal(t, model.ProviderFuga, GetCloudProviderCode("Fuga Cloud"))
+}
+
+func TestRegisterTwicePanics(t *testing.T) {
+	p := Provider{
+		Code: "test-twice",
+		Factory: func(_ context.Context, _ Credentials, _ []template.Option) (automation.Automation, error) {
+			return &stubAutomation{}, nil
+		},
+	}
+	Register(p)
+	assert.Equal(t, "test-twice", GetCloudProviderFullName("test-twice"))
+	assert.Panics(t, func() { Register(p) })
+}
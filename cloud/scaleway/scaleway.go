@@ -2,14 +2,15 @@
 package scaleway
 
 import (
+	"context"
 	"fmt"
-	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/dirien/minectl-sdk/automation"
 	"github.com/dirien/minectl-sdk/cloud"
 	"github.com/dirien/minectl-sdk/common"
+	"github.com/dirien/minectl-sdk/model"
 	minctlTemplate "github.com/dirien/minectl-sdk/template"
 	"github.com/dirien/minectl-sdk/update"
 	iam "github.com/scaleway/scaleway-sdk-go/api/iam/v1alpha1"
@@ -17,16 +18,35 @@ import (
 	"github.com/scaleway/scaleway-sdk-go/scw"
 )
 
+// sshLogin is the user minectl logs in as on Scaleway servers.
+var sshLogin = update.RootLogin
+
 // Scaleway implements the Automation interface for Scaleway.
 type Scaleway struct {
-	instanceAPI    *instance.API
-	iamAPI         *iam.API
-	organizationID string
-	tmpl           *minctlTemplate.Template
+	instanceAPI     *instance.API
+	iamAPI          *iam.API
+	organizationID  string
+	tmpl            *minctlTemplate.Template
+	templateOptions []minctlTemplate.Option
+}
+
+func init() {
+	cloud.Register(cloud.Provider{
+		Code: model.ProviderScaleway,
+		Credentials: []cloud.CredentialField{
+			{Name: "accessKey", EnvVar: "SCW_ACCESS_KEY", Description: "Scaleway access key", Required: true},
+			{Name: "secretKey", EnvVar: "SCW_SECRET_KEY", Description: "Scaleway secret key", Required: true},
+			{Name: "organizationID", EnvVar: "SCW_DEFAULT_ORGANIZATION_ID", Description: "Scaleway organization ID", Required: true},
+			{Name: cloud.CredentialRegion, EnvVar: "SCW_DEFAULT_REGION", Description: "Scaleway region", Required: true},
+		},
+		Factory: func(_ context.Context, creds cloud.Credentials, opts []minctlTemplate.Option) (automation.Automation, error) {
+			return NewScaleway(creds["accessKey"], creds["secretKey"], creds["organizationID"], creds[cloud.CredentialRegion], opts...)
+		},
+	})
 }
 
 // NewScaleway creates a new Scaleway instance.
-func NewScaleway(accessKey, secretKey, organizationID, region string) (*Scaleway, error) {
+func NewScaleway(accessKey, secretKey, organizationID, region string, opts ...minctlTemplate.Option) (*Scaleway, error) {
 	zone, err := scw.ParseZone(region)
 	if err != nil {
 		return nil, err
@@ -40,20 +60,26 @@ func NewScaleway(accessKey, secretKey, organizationID, region string) (*Scaleway
 	if err != nil {
 		return nil, err
 	}
-	tmpl, err := minctlTemplate.NewTemplateCloudConfig()
+	tmpl, err := minctlTemplate.NewTemplateCloudConfig(opts...)
 	if err != nil {
 		return nil, err
 	}
 	return &Scaleway{
-		instanceAPI:    instance.NewAPI(client),
-		iamAPI:         iam.NewAPI(client),
-		organizationID: organizationID,
-		tmpl:           tmpl,
+		instanceAPI:     instance.NewAPI(client),
+		iamAPI:          iam.NewAPI(client),
+		organizationID:  organizationID,
+		tmpl:            tmpl,
+		templateOptions: opts,
 	}, nil
 }
 
 // CreateServer creates a new Minecraft server on Scaleway.
 func (s *Scaleway) CreateServer(args automation.ServerArgs) (*automation.ResourceResults, error) {
+	return s.CreateServerContext(context.Background(), args)
+}
+
+// CreateServerContext is like CreateServer but honors the cancellation and deadline of ctx.
+func (s *Scaleway) CreateServerContext(ctx context.Context, args automation.ServerArgs) (*automation.ResourceResults, error) {
 	publicKey, err := cloud.GetSSHPublicKey(args)
 	if err != nil {
 		return nil, err
@@ -62,7 +88,7 @@ func (s *Scaleway) CreateServer(args automation.ServerArgs) (*automation.Resourc
 		Name:      fmt.Sprintf("%s-ssh", args.MinecraftResource.GetName()),
 		PublicKey: *publicKey,
 		ProjectID: s.organizationID,
-	})
+	}, scw.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -70,9 +96,9 @@ func (s *Scaleway) CreateServer(args automation.ServerArgs) (*automation.Resourc
 		Name:              args.MinecraftResource.GetName(),
 		CommercialType:    args.MinecraftResource.GetSize(),
 		Image:             scw.StringPtr("ubuntu_jammy"),
-		Tags:              []string{"minectl"},
+		Tags:              automation.ServerTags(args),
 		DynamicIPRequired: scw.BoolPtr(true),
-	})
+	}, scw.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -83,20 +109,20 @@ func (s *Scaleway) CreateServer(args automation.ServerArgs) (*automation.Resourc
 			Name:       fmt.Sprintf("%s-vol", args.MinecraftResource.GetName()),
 			VolumeType: instance.VolumeVolumeTypeBSSD,
 			Size:       scw.SizePtr(scw.Size(args.MinecraftResource.GetVolumeSize()) * scw.GB), //nolint:gosec // volume size is validated
-		})
+		}, scw.WithContext(ctx))
 		if err != nil {
 			return nil, err
 		}
 		_, err = s.instanceAPI.AttachVolume(&instance.AttachVolumeRequest{
 			VolumeID: volume.Volume.ID,
 			ServerID: server.Server.ID,
-		})
+		}, scw.WithContext(ctx))
 		if err != nil {
 			return nil, err
 		}
 		mount = "sda"
 	}
-	userData, err := s.tmpl.GetTemplate(args.MinecraftResource, &minctlTemplate.CreateUpdateTemplateArgs{Mount: mount, Name: minctlTemplate.GetTemplateCloudConfigName(args.MinecraftResource.IsProxyServer())})
+	userData, err := s.tmpl.GetTemplateContext(ctx, args.MinecraftResource, &minctlTemplate.CreateUpdateTemplateArgs{Mount: mount, SSHHostKey: args.SSHHostKey, Name: minctlTemplate.GetTemplateCloudConfigName(args.MinecraftResource.IsProxyServer())})
 	if err != nil {
 		return nil, err
 	}
@@ -104,7 +130,7 @@ func (s *Scaleway) CreateServer(args automation.ServerArgs) (*automation.Resourc
 		ServerID: server.Server.ID,
 		Key:      "cloud-init",
 		Content:  strings.NewReader(userData),
-	})
+	}, scw.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -114,36 +140,37 @@ func (s *Scaleway) CreateServer(args automation.ServerArgs) (*automation.Resourc
 		ServerID:      server.Server.ID,
 		Action:        instance.ServerActionPoweron,
 		RetryInterval: &duration,
-	})
+	}, scw.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
 
 	getServer, err := s.instanceAPI.GetServer(&instance.GetServerRequest{
 		ServerID: server.Server.ID,
-	})
+	}, scw.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
 
-	var publicIP string
-	if len(getServer.Server.PublicIPs) > 0 {
-		publicIP = getServer.Server.PublicIPs[0].Address.String()
-	}
-	return &automation.ResourceResults{
-		ID:       server.Server.ID,
-		Name:     server.Server.Name,
-		Region:   server.Server.Zone.String(),
-		PublicIP: publicIP,
-		Tags:     strings.Join(server.Server.Tags, ","),
-	}, err
+	return serverToResourceResults(getServer.Server), err
+}
+
+// SSHLogin returns the user minectl logs in as on the servers, see
+// update.LoginProvider.
+func (s *Scaleway) SSHLogin() update.Login {
+	return sshLogin
 }
 
 // DeleteServer deletes a Minecraft server on Scaleway.
 func (s *Scaleway) DeleteServer(id string, args automation.ServerArgs) error {
+	return s.DeleteServerContext(context.Background(), id, args)
+}
+
+// DeleteServerContext is like DeleteServer but honors the cancellation and deadline of ctx.
+func (s *Scaleway) DeleteServerContext(ctx context.Context, id string, args automation.ServerArgs) error {
 	getServer, err := s.instanceAPI.GetServer(&instance.GetServerRequest{
 		ServerID: id,
-	})
+	}, scw.WithContext(ctx))
 	if err != nil {
 		return err
 	}
@@ -152,34 +179,34 @@ func (s *Scaleway) DeleteServer(id string, args automation.ServerArgs) error {
 		ServerID:      getServer.Server.ID,
 		Action:        instance.ServerActionPoweroff,
 		RetryInterval: &duration,
-	})
+	}, scw.WithContext(ctx))
 	if err != nil {
 		return err
 	}
 	err = s.instanceAPI.DeleteServer(&instance.DeleteServerRequest{
 		ServerID: getServer.Server.ID,
-	})
+	}, scw.WithContext(ctx))
 	if err != nil {
 		return err
 	}
 	for _, volume := range getServer.Server.Volumes {
 		err := s.instanceAPI.DeleteVolume(&instance.DeleteVolumeRequest{
 			VolumeID: volume.ID,
-		})
+		}, scw.WithContext(ctx))
 		if err != nil {
 			return err
 		}
 	}
 	keys, err := s.iamAPI.ListSSHKeys(&iam.ListSSHKeysRequest{
 		Name: scw.StringPtr(fmt.Sprintf("%s-ssh", args.MinecraftResource.GetName())),
-	})
+	}, scw.WithContext(ctx))
 	if err != nil {
 		return err
 	}
 	for _, key := range keys.SSHKeys {
 		err := s.iamAPI.DeleteSSHKey(&iam.DeleteSSHKeyRequest{
 			SSHKeyID: key.ID,
-		})
+		}, scw.WithContext(ctx))
 		if err != nil {
 			return err
 		}
@@ -187,36 +214,92 @@ func (s *Scaleway) DeleteServer(id string, args automation.ServerArgs) error {
 	return nil
 }
 
+func serverStatus(state instance.ServerState) automation.Status {
+	switch state {
+	case instance.ServerStateStarting:
+		return automation.StatusPending
+	case instance.ServerStateRunning:
+		return automation.StatusRunning
+	case instance.ServerStateStopping:
+		return automation.StatusStopping
+	case instance.ServerStateStopped, instance.ServerStateStoppedInPlace:
+		return automation.StatusStopped
+	default:
+		return automation.StatusUnknown
+	}
+}
+
+func serverToResourceResults(server *instance.Server) *automation.ResourceResults {
+	labels := automation.LabelsFromTags(server.Tags)
+	result := &automation.ResourceResults{
+		ID:      server.ID,
+		Name:    server.Name,
+		Region:  server.Zone.String(),
+		Tags:    strings.Join(server.Tags, ","),
+		Status:  serverStatus(server.State),
+		Size:    server.CommercialType,
+		Arch:    automation.ArchAMD64,
+		Labels:  labels,
+		Edition: automation.EditionFromLabels(labels),
+		Version: automation.VersionFromLabels(labels),
+	}
+	if server.Arch == instance.ArchArm64 {
+		result.Arch = automation.ArchARM64
+	}
+	for _, ip := range server.PublicIPs {
+		if ip.Family == instance.ServerIPIPFamilyInet6 {
+			if result.PublicIPv6 == "" {
+				result.PublicIPv6 = ip.Address.String()
+			}
+		} else if result.PublicIP == "" {
+			result.PublicIP = ip.Address.String()
+		}
+	}
+	if server.PrivateIP != nil {
+		result.PrivateIP = *server.PrivateIP
+	}
+	if server.CreationDate != nil {
+		result.CreatedAt = *server.CreationDate
+	}
+	// volume "0" is the root volume
+	for index, volume := range server.Volumes {
+		if index != "0" {
+			result.VolumeIDs = append(result.VolumeIDs, volume.ID)
+		}
+	}
+	return result
+}
+
 // ListServer lists all Minecraft servers on Scaleway.
 func (s *Scaleway) ListServer() ([]automation.ResourceResults, error) {
+	return s.ListServerContext(context.Background())
+}
+
+// ListServerContext is like ListServer but honors the cancellation and deadline of ctx.
+func (s *Scaleway) ListServerContext(ctx context.Context) ([]automation.ResourceResults, error) {
 	servers, err := s.instanceAPI.ListServers(&instance.ListServersRequest{
 		Tags: []string{common.InstanceTag},
-	})
+	}, scw.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
 	var result []automation.ResourceResults
 	for _, server := range servers.Servers {
-		var publicIP string
-		if len(server.PublicIPs) > 0 {
-			publicIP = server.PublicIPs[0].Address.String()
-		}
-		result = append(result, automation.ResourceResults{
-			ID:       server.ID,
-			PublicIP: publicIP,
-			Name:     server.Name,
-			Region:   server.Zone.String(),
-			Tags:     strings.Join(server.Tags, ","),
-		})
+		result = append(result, *serverToResourceResults(server))
 	}
 	return result, nil
 }
 
 // UpdateServer updates a Minecraft server on Scaleway.
 func (s *Scaleway) UpdateServer(id string, args automation.ServerArgs) error {
+	return s.UpdateServerContext(context.Background(), id, args)
+}
+
+// UpdateServerContext is like UpdateServer but honors the cancellation and deadline of ctx.
+func (s *Scaleway) UpdateServerContext(ctx context.Context, id string, args automation.ServerArgs) error {
 	inst, err := s.instanceAPI.GetServer(&instance.GetServerRequest{
 		ServerID: id,
-	})
+	}, scw.WithContext(ctx))
 	if err != nil {
 		return err
 	}
@@ -225,8 +308,9 @@ func (s *Scaleway) UpdateServer(id string, args automation.ServerArgs) error {
 	if len(inst.Server.PublicIPs) > 0 {
 		publicIP = inst.Server.PublicIPs[0].Address.String()
 	}
-	remoteCommand := update.NewRemoteServer(args.SSHPrivateKeyPath, publicIP, "root")
-	err = remoteCommand.UpdateServer(args.MinecraftResource)
+	remoteCommand := update.NewRemoteServerFromArgs(args, publicIP, sshLogin).WithTemplateOptions(s.templateOptions...)
+	defer func() { _ = remoteCommand.Close() }()
+	_, err = remoteCommand.UpdateServerStream(ctx, args.MinecraftResource, update.ExecOptions{})
 	if err != nil {
 		return err
 	}
@@ -235,9 +319,14 @@ func (s *Scaleway) UpdateServer(id string, args automation.ServerArgs) error {
 
 // UploadPlugin uploads a plugin to a Minecraft server on Scaleway.
 func (s *Scaleway) UploadPlugin(id string, args automation.ServerArgs, plugin, destination string) error {
+	return s.UploadPluginContext(context.Background(), id, args, plugin, destination)
+}
+
+// UploadPluginContext is like UploadPlugin but honors the cancellation and deadline of ctx.
+func (s *Scaleway) UploadPluginContext(ctx context.Context, id string, args automation.ServerArgs, plugin, destination string) error {
 	inst, err := s.instanceAPI.GetServer(&instance.GetServerRequest{
 		ServerID: id,
-	})
+	}, scw.WithContext(ctx))
 	if err != nil {
 		return err
 	}
@@ -246,12 +335,9 @@ func (s *Scaleway) UploadPlugin(id string, args automation.ServerArgs, plugin, d
 	if len(inst.Server.PublicIPs) > 0 {
 		publicIP = inst.Server.PublicIPs[0].Address.String()
 	}
-	remoteCommand := update.NewRemoteServer(args.SSHPrivateKeyPath, publicIP, "root")
-	err = remoteCommand.TransferFile(plugin, filepath.Join(destination, filepath.Base(plugin)), args.MinecraftResource.GetSSHPort())
-	if err != nil {
-		return err
-	}
-	_, err = remoteCommand.ExecuteCommand("systemctl restart minecraft.service", args.MinecraftResource.GetSSHPort())
+	remoteCommand := update.NewRemoteServerFromArgs(args, publicIP, sshLogin)
+	defer func() { _ = remoteCommand.Close() }()
+	err = remoteCommand.UploadPluginContext(ctx, plugin, destination, args.MinecraftResource.GetSSHPort())
 	if err != nil {
 		return err
 	}
@@ -259,22 +345,17 @@ func (s *Scaleway) UploadPlugin(id string, args automation.ServerArgs, plugin, d
 }
 
 // GetServer gets a Minecraft server on Scaleway.
-func (s *Scaleway) GetServer(id string, _ automation.ServerArgs) (*automation.ResourceResults, error) {
+func (s *Scaleway) GetServer(id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
+	return s.GetServerContext(context.Background(), id, args)
+}
+
+// GetServerContext is like GetServer but honors the cancellation and deadline of ctx.
+func (s *Scaleway) GetServerContext(ctx context.Context, id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
 	inst, err := s.instanceAPI.GetServer(&instance.GetServerRequest{
 		ServerID: id,
-	})
+	}, scw.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
-	var publicIP string
-	if len(inst.Server.PublicIPs) > 0 {
-		publicIP = inst.Server.PublicIPs[0].Address.String()
-	}
-	return &automation.ResourceResults{
-		ID:       inst.Server.ID,
-		Name:     inst.Server.Name,
-		Region:   inst.Server.Zone.String(),
-		PublicIP: publicIP,
-		Tags:     strings.Join(inst.Server.Tags, ","),
-	}, err
+	return serverToResourceResults(inst.Server), err
 }
@@ -2,8 +2,13 @@
 package vexxhost
 
 import (
+	"context"
+
 	"github.com/dirien/minectl-sdk/automation"
+	"github.com/dirien/minectl-sdk/cloud"
 	"github.com/dirien/minectl-sdk/cloud/openstack"
+	"github.com/dirien/minectl-sdk/model"
+	minctlTemplate "github.com/dirien/minectl-sdk/template"
 )
 
 // VEXXHOST implements the Automation interface for VEXXHOST.
@@ -13,9 +18,18 @@ type VEXXHOST struct {
 
 const imageName = "Ubuntu 20.04.3 LTS"
 
+func init() {
+	cloud.Register(cloud.Provider{
+		Code: model.ProviderVexxhost,
+		Factory: func(_ context.Context, _ cloud.Credentials, opts []minctlTemplate.Option) (automation.Automation, error) {
+			return NewVEXXHOST(opts...)
+		},
+	})
+}
+
 // NewVEXXHOST creates a new VEXXHOST instance.
-func NewVEXXHOST() (*VEXXHOST, error) {
-	client, err := openstack.NewOpenStack(imageName)
+func NewVEXXHOST(opts ...minctlTemplate.Option) (*VEXXHOST, error) {
+	client, err := openstack.NewOpenStack(imageName, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -26,30 +40,60 @@ func NewVEXXHOST() (*VEXXHOST, error) {
 
 // CreateServer creates a new Minecraft server on VEXXHOST.
 func (v *VEXXHOST) CreateServer(args automation.ServerArgs) (*automation.ResourceResults, error) {
-	return v.openshift.CreateServer(args)
+	return v.CreateServerContext(context.Background(), args)
+}
+
+// CreateServerContext is like CreateServer but honors the cancellation and deadline of ctx.
+func (v *VEXXHOST) CreateServerContext(ctx context.Context, args automation.ServerArgs) (*automation.ResourceResults, error) {
+	return v.openshift.CreateServerContext(ctx, args)
 }
 
 // DeleteServer deletes a Minecraft server on VEXXHOST.
 func (v *VEXXHOST) DeleteServer(id string, args automation.ServerArgs) error {
-	return v.openshift.DeleteServer(id, args)
+	return v.DeleteServerContext(context.Background(), id, args)
+}
+
+// DeleteServerContext is like DeleteServer but honors the cancellation and deadline of ctx.
+func (v *VEXXHOST) DeleteServerContext(ctx context.Context, id string, args automation.ServerArgs) error {
+	return v.openshift.DeleteServerContext(ctx, id, args)
 }
 
 // ListServer lists all Minecraft servers on VEXXHOST.
 func (v *VEXXHOST) ListServer() ([]automation.ResourceResults, error) {
-	return v.openshift.ListServer()
+	return v.ListServerContext(context.Background())
+}
+
+// ListServerContext is like ListServer but honors the cancellation and deadline of ctx.
+func (v *VEXXHOST) ListServerContext(ctx context.Context) ([]automation.ResourceResults, error) {
+	return v.openshift.ListServerContext(ctx)
 }
 
 // UpdateServer updates a Minecraft server on VEXXHOST.
 func (v *VEXXHOST) UpdateServer(id string, args automation.ServerArgs) error {
-	return v.openshift.UpdateServer(id, args)
+	return v.UpdateServerContext(context.Background(), id, args)
+}
+
+// UpdateServerContext is like UpdateServer but honors the cancellation and deadline of ctx.
+func (v *VEXXHOST) UpdateServerContext(ctx context.Context, id string, args automation.ServerArgs) error {
+	return v.openshift.UpdateServerContext(ctx, id, args)
 }
 
 // UploadPlugin uploads a plugin to a Minecraft server on VEXXHOST.
 func (v *VEXXHOST) UploadPlugin(id string, args automation.ServerArgs, plugin, destination string) error {
-	return v.openshift.UploadPlugin(id, args, plugin, destination)
+	return v.UploadPluginContext(context.Background(), id, args, plugin, destination)
+}
+
+// UploadPluginContext is like UploadPlugin but honors the cancellation and deadline of ctx.
+func (v *VEXXHOST) UploadPluginContext(ctx context.Context, id string, args automation.ServerArgs, plugin, destination string) error {
+	return v.openshift.UploadPluginContext(ctx, id, args, plugin, destination)
 }
 
 // GetServer gets a Minecraft server on VEXXHOST.
 func (v *VEXXHOST) GetServer(id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
-	return v.openshift.GetServer(id, args)
+	return v.GetServerContext(context.Background(), id, args)
+}
+
+// GetServerContext is like GetServer but honors the cancellation and deadline of ctx.
+func (v *VEXXHOST) GetServerContext(ctx context.Context, id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
+	return v.openshift.GetServerContext(ctx, id, args)
 }
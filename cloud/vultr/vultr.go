@@ -5,49 +5,71 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
-	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/dirien/minectl-sdk/automation"
 	"github.com/dirien/minectl-sdk/cloud"
 	"github.com/dirien/minectl-sdk/common"
+	"github.com/dirien/minectl-sdk/model"
 	minctlTemplate "github.com/dirien/minectl-sdk/template"
 	"github.com/dirien/minectl-sdk/update"
 	"github.com/vultr/govultr/v3"
 	"golang.org/x/oauth2"
 )
 
+// sshLogin is the user minectl logs in as on Vultr servers.
+var sshLogin = update.RootLogin
+
 // Vultr implements the Automation interface for Vultr.
 type Vultr struct {
-	client *govultr.Client
-	tmpl   *minctlTemplate.Template
+	client          *govultr.Client
+	tmpl            *minctlTemplate.Template
+	templateOptions []minctlTemplate.Option
+}
+
+func init() {
+	cloud.Register(cloud.Provider{
+		Code: model.ProviderVultr,
+		Credentials: []cloud.CredentialField{
+			{Name: cloud.CredentialToken, EnvVar: "VULTR_API_KEY", Description: "Vultr API key", Required: true},
+		},
+		Factory: func(_ context.Context, creds cloud.Credentials, opts []minctlTemplate.Option) (automation.Automation, error) {
+			return NewVultr(creds[cloud.CredentialToken], opts...)
+		},
+	})
 }
 
 // NewVultr creates a new Vultr instance.
-func NewVultr(apiKey string) (*Vultr, error) {
+func NewVultr(apiKey string, opts ...minctlTemplate.Option) (*Vultr, error) {
 	config := &oauth2.Config{}
 	ctx := context.Background()
 	ts := config.TokenSource(ctx, &oauth2.Token{AccessToken: apiKey})
 	vultrClient := govultr.NewClient(oauth2.NewClient(ctx, ts))
-	tmpl, err := minctlTemplate.NewTemplateBash()
+	tmpl, err := minctlTemplate.NewTemplateBash(opts...)
 	if err != nil {
 		return nil, err
 	}
 	vultr := &Vultr{
-		client: vultrClient,
-		tmpl:   tmpl,
+		client:          vultrClient,
+		tmpl:            tmpl,
+		templateOptions: opts,
 	}
 	return vultr, nil
 }
 
 // CreateServer creates a new Minecraft server on Vultr.
 func (v *Vultr) CreateServer(args automation.ServerArgs) (*automation.ResourceResults, error) {
+	return v.CreateServerContext(context.Background(), args)
+}
+
+// CreateServerContext is like CreateServer but honors the cancellation and deadline of ctx.
+func (v *Vultr) CreateServerContext(ctx context.Context, args automation.ServerArgs) (*automation.ResourceResults, error) {
 	publicKey, err := cloud.GetSSHPublicKey(args)
 	if err != nil {
 		return nil, err
 	}
-	sshKey, _, err := v.client.SSHKey.Create(context.Background(), &govultr.SSHKeyReq{
+	sshKey, _, err := v.client.SSHKey.Create(ctx, &govultr.SSHKeyReq{
 		SSHKey: *publicKey,
 		Name:   fmt.Sprintf("%s-ssh", args.MinecraftResource.GetName()),
 	})
@@ -55,11 +77,11 @@ func (v *Vultr) CreateServer(args automation.ServerArgs) (*automation.ResourceRe
 		return nil, err
 	}
 
-	script, err := v.tmpl.GetTemplate(args.MinecraftResource, &minctlTemplate.CreateUpdateTemplateArgs{Name: minctlTemplate.GetTemplateBashName(args.MinecraftResource.IsProxyServer())})
+	script, err := v.tmpl.GetTemplateContext(ctx, args.MinecraftResource, &minctlTemplate.CreateUpdateTemplateArgs{SSHHostKey: args.SSHHostKey, Name: minctlTemplate.GetTemplateBashName(args.MinecraftResource.IsProxyServer())})
 	if err != nil {
 		return nil, err
 	}
-	startupScript, _, err := v.client.StartupScript.Create(context.Background(), &govultr.StartupScriptReq{
+	startupScript, _, err := v.client.StartupScript.Create(ctx, &govultr.StartupScriptReq{
 		Script: base64.StdEncoding.EncodeToString([]byte(script)),
 		Name:   fmt.Sprintf("%s-stackscript", args.MinecraftResource.GetName()),
 		Type:   "boot",
@@ -77,64 +99,106 @@ func (v *Vultr) CreateServer(args automation.ServerArgs) (*automation.ResourceRe
 		Region:   args.MinecraftResource.GetRegion(),
 		Plan:     args.MinecraftResource.GetSize(),
 		OsID:     ubuntu2204Id,
-		Tags: []string{
-			common.InstanceTag,
-			args.MinecraftResource.GetEdition(),
-		},
+		Tags:     automation.ServerTags(args),
 	}
 
-	instance, _, err := v.client.Instance.Create(context.Background(), opts)
+	instance, _, err := v.client.Instance.Create(ctx, opts)
 	if err != nil {
 		return nil, err
 	}
 
 	stillCreating := true
 	for stillCreating {
-		instance, _, err = v.client.Instance.Get(context.Background(), instance.ID)
+		instance, _, err = v.client.Instance.Get(ctx, instance.ID)
 		if err != nil {
 			return nil, err
 		}
 		if instance.Status == "active" {
 			stillCreating = false
-			time.Sleep(2 * time.Second)
-		} else {
-			time.Sleep(2 * time.Second)
+		}
+		if err := common.Sleep(ctx, 2*time.Second); err != nil {
+			return nil, err
 		}
 	}
-	return &automation.ResourceResults{
-		ID:       instance.ID,
-		Name:     instance.Label,
-		Region:   instance.Region,
-		PublicIP: instance.MainIP,
-		Tags:     strings.Join(instance.Tags, ","),
-	}, err
+	return instanceToResourceResults(instance), err
+}
+
+// SSHLogin returns the user minectl logs in as on the servers, see
+// update.LoginProvider.
+func (v *Vultr) SSHLogin() update.Login {
+	return sshLogin
 }
 
 // DeleteServer deletes a Minecraft server on Vultr.
 func (v *Vultr) DeleteServer(id string, args automation.ServerArgs) error {
-	sshKeys, _, _, err := v.client.SSHKey.List(context.Background(), nil)
+	return v.DeleteServerContext(context.Background(), id, args)
+}
+
+// DeleteServerContext is like DeleteServer but honors the cancellation and deadline of ctx.
+func (v *Vultr) DeleteServerContext(ctx context.Context, id string, args automation.ServerArgs) error {
+	sshKeys, _, _, err := v.client.SSHKey.List(ctx, nil)
 	if err != nil {
 		return err
 	}
 	for _, sshKey := range sshKeys {
 		if sshKey.Name == fmt.Sprintf("%s-ssh", args.MinecraftResource.GetName()) {
-			err := v.client.SSHKey.Delete(context.Background(), sshKey.ID)
+			err := v.client.SSHKey.Delete(ctx, sshKey.ID)
 			if err != nil {
 				return err
 			}
 			break
 		}
 	}
-	err = v.client.Instance.Delete(context.Background(), id)
+	err = v.client.Instance.Delete(ctx, id)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+func instanceStatus(instance *govultr.Instance) automation.Status {
+	switch {
+	case instance.Status == "pending":
+		return automation.StatusPending
+	case instance.Status == "active" && instance.PowerStatus == "running":
+		return automation.StatusRunning
+	case instance.PowerStatus == "stopped":
+		return automation.StatusStopped
+	default:
+		return automation.StatusUnknown
+	}
+}
+
+func instanceToResourceResults(instance *govultr.Instance) *automation.ResourceResults {
+	labels := automation.LabelsFromTags(instance.Tags)
+	result := &automation.ResourceResults{
+		ID:         instance.ID,
+		Name:       instance.Label,
+		Region:     instance.Region,
+		PublicIP:   instance.MainIP,
+		Tags:       strings.Join(instance.Tags, ","),
+		Status:     instanceStatus(instance),
+		PublicIPv6: instance.V6MainIP,
+		PrivateIP:  instance.InternalIP,
+		Size:       instance.Plan,
+		Labels:     labels,
+		Edition:    automation.EditionFromLabels(labels),
+		Version:    automation.VersionFromLabels(labels),
+	}
+	if createdAt, err := time.Parse(time.RFC3339, instance.DateCreated); err == nil {
+		result.CreatedAt = createdAt
+	}
+	return result
+}
+
 // ListServer lists all Minecraft servers on Vultr.
 func (v *Vultr) ListServer() ([]automation.ResourceResults, error) {
-	instances, _, _, err := v.client.Instance.List(context.Background(), nil)
+	return v.ListServerContext(context.Background())
+}
+
+// ListServerContext is like ListServer but honors the cancellation and deadline of ctx.
+func (v *Vultr) ListServerContext(ctx context.Context) ([]automation.ResourceResults, error) {
+	instances, _, _, err := v.client.Instance.List(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -142,13 +206,7 @@ func (v *Vultr) ListServer() ([]automation.ResourceResults, error) {
 	for _, instance := range instances {
 		for _, tag := range instance.Tags {
 			if strings.Contains(tag, common.InstanceTag) {
-				result = append(result, automation.ResourceResults{
-					ID:       instance.ID,
-					Name:     instance.Label,
-					Region:   instance.Region,
-					PublicIP: instance.MainIP,
-					Tags:     strings.Join(instance.Tags, ","),
-				})
+				result = append(result, *instanceToResourceResults(&instance))
 			}
 		}
 	}
@@ -157,13 +215,19 @@ func (v *Vultr) ListServer() ([]automation.ResourceResults, error) {
 
 // UpdateServer updates a Minecraft server on Vultr.
 func (v *Vultr) UpdateServer(id string, args automation.ServerArgs) error {
-	instance, _, err := v.client.Instance.Get(context.Background(), id)
+	return v.UpdateServerContext(context.Background(), id, args)
+}
+
+// UpdateServerContext is like UpdateServer but honors the cancellation and deadline of ctx.
+func (v *Vultr) UpdateServerContext(ctx context.Context, id string, args automation.ServerArgs) error {
+	instance, _, err := v.client.Instance.Get(ctx, id)
 	if err != nil {
 		return err
 	}
 
-	remoteCommand := update.NewRemoteServer(args.SSHPrivateKeyPath, instance.MainIP, "root")
-	err = remoteCommand.UpdateServer(args.MinecraftResource)
+	remoteCommand := update.NewRemoteServerFromArgs(args, instance.MainIP, sshLogin).WithTemplateOptions(v.templateOptions...)
+	defer func() { _ = remoteCommand.Close() }()
+	_, err = remoteCommand.UpdateServerStream(ctx, args.MinecraftResource, update.ExecOptions{})
 	if err != nil {
 		return err
 	}
@@ -172,16 +236,18 @@ func (v *Vultr) UpdateServer(id string, args automation.ServerArgs) error {
 
 // UploadPlugin uploads a plugin to a Minecraft server on Vultr.
 func (v *Vultr) UploadPlugin(id string, args automation.ServerArgs, plugin, destination string) error {
-	instance, _, err := v.client.Instance.Get(context.Background(), id)
-	if err != nil {
-		return err
-	}
-	remoteCommand := update.NewRemoteServer(args.SSHPrivateKeyPath, instance.MainIP, "root")
-	err = remoteCommand.TransferFile(plugin, filepath.Join(destination, filepath.Base(plugin)), args.MinecraftResource.GetSSHPort())
+	return v.UploadPluginContext(context.Background(), id, args, plugin, destination)
+}
+
+// UploadPluginContext is like UploadPlugin but honors the cancellation and deadline of ctx.
+func (v *Vultr) UploadPluginContext(ctx context.Context, id string, args automation.ServerArgs, plugin, destination string) error {
+	instance, _, err := v.client.Instance.Get(ctx, id)
 	if err != nil {
 		return err
 	}
-	_, err = remoteCommand.ExecuteCommand("systemctl restart minecraft.service", args.MinecraftResource.GetSSHPort())
+	remoteCommand := update.NewRemoteServerFromArgs(args, instance.MainIP, sshLogin)
+	defer func() { _ = remoteCommand.Close() }()
+	err = remoteCommand.UploadPluginContext(ctx, plugin, destination, args.MinecraftResource.GetSSHPort())
 	if err != nil {
 		return err
 	}
@@ -189,17 +255,16 @@ func (v *Vultr) UploadPlugin(id string, args automation.ServerArgs, plugin, dest
 }
 
 // GetServer gets a Minecraft server on Vultr.
-func (v *Vultr) GetServer(id string, _ automation.ServerArgs) (*automation.ResourceResults, error) {
-	instance, _, err := v.client.Instance.Get(context.Background(), id)
+func (v *Vultr) GetServer(id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
+	return v.GetServerContext(context.Background(), id, args)
+}
+
+// GetServerContext is like GetServer but honors the cancellation and deadline of ctx.
+func (v *Vultr) GetServerContext(ctx context.Context, id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
+	instance, _, err := v.client.Instance.Get(ctx, id)
 	if err != nil {
 		return nil, err
 	}
 
-	return &automation.ResourceResults{
-		ID:       instance.ID,
-		Name:     instance.Label,
-		Region:   instance.Region,
-		PublicIP: instance.MainIP,
-		Tags:     strings.Join(instance.Tags, ","),
-	}, err
+	return instanceToResourceResults(instance), err
 }
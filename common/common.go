@@ -2,8 +2,11 @@
 package common //nolint:revive // package name is acceptable for SDK shared utilities
 
 import (
+	"context"
 	"fmt"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/fatih/color"
 )
@@ -32,11 +35,45 @@ func StringPtr(s string) *string {
 // ExtractFieldsFromServername extracts labels from a server name ID.
 func ExtractFieldsFromServername(id string) (label string, err error) {
 	fields := strings.Split(id, "|")
-	if len(fields) == 3 {
-		label = strings.Join([]string{fields[1], fields[2]}, ",")
+	if len(fields) >= 3 {
+		label = strings.Join(fields[1:], ",")
 	} else {
 		err = fmt.Errorf("could not get fields from custom ID: fields: %v", fields)
 		return "", err
 	}
 	return label, nil
 }
+
+// Sleep pauses for the given duration or until ctx is done, whichever happens
+// first. It returns ctx.Err() if the context ended before the duration elapsed.
+func Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// ShellQuote quotes s as a single word for sh.
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// ValidateDownloadURL checks that raw is an https URL that can be put into
+// shell commands and cloud-configs without escaping.
+func ValidateDownloadURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("download %q is not an https URL", raw)
+	}
+	if strings.ContainsAny(raw, "'\" \t\n#\\") {
+		return fmt.Errorf("download %q contains characters that need escaping", raw)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+// Package testutil holds the fixtures shared by the tests of the other
+// packages in this module.
+package testutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dirien/minectl-sdk/automation"
+	"github.com/dirien/minectl-sdk/model"
+)
+
+// Resource returns a defaulted, valid server called minecraft-server for the
+// given cloud and edition. The SSH public key is written to a temporary
+// directory of t.
+func Resource(t testing.TB, cloud, region, size, edition string) *model.MinecraftResource {
+	t.Helper()
+	keyFile := filepath.Join(t.TempDir(), "id_rsa.pub")
+	if err := os.WriteFile(keyFile, []byte("ssh-rsa AAAA test"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	resource := &model.MinecraftResource{
+		Metadata: model.Metadata{Name: "minecraft-server"},
+		Spec: model.Spec{
+			Server: model.Server{
+				Cloud:  cloud,
+				Region: region,
+				Size:   size,
+				SSH:    model.SSH{Port: 22, PublicKeyFile: keyFile},
+			},
+			Minecraft: model.Minecraft{
+				Edition: edition,
+				Version: "1.20.4",
+				Eula:    true,
+				Java: model.Java{
+					OpenJDK: 17,
+					Xms:     "2G",
+					Xmx:     "2G",
+					Rcon:    model.Rcon{Port: 25575, Password: "test", Enabled: true},
+				},
+			},
+		},
+	}
+	resource.Default()
+	return resource
+}
+
+// ServerArgs wraps Resource in automation.ServerArgs.
+func ServerArgs(t testing.TB, cloud, region, size, edition string) automation.ServerArgs {
+	t.Helper()
+	return automation.ServerArgs{MinecraftResource: Resource(t, cloud, region, size, edition)}
+}
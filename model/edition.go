@@ -0,0 +1,157 @@
+package model
+
+import "fmt"
+
+// Protocol is the transport protocol of the game port.
+type Protocol string
+
+// Protocols of Edition.Protocol.
+const (
+	ProtocolTCP Protocol = "tcp"
+	ProtocolUDP Protocol = "udp"
+)
+
+// Edition describes a Minecraft edition or proxy: how it is installed,
+// started and updated, and how players reach it. The templates, the
+// firewalls of the cloud providers and the updater look the edition of a
+// resource up instead of switching over the names, so adding an edition
+// takes a single RegisterEdition call and its binary templates.
+type Edition struct {
+	// Name is the value of spec.minecraft.edition, or of spec.proxy.type
+	// for proxies.
+	Name string
+	// Proxy marks the proxies.
+	Proxy bool
+	// Binary is the template that installs the server into /minecraft. It
+	// runs on provisioning and again on every update.
+	Binary string
+	// PreUpdate is run before Binary on updates, to remove the files the
+	// binary template does not overwrite.
+	PreUpdate string
+	// Start is the ExecStart command of the systemd unit. If empty, java
+	// runs server.jar with the options of spec.minecraft.java.
+	Start string
+	// ServerArgs are appended to the command line of server.jar.
+	ServerArgs []string
+	// Protocol is the protocol of the game port.
+	Protocol Protocol
+	// Port is the default game port.
+	Port int
+	// JVM reports whether the server runs on Java, which is installed with
+	// the OpenJDK release of spec.minecraft.java.
+	JVM bool
+	// ModLoader is the dependency naming the mod loader in the index of
+	// Modrinth modpacks. Editions with a mod loader take modpacks and mods.
+	ModLoader string
+	// Plugins reports whether the edition loads plugins.
+	Plugins bool
+}
+
+var (
+	// ServerEditions are the Minecraft editions a server can run.
+	ServerEditions []string
+	// ModEditions are the editions with a mod loader, for modpacks and mods.
+	ModEditions []string
+	// PluginEditions are the editions that load plugins.
+	PluginEditions []string
+	// ProxyTypes are the proxies a proxy server can run.
+	ProxyTypes []string
+
+	editions = map[string]Edition{}
+)
+
+const runScript = `/bin/sh -c "./run.sh"`
+
+func init() {
+	for _, e := range []Edition{
+		{Name: "java", Binary: "java-binary", JVM: true},
+		{Name: "bedrock", Binary: "bedrock-binary", Start: `/bin/sh -c "LD_LIBRARY_PATH=. ./bedrock_server"`, Protocol: ProtocolUDP, Port: DefaultBedrockPort},
+		{Name: "craftbukkit", Binary: "spigotbukkit-binary", JVM: true, Plugins: true},
+		{Name: "spigot", Binary: "spigotbukkit-binary", JVM: true, Plugins: true},
+		{Name: "fabric", Binary: "fabric-binary", JVM: true, PreUpdate: "rm -rf /minecraft/minecraft-server.jar", ModLoader: "fabric-loader"},
+		{Name: "forge", Binary: "forge-binary", JVM: true, Start: runScript, ModLoader: "forge"},
+		{Name: "quilt", Binary: "quilt-binary", JVM: true, PreUpdate: "rm -rf /minecraft/minecraft-server.jar", ModLoader: "quilt-loader"},
+		{Name: "neoforge", Binary: "neoforge-binary", JVM: true, Start: runScript, ModLoader: "neoforge"},
+		{Name: "papermc", Binary: "papermc-binary", JVM: true, Plugins: true},
+		{Name: "purpur", Binary: "purpur-binary", JVM: true, Plugins: true},
+		{Name: "folia", Binary: "folia-binary", JVM: true, Plugins: true},
+		{Name: "nukkit", Binary: "nukkit-binary", JVM: true, ServerArgs: []string{"--language", "eng"}, Protocol: ProtocolUDP, Port: DefaultBedrockPort, Plugins: true},
+		{Name: "powernukkit", Binary: "powernukkit-binary", JVM: true, ServerArgs: []string{"--language", "eng"}, Protocol: ProtocolUDP, Port: DefaultBedrockPort, Plugins: true},
+		{Name: "bungeecord", Proxy: true, Binary: "bungeecord-binary", JVM: true},
+		{Name: "waterfall", Proxy: true, Binary: "waterfall-binary", JVM: true},
+		{Name: "velocity", Proxy: true, Binary: "velocity-binary", JVM: true},
+	} {
+		RegisterEdition(e)
+	}
+}
+
+// RegisterEdition adds an edition or proxy. The protocol defaults to TCP
+// and the port to the one of the protocol. It panics if the name is taken,
+// and is meant to be called from init functions.
+func RegisterEdition(e Edition) {
+	if e.Name == "" {
+		panic("model: edition without name")
+	}
+	if _, ok := editions[e.Name]; ok {
+		panic(fmt.Sprintf("model: edition %q registered twice", e.Name))
+	}
+	if e.Protocol == "" {
+		e.Protocol = ProtocolTCP
+	}
+	if e.Port == 0 {
+		e.Port = DefaultJavaPort
+		if e.Protocol == ProtocolUDP {
+			e.Port = DefaultBedrockPort
+		}
+	}
+	editions[e.Name] = e
+	if e.Proxy {
+		ProxyTypes = append(ProxyTypes, e.Name)
+		return
+	}
+	ServerEditions = append(ServerEditions, e.Name)
+	if e.ModLoader != "" {
+		ModEditions = append(ModEditions, e.Name)
+	}
+	if e.Plugins {
+		PluginEditions = append(PluginEditions, e.Name)
+	}
+}
+
+// LookupEdition returns the edition or proxy called name.
+func LookupEdition(name string) (Edition, bool) {
+	e, ok := editions[name]
+	return e, ok
+}
+
+// GetEditionSpec returns the edition the resource runs, or an error if it
+// is not registered.
+func (m *MinecraftResource) GetEditionSpec() (Edition, error) {
+	e, ok := LookupEdition(m.GetEdition())
+	if !ok {
+		return Edition{}, fmt.Errorf("model: unknown edition %q", m.GetEdition())
+	}
+	return e, nil
+}
+
+// GetProtocol returns the protocol of the game port of the resource.
+func (m *MinecraftResource) GetProtocol() Protocol {
+	if e, ok := LookupEdition(m.GetEdition()); ok {
+		return e.Protocol
+	}
+	return ProtocolTCP
+}
+
+// IsBedrockProtocol reports whether edition speaks the Bedrock protocol over
+// UDP instead of the Java protocol over TCP.
+func IsBedrockProtocol(edition string) bool {
+	e, ok := LookupEdition(edition)
+	return ok && e.Protocol == ProtocolUDP
+}
+
+// isJVM reports whether edition runs on Java. Unknown editions are taken
+// for Java ones, validation reports them.
+func isJVM(edition string) bool {
+	e, ok := LookupEdition(edition)
+	return !ok || e.JVM
+}
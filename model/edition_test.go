@@ -0,0 +1,55 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupEdition(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol Protocol
+		port     int
+		jvm      bool
+	}{
+		{"java", ProtocolTCP, DefaultJavaPort, true},
+		{"bedrock", ProtocolUDP, DefaultBedrockPort, false},
+		{"nukkit", ProtocolUDP, DefaultBedrockPort, true},
+		{"neoforge", ProtocolTCP, DefaultJavaPort, true},
+		{"velocity", ProtocolTCP, DefaultJavaPort, true},
+	}
+	for _, tt := range tests {
+		e, ok := LookupEdition(tt.name)
+		require.True(t, ok, tt.name)
+		assert.Equal(t, tt.protocol, e.Protocol, tt.name)
+		assert.Equal(t, tt.port, e.Port, tt.name)
+		assert.Equal(t, tt.jvm, e.JVM, tt.name)
+		assert.NotEmpty(t, e.Binary, tt.name)
+	}
+	_, ok := LookupEdition("vanilla")
+	assert.False(t, ok)
+}
+
+func TestRegisterEdition(t *testing.T) {
+	serverEditions, modEditions, pluginEditions := ServerEditions, ModEditions, PluginEditions
+	t.Cleanup(func() {
+		delete(editions, "geyser")
+		ServerEditions, ModEditions, PluginEditions = serverEditions, modEditions, pluginEditions
+	})
+
+	RegisterEdition(Edition{Name: "geyser", Binary: "geyser-binary", Protocol: ProtocolUDP, JVM: true, Plugins: true})
+	assert.Contains(t, ServerEditions, "geyser")
+	assert.Contains(t, PluginEditions, "geyser")
+	assert.NotContains(t, ModEditions, "geyser")
+	assert.Panics(t, func() { RegisterEdition(Edition{Name: "geyser"}) })
+
+	m := makeResource()
+	m.Spec.Minecraft.Edition = "geyser"
+	m.Default()
+	require.NoError(t, m.Validate())
+	assert.Equal(t, DefaultBedrockPort, m.GetPort())
+	assert.Equal(t, ProtocolUDP, m.GetProtocol())
+	assert.Equal(t, 17, m.GetJDKVersion())
+}
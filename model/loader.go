@@ -0,0 +1,180 @@
+package model
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envRegex matches ${VAR} and ${VAR:-default} references, and the $${
+// escape for a literal ${.
+var envRegex = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// Loader reads MinecraftResource manifests.
+type Loader struct {
+	// LookupEnv resolves ${VAR} references. It defaults to os.LookupEnv.
+	LookupEnv func(key string) (string, bool)
+}
+
+// NewLoader creates a Loader that expands references from the environment.
+func NewLoader() *Loader {
+	return &Loader{LookupEnv: os.LookupEnv}
+}
+
+// LoadFile reads all manifests in the file at path.
+func LoadFile(path string) ([]*MinecraftResource, error) {
+	return NewLoader().LoadFile(path)
+}
+
+// Load reads all manifests from r.
+func Load(r io.Reader) ([]*MinecraftResource, error) {
+	return NewLoader().Load(r, "")
+}
+
+// LoadFile reads all manifests in the file at path.
+func (l *Loader) LoadFile(path string) ([]*MinecraftResource, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return l.Load(bytes.NewReader(data), path)
+}
+
+// Load reads the ---separated manifests from r. Every document must have the
+// minectl apiVersion and a known kind. ${VAR} references in values are
+// expanded, ${VAR:-default} falls back to default if VAR is unset and $${
+// is a literal ${. Errors are prefixed with source and the line of the
+// offending document or value. The resources are neither defaulted nor
+// validated, see MinecraftResource.Default and MinecraftResource.Validate.
+func (l *Loader) Load(r io.Reader, source string) ([]*MinecraftResource, error) {
+	if source == "" {
+		source = "<input>"
+	}
+	decoder := yaml.NewDecoder(r)
+	var resources []*MinecraftResource
+	for {
+		var document yaml.Node
+		err := decoder.Decode(&document)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", source, err)
+		}
+		if len(document.Content) == 0 || (document.Content[0].Kind == yaml.ScalarNode && document.Content[0].Tag == "!!null") {
+			continue
+		}
+		resource, err := l.decode(&document)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%w", source, err)
+		}
+		resources = append(resources, resource)
+	}
+	return resources, nil
+}
+
+// lineError is an error at a line of the source.
+type lineError struct {
+	line int
+	err  error
+}
+
+func (e *lineError) Error() string {
+	return fmt.Sprintf("%d: %v", e.line, e.err)
+}
+
+func (e *lineError) Unwrap() error {
+	return e.err
+}
+
+func (l *Loader) decode(document *yaml.Node) (*MinecraftResource, error) {
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, &lineError{line: root.Line, err: errors.New("document is not a mapping")}
+	}
+	if err := checkField(root, "apiVersion", APIVersion); err != nil {
+		return nil, err
+	}
+	if err := checkField(root, "kind", KindMinecraftServer, KindMinecraftProxy); err != nil {
+		return nil, err
+	}
+	if err := l.expand(root); err != nil {
+		return nil, err
+	}
+	var resource MinecraftResource
+	if err := root.Decode(&resource); err != nil {
+		return nil, &lineError{line: root.Line, err: err}
+	}
+	return &resource, nil
+}
+
+func checkField(mapping *yaml.Node, key string, allowed ...string) error {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value != key {
+			continue
+		}
+		value := mapping.Content[i+1]
+		for _, a := range allowed {
+			if value.Value == a {
+				return nil
+			}
+		}
+		return &lineError{line: value.Line, err: fmt.Errorf("unsupported %s %q, expected %s", key, value.Value, strings.Join(allowed, " or "))}
+	}
+	return &lineError{line: mapping.Line, err: fmt.Errorf("missing %s", key)}
+}
+
+// expand replaces the env references in all scalar values below node.
+func (l *Loader) expand(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		if !strings.Contains(node.Value, "${") {
+			return nil
+		}
+		var missing []string
+		node.Value = envRegex.ReplaceAllStringFunc(node.Value, func(match string) string {
+			if match == "$${" {
+				return "${"
+			}
+			groups := envRegex.FindStringSubmatch(match)
+			if value, ok := l.lookupEnv(groups[1]); ok {
+				return value
+			}
+			if groups[2] != "" {
+				return groups[3]
+			}
+			missing = append(missing, groups[1])
+			return match
+		})
+		if len(missing) > 0 {
+			return &lineError{line: node.Line, err: fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))}
+		}
+		// let the value be resolved again, so ${PORT} can fill an int field
+		if node.Style == 0 {
+			node.Tag = ""
+		}
+		return nil
+	}
+	for i, child := range node.Content {
+		// keys of mappings are left alone
+		if node.Kind == yaml.MappingNode && i%2 == 0 {
+			continue
+		}
+		if err := l.expand(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *Loader) lookupEnv(key string) (string, bool) {
+	if l.LookupEnv == nil {
+		return os.LookupEnv(key)
+	}
+	return l.LookupEnv(key)
+}
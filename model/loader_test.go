@@ -0,0 +1,113 @@
+package model
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const manifests = `apiVersion: minectl.ediri.io/v1alpha1
+kind: MinecraftServer
+metadata:
+  name: minecraft-java
+spec:
+  server:
+    cloud: hetzner
+    region: nbg1
+    size: cx21
+    port: ${PORT}
+    ssh:
+      publickey: ${SSH_PUBLIC_KEY}
+  minecraft:
+    edition: java
+    version: ${VERSION:-1.20.4}
+    java:
+      rcon:
+        enabled: true
+        password: "${RCON_PASSWORD}"
+    properties: |
+      motd=$${literal}
+---
+apiVersion: minectl.ediri.io/v1alpha1
+kind: MinecraftProxy
+metadata:
+  name: minecraft-proxy
+spec:
+  proxy:
+    type: velocity
+    version: 3.3.0
+---
+`
+
+func lookup(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+}
+
+func TestLoad(t *testing.T) {
+	l := &Loader{LookupEnv: lookup(map[string]string{
+		"PORT":           "25566",
+		"SSH_PUBLIC_KEY": "ssh-ed25519 AAAA",
+		"RCON_PASSWORD":  "s3cr3t: #1",
+	})}
+	resources, err := l.Load(strings.NewReader(manifests), "servers.yaml")
+	require.NoError(t, err)
+	require.Len(t, resources, 2)
+
+	java := resources[0]
+	assert.Equal(t, "minecraft-java", java.GetName())
+	assert.Equal(t, 25566, java.GetPort())
+	assert.Equal(t, "ssh-ed25519 AAAA", java.GetSSHPublicKey())
+	assert.Equal(t, "1.20.4", java.GetVersion())
+	assert.Equal(t, "s3cr3t: #1", java.GetRCONPassword())
+	assert.Equal(t, "motd=${literal}\n", java.GetProperties())
+
+	assert.Equal(t, KindMinecraftProxy, resources[1].Kind)
+	assert.Equal(t, "velocity", resources[1].GetEdition())
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		err      string
+	}{
+		{
+			name:     "missing env",
+			manifest: manifests,
+			err:      "servers.yaml:10: environment variable PORT is not set",
+		},
+		{
+			name:     "wrong apiVersion",
+			manifest: "apiVersion: v1\nkind: MinecraftServer\n",
+			err:      `servers.yaml:1: unsupported apiVersion "v1", expected minectl.ediri.io/v1alpha1`,
+		},
+		{
+			name:     "wrong kind in second document",
+			manifest: "apiVersion: minectl.ediri.io/v1alpha1\nkind: MinecraftServer\n---\napiVersion: minectl.ediri.io/v1alpha1\nkind: Pod\n",
+			err:      `servers.yaml:5: unsupported kind "Pod", expected MinecraftServer or MinecraftProxy`,
+		},
+		{
+			name:     "missing kind",
+			manifest: "apiVersion: minectl.ediri.io/v1alpha1\n",
+			err:      "servers.yaml:1: missing kind",
+		},
+		{
+			name:     "wrong type",
+			manifest: "apiVersion: minectl.ediri.io/v1alpha1\nkind: MinecraftServer\nspec:\n  server:\n    port: twenty\n",
+			err:      "line 5: cannot unmarshal !!str `twenty` into int",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &Loader{LookupEnv: lookup(nil)}
+			_, err := l.Load(strings.NewReader(tt.manifest), "servers.yaml")
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
@@ -52,6 +52,9 @@ type Server struct {
 	VolumeSize int    `yaml:"volumeSize"`
 	Spot       bool   `yaml:"spot"`
 	Arm        bool   `yaml:"arm"`
+	// VolumeReclaimPolicy decides what DeleteServer does with the volume,
+	// VolumeReclaimDelete if empty.
+	VolumeReclaimPolicy string `yaml:"volumeReclaimPolicy"`
 }
 
 // SSH represents a SSH configuration.
@@ -76,6 +79,40 @@ type Minecraft struct {
 	Edition    string `yaml:"edition"`
 	Version    string `yaml:"version"`
 	Eula       bool   `yaml:"eula"`
+	// Modpack is installed on top of the mod loader of the edition.
+	Modpack Modpack `yaml:"modpack"`
+	// Plugins are installed into /minecraft/plugins and Mods into
+	// /minecraft/mods. Updating the server adds, upgrades and removes them
+	// to match the lists.
+	Plugins []Plugin `yaml:"plugins"`
+	Mods    []Plugin `yaml:"mods"`
+}
+
+// Plugin represents a plugin or mod jar. Exactly one of URL, Modrinth,
+// Hangar, Spigot and File is set.
+type Plugin struct {
+	// Name is the file name of the jar on the server. It defaults to the
+	// name the source gives the jar.
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	// Modrinth, Hangar and Spigot are the id or slug of a project on
+	// Modrinth, Hangar or SpigotMC, of which Version is installed.
+	Modrinth string `yaml:"modrinth"`
+	Hangar   string `yaml:"hangar"`
+	Spigot   string `yaml:"spigot"`
+	Version  string `yaml:"version"`
+	// File is the local path of the jar. It is uploaded when the server is
+	// updated, as the user data of a new server cannot carry it.
+	File string `yaml:"file"`
+	// Checksum is sha256:<hex> or sha512:<hex>. It is required for URL and
+	// Spigot, Modrinth and Hangar publish the checksums of their jars.
+	Checksum string `yaml:"checksum"`
+}
+
+// Modpack represents a Modrinth modpack.
+type Modpack struct {
+	// Source is the URL or local path of the .mrpack file.
+	Source string `yaml:"source"`
 }
 
 // Java represents a java configuration.
@@ -166,11 +203,21 @@ func (m *MinecraftResource) GetVolumeSize() int {
 	return m.Spec.Server.VolumeSize
 }
 
+// RetainVolume returns whether the volume is kept when the server is deleted.
+func (m *MinecraftResource) RetainVolume() bool {
+	return m.Spec.Server.VolumeReclaimPolicy == VolumeReclaimRetain
+}
+
 // GetVersion returns the Minecraft version.
 func (m *MinecraftResource) GetVersion() string {
 	return m.Spec.Minecraft.Version
 }
 
+// GetModpack returns the source of the modpack, if one is set.
+func (m *MinecraftResource) GetModpack() string {
+	return m.Spec.Minecraft.Modpack.Source
+}
+
 // GetPort returns the server port.
 func (m *MinecraftResource) GetPort() int {
 	return m.Spec.Server.Port
@@ -243,3 +290,32 @@ const (
 	ProviderExoscale     = "exoscale"
 	ProviderMultipass    = "multipass"
 )
+
+var providerFullNames = map[string]string{
+	ProviderDigitalocean: "DigitalOcean",
+	ProviderCivo:         "Civo",
+	ProviderScaleway:     "Scaleway",
+	ProviderHetzner:      "Hetzner",
+	ProviderAkamai:       "Akamai Connected Cloud",
+	ProviderOvh:          "OVHcloud",
+	ProviderGce:          "Google Compute Engine",
+	ProviderVultr:        "vultr",
+	ProviderAzure:        "Azure",
+	ProviderOci:          "Oracle Cloud Infrastructure",
+	ProviderAws:          "Amazon Web Services",
+	ProviderVexxhost:     "VEXXHOST",
+	ProviderExoscale:     "Exoscale",
+	ProviderMultipass:    "Ubuntu Multipass",
+	ProviderFuga:         "Fuga Cloud",
+}
+
+// ProviderFullNames returns the full names of the built-in providers, keyed
+// by their code. Use cloud.GetCloudProviderFullName to include the providers
+// registered by other packages.
+func ProviderFullNames() map[string]string {
+	names := make(map[string]string, len(providerFullNames))
+	for code, name := range providerFullNames {
+		names[code] = name
+	}
+	return names
+}
@@ -0,0 +1,9 @@
+package model
+
+import _ "embed"
+
+// JSONSchema is the JSON Schema of the minectl.ediri.io/v1alpha1 manifests,
+// for editors and CI pipelines that lint minectl YAML files.
+//
+//go:embed minecraftresource.schema.json
+var JSONSchema []byte
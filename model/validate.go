@@ -0,0 +1,351 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/dirien/minectl-sdk/common"
+)
+
+// APIVersion is the apiVersion of the minectl manifests.
+const APIVersion = "minectl.ediri.io/v1alpha1"
+
+// Kinds of the minectl manifests.
+const (
+	KindMinecraftServer = "MinecraftServer"
+	KindMinecraftProxy  = "MinecraftProxy"
+)
+
+// Default ports and settings applied by Default.
+const (
+	DefaultJavaPort    = 25565
+	DefaultBedrockPort = 19132
+	DefaultSSHPort     = 22
+	DefaultRCONPort    = 25575
+	DefaultHeap        = "2G"
+	DefaultBantime     = 1000
+	DefaultMaxretry    = 3
+)
+
+// Volume reclaim policies of Server.VolumeReclaimPolicy.
+const (
+	// VolumeReclaimDelete deletes the volume together with the server.
+	VolumeReclaimDelete = "delete"
+	// VolumeReclaimRetain keeps the volume, a new server with the same name
+	// attaches it again.
+	VolumeReclaimRetain = "retain"
+)
+
+var (
+	nameRegex       = regexp.MustCompile(common.NameRegex)
+	heapRegex       = regexp.MustCompile(`^[0-9]+[KkMmGg]$`)
+	checksumRegex   = regexp.MustCompile(ChecksumRegex)
+	pluginNameRegex = regexp.MustCompile(PluginNameRegex)
+)
+
+// PluginNameRegex matches the file names of plugins and mods.
+const PluginNameRegex = `^[A-Za-z0-9][A-Za-z0-9 ._+\-()\[\]]*\.jar$`
+
+// ChecksumRegex matches the checksums of plugins and mods.
+const ChecksumRegex = `^(sha256:[0-9a-f]{64}|sha512:[0-9a-f]{128})$`
+
+// FieldError is a validation error of a single field.
+type FieldError struct {
+	// Path is the YAML path of the field, e.g. spec.server.ssh.port.
+	Path    string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ValidationError holds all field errors found by Validate.
+type ValidationError []*FieldError
+
+func (e ValidationError) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return fmt.Sprintf("invalid MinecraftResource: %s", strings.Join(messages, "; "))
+}
+
+type validator struct {
+	errs ValidationError
+}
+
+func (v *validator) addf(path, format string, a ...any) {
+	v.errs = append(v.errs, &FieldError{Path: path, Message: fmt.Sprintf(format, a...)})
+}
+
+func (v *validator) required(path, value string) bool {
+	if value == "" {
+		v.addf(path, "is required")
+		return false
+	}
+	return true
+}
+
+func (v *validator) port(path string, port int) {
+	if port < 1 || port > 65535 {
+		v.addf(path, "must be between 1 and 65535, got %d", port)
+	}
+}
+
+// Validate checks the resource and returns a ValidationError with all field
+// errors, or nil if the resource is valid. Call Default first to fill in the
+// fields that have sensible defaults.
+func (m *MinecraftResource) Validate() error {
+	v := &validator{}
+	if m.APIVersion != APIVersion {
+		v.addf("apiVersion", "must be %q, got %q", APIVersion, m.APIVersion)
+	}
+	if m.Kind != KindMinecraftServer && m.Kind != KindMinecraftProxy {
+		v.addf("kind", "must be %q or %q, got %q", KindMinecraftServer, KindMinecraftProxy, m.Kind)
+	}
+	if v.required("metadata.name", m.Metadata.Name) && !nameRegex.MatchString(m.Metadata.Name) {
+		v.addf("metadata.name", "must match %s, got %q", common.NameRegex, m.Metadata.Name)
+	}
+	m.validateServer(v)
+	if m.IsProxyServer() {
+		m.validateProxy(v)
+	} else {
+		m.validateMinecraft(v)
+	}
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+	return nil
+}
+
+func (m *MinecraftResource) validateServer(v *validator) {
+	server := m.Spec.Server
+	v.required("spec.server.cloud", server.Cloud)
+	v.required("spec.server.region", server.Region)
+	v.required("spec.server.size", server.Size)
+	v.port("spec.server.port", server.Port)
+	v.port("spec.server.ssh.port", server.SSH.Port)
+	if server.SSH.Port == server.Port {
+		v.addf("spec.server.ssh.port", "must differ from spec.server.port")
+	}
+	if server.VolumeSize < 0 {
+		v.addf("spec.server.volumeSize", "must not be negative, got %d", server.VolumeSize)
+	}
+	switch server.VolumeReclaimPolicy {
+	case "", VolumeReclaimDelete, VolumeReclaimRetain:
+	default:
+		v.addf("spec.server.volumeReclaimPolicy", "must be %q or %q, got %q", VolumeReclaimDelete, VolumeReclaimRetain, server.VolumeReclaimPolicy)
+	}
+	switch {
+	case server.SSH.PublicKeyFile == "" && server.SSH.PublicKey == "":
+		v.addf("spec.server.ssh", "one of publickeyfile or publickey is required")
+	case server.SSH.PublicKeyFile != "" && !strings.HasSuffix(server.SSH.PublicKeyFile, ".pub"):
+		v.addf("spec.server.ssh.publickeyfile", "must have .pub extension")
+	}
+	if server.SSH.Fail2ban.Bantime < 0 {
+		v.addf("spec.server.ssh.fail2ban.bantime", "must not be negative, got %d", server.SSH.Fail2ban.Bantime)
+	}
+	if server.SSH.Fail2ban.Maxretry < 0 {
+		v.addf("spec.server.ssh.fail2ban.maxretry", "must not be negative, got %d", server.SSH.Fail2ban.Maxretry)
+	}
+}
+
+func (m *MinecraftResource) validateMinecraft(v *validator) {
+	minecraft := m.Spec.Minecraft
+	if v.required("spec.minecraft.edition", minecraft.Edition) && !slices.Contains(ServerEditions, minecraft.Edition) {
+		v.addf("spec.minecraft.edition", "must be one of %s, got %q", strings.Join(ServerEditions, ", "), minecraft.Edition)
+	}
+	v.required("spec.minecraft.version", minecraft.Version)
+	if !minecraft.Eula {
+		v.addf("spec.minecraft.eula", "must be true to accept the Minecraft EULA")
+	}
+	if minecraft.Modpack.Source != "" && !slices.Contains(ModEditions, minecraft.Edition) {
+		v.addf("spec.minecraft.modpack.source", "needs one of the editions %s, got %q", strings.Join(ModEditions, ", "), minecraft.Edition)
+	}
+	if len(minecraft.Plugins) > 0 && !slices.Contains(PluginEditions, minecraft.Edition) {
+		v.addf("spec.minecraft.plugins", "needs one of the editions %s, got %q", strings.Join(PluginEditions, ", "), minecraft.Edition)
+	}
+	if len(minecraft.Mods) > 0 && !slices.Contains(ModEditions, minecraft.Edition) {
+		v.addf("spec.minecraft.mods", "needs one of the editions %s, got %q", strings.Join(ModEditions, ", "), minecraft.Edition)
+	}
+	validatePlugins(v, "spec.minecraft.plugins", minecraft.Plugins)
+	validatePlugins(v, "spec.minecraft.mods", minecraft.Mods)
+	if isJVM(minecraft.Edition) {
+		validateJava(v, "spec.minecraft.java", minecraft.Java, m.Spec.Server.Port)
+	}
+}
+
+func (m *MinecraftResource) validateProxy(v *validator) {
+	proxy := m.Spec.Proxy
+	if v.required("spec.proxy.type", proxy.Type) && !slices.Contains(ProxyTypes, proxy.Type) {
+		v.addf("spec.proxy.type", "must be one of %s, got %q", strings.Join(ProxyTypes, ", "), proxy.Type)
+	}
+	v.required("spec.proxy.version", proxy.Version)
+	validateJava(v, "spec.proxy.java", proxy.Java, m.Spec.Server.Port)
+}
+
+func validatePlugins(v *validator, path string, plugins []Plugin) {
+	names := map[string]bool{}
+	for i, plugin := range plugins {
+		path := fmt.Sprintf("%s[%d]", path, i)
+		var sources []string
+		for source, value := range map[string]string{"url": plugin.URL, "modrinth": plugin.Modrinth, "hangar": plugin.Hangar, "spigot": plugin.Spigot, "file": plugin.File} {
+			if value != "" {
+				sources = append(sources, source)
+			}
+		}
+		if len(sources) != 1 {
+			v.addf(path, "needs exactly one of url, modrinth, hangar, spigot or file")
+			continue
+		}
+		repository := plugin.Modrinth != "" || plugin.Hangar != "" || plugin.Spigot != ""
+		if repository {
+			v.required(path+".version", plugin.Version)
+		}
+		switch {
+		case plugin.Checksum != "" && !checksumRegex.MatchString(plugin.Checksum):
+			v.addf(path+".checksum", "must be sha256:<hex> or sha512:<hex>, got %q", plugin.Checksum)
+		case plugin.Checksum == "" && (plugin.URL != "" || plugin.Spigot != ""):
+			v.addf(path+".checksum", "is required for %s", sources[0])
+		}
+		if plugin.Name != "" {
+			if !pluginNameRegex.MatchString(plugin.Name) {
+				v.addf(path+".name", "must be a jar file name, got %q", plugin.Name)
+			}
+			if names[plugin.Name] {
+				v.addf(path+".name", "%q is used twice", plugin.Name)
+			}
+			names[plugin.Name] = true
+		}
+	}
+}
+
+func validateJava(v *validator, path string, java Java, serverPort int) {
+	if java.OpenJDK <= 0 {
+		v.addf(path+".openjdk", "is required")
+	}
+	if v.required(path+".xmx", java.Xmx) && !heapRegex.MatchString(java.Xmx) {
+		v.addf(path+".xmx", "must be a size like 2G or 512M, got %q", java.Xmx)
+	}
+	if v.required(path+".xms", java.Xms) && !heapRegex.MatchString(java.Xms) {
+		v.addf(path+".xms", "must be a size like 2G or 512M, got %q", java.Xms)
+	}
+	if java.Rcon.Enabled {
+		v.required(path+".rcon.password", java.Rcon.Password)
+		v.port(path+".rcon.port", java.Rcon.Port)
+		if java.Rcon.Port == serverPort {
+			v.addf(path+".rcon.port", "must differ from spec.server.port")
+		}
+	}
+}
+
+// Default fills the fields that are left empty with sensible values: the
+// apiVersion and kind, the server port of the edition, the SSH and RCON
+// ports, the Java heap and the OpenJDK release the Minecraft version needs.
+func (m *MinecraftResource) Default() {
+	if m.APIVersion == "" {
+		m.APIVersion = APIVersion
+	}
+	if m.Kind == "" {
+		m.Kind = KindMinecraftServer
+		if m.IsProxyServer() {
+			m.Kind = KindMinecraftProxy
+		}
+	}
+	server := &m.Spec.Server
+	if server.Port == 0 {
+		server.Port = DefaultJavaPort
+		if e, ok := LookupEdition(m.GetEdition()); ok {
+			server.Port = e.Port
+		}
+	}
+	if server.SSH.Port == 0 {
+		server.SSH.Port = DefaultSSHPort
+	}
+	if server.SSH.Fail2ban.Bantime == 0 {
+		server.SSH.Fail2ban.Bantime = DefaultBantime
+	}
+	if server.SSH.Fail2ban.Maxretry == 0 {
+		server.SSH.Fail2ban.Maxretry = DefaultMaxretry
+	}
+	if m.IsProxyServer() {
+		defaultJava(&m.Spec.Proxy.Java, 17)
+		return
+	}
+	if isJVM(m.Spec.Minecraft.Edition) {
+		defaultJava(&m.Spec.Minecraft.Java, OpenJDKForVersion(MinecraftVersion(m.Spec.Minecraft.Edition, m.Spec.Minecraft.Version)))
+	}
+}
+
+func defaultJava(java *Java, openJDK int) {
+	if java.OpenJDK == 0 {
+		java.OpenJDK = openJDK
+	}
+	if java.Xmx == "" {
+		java.Xmx = DefaultHeap
+	}
+	if java.Xms == "" {
+		java.Xms = java.Xmx
+	}
+	if java.Rcon.Enabled && java.Rcon.Port == 0 {
+		java.Rcon.Port = DefaultRCONPort
+	}
+}
+
+// MinecraftVersion returns the Minecraft version a server of edition and
+// version runs. Forge versions are <minecraft>-<forge>, and NeoForge
+// versions like 20.4.237 encode it without the leading 1: NeoForge 20.4
+// runs Minecraft 1.20.4. Other versions are returned as they are.
+func MinecraftVersion(edition, version string) string {
+	if edition == "forge" {
+		minecraft, _, _ := strings.Cut(version, "-")
+		return minecraft
+	}
+	if edition != "neoforge" {
+		return version
+	}
+	// strip suffixes like 20.4.80-beta
+	version, _, _ = strings.Cut(version, "-")
+	parts := strings.Split(version, ".")
+	if len(parts) != 3 {
+		return version
+	}
+	if parts[1] == "0" {
+		return "1." + parts[0]
+	}
+	return "1." + parts[0] + "." + parts[1]
+}
+
+// OpenJDKForVersion returns the OpenJDK release required by a Minecraft
+// version like 1.20.4. Versions that cannot be parsed get OpenJDK 17.
+func OpenJDKForVersion(version string) int {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 || parts[0] != "1" {
+		return 17
+	}
+	// strip suffixes like 1.20.1-47.1.0 of modded editions
+	minorPart, _, _ := strings.Cut(parts[1], "-")
+	minor, err := strconv.Atoi(minorPart)
+	if err != nil {
+		return 17
+	}
+	patch := 0
+	if len(parts) == 3 {
+		patchPart, _, _ := strings.Cut(parts[2], "-")
+		patch, _ = strconv.Atoi(patchPart)
+	}
+	switch {
+	case minor > 20 || (minor == 20 && patch >= 5):
+		return 21
+	case minor >= 18:
+		return 17
+	case minor == 17:
+		return 16
+	default:
+		return 8
+	}
+}
@@ -0,0 +1,211 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeResource() *MinecraftResource {
+	return &MinecraftResource{
+		Metadata: Metadata{Name: "minecraft-server"},
+		Spec: Spec{
+			Server: Server{
+				Cloud:  ProviderHetzner,
+				Region: "nbg1",
+				Size:   "cx21",
+				SSH:    SSH{PublicKeyFile: "/home/user/.ssh/id_rsa.pub"},
+			},
+			Minecraft: Minecraft{
+				Edition: "java",
+				Version: "1.20.4",
+				Eula:    true,
+			},
+		},
+	}
+}
+
+func TestDefault(t *testing.T) {
+	m := makeResource()
+	m.Spec.Minecraft.Java.Rcon = Rcon{Enabled: true, Password: "secret"}
+	m.Default()
+	require.NoError(t, m.Validate())
+	assert.Equal(t, APIVersion, m.APIVersion)
+	assert.Equal(t, KindMinecraftServer, m.Kind)
+	assert.Equal(t, 25565, m.GetPort())
+	assert.Equal(t, 22, m.GetSSHPort())
+	assert.Equal(t, 25575, m.GetRCONPort())
+	assert.Equal(t, 17, m.GetJDKVersion())
+	assert.Equal(t, "2G", m.Spec.Minecraft.Java.Xms)
+
+	bedrock := makeResource()
+	bedrock.Spec.Minecraft.Edition = "bedrock"
+	bedrock.Default()
+	require.NoError(t, bedrock.Validate())
+	assert.Equal(t, 19132, bedrock.GetPort())
+	assert.Zero(t, bedrock.GetJDKVersion())
+
+	proxy := makeResource()
+	proxy.Spec.Minecraft = Minecraft{}
+	proxy.Spec.Proxy = Proxy{Type: "velocity", Version: "3.3.0"}
+	proxy.Default()
+	require.NoError(t, proxy.Validate())
+	assert.Equal(t, KindMinecraftProxy, proxy.Kind)
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(m *MinecraftResource)
+		paths  []string
+	}{
+		{
+			name: "invalid name",
+			modify: func(m *MinecraftResource) {
+				m.Metadata.Name = "Minecraft_Server"
+			},
+			paths: []string{"metadata.name"},
+		},
+		{
+			name: "unknown edition",
+			modify: func(m *MinecraftResource) {
+				m.Spec.Minecraft.Edition = "vanilla"
+			},
+			paths: []string{"spec.minecraft.edition"},
+		},
+		{
+			name: "rcon without password",
+			modify: func(m *MinecraftResource) {
+				m.Spec.Minecraft.Java.Rcon = Rcon{Enabled: true, Port: 25575}
+			},
+			paths: []string{"spec.minecraft.java.rcon.password"},
+		},
+		{
+			name: "unknown volume reclaim policy",
+			modify: func(m *MinecraftResource) {
+				m.Spec.Server.VolumeReclaimPolicy = "keep"
+			},
+			paths: []string{"spec.server.volumeReclaimPolicy"},
+		},
+		{
+			name: "modpack without mod loader",
+			modify: func(m *MinecraftResource) {
+				m.Spec.Minecraft.Modpack.Source = "https://cdn.modrinth.com/data/pack.mrpack"
+			},
+			paths: []string{"spec.minecraft.modpack.source"},
+		},
+		{
+			name: "plugins",
+			modify: func(m *MinecraftResource) {
+				m.Spec.Minecraft.Edition = "papermc"
+				m.Spec.Minecraft.Plugins = []Plugin{
+					{Hangar: "ViaVersion", Version: "4.9.2"},
+					{Modrinth: "luckperms", Version: "v5.4.102-bukkit", Name: "LuckPerms.jar"},
+					{URL: "https://example.com/plugin.jar", Checksum: "sha256:" + strings.Repeat("a", 64)},
+					{File: "plugins/local.jar", Name: "LuckPerms.jar"},
+					{URL: "https://example.com/other.jar", Spigot: "1234"},
+					{Spigot: "1234", Version: "1.0"},
+					{Modrinth: "worldedit", Checksum: "md5:abc"},
+				}
+				m.Spec.Minecraft.Mods = []Plugin{{Modrinth: "fabric-api", Version: "0.92.0"}}
+			},
+			paths: []string{
+				"spec.minecraft.mods",
+				"spec.minecraft.plugins[3].name",
+				"spec.minecraft.plugins[4]",
+				"spec.minecraft.plugins[5].checksum",
+				"spec.minecraft.plugins[6].version", "spec.minecraft.plugins[6].checksum",
+			},
+		},
+		{
+			name: "all errors are reported",
+			modify: func(m *MinecraftResource) {
+				m.APIVersion = "v1"
+				m.Spec.Server.SSH = SSH{Port: 70000}
+				m.Spec.Minecraft.Java.Xmx = "lots"
+				m.Spec.Minecraft.Eula = false
+			},
+			paths: []string{
+				"apiVersion", "spec.server.ssh.port", "spec.server.ssh",
+				"spec.minecraft.eula", "spec.minecraft.java.xmx",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := makeResource()
+			m.Default()
+			tt.modify(m)
+			err := m.Validate()
+			var validationErr ValidationError
+			require.True(t, errors.As(err, &validationErr), "expected ValidationError, got %v", err)
+			var paths []string
+			for _, fieldErr := range validationErr {
+				paths = append(paths, fieldErr.Path)
+			}
+			assert.Equal(t, tt.paths, paths)
+		})
+	}
+}
+
+func TestOpenJDKForVersion(t *testing.T) {
+	tests := map[string]int{
+		"1.16.5":        8,
+		"1.17.1":        16,
+		"1.18":          17,
+		"1.20.1-47.1.0": 17,
+		"1.20.4":        17,
+		"1.20.5":        21,
+		"1.21.1":        21,
+		"latest":        17,
+	}
+	for version, want := range tests {
+		assert.Equal(t, want, OpenJDKForVersion(version), version)
+	}
+}
+
+func TestMinecraftVersion(t *testing.T) {
+	tests := []struct {
+		edition string
+		version string
+		want    string
+	}{
+		{"java", "1.20.4", "1.20.4"},
+		{"forge", "1.20.1-47.1.0", "1.20.1"},
+		{"neoforge", "20.4.237", "1.20.4"},
+		{"neoforge", "20.2.88", "1.20.2"},
+		{"neoforge", "21.0.167", "1.21"},
+		{"neoforge", "20.4.80-beta", "1.20.4"},
+		{"neoforge", "latest", "latest"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, MinecraftVersion(tt.edition, tt.version), tt.edition+" "+tt.version)
+	}
+}
+
+func TestJSONSchema(t *testing.T) {
+	var schema struct {
+		Defs map[string]struct {
+			Properties map[string]struct {
+				Enum        []string `json:"enum"`
+				Description string   `json:"description"`
+				Properties  map[string]struct {
+					Description string `json:"description"`
+				} `json:"properties"`
+			} `json:"properties"`
+		} `json:"$defs"`
+	}
+	require.NoError(t, json.Unmarshal(JSONSchema, &schema))
+	assert.Equal(t, ServerEditions, schema.Defs["minecraft"].Properties["edition"].Enum)
+	assert.Equal(t, ProxyTypes, schema.Defs["proxy"].Properties["type"].Enum)
+	assert.Equal(t, []string{VolumeReclaimDelete, VolumeReclaimRetain}, schema.Defs["server"].Properties["volumeReclaimPolicy"].Enum)
+	minecraft := schema.Defs["minecraft"].Properties
+	for _, edition := range ModEditions {
+		assert.Contains(t, minecraft["mods"].Description, edition)
+		assert.Contains(t, minecraft["modpack"].Properties["source"].Description, edition)
+	}
+}
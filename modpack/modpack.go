@@ -0,0 +1,455 @@
+// Package modpack reads Modrinth modpacks (.mrpack files), to install them on
+// the servers of the modded editions.
+//
+// A modpack is a zip archive with a modrinth.index.json that lists the files
+// to download, with their hashes, and the overrides/ and server-overrides/
+// directories that are copied into the server directory as they are.
+package modpack
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dirien/minectl-sdk/common"
+	"github.com/dirien/minectl-sdk/model"
+)
+
+const (
+	// IndexFile is the name of the index in the modpack.
+	IndexFile = "modrinth.index.json"
+	// OverridesDir and ServerOverridesDir hold the files that are copied
+	// into the server directory. Server overrides are applied last.
+	OverridesDir       = "overrides"
+	ServerOverridesDir = "server-overrides"
+	// MaxEmbeddedOverrides limits the base64 encoded overrides of a local
+	// modpack, which are embedded in the user data of the server. It leaves
+	// room for the rest of the user data below the smallest limit of the
+	// providers, 16KB on AWS.
+	MaxEmbeddedOverrides = 4 << 10
+	// MaxSize limits the size of a downloaded modpack.
+	MaxSize = 512 << 20
+
+	formatVersion   = 1
+	game            = "minecraft"
+	maxIndexSize    = 16 << 20
+	envUnsupported  = "unsupported"
+	minecraftDepKey = "minecraft"
+)
+
+var (
+	// pathRegex limits the paths of files to characters that are safe in
+	// quoted shell arguments and cloud-init YAML.
+	pathRegex    = regexp.MustCompile(`^[A-Za-z0-9 ._+\-/()\[\],!@&=~%]+$`)
+	versionRegex = regexp.MustCompile(`^[A-Za-z0-9._+\-]+$`)
+	sha1Regex    = regexp.MustCompile(`^[0-9a-f]{40}$`)
+	sha512Regex  = regexp.MustCompile(`^[0-9a-f]{128}$`)
+
+	// httpClient downloads modpacks.
+	httpClient = http.DefaultClient
+
+	// cache keeps the packs downloaded by Open, so rendering the templates
+	// of every update does not download the pack again.
+	cache = &packCache{entries: map[string]cachedPack{}}
+)
+
+// packCache maps the URL of a pack to the pack and the validators of the
+// response it was read from.
+type packCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedPack
+}
+
+type cachedPack struct {
+	pack         *Pack
+	etag         string
+	lastModified string
+}
+
+func (c *packCache) get(source string) (cachedPack, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[source]
+	return entry, ok
+}
+
+func (c *packCache) put(source string, entry cachedPack) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[source] = entry
+}
+
+// Index is the modrinth.index.json of a modpack.
+type Index struct {
+	FormatVersion int               `json:"formatVersion"` //nolint:tagliatelle // Modrinth format
+	Game          string            `json:"game"`
+	VersionID     string            `json:"versionId"` //nolint:tagliatelle // Modrinth format
+	Name          string            `json:"name"`
+	Summary       string            `json:"summary"`
+	Files         []File            `json:"files"`
+	Dependencies  map[string]string `json:"dependencies"`
+}
+
+// File is a file of the modpack that is downloaded.
+type File struct {
+	// Path is relative to the server directory.
+	Path   string `json:"path"`
+	Hashes Hashes `json:"hashes"`
+	// Env is nil if the file is needed on clients and servers.
+	Env       *Env     `json:"env"`
+	Downloads []string `json:"downloads"`
+	FileSize  int64    `json:"fileSize"` //nolint:tagliatelle // Modrinth format
+}
+
+// Hashes are the hex encoded hashes of a file.
+type Hashes struct {
+	SHA1   string `json:"sha1"`
+	SHA512 string `json:"sha512"`
+}
+
+// Env tells whether a file is "required", "optional" or "unsupported" on
+// clients and servers.
+type Env struct {
+	Client string `json:"client"`
+	Server string `json:"server"`
+}
+
+// Pack is a modpack as it is installed on a server.
+type Pack struct {
+	Name      string
+	VersionID string
+	// Dependencies are the versions of Minecraft and the mod loader.
+	Dependencies map[string]string
+	// Files are the files of the index the server needs, without the ones
+	// that are unsupported on servers.
+	Files []File
+	// Overrides and ServerOverrides tell whether the pack has the
+	// directories.
+	Overrides       bool
+	ServerOverrides bool
+	// URL is where the server downloads the pack to apply the overrides. It
+	// is empty for local packs.
+	URL string
+	// SHA512 is the hex encoded hash of the .mrpack file.
+	SHA512 string
+	// Archive is a gzipped tar of the overrides of a local pack, with the
+	// server overrides applied. The server cannot download a local pack, so
+	// the templates embed it.
+	Archive []byte
+}
+
+// Open reads the modpack at source, an https URL or a local path. Packs
+// downloaded before are only downloaded again if the server reports that
+// they changed, through their ETag or Last-Modified header.
+func Open(ctx context.Context, source string) (*Pack, error) {
+	if strings.HasPrefix(source, "https://") || strings.HasPrefix(source, "http://") {
+		return openURL(ctx, source)
+	}
+	return openFile(source)
+}
+
+func openURL(ctx context.Context, source string) (*Pack, error) {
+	if err := common.ValidateDownloadURL(source); err != nil {
+		return nil, fmt.Errorf("modpack: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	cached, ok := cache.get(source)
+	if ok {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if ok && resp.StatusCode == http.StatusNotModified {
+		pack := *cached.pack
+		return &pack, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("modpack: downloading %s: %s", source, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxSize {
+		return nil, fmt.Errorf("modpack: %s is larger than %d bytes", source, MaxSize)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("modpack: reading %s: %w", source, err)
+	}
+	pack, err := read(zr)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha512.Sum512(data)
+	pack.URL = source
+	pack.SHA512 = hex.EncodeToString(sum[:])
+	if etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"); etag != "" || lastModified != "" {
+		cached := *pack
+		cache.put(source, cachedPack{pack: &cached, etag: etag, lastModified: lastModified})
+	}
+	return pack, nil
+}
+
+func openFile(name string) (*Pack, error) {
+	data, err := os.ReadFile(name) //nolint:gosec // the path is given by the user
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("modpack: reading %s: %w", name, err)
+	}
+	pack, err := read(zr)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha512.Sum512(data)
+	pack.SHA512 = hex.EncodeToString(sum[:])
+	if pack.Overrides || pack.ServerOverrides {
+		if pack.Archive, err = archiveOverrides(zr); err != nil {
+			return nil, err
+		}
+		if size := base64.StdEncoding.EncodedLen(len(pack.Archive)); size > MaxEmbeddedOverrides {
+			return nil, fmt.Errorf("modpack: the overrides of %s are too large to embed (%d bytes encoded, at most %d), use an https URL as source", name, size, MaxEmbeddedOverrides)
+		}
+	}
+	return pack, nil
+}
+
+// Read reads the modpack from r, which has size bytes. It leaves URL, SHA512
+// and Archive empty.
+func Read(r io.ReaderAt, size int64) (*Pack, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("modpack: %w", err)
+	}
+	return read(zr)
+}
+
+func read(zr *zip.Reader) (*Pack, error) {
+	index, err := readIndex(zr)
+	if err != nil {
+		return nil, err
+	}
+	pack := &Pack{
+		Name:         index.Name,
+		VersionID:    index.VersionID,
+		Dependencies: index.Dependencies,
+	}
+	for _, file := range index.Files {
+		if file.Env != nil && file.Env.Server == envUnsupported {
+			continue
+		}
+		if err := file.validate(); err != nil {
+			return nil, err
+		}
+		pack.Files = append(pack.Files, file)
+	}
+	for _, f := range zr.File {
+		switch {
+		case strings.HasPrefix(f.Name, OverridesDir+"/"):
+			pack.Overrides = true
+		case strings.HasPrefix(f.Name, ServerOverridesDir+"/"):
+			pack.ServerOverrides = true
+		}
+	}
+	return pack, nil
+}
+
+func readIndex(zr *zip.Reader) (*Index, error) {
+	f, err := zr.Open(IndexFile)
+	if err != nil {
+		return nil, fmt.Errorf("modpack: %s not found: %w", IndexFile, err)
+	}
+	defer func() { _ = f.Close() }()
+	data, err := io.ReadAll(io.LimitReader(f, maxIndexSize))
+	if err != nil {
+		return nil, err
+	}
+	var index Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("modpack: invalid %s: %w", IndexFile, err)
+	}
+	if index.FormatVersion != formatVersion {
+		return nil, fmt.Errorf("modpack: unsupported format version %d", index.FormatVersion)
+	}
+	if index.Game != game {
+		return nil, fmt.Errorf("modpack: unsupported game %q", index.Game)
+	}
+	if index.Dependencies[minecraftDepKey] == "" {
+		return nil, errors.New("modpack: the index has no minecraft dependency")
+	}
+	for dependency, version := range index.Dependencies {
+		if !versionRegex.MatchString(version) {
+			return nil, fmt.Errorf("modpack: invalid version %q of %s", version, dependency)
+		}
+	}
+	return &index, nil
+}
+
+func (f *File) validate() error {
+	if !validPath(f.Path) {
+		return fmt.Errorf("modpack: invalid path %q", f.Path)
+	}
+	if !sha1Regex.MatchString(f.Hashes.SHA1) || !sha512Regex.MatchString(f.Hashes.SHA512) {
+		return fmt.Errorf("modpack: %s needs sha1 and sha512 hashes", f.Path)
+	}
+	if len(f.Downloads) == 0 {
+		return fmt.Errorf("modpack: %s has no downloads", f.Path)
+	}
+	for _, download := range f.Downloads {
+		if err := common.ValidateDownloadURL(download); err != nil {
+			return fmt.Errorf("modpack: %s: %w", f.Path, err)
+		}
+	}
+	return nil
+}
+
+// validPath reports whether p is a clean relative path inside the server
+// directory.
+func validPath(p string) bool {
+	return pathRegex.MatchString(p) && !path.IsAbs(p) && path.Clean(p) == p &&
+		p != ".." && !strings.HasPrefix(p, "../")
+}
+
+// Check returns an error if the pack does not fit a server of edition and
+// version, as the mod loader or Minecraft version differ.
+func (p *Pack) Check(edition, version string) error {
+	dependency := loaderDependency(edition)
+	if dependency == "" {
+		return fmt.Errorf("modpack: edition %s has no mod loader", edition)
+	}
+	loader := p.Dependencies[dependency]
+	if loader == "" {
+		return fmt.Errorf("modpack: %s needs one of the mod loaders %s, not %s", p.Name, p.loaders(), edition)
+	}
+	switch edition {
+	case "forge":
+		// forge versions are <minecraft>-<forge>
+		if _, forge, _ := strings.Cut(version, "-"); forge != loader {
+			return fmt.Errorf("modpack: %s needs forge %s, got %s", p.Name, loader, forge)
+		}
+	case "neoforge":
+		if version != loader {
+			return fmt.Errorf("modpack: %s needs neoforge %s, got %s", p.Name, loader, version)
+		}
+	}
+	minecraft := model.MinecraftVersion(edition, version)
+	if want := p.Dependencies[minecraftDepKey]; want != minecraft {
+		return fmt.Errorf("modpack: %s needs minecraft %s, got %s", p.Name, want, minecraft)
+	}
+	return nil
+}
+
+// Loader returns the version of the mod loader of edition the pack needs.
+func (p *Pack) Loader(edition string) string {
+	return p.Dependencies[loaderDependency(edition)]
+}
+
+// loaderDependency returns the dependency of the index naming the mod
+// loader of edition, or "" if the edition has none.
+func loaderDependency(edition string) string {
+	e, _ := model.LookupEdition(edition)
+	return e.ModLoader
+}
+
+func (p *Pack) loaders() string {
+	var loaders []string
+	for _, edition := range model.ModEditions {
+		if p.Dependencies[loaderDependency(edition)] != "" {
+			loaders = append(loaders, edition)
+		}
+	}
+	if len(loaders) == 0 {
+		return "none"
+	}
+	sort.Strings(loaders)
+	return strings.Join(loaders, ", ")
+}
+
+// DownloadsOverrides reports whether the server downloads the pack from URL
+// to apply the overrides.
+func (p *Pack) DownloadsOverrides() bool {
+	return p.URL != "" && (p.Overrides || p.ServerOverrides)
+}
+
+// ArchiveBase64 returns Archive base64 encoded, for the templates.
+func (p *Pack) ArchiveBase64() string {
+	return base64.StdEncoding.EncodeToString(p.Archive)
+}
+
+// archiveOverrides writes the overrides and then the server overrides of the
+// pack into a gzipped tar, so that extracting it applies them in order.
+func archiveOverrides(zr *zip.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, dir := range []string{OverridesDir, ServerOverridesDir} {
+		for _, f := range zr.File {
+			name, ok := strings.CutPrefix(f.Name, dir+"/")
+			if !ok || name == "" || f.FileInfo().IsDir() {
+				continue
+			}
+			if !validPath(name) {
+				return nil, fmt.Errorf("modpack: invalid path %q", f.Name)
+			}
+			if err := addFile(tw, name, f); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func addFile(tw *tar.Writer, name string, f *zip.File) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rc.Close() }()
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0o644,
+		Size:    int64(f.UncompressedSize64), //nolint:gosec // checked by the zip reader
+		ModTime: f.Modified,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.CopyN(tw, rc, header.Size)
+	return err
+}
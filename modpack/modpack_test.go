@@ -0,0 +1,362 @@
+package modpack
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec // Modrinth lists SHA-1 hashes
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var modJar = []byte("fabric api")
+
+func hashes(content []byte) Hashes {
+	s1 := sha1.Sum(content) //nolint:gosec // Modrinth lists SHA-1 hashes
+	s512 := sha512.Sum512(content)
+	return Hashes{SHA1: hex.EncodeToString(s1[:]), SHA512: hex.EncodeToString(s512[:])}
+}
+
+func makeIndex() Index {
+	return Index{
+		FormatVersion: 1,
+		Game:          "minecraft",
+		VersionID:     "1.0.0",
+		Name:          "minectl pack",
+		Dependencies:  map[string]string{"minecraft": "1.20.4", "fabric-loader": "0.15.7"},
+		Files: []File{
+			{
+				Path:      "mods/fabric-api.jar",
+				Hashes:    hashes(modJar),
+				Env:       &Env{Client: "required", Server: "required"},
+				Downloads: []string{"https://cdn.modrinth.com/data/P7dR8mSH/versions/1/fabric-api.jar"},
+				FileSize:  int64(len(modJar)),
+			},
+			{
+				Path:      "mods/sodium.jar",
+				Hashes:    hashes([]byte("sodium")),
+				Env:       &Env{Client: "required", Server: "unsupported"},
+				Downloads: []string{"https://cdn.modrinth.com/data/AANobbMI/versions/1/sodium.jar"},
+			},
+		},
+	}
+}
+
+// makePack writes a .mrpack with index and the files, keyed by their path in
+// the archive.
+func makePack(t *testing.T, index Index, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	data, err := json.Marshal(index)
+	require.NoError(t, err)
+	names := []string{IndexFile}
+	files[IndexFile] = string(data)
+	for name := range files {
+		if name != IndexFile {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)})
+		require.NoError(t, err)
+		_, err = w.Write([]byte(files[name]))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestRead(t *testing.T) {
+	data := makePack(t, makeIndex(), map[string]string{
+		"server-overrides/server.properties": "motd=modded",
+	})
+	pack, err := Read(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	assert.Equal(t, "minectl pack", pack.Name)
+	assert.Equal(t, "1.0.0", pack.VersionID)
+	require.Len(t, pack.Files, 1)
+	assert.Equal(t, "mods/fabric-api.jar", pack.Files[0].Path)
+	assert.False(t, pack.Overrides)
+	assert.True(t, pack.ServerOverrides)
+	assert.Empty(t, pack.Archive)
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(index *Index)
+		wantErr string
+	}{
+		{
+			name:    "format version",
+			modify:  func(index *Index) { index.FormatVersion = 2 },
+			wantErr: "unsupported format version 2",
+		},
+		{
+			name:    "no minecraft",
+			modify:  func(index *Index) { delete(index.Dependencies, "minecraft") },
+			wantErr: "no minecraft dependency",
+		},
+		{
+			name:    "absolute path",
+			modify:  func(index *Index) { index.Files[0].Path = "/etc/passwd" },
+			wantErr: "invalid path",
+		},
+		{
+			name:    "path outside the server directory",
+			modify:  func(index *Index) { index.Files[0].Path = "../mods/fabric-api.jar" },
+			wantErr: "invalid path",
+		},
+		{
+			name:    "path with quotes",
+			modify:  func(index *Index) { index.Files[0].Path = "mods/it's.jar" },
+			wantErr: "invalid path",
+		},
+		{
+			name:    "missing sha512",
+			modify:  func(index *Index) { index.Files[0].Hashes.SHA512 = "" },
+			wantErr: "needs sha1 and sha512 hashes",
+		},
+		{
+			name:    "plain http download",
+			modify:  func(index *Index) { index.Files[0].Downloads = []string{"http://example.com/mod.jar"} },
+			wantErr: "is not an https URL",
+		},
+		{
+			name: "unsupported files are not checked",
+			modify: func(index *Index) {
+				index.Files[1].Downloads = nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index := makeIndex()
+			tt.modify(&index)
+			data := makePack(t, index, map[string]string{})
+			_, err := Read(bytes.NewReader(data), int64(len(data)))
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	data := makePack(t, makeIndex(), map[string]string{
+		"overrides/config/mod.json":          `{"client": false}`,
+		"overrides/server.properties":        "motd=overrides",
+		"server-overrides/server.properties": "motd=server-overrides",
+	})
+	name := filepath.Join(t.TempDir(), "pack.mrpack")
+	require.NoError(t, os.WriteFile(name, data, 0o600))
+
+	pack, err := Open(context.Background(), name)
+	require.NoError(t, err)
+	assert.Empty(t, pack.URL)
+	sum := sha512.Sum512(data)
+	assert.Equal(t, hex.EncodeToString(sum[:]), pack.SHA512)
+	assert.True(t, pack.Overrides)
+	assert.True(t, pack.ServerOverrides)
+
+	// extracting the archive in order leaves the server overrides
+	extracted := map[string]string{}
+	gz, err := gzip.NewReader(bytes.NewReader(pack.Archive))
+	require.NoError(t, err)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		content, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		extracted[header.Name] = string(content)
+	}
+	assert.Equal(t, map[string]string{
+		"config/mod.json":   `{"client": false}`,
+		"server.properties": "motd=server-overrides",
+	}, extracted)
+
+	_, err = Open(context.Background(), filepath.Join(t.TempDir(), "missing.mrpack"))
+	require.Error(t, err)
+}
+
+func TestOpenFileTooLarge(t *testing.T) {
+	// random data does not compress
+	random := make([]byte, MaxEmbeddedOverrides)
+	_, err := rand.Read(random)
+	require.NoError(t, err)
+	data := makePack(t, makeIndex(), map[string]string{"overrides/world.dat": string(random)})
+	name := filepath.Join(t.TempDir(), "pack.mrpack")
+	require.NoError(t, os.WriteFile(name, data, 0o600))
+
+	_, err = Open(context.Background(), name)
+	require.ErrorContains(t, err, "too large to embed")
+}
+
+func TestOpenURL(t *testing.T) {
+	data := makePack(t, makeIndex(), map[string]string{"overrides/config/mod.json": "{}"})
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/pack.mrpack" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+	client := httpClient
+	httpClient = server.Client()
+	t.Cleanup(func() { httpClient = client })
+
+	pack, err := Open(context.Background(), server.URL+"/pack.mrpack")
+	require.NoError(t, err)
+	assert.Equal(t, server.URL+"/pack.mrpack", pack.URL)
+	sum := sha512.Sum512(data)
+	assert.Equal(t, hex.EncodeToString(sum[:]), pack.SHA512)
+	assert.True(t, pack.Overrides)
+	// the server downloads the pack for the overrides
+	assert.Empty(t, pack.Archive)
+
+	_, err = Open(context.Background(), server.URL+"/missing.mrpack")
+	require.ErrorContains(t, err, "404")
+	_, err = Open(context.Background(), "http://example.com/pack.mrpack")
+	require.ErrorContains(t, err, "is not an https URL")
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name         string
+		dependencies map[string]string
+		edition      string
+		version      string
+		wantErr      string
+	}{
+		{
+			name:         "fabric",
+			dependencies: map[string]string{"minecraft": "1.20.4", "fabric-loader": "0.15.7"},
+			edition:      "fabric",
+			version:      "1.20.4",
+		},
+		{
+			name:         "forge",
+			dependencies: map[string]string{"minecraft": "1.20.1", "forge": "47.2.0"},
+			edition:      "forge",
+			version:      "1.20.1-47.2.0",
+		},
+		{
+			name:         "quilt",
+			dependencies: map[string]string{"minecraft": "1.20.4", "quilt-loader": "0.26.0"},
+			edition:      "quilt",
+			version:      "1.20.4",
+		},
+		{
+			name:         "neoforge",
+			dependencies: map[string]string{"minecraft": "1.20.4", "neoforge": "20.4.237"},
+			edition:      "neoforge",
+			version:      "20.4.237",
+		},
+		{
+			name:         "other minecraft version",
+			dependencies: map[string]string{"minecraft": "1.20.4", "fabric-loader": "0.15.7"},
+			edition:      "fabric",
+			version:      "1.20.2",
+			wantErr:      "needs minecraft 1.20.4, got 1.20.2",
+		},
+		{
+			name:         "other forge version",
+			dependencies: map[string]string{"minecraft": "1.20.1", "forge": "47.2.0"},
+			edition:      "forge",
+			version:      "1.20.1-47.1.0",
+			wantErr:      "needs forge 47.2.0, got 47.1.0",
+		},
+		{
+			name:         "other neoforge version",
+			dependencies: map[string]string{"minecraft": "1.20.4", "neoforge": "20.4.237"},
+			edition:      "neoforge",
+			version:      "20.4.190",
+			wantErr:      "needs neoforge 20.4.237, got 20.4.190",
+		},
+		{
+			name:         "other mod loader",
+			dependencies: map[string]string{"minecraft": "1.20.1", "forge": "47.2.0"},
+			edition:      "fabric",
+			version:      "1.20.1",
+			wantErr:      "needs one of the mod loaders forge, not fabric",
+		},
+		{
+			name:         "no mod loader",
+			dependencies: map[string]string{"minecraft": "1.20.4"},
+			edition:      "papermc",
+			version:      "1.20.4",
+			wantErr:      "edition papermc has no mod loader",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pack := &Pack{Name: "pack", Dependencies: tt.dependencies}
+			err := pack.Check(tt.edition, tt.version)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestOpenURLCached(t *testing.T) {
+	data := makePack(t, makeIndex(), map[string]string{"overrides/config/mod.json": "{}"})
+	var downloads int
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := `"v1"`
+		if r.URL.Path == "/changed.mrpack" {
+			etag = fmt.Sprintf(`"v%d"`, downloads)
+		}
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads++
+		w.Header().Set("ETag", etag)
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+	client := httpClient
+	httpClient = server.Client()
+	t.Cleanup(func() { httpClient = client })
+	ctx := context.Background()
+
+	first, err := Open(ctx, server.URL+"/pack.mrpack")
+	require.NoError(t, err)
+	second, err := Open(ctx, server.URL+"/pack.mrpack")
+	require.NoError(t, err)
+	assert.Equal(t, 1, downloads)
+	assert.Equal(t, first, second)
+	assert.NotSame(t, first, second)
+
+	_, err = Open(ctx, server.URL+"/changed.mrpack")
+	require.NoError(t, err)
+	_, err = Open(ctx, server.URL+"/changed.mrpack")
+	require.NoError(t, err)
+	assert.Equal(t, 3, downloads)
+}
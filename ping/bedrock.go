@@ -0,0 +1,145 @@
+package ping
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Packet ids of the RakNet unconnected ping.
+const (
+	raknetUnconnectedPing = 0x01
+	raknetUnconnectedPong = 0x1c
+)
+
+// raknetMagic marks offline RakNet messages.
+var raknetMagic = []byte{0x00, 0xff, 0xff, 0x00, 0xfe, 0xfe, 0xfe, 0xfe, 0xfd, 0xfd, 0xfd, 0xfd, 0x12, 0x34, 0x56, 0x78}
+
+// errNotOurPong is returned by parsePong for packets that do not answer the
+// ping.
+var errNotOurPong = errors.New("ping: not an answer to the unconnected ping")
+
+// bedrockResendInterval is the time after which an unanswered ping is sent
+// again, as UDP packets may get lost.
+var bedrockResendInterval = time.Second
+
+// Bedrock asks the Bedrock Edition server at address (host:port) for its
+// status with a RakNet unconnected ping. Nukkit and PowerNukkit answer it as
+// well.
+func Bedrock(ctx context.Context, address string) (*Status, error) {
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "udp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(DefaultTimeout)
+	}
+	// close the connection if ctx is canceled before the deadline
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	status, err := bedrockPing(conn, deadline)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return status, nil
+}
+
+func bedrockPing(conn net.Conn, deadline time.Time) (*Status, error) {
+	var guid [8]byte
+	if _, err := rand.Read(guid[:]); err != nil {
+		return nil, err
+	}
+	token := uint64(time.Now().UnixMilli()) //nolint:gosec // the token is opaque
+	ping := []byte{raknetUnconnectedPing}
+	ping = binary.BigEndian.AppendUint64(ping, token)
+	ping = append(ping, raknetMagic...)
+	ping = append(ping, guid[:]...)
+
+	buf := make([]byte, 1500)
+	for {
+		sent := time.Now()
+		if _, err := conn.Write(ping); err != nil {
+			return nil, err
+		}
+		readDeadline := sent.Add(bedrockResendInterval)
+		if readDeadline.After(deadline) {
+			readDeadline = deadline
+		}
+		if err := conn.SetReadDeadline(readDeadline); err != nil {
+			return nil, err
+		}
+		for {
+			n, err := conn.Read(buf)
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() && time.Now().Before(deadline) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			status, err := parsePong(buf[:n], token)
+			if errors.Is(err, errNotOurPong) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			status.Latency = time.Since(sent)
+			return status, nil
+		}
+	}
+}
+
+// parsePong parses an unconnected pong. Its server id string is
+// "edition;motd;protocol;version;online;max;server id;level name;game mode;
+// game mode number;port;port v6;".
+func parsePong(packet []byte, token uint64) (*Status, error) {
+	// id, time, server guid, magic and the string length
+	const header = 1 + 8 + 8 + 16 + 2
+	if len(packet) < header || packet[0] != raknetUnconnectedPong ||
+		binary.BigEndian.Uint64(packet[1:9]) != token || !bytes.Equal(packet[17:33], raknetMagic) {
+		return nil, errNotOurPong
+	}
+	size := int(binary.BigEndian.Uint16(packet[33:35]))
+	if len(packet) < header+size {
+		return nil, errors.New("ping: truncated unconnected pong")
+	}
+	fields := strings.Split(string(packet[header:header+size]), ";")
+	if len(fields) < 6 {
+		return nil, fmt.Errorf("ping: invalid server id %q", packet[header:header+size])
+	}
+	// fields after the player counts are optional
+	fields = append(fields, make([]string, 12-min(len(fields), 12))...)
+	status := &Status{
+		Edition:   fields[0],
+		MOTD:      fields[1],
+		Version:   fields[3],
+		ServerID:  fields[6],
+		LevelName: fields[7],
+		GameMode:  fields[8],
+	}
+	var err error
+	if status.Protocol, err = strconv.Atoi(fields[2]); err != nil {
+		return nil, fmt.Errorf("ping: invalid protocol %q", fields[2])
+	}
+	if status.OnlinePlayers, err = strconv.Atoi(fields[4]); err != nil {
+		return nil, fmt.Errorf("ping: invalid player count %q", fields[4])
+	}
+	if status.MaxPlayers, err = strconv.Atoi(fields[5]); err != nil {
+		return nil, fmt.Errorf("ping: invalid player count %q", fields[5])
+	}
+	status.Port, _ = strconv.Atoi(fields[10])
+	status.PortV6, _ = strconv.Atoi(fields[11])
+	return status, nil
+}
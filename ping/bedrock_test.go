@@ -0,0 +1,172 @@
+package ping
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dirien/minectl-sdk/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBedrockServer answers unconnected pings like a Bedrock server.
+type fakeBedrockServer struct {
+	conn     net.PacketConn
+	serverID string
+
+	mu sync.Mutex
+	// drop ignores this many pings before answering
+	drop  int
+	pings int
+}
+
+func newFakeBedrockServer(t *testing.T, serverID string) *fakeBedrockServer {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &fakeBedrockServer{conn: conn, serverID: serverID}
+	t.Cleanup(func() { _ = conn.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *fakeBedrockServer) address() string {
+	return s.conn.LocalAddr().String()
+}
+
+func (s *fakeBedrockServer) received() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pings
+}
+
+func (s *fakeBedrockServer) serve() {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		ping := buf[:n]
+		if n != 33 || ping[0] != raknetUnconnectedPing || !bytes.Equal(ping[9:25], raknetMagic) {
+			continue
+		}
+		s.mu.Lock()
+		s.pings++
+		drop := s.drop > 0
+		s.drop--
+		s.mu.Unlock()
+		if drop {
+			continue
+		}
+		// another packet first, which the client must skip
+		_, _ = s.conn.WriteTo([]byte{0x00}, addr)
+		pong := []byte{raknetUnconnectedPong}
+		pong = append(pong, ping[1:9]...)
+		pong = binary.BigEndian.AppendUint64(pong, 42)
+		pong = append(pong, raknetMagic...)
+		pong = binary.BigEndian.AppendUint16(pong, uint16(len(s.serverID))) //nolint:gosec // test data
+		pong = append(pong, s.serverID...)
+		_, _ = s.conn.WriteTo(pong, addr)
+	}
+}
+
+func TestBedrock(t *testing.T) {
+	tests := []struct {
+		name     string
+		serverID string
+		want     Status
+		wantErr  string
+	}{
+		{
+			name:     "bedrock",
+			serverID: "MCPE;Dedicated Server;671;1.20.80;1;10;13253860892328930865;Bedrock level;Survival;1;19132;19133;",
+			want: Status{
+				Edition:       "MCPE",
+				MOTD:          "Dedicated Server",
+				Protocol:      671,
+				Version:       "1.20.80",
+				OnlinePlayers: 1,
+				MaxPlayers:    10,
+				ServerID:      "13253860892328930865",
+				LevelName:     "Bedrock level",
+				GameMode:      "Survival",
+				Port:          19132,
+				PortV6:        19133,
+			},
+		},
+		{
+			name:     "nukkit without ports",
+			serverID: "MCPE;A Nukkit Server;649;1.20.60;0;20;2861420226;Nukkit;Survival",
+			want: Status{
+				Edition:    "MCPE",
+				MOTD:       "A Nukkit Server",
+				Protocol:   649,
+				Version:    "1.20.60",
+				MaxPlayers: 20,
+				ServerID:   "2861420226",
+				LevelName:  "Nukkit",
+				GameMode:   "Survival",
+			},
+		},
+		{
+			name:     "invalid player count",
+			serverID: "MCPE;motd;671;1.20.80;many;10",
+			wantErr:  "invalid player count",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFakeBedrockServer(t, tt.serverID)
+			status, err := Bedrock(context.Background(), s.address())
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Positive(t, status.Latency)
+			status.Latency = 0
+			assert.Equal(t, tt.want, *status)
+		})
+	}
+}
+
+func TestBedrockResends(t *testing.T) {
+	interval := bedrockResendInterval
+	bedrockResendInterval = 10 * time.Millisecond
+	t.Cleanup(func() { bedrockResendInterval = interval })
+
+	s := newFakeBedrockServer(t, "MCPE;motd;671;1.20.80;0;10;1;level;Creative;1;19132;19133;")
+	s.mu.Lock()
+	s.drop = 2
+	s.mu.Unlock()
+	status, err := Bedrock(context.Background(), s.address())
+	require.NoError(t, err)
+	assert.Equal(t, "Creative", status.GameMode)
+	assert.Equal(t, 3, s.received())
+
+	s.mu.Lock()
+	s.drop = 1000
+	s.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = Bedrock(ctx, s.address())
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestWaitForReadyBedrock(t *testing.T) {
+	s := newFakeBedrockServer(t, "MCPE;Dedicated Server;671;1.20.80;0;10;1;level;Survival;1;19132;19133;")
+	host, port, _ := net.SplitHostPort(s.address())
+	resource := &model.MinecraftResource{Spec: model.Spec{Minecraft: model.Minecraft{Edition: "bedrock"}}}
+	resource.Spec.Server.Port, _ = strconv.Atoi(port)
+
+	status, err := WaitForReady(context.Background(), host, resource)
+	require.NoError(t, err)
+	assert.Equal(t, "1.20.80", status.Version)
+}
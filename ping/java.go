@@ -0,0 +1,284 @@
+// Package ping asks Minecraft servers for their status the way the server
+// list of the game does, to tell whether a server is up and accepts players.
+package ping
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout is used for dialing and the whole exchange if the context has
+// no deadline.
+const DefaultTimeout = 10 * time.Second
+
+const (
+	// javaMaxPacket limits the packets a server may send. The status JSON is
+	// at most 32767 characters, plus the base64 encoded favicon.
+	javaMaxPacket = 1 << 21
+	// javaUnknownProtocol tells the server that the client does not care
+	// about the protocol version, as it only asks for the status.
+	javaUnknownProtocol = -1
+	// javaStateStatus is the next state of the handshake for a status request.
+	javaStateStatus = 1
+)
+
+// Status is the answer of a Minecraft server to a ping.
+type Status struct {
+	// MOTD is the message of the day as plain text, without formatting.
+	MOTD string
+	// Version is the name of the version, e.g. "1.20.4" or "Paper 1.20.4".
+	Version string
+	// Protocol is the protocol number of the version.
+	Protocol      int
+	OnlinePlayers int
+	MaxPlayers    int
+	// Players are the names of some online players. Servers only send a
+	// sample, and may send none.
+	Players []string
+	// Latency is the round trip time of the ping.
+	Latency time.Duration
+
+	// Edition is "MCPE" for Bedrock servers and "MCEE" for Education Edition
+	// servers. It and the following fields are only sent by Bedrock servers.
+	Edition string
+	// ServerID is the unique id of the server.
+	ServerID  string
+	LevelName string
+	// GameMode is the default game mode, e.g. "Survival".
+	GameMode string
+	// Port and PortV6 are the ports the server listens on for IPv4 and IPv6.
+	Port   int
+	PortV6 int
+}
+
+// javaStatus is the JSON sent by Java servers.
+type javaStatus struct {
+	Version struct {
+		Name     string `json:"name"`
+		Protocol int    `json:"protocol"`
+	} `json:"version"`
+	Players struct {
+		Max    int `json:"max"`
+		Online int `json:"online"`
+		Sample []struct {
+			Name string `json:"name"`
+		} `json:"sample"`
+	} `json:"players"`
+	Description chat `json:"description"`
+}
+
+// chat is a chat component, either a plain string or an object with text
+// and extra components.
+type chat struct {
+	text string
+}
+
+func (c *chat) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		c.text = text
+		return nil
+	}
+	var component struct {
+		Text  string `json:"text"`
+		Extra []chat `json:"extra"`
+	}
+	if err := json.Unmarshal(data, &component); err != nil {
+		return err
+	}
+	var b strings.Builder
+	b.WriteString(component.Text)
+	for _, extra := range component.Extra {
+		b.WriteString(extra.text)
+	}
+	c.text = b.String()
+	return nil
+}
+
+// Java asks the Java Edition server at address (host:port) for its status
+// with the Server List Ping protocol.
+func Java(ctx context.Context, address string) (*Status, error) {
+	host, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("ping: invalid port %q", portString)
+	}
+	dialer := &net.Dialer{Timeout: DefaultTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(DefaultTimeout)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	// close the connection if ctx is canceled before the deadline
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	status, err := javaPing(conn, host, uint16(port)) //nolint:gosec // parsed with 16 bits
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return status, nil
+}
+
+// contextError returns the error of ctx if err happened because ctx is done.
+// The deadline of the connection is the one of ctx, so its timeouts can
+// fire just before ctx reports them.
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	var netErr net.Error
+	if _, ok := ctx.Deadline(); ok && errors.As(err, &netErr) && netErr.Timeout() {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+func javaPing(conn net.Conn, host string, port uint16) (*Status, error) {
+	r := bufio.NewReader(conn)
+
+	var handshake bytes.Buffer
+	writeVarInt(&handshake, javaUnknownProtocol)
+	writeString(&handshake, host)
+	_ = binary.Write(&handshake, binary.BigEndian, port)
+	writeVarInt(&handshake, javaStateStatus)
+	if err := writePacket(conn, 0x00, handshake.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := writePacket(conn, 0x00, nil); err != nil {
+		return nil, err
+	}
+	id, payload, err := readPacket(r)
+	if err != nil {
+		return nil, err
+	}
+	if id != 0x00 {
+		return nil, fmt.Errorf("ping: unexpected packet %#x, want the status response", id)
+	}
+	data, err := readString(bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	var response javaStatus
+	if err := json.Unmarshal([]byte(data), &response); err != nil {
+		return nil, fmt.Errorf("ping: invalid status: %w", err)
+	}
+	status := &Status{
+		MOTD:          response.Description.text,
+		Version:       response.Version.Name,
+		Protocol:      response.Version.Protocol,
+		OnlinePlayers: response.Players.Online,
+		MaxPlayers:    response.Players.Max,
+	}
+	for _, player := range response.Players.Sample {
+		status.Players = append(status.Players, player.Name)
+	}
+
+	start := time.Now()
+	token := start.UnixNano()
+	if err := writePacket(conn, 0x01, binary.BigEndian.AppendUint64(nil, uint64(token))); err != nil { //nolint:gosec // the token is opaque
+		return nil, err
+	}
+	id, payload, err = readPacket(r)
+	if err != nil {
+		return nil, err
+	}
+	if id != 0x01 || len(payload) != 8 || int64(binary.BigEndian.Uint64(payload)) != token { //nolint:gosec // the token is opaque
+		return nil, errors.New("ping: invalid pong")
+	}
+	status.Latency = time.Since(start)
+	return status, nil
+}
+
+func writePacket(w io.Writer, id int32, payload []byte) error {
+	var body bytes.Buffer
+	writeVarInt(&body, id)
+	body.Write(payload)
+	var packet bytes.Buffer
+	writeVarInt(&packet, int32(body.Len())) //nolint:gosec // packets are small
+	packet.Write(body.Bytes())
+	_, err := w.Write(packet.Bytes())
+	return err
+}
+
+func readPacket(r *bufio.Reader) (id int32, payload []byte, err error) {
+	size, err := readVarInt(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if size <= 0 || size > javaMaxPacket {
+		return 0, nil, fmt.Errorf("ping: invalid packet size %d", size)
+	}
+	packet := make([]byte, size)
+	if _, err := io.ReadFull(r, packet); err != nil {
+		return 0, nil, err
+	}
+	body := bytes.NewReader(packet)
+	id, err = readVarInt(body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return id, packet[len(packet)-body.Len():], nil
+}
+
+func writeVarInt(b *bytes.Buffer, value int32) {
+	v := uint32(value) //nolint:gosec // VarInts encode the two's complement
+	for v >= 0x80 {
+		b.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	b.WriteByte(byte(v))
+}
+
+func readVarInt(r io.ByteReader) (int32, error) {
+	var value uint32
+	for i := range 5 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		value |= uint32(b&0x7f) << (7 * i)
+		if b&0x80 == 0 {
+			return int32(value), nil //nolint:gosec // VarInts encode the two's complement
+		}
+	}
+	return 0, errors.New("ping: VarInt is too long")
+}
+
+func writeString(b *bytes.Buffer, s string) {
+	writeVarInt(b, int32(len(s))) //nolint:gosec // strings are short
+	b.WriteString(s)
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	size, err := readVarInt(r)
+	if err != nil {
+		return "", err
+	}
+	if size < 0 || int(size) > r.Len() {
+		return "", fmt.Errorf("ping: invalid string size %d", size)
+	}
+	s := make([]byte, size)
+	_, err = io.ReadFull(r, s)
+	return string(s), err
+}
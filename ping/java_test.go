@@ -0,0 +1,192 @@
+package ping
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dirien/minectl-sdk/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeJavaServer answers the Server List Ping like a Java server.
+type fakeJavaServer struct {
+	listener net.Listener
+	status   string
+
+	mu sync.Mutex
+	// refuse closes this many connections before answering
+	refuse     int
+	handshakes []handshake
+}
+
+type handshake struct {
+	protocol  int32
+	host      string
+	port      uint16
+	nextState int32
+}
+
+func newFakeJavaServer(t *testing.T, status string) *fakeJavaServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &fakeJavaServer{listener: listener, status: status}
+	t.Cleanup(func() { _ = listener.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *fakeJavaServer) address() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeJavaServer) received() []handshake {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]handshake(nil), s.handshakes...)
+}
+
+func (s *fakeJavaServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		refuse := s.refuse > 0
+		s.refuse--
+		s.mu.Unlock()
+		if refuse {
+			_ = conn.Close()
+			continue
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeJavaServer) handle(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	r := bufio.NewReader(conn)
+	id, payload, err := readPacket(r)
+	if err != nil || id != 0x00 {
+		return
+	}
+	body := bytes.NewReader(payload)
+	var h handshake
+	h.protocol, _ = readVarInt(body)
+	h.host, _ = readString(body)
+	_ = binary.Read(body, binary.BigEndian, &h.port)
+	h.nextState, _ = readVarInt(body)
+	s.mu.Lock()
+	s.handshakes = append(s.handshakes, h)
+	s.mu.Unlock()
+
+	if id, _, err := readPacket(r); err != nil || id != 0x00 {
+		return
+	}
+	var response bytes.Buffer
+	writeString(&response, s.status)
+	if err := writePacket(conn, 0x00, response.Bytes()); err != nil {
+		return
+	}
+	id, payload, err = readPacket(r)
+	if err != nil || id != 0x01 {
+		return
+	}
+	_ = writePacket(conn, 0x01, payload)
+}
+
+const paperStatus = `{
+	"version": {"name": "Paper 1.20.4", "protocol": 765},
+	"players": {"max": 20, "online": 2, "sample": [{"name": "Notch", "id": "069a79f4-44e9-4726-a5be-fca90e38aaf5"}, {"name": "jeb_", "id": "853c80ef-3c37-49fd-aa49-938b674adae6"}]},
+	"description": {"text": "A ", "extra": [{"text": "minectl", "bold": true}, {"text": " server", "extra": [{"text": "!"}]}]},
+	"favicon": "data:image/png;base64,AAAA"
+}`
+
+func TestJava(t *testing.T) {
+	tests := []struct {
+		name   string
+		status string
+		want   Status
+	}{
+		{
+			name:   "chat component",
+			status: paperStatus,
+			want: Status{
+				MOTD:          "A minectl server!",
+				Version:       "Paper 1.20.4",
+				Protocol:      765,
+				OnlinePlayers: 2,
+				MaxPlayers:    20,
+				Players:       []string{"Notch", "jeb_"},
+			},
+		},
+		{
+			name:   "plain description",
+			status: `{"version": {"name": "1.8.9", "protocol": 47}, "players": {"max": 10, "online": 0}, "description": "Hello"}`,
+			want:   Status{MOTD: "Hello", Version: "1.8.9", Protocol: 47, MaxPlayers: 10},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFakeJavaServer(t, tt.status)
+			status, err := Java(context.Background(), s.address())
+			require.NoError(t, err)
+			assert.Positive(t, status.Latency)
+			status.Latency = 0
+			assert.Equal(t, tt.want, *status)
+
+			_, port, _ := net.SplitHostPort(s.address())
+			wantPort, _ := strconv.Atoi(port)
+			assert.Equal(t, []handshake{{protocol: -1, host: "127.0.0.1", port: uint16(wantPort), nextState: 1}}, s.received()) //nolint:gosec // test port
+		})
+	}
+}
+
+func TestJavaErrors(t *testing.T) {
+	s := newFakeJavaServer(t, "not json")
+	_, err := Java(context.Background(), s.address())
+	require.ErrorContains(t, err, "invalid status")
+
+	// a listener that never answers
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = Java(ctx, listener.Addr().String())
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestWaitForReady(t *testing.T) {
+	interval := ReadyInterval
+	ReadyInterval = 10 * time.Millisecond
+	t.Cleanup(func() { ReadyInterval = interval })
+
+	s := newFakeJavaServer(t, paperStatus)
+	s.mu.Lock()
+	s.refuse = 2
+	s.mu.Unlock()
+	host, port, _ := net.SplitHostPort(s.address())
+	resource := &model.MinecraftResource{Spec: model.Spec{Minecraft: model.Minecraft{Edition: "papermc"}}}
+	resource.Spec.Server.Port, _ = strconv.Atoi(port)
+
+	status, err := WaitForReady(context.Background(), host, resource)
+	require.NoError(t, err)
+	assert.Equal(t, "Paper 1.20.4", status.Version)
+	assert.Len(t, s.received(), 1)
+
+	require.NoError(t, s.listener.Close())
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = WaitForReady(ctx, host, resource)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
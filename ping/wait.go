@@ -0,0 +1,44 @@
+package ping
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/dirien/minectl-sdk/common"
+	"github.com/dirien/minectl-sdk/model"
+	"go.uber.org/zap"
+)
+
+// ReadyInterval is the time between two pings of WaitForReady.
+var ReadyInterval = 5 * time.Second
+
+// Server pings the Minecraft server of resource on host, with the protocol
+// of its edition.
+func Server(ctx context.Context, host string, resource *model.MinecraftResource) (*Status, error) {
+	address := net.JoinHostPort(host, strconv.Itoa(resource.GetPort()))
+	if model.IsBedrockProtocol(resource.GetEdition()) {
+		return Bedrock(ctx, address)
+	}
+	return Java(ctx, address)
+}
+
+// WaitForReady pings the Minecraft server of resource on host until it
+// answers, and returns its status. Call it after CreateServer, which only
+// waits for the machine. It gives up once ctx is done.
+func WaitForReady(ctx context.Context, host string, resource *model.MinecraftResource) (*Status, error) {
+	for {
+		pingCtx, cancel := context.WithTimeout(ctx, DefaultTimeout)
+		status, err := Server(pingCtx, host, resource)
+		cancel()
+		if err == nil {
+			zap.S().Infow("Minecraft server is ready", "name", resource.GetName(), "version", status.Version)
+			return status, nil
+		}
+		if sleepErr := common.Sleep(ctx, ReadyInterval); sleepErr != nil {
+			return nil, fmt.Errorf("ping: %s is not ready: %w (last error: %v)", resource.GetName(), sleepErr, err)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+// Package plugins resolves the plugins and mods of a MinecraftResource to the
+// jars to install, and tells which jars a server has to add, upgrade and
+// remove to match them.
+package plugins
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/dirien/minectl-sdk/common"
+	"github.com/dirien/minectl-sdk/model"
+)
+
+const (
+	// ManifestFile lists the jars minectl installed, relative to the server
+	// directory and with their checksums. Jars that are not listed are left
+	// alone.
+	ManifestFile = ".minectl-plugins"
+	// PluginsDir and ModsDir are the directories of the jars, relative to the
+	// server directory.
+	PluginsDir = "plugins"
+	ModsDir    = "mods"
+)
+
+var (
+	modrinthAPI = "https://api.modrinth.com/v2"
+	hangarAPI   = "https://hangar.papermc.io/api/v1"
+	spigetAPI   = "https://api.spiget.org/v2"
+	// httpClient calls the APIs of the repositories.
+	httpClient = http.DefaultClient
+
+	nameRegex     = regexp.MustCompile(model.PluginNameRegex)
+	checksumRegex = regexp.MustCompile(model.ChecksumRegex)
+)
+
+// Artifact is a plugin or mod resolved to the jar to install.
+type Artifact struct {
+	// Path is relative to the server directory, e.g. plugins/LuckPerms.jar.
+	Path string
+	// URL downloads the jar. It is empty for local jars.
+	URL string
+	// File is the local path of the jar.
+	File string
+	// Checksum is sha256:<hex> or sha512:<hex>.
+	Checksum string
+}
+
+// Resolve looks up the jars of the plugins and mods of m. Jars of the
+// repositories are verified against the checksums of the spec, if set, and
+// local jars are hashed.
+func Resolve(ctx context.Context, m *model.MinecraftResource) ([]Artifact, error) {
+	var artifacts []Artifact
+	names := map[string]bool{}
+	for _, list := range []struct {
+		dir     string
+		plugins []model.Plugin
+	}{
+		{PluginsDir, m.Spec.Minecraft.Plugins},
+		{ModsDir, m.Spec.Minecraft.Mods},
+	} {
+		for _, plugin := range list.plugins {
+			artifact, err := resolve(ctx, plugin, list.dir, m.GetEdition())
+			if err != nil {
+				return nil, err
+			}
+			if names[artifact.Path] {
+				return nil, fmt.Errorf("plugins: %s is installed twice, set different names", artifact.Path)
+			}
+			names[artifact.Path] = true
+			artifacts = append(artifacts, artifact)
+		}
+	}
+	return artifacts, nil
+}
+
+func resolve(ctx context.Context, plugin model.Plugin, dir, edition string) (Artifact, error) {
+	var name string
+	var artifact Artifact
+	var err error
+	switch {
+	case plugin.URL != "":
+		name = path.Base(plugin.URL)
+		artifact = Artifact{URL: plugin.URL, Checksum: plugin.Checksum}
+	case plugin.Modrinth != "":
+		name, artifact, err = modrinth(ctx, plugin)
+	case plugin.Hangar != "":
+		name, artifact, err = hangar(ctx, plugin, edition)
+	case plugin.Spigot != "":
+		name = fmt.Sprintf("%s-%s.jar", plugin.Spigot, plugin.Version)
+		artifact = Artifact{
+			URL:      fmt.Sprintf("%s/resources/%s/versions/%s/download", spigetAPI, url.PathEscape(plugin.Spigot), url.PathEscape(plugin.Version)),
+			Checksum: plugin.Checksum,
+		}
+	case plugin.File != "":
+		name = filepath.Base(plugin.File)
+		artifact, err = file(plugin)
+	default:
+		return Artifact{}, fmt.Errorf("plugins: %+v has no source", plugin)
+	}
+	if err != nil {
+		return Artifact{}, err
+	}
+	if plugin.Name != "" {
+		name = plugin.Name
+	}
+	if !nameRegex.MatchString(name) {
+		return Artifact{}, fmt.Errorf("plugins: %q is not a jar file name, set the name of the plugin", name)
+	}
+	artifact.Path = dir + "/" + name
+	if artifact.URL != "" {
+		if err := common.ValidateDownloadURL(artifact.URL); err != nil {
+			return Artifact{}, fmt.Errorf("plugins: %w", err)
+		}
+	}
+	if !checksumRegex.MatchString(artifact.Checksum) {
+		return Artifact{}, fmt.Errorf("plugins: %s has no valid checksum, got %q", artifact.Path, artifact.Checksum)
+	}
+	return artifact, nil
+}
+
+type modrinthVersion struct {
+	Files []struct {
+		URL      string `json:"url"`
+		Filename string `json:"filename"`
+		Primary  bool   `json:"primary"`
+		Hashes   struct {
+			SHA512 string `json:"sha512"`
+		} `json:"hashes"`
+	} `json:"files"`
+}
+
+func modrinth(ctx context.Context, plugin model.Plugin) (string, Artifact, error) {
+	var version modrinthVersion
+	endpoint := fmt.Sprintf("%s/project/%s/version/%s", modrinthAPI, url.PathEscape(plugin.Modrinth), url.PathEscape(plugin.Version))
+	if err := getJSON(ctx, endpoint, &version); err != nil {
+		return "", Artifact{}, err
+	}
+	if len(version.Files) == 0 {
+		return "", Artifact{}, fmt.Errorf("plugins: modrinth project %s %s has no files", plugin.Modrinth, plugin.Version)
+	}
+	primary := version.Files[0]
+	for _, f := range version.Files {
+		if f.Primary {
+			primary = f
+			break
+		}
+	}
+	checksum, err := pinned(plugin, "sha512:"+primary.Hashes.SHA512)
+	return primary.Filename, Artifact{URL: primary.URL, Checksum: checksum}, err
+}
+
+type hangarVersion struct {
+	Downloads map[string]struct {
+		FileInfo *struct {
+			Name       string `json:"name"`
+			SHA256Hash string `json:"sha256Hash"` //nolint:tagliatelle // Hangar API
+		} `json:"fileInfo"` //nolint:tagliatelle // Hangar API
+		ExternalURL string `json:"externalUrl"` //nolint:tagliatelle // Hangar API
+		DownloadURL string `json:"downloadUrl"` //nolint:tagliatelle // Hangar API
+	} `json:"downloads"`
+}
+
+// hangarPlatform returns the Hangar platform whose download runs on edition.
+// BungeeCord runs the plugins built for Waterfall, the servers the ones built
+// for Paper.
+func hangarPlatform(edition string) string {
+	switch edition {
+	case "velocity":
+		return "VELOCITY"
+	case "waterfall", "bungeecord":
+		return "WATERFALL"
+	default:
+		return "PAPER"
+	}
+}
+
+func hangar(ctx context.Context, plugin model.Plugin, edition string) (string, Artifact, error) {
+	var version hangarVersion
+	endpoint := fmt.Sprintf("%s/projects/%s/versions/%s", hangarAPI, url.PathEscape(plugin.Hangar), url.PathEscape(plugin.Version))
+	if err := getJSON(ctx, endpoint, &version); err != nil {
+		return "", Artifact{}, err
+	}
+	platform := hangarPlatform(edition)
+	download, ok := version.Downloads[platform]
+	if !ok {
+		return "", Artifact{}, fmt.Errorf("plugins: hangar project %s %s has no download for %s", plugin.Hangar, plugin.Version, strings.ToLower(platform))
+	}
+	if download.FileInfo == nil {
+		// external downloads are not hosted by Hangar, which knows neither
+		// their name nor their checksum
+		return path.Base(download.ExternalURL), Artifact{URL: download.ExternalURL, Checksum: plugin.Checksum}, nil
+	}
+	checksum, err := pinned(plugin, "sha256:"+download.FileInfo.SHA256Hash)
+	return download.FileInfo.Name, Artifact{URL: download.DownloadURL, Checksum: checksum}, err
+}
+
+// pinned returns the checksum a repository published, or an error if the
+// spec pins another one.
+func pinned(plugin model.Plugin, published string) (string, error) {
+	if plugin.Checksum != "" && plugin.Checksum != published {
+		return "", fmt.Errorf("plugins: checksum of %s%s %s is %s, not %s", plugin.Modrinth, plugin.Hangar, plugin.Version, published, plugin.Checksum)
+	}
+	return published, nil
+}
+
+func file(plugin model.Plugin) (Artifact, error) {
+	f, err := os.Open(plugin.File) //nolint:gosec // the path is given by the user
+	if err != nil {
+		return Artifact{}, err
+	}
+	defer func() { _ = f.Close() }()
+	algorithm := "sha256"
+	if strings.HasPrefix(plugin.Checksum, "sha512:") {
+		algorithm = "sha512"
+	}
+	h := newHash(algorithm)
+	if _, err := io.Copy(h, f); err != nil {
+		return Artifact{}, err
+	}
+	checksum := algorithm + ":" + hex.EncodeToString(h.Sum(nil))
+	if plugin.Checksum != "" && plugin.Checksum != checksum {
+		return Artifact{}, fmt.Errorf("plugins: checksum of %s is %s, not %s", plugin.File, checksum, plugin.Checksum)
+	}
+	return Artifact{File: plugin.File, Checksum: checksum}, nil
+}
+
+func newHash(algorithm string) hash.Hash {
+	if algorithm == "sha512" {
+		return sha512.New()
+	}
+	return sha256.New()
+}
+
+func getJSON(ctx context.Context, endpoint string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "minectl-sdk")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("plugins: GET %s: %s", endpoint, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// InstallCommand returns the shell command that downloads the jar into the
+// server directory root, verifies its checksum and records it in the
+// manifest. It exits the shell if it fails, without leaving a partial
+// download behind, so provisioning stops like it does for a broken modpack.
+func (a *Artifact) InstallCommand(root string) string {
+	algorithm, sum, _ := strings.Cut(a.Checksum, ":")
+	dst := path.Join(root, a.Path)
+	part := path.Join(path.Dir(dst), ".minectl-"+path.Base(dst)+".part")
+	return fmt.Sprintf("mkdir -p %s && curl -sLSf -o %s %s && echo %s | %ssum -c - && mv %s %s && echo %s >> %s || { rm -f %s; exit 1; }",
+		common.ShellQuote(path.Dir(dst)), common.ShellQuote(part), common.ShellQuote(a.URL), common.ShellQuote(sum+"  "+part), algorithm,
+		common.ShellQuote(part), common.ShellQuote(dst), common.ShellQuote(a.ManifestLine()), common.ShellQuote(path.Join(root, ManifestFile)), common.ShellQuote(part))
+}
+
+// ManifestLine returns the line of the manifest that records the jar.
+func (a *Artifact) ManifestLine() string {
+	return a.Path + " " + a.Checksum
+}
+
+// Manifest returns the manifest that records artifacts.
+func Manifest(artifacts []Artifact) string {
+	var b strings.Builder
+	for _, artifact := range artifacts {
+		b.WriteString(artifact.ManifestLine())
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// ParseManifest returns the checksums of the jars recorded in a manifest,
+// keyed by their path. Later lines win over earlier ones, and lines that do
+// not name a jar in the plugins or mods directory are skipped.
+func ParseManifest(manifest string) map[string]string {
+	installed := map[string]string{}
+	for _, line := range strings.Split(manifest, "\n") {
+		// names may contain spaces, checksums do not
+		i := strings.LastIndex(line, " ")
+		if i <= 0 {
+			continue
+		}
+		dir, name, _ := strings.Cut(line[:i], "/")
+		if (dir != PluginsDir && dir != ModsDir) || !nameRegex.MatchString(name) {
+			continue
+		}
+		installed[line[:i]] = line[i+1:]
+	}
+	return installed
+}
+
+// Diff returns the artifacts that are missing on a server or have another
+// checksum there, and the paths of the installed jars that are no longer
+// wanted.
+func Diff(installed map[string]string, artifacts []Artifact) (install []Artifact, remove []string) {
+	wanted := map[string]bool{}
+	for _, artifact := range artifacts {
+		wanted[artifact.Path] = true
+		if installed[artifact.Path] != artifact.Checksum {
+			install = append(install, artifact)
+		}
+	}
+	for p := range installed {
+		if !wanted[p] {
+			remove = append(remove, p)
+		}
+	}
+	sort.Strings(remove)
+	return install, remove
+}
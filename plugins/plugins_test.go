@@ -0,0 +1,235 @@
+package plugins
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dirien/minectl-sdk/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	sha256Sum = strings.Repeat("a", 64)
+	sha512Sum = strings.Repeat("b", 128)
+)
+
+// newRepositories serves the APIs of Modrinth and Hangar.
+func newRepositories(t *testing.T) {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /modrinth/project/luckperms/version/v5.4.102-bukkit", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"files": [
+			{"url": "https://cdn.modrinth.com/data/Vebnzrzj/versions/1/LuckPerms-Bukkit-5.4.102-sources.jar", "filename": "LuckPerms-Bukkit-5.4.102-sources.jar", "primary": false, "hashes": {"sha512": "` + strings.Repeat("c", 128) + `"}},
+			{"url": "https://cdn.modrinth.com/data/Vebnzrzj/versions/1/LuckPerms-Bukkit-5.4.102.jar", "filename": "LuckPerms-Bukkit-5.4.102.jar", "primary": true, "hashes": {"sha512": "` + sha512Sum + `"}}
+		]}`))
+	})
+	mux.HandleFunc("GET /hangar/projects/ViaVersion/versions/4.9.2", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"downloads": {"PAPER": {
+			"fileInfo": {"name": "ViaVersion-4.9.2.jar", "sizeBytes": 5000000, "sha256Hash": "` + sha256Sum + `"},
+			"externalUrl": null,
+			"downloadUrl": "https://hangarcdn.papermc.io/plugins/ViaVersion/ViaVersion/versions/4.9.2/PAPER/ViaVersion-4.9.2.jar"
+		}, "VELOCITY": {
+			"fileInfo": {"name": "ViaVersion-Velocity-4.9.2.jar", "sizeBytes": 5000000, "sha256Hash": "` + sha256Sum + `"},
+			"externalUrl": null,
+			"downloadUrl": "https://hangarcdn.papermc.io/plugins/ViaVersion/ViaVersion/versions/4.9.2/VELOCITY/ViaVersion-Velocity-4.9.2.jar"
+		}}}`))
+	})
+	mux.HandleFunc("GET /hangar/projects/Geyser/versions/2.2.0", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"downloads": {"PAPER": {"fileInfo": null, "externalUrl": "https://download.geysermc.org/v2/projects/geyser/versions/2.2.0/builds/latest/downloads/spigot", "downloadUrl": null}}}`))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	modrinth, hangar := modrinthAPI, hangarAPI
+	modrinthAPI, hangarAPI = server.URL+"/modrinth", server.URL+"/hangar"
+	t.Cleanup(func() { modrinthAPI, hangarAPI = modrinth, hangar })
+}
+
+func TestResolve(t *testing.T) {
+	newRepositories(t)
+	local := filepath.Join(t.TempDir(), "local.jar")
+	require.NoError(t, os.WriteFile(local, []byte("local"), 0o600))
+	localSum := sha256.Sum256([]byte("local"))
+
+	m := &model.MinecraftResource{Spec: model.Spec{Minecraft: model.Minecraft{
+		Edition: "papermc",
+		Plugins: []model.Plugin{
+			{Modrinth: "luckperms", Version: "v5.4.102-bukkit"},
+			{Hangar: "ViaVersion", Version: "4.9.2", Checksum: "sha256:" + sha256Sum},
+			{Hangar: "Geyser", Version: "2.2.0", Name: "Geyser-Spigot.jar", Checksum: "sha256:" + sha256Sum},
+			{URL: "https://example.com/download/EssentialsX-2.20.1.jar", Checksum: "sha256:" + sha256Sum},
+			{Spigot: "28140", Version: "512353", Checksum: "sha512:" + sha512Sum},
+			{File: local},
+		},
+	}}}
+	artifacts, err := Resolve(context.Background(), m)
+	require.NoError(t, err)
+	assert.Equal(t, []Artifact{
+		{
+			Path:     "plugins/LuckPerms-Bukkit-5.4.102.jar",
+			URL:      "https://cdn.modrinth.com/data/Vebnzrzj/versions/1/LuckPerms-Bukkit-5.4.102.jar",
+			Checksum: "sha512:" + sha512Sum,
+		},
+		{
+			Path:     "plugins/ViaVersion-4.9.2.jar",
+			URL:      "https://hangarcdn.papermc.io/plugins/ViaVersion/ViaVersion/versions/4.9.2/PAPER/ViaVersion-4.9.2.jar",
+			Checksum: "sha256:" + sha256Sum,
+		},
+		{
+			Path:     "plugins/Geyser-Spigot.jar",
+			URL:      "https://download.geysermc.org/v2/projects/geyser/versions/2.2.0/builds/latest/downloads/spigot",
+			Checksum: "sha256:" + sha256Sum,
+		},
+		{
+			Path:     "plugins/EssentialsX-2.20.1.jar",
+			URL:      "https://example.com/download/EssentialsX-2.20.1.jar",
+			Checksum: "sha256:" + sha256Sum,
+		},
+		{
+			Path:     "plugins/28140-512353.jar",
+			URL:      "https://api.spiget.org/v2/resources/28140/versions/512353/download",
+			Checksum: "sha512:" + sha512Sum,
+		},
+		{
+			Path:     "plugins/local.jar",
+			File:     local,
+			Checksum: "sha256:" + hex.EncodeToString(localSum[:]),
+		},
+	}, artifacts)
+}
+
+func TestResolveHangarPlatform(t *testing.T) {
+	newRepositories(t)
+	tests := []struct {
+		edition string
+		want    string
+		wantErr string
+	}{
+		{edition: "papermc", want: "plugins/ViaVersion-4.9.2.jar"},
+		{edition: "velocity", want: "plugins/ViaVersion-Velocity-4.9.2.jar"},
+		{edition: "waterfall", wantErr: "has no download for waterfall"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.edition, func(t *testing.T) {
+			m := &model.MinecraftResource{Spec: model.Spec{Minecraft: model.Minecraft{
+				Edition: tt.edition,
+				Plugins: []model.Plugin{{Hangar: "ViaVersion", Version: "4.9.2"}},
+			}}}
+			artifacts, err := Resolve(context.Background(), m)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, artifacts, 1)
+			assert.Equal(t, tt.want, artifacts[0].Path)
+		})
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	newRepositories(t)
+	tests := []struct {
+		name    string
+		plugins []model.Plugin
+		wantErr string
+	}{
+		{
+			name:    "pinned checksum differs",
+			plugins: []model.Plugin{{Modrinth: "luckperms", Version: "v5.4.102-bukkit", Checksum: "sha512:" + strings.Repeat("d", 128)}},
+			wantErr: "checksum of luckperms v5.4.102-bukkit is sha512:bbb",
+		},
+		{
+			name:    "unknown version",
+			plugins: []model.Plugin{{Modrinth: "luckperms", Version: "v1"}},
+			wantErr: "404 Not Found",
+		},
+		{
+			name:    "external download without checksum",
+			plugins: []model.Plugin{{Hangar: "Geyser", Version: "2.2.0", Name: "Geyser-Spigot.jar"}},
+			wantErr: "has no valid checksum",
+		},
+		{
+			name:    "external download without name",
+			plugins: []model.Plugin{{Hangar: "Geyser", Version: "2.2.0", Checksum: "sha256:" + sha256Sum}},
+			wantErr: `"spigot" is not a jar file name`,
+		},
+		{
+			name:    "plain http",
+			plugins: []model.Plugin{{URL: "http://example.com/plugin.jar", Checksum: "sha256:" + sha256Sum}},
+			wantErr: "is not an https URL",
+		},
+		{
+			name: "same name twice",
+			plugins: []model.Plugin{
+				{URL: "https://example.com/a/plugin.jar", Checksum: "sha256:" + sha256Sum},
+				{URL: "https://example.com/b/plugin.jar", Checksum: "sha256:" + sha256Sum},
+			},
+			wantErr: "plugins/plugin.jar is installed twice",
+		},
+		{
+			name:    "local file with another checksum",
+			plugins: []model.Plugin{{File: "plugins_test.go", Name: "test.jar", Checksum: "sha256:" + sha256Sum}},
+			wantErr: "checksum of plugins_test.go is sha256:",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &model.MinecraftResource{Spec: model.Spec{Minecraft: model.Minecraft{Plugins: tt.plugins}}}
+			_, err := Resolve(context.Background(), m)
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	installed := ParseManifest("plugins/old.jar sha256:" + sha256Sum + "\n" +
+		"plugins/same.jar sha256:" + sha256Sum + "\n" +
+		"plugins/upgraded.jar sha256:" + sha256Sum + "\n" +
+		"plugins/upgraded.jar sha256:" + strings.Repeat("f", 64) + "\n" +
+		"mods/with space.jar sha256:" + sha256Sum + "\n" +
+		"../etc/passwd.jar sha256:" + sha256Sum + "\n" +
+		"garbage\n")
+	assert.Equal(t, map[string]string{
+		"plugins/old.jar":      "sha256:" + sha256Sum,
+		"plugins/same.jar":     "sha256:" + sha256Sum,
+		"plugins/upgraded.jar": "sha256:" + strings.Repeat("f", 64),
+		"mods/with space.jar":  "sha256:" + sha256Sum,
+	}, installed)
+
+	artifacts := []Artifact{
+		{Path: "plugins/same.jar", Checksum: "sha256:" + sha256Sum},
+		{Path: "plugins/upgraded.jar", Checksum: "sha256:" + sha256Sum},
+		{Path: "plugins/new.jar", Checksum: "sha512:" + sha512Sum},
+	}
+	install, remove := Diff(installed, artifacts)
+	assert.Equal(t, artifacts[1:], install)
+	assert.Equal(t, []string{"mods/with space.jar", "plugins/old.jar"}, remove)
+
+	install, remove = Diff(ParseManifest(Manifest(artifacts)), artifacts)
+	assert.Empty(t, install)
+	assert.Empty(t, remove)
+}
+
+func TestInstallCommand(t *testing.T) {
+	artifact := Artifact{
+		Path:     "plugins/ViaVersion-4.9.2.jar",
+		URL:      "https://hangarcdn.papermc.io/ViaVersion-4.9.2.jar",
+		Checksum: "sha256:" + sha256Sum,
+	}
+	assert.Equal(t, "mkdir -p '/minecraft/plugins'"+
+		" && curl -sLSf -o '/minecraft/plugins/.minectl-ViaVersion-4.9.2.jar.part' 'https://hangarcdn.papermc.io/ViaVersion-4.9.2.jar'"+
+		" && echo '"+sha256Sum+"  /minecraft/plugins/.minectl-ViaVersion-4.9.2.jar.part' | sha256sum -c -"+
+		" && mv '/minecraft/plugins/.minectl-ViaVersion-4.9.2.jar.part' '/minecraft/plugins/ViaVersion-4.9.2.jar'"+
+		" && echo 'plugins/ViaVersion-4.9.2.jar sha256:"+sha256Sum+"' >> '/minecraft/.minectl-plugins'"+
+		" || { rm -f '/minecraft/plugins/.minectl-ViaVersion-4.9.2.jar.part'; exit 1; }",
+		artifact.InstallCommand("/minecraft"))
+}
@@ -0,0 +1,494 @@
+// Package reconcile plans and applies the changes needed to bring a Minecraft
+// server in line with a MinecraftResource.
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/dirien/minectl-sdk/automation"
+	"github.com/dirien/minectl-sdk/model"
+	"go.uber.org/zap"
+)
+
+// prometheusPort is the port the providers open when monitoring is enabled.
+const prometheusPort = 9090
+
+// ErrReplaceNotAllowed is returned by Apply for a plan that replaces the
+// server when the Reconciler does not allow it.
+var ErrReplaceNotAllowed = errors.New("reconcile: plan replaces the server")
+
+// Action is the kind of change a plan makes to a server.
+type Action string
+
+// Actions reported in a Change.
+const (
+	ActionCreate        Action = "create"
+	ActionRecreate      Action = "recreate"
+	ActionResize        Action = "resize"
+	ActionAddVolume     Action = "add-volume"
+	ActionRemoveVolume  Action = "remove-volume"
+	ActionResizeVolume  Action = "resize-volume"
+	ActionOpenPort      Action = "open-port"
+	ActionClosePort     Action = "close-port"
+	ActionUpdateVersion Action = "update-version"
+	ActionUpdatePlugins Action = "update-plugins"
+)
+
+// Change is a single difference between the running server and the desired
+// resource.
+type Change struct {
+	Action Action
+	// Field is the path of the spec field that differs, e.g. spec.server.size.
+	Field string
+	From  string
+	To    string
+}
+
+// Replace reports whether the change can only be applied by replacing the
+// server. The Automation interface has no way to resize a server, change its
+// volumes or its firewall in place, so everything except a version change
+// and changed plugins replaces the server. This includes new game, SSH, RCON
+// and monitoring ports, which only need other firewall rules.
+func (c Change) Replace() bool {
+	return c.Action != ActionUpdateVersion && c.Action != ActionUpdatePlugins
+}
+
+func (c Change) String() string {
+	switch c.Action {
+	case ActionCreate:
+		return fmt.Sprintf("+ %s (%s)", c.To, c.Action)
+	case ActionUpdateVersion, ActionUpdatePlugins:
+		return fmt.Sprintf("~ %s: %s -> %s (%s)", c.Field, c.From, c.To, c.Action)
+	case ActionOpenPort, ActionClosePort:
+		return fmt.Sprintf("-/+ %s: %s -> %s (%s, replaces server to change the firewall)", c.Field, c.From, c.To, c.Action)
+	default:
+		return fmt.Sprintf("-/+ %s: %s -> %s (%s, replaces server)", c.Field, c.From, c.To, c.Action)
+	}
+}
+
+// Plan is the list of changes needed to reconcile a server.
+type Plan struct {
+	// ID of the existing server, empty if it has to be created.
+	ID string
+	// Observed is what the provider reports for the existing server.
+	Observed *automation.ResourceResults
+	// Applied is the resource of the last successful apply, nil if the Store
+	// does not know the server.
+	Applied *model.MinecraftResource
+	Desired *model.MinecraftResource
+	Changes []Change
+}
+
+// Empty reports whether the server already matches the desired resource.
+func (p *Plan) Empty() bool {
+	return len(p.Changes) == 0
+}
+
+// Replace reports whether applying the plan replaces the server.
+func (p *Plan) Replace() bool {
+	if p.ID == "" {
+		return false
+	}
+	for _, change := range p.Changes {
+		if change.Replace() {
+			return true
+		}
+	}
+	return false
+}
+
+// String renders the plan as a diff. Changes that replace the server are
+// marked with -/+, port changes among them too as the firewall of a server
+// cannot be changed in place.
+func (p *Plan) String() string {
+	var b strings.Builder
+	name := p.Desired.GetName()
+	switch {
+	case p.ID == "":
+		fmt.Fprintf(&b, "%s will be created\n", name)
+	case p.Empty():
+		fmt.Fprintf(&b, "%s (%s) is up to date\n", name, p.ID)
+		return b.String()
+	case p.Replace():
+		fmt.Fprintf(&b, "%s (%s) will be replaced\n", name, p.ID)
+	default:
+		fmt.Fprintf(&b, "%s (%s) will be updated in place\n", name, p.ID)
+	}
+	for _, change := range p.Changes {
+		fmt.Fprintf(&b, "  %s\n", change)
+	}
+	return b.String()
+}
+
+// Reconciler compares MinecraftResources with the servers of a provider and
+// applies the difference.
+type Reconciler struct {
+	automation automation.Automation
+	args       automation.ServerArgs
+	store      Store
+	// DryRun only prints the plan to Out and leaves the server untouched.
+	DryRun bool
+	// Out receives the plan before it is applied. Nothing is printed if nil.
+	Out io.Writer
+	// AllowReplace lets Apply carry out plans that delete the server and
+	// create it again. Otherwise they fail with ErrReplaceNotAllowed. Note
+	// that changed ports, RCON and monitoring replace the server as well,
+	// because the firewall cannot be changed in place, see Change.Replace.
+	//
+	// The volume is retained during a replace whatever the reclaim policy of
+	// the resource, so the new server attaches it again, but a new volume size
+	// is not applied to it. If the desired resource has no volume, the reclaim
+	// policy of the resource decides. A new region needs a provider that
+	// implements automation.VolumeSnapshotter and
+	// automation.VolumeSnapshotCopier: the volume is snapshotted, the snapshot
+	// copied to the new region and the new server created from the copy. Both
+	// snapshots are kept. Providers that cannot retain volumes lose the world,
+	// back it up with package backup first.
+	AllowReplace bool
+}
+
+// NewReconciler creates a Reconciler for the servers of a provider. args is
+// passed to every call of the provider, with its MinecraftResource replaced
+// by the reconciled one, so it carries the SSH settings used to update the
+// server. store remembers the last applied resource, which is needed to
+// detect the changes the provider does not report, like volumes, ports and
+// updates of the version.
+func NewReconciler(a automation.Automation, args automation.ServerArgs, store Store) *Reconciler {
+	return &Reconciler{
+		automation: a,
+		args:       args,
+		store:      store,
+	}
+}
+
+// Reconcile plans the changes for desired, prints them to Out and applies
+// them unless DryRun is set. It returns the plan together with the server
+// after the apply, or as observed in dry-run mode.
+func (r *Reconciler) Reconcile(ctx context.Context, desired *model.MinecraftResource) (*Plan, *automation.ResourceResults, error) {
+	plan, err := r.Plan(ctx, desired)
+	if err != nil {
+		return nil, nil, err
+	}
+	if r.Out != nil {
+		if _, err := io.WriteString(r.Out, plan.String()); err != nil {
+			return nil, nil, err
+		}
+	}
+	if r.DryRun {
+		return plan, plan.Observed, nil
+	}
+	result, err := r.Apply(ctx, plan)
+	if err != nil {
+		return nil, nil, err
+	}
+	return plan, result, nil
+}
+
+// Plan compares desired with the server of the same name.
+func (r *Reconciler) Plan(ctx context.Context, desired *model.MinecraftResource) (*Plan, error) {
+	if r.store == nil {
+		return nil, errors.New("reconcile: a Store is needed to plan changes")
+	}
+	plan := &Plan{Desired: desired}
+	servers, err := r.listServer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range servers {
+		if servers[i].Name == desired.GetName() {
+			plan.ID = servers[i].ID
+			plan.Observed = &servers[i]
+			break
+		}
+	}
+	plan.Applied, err = r.store.Load(desired.GetName())
+	if err != nil {
+		return nil, err
+	}
+	if plan.ID == "" {
+		plan.Changes = []Change{{Action: ActionCreate, Field: "metadata.name", To: desired.GetName()}}
+		return plan, nil
+	}
+	plan.Changes = diff(plan.Observed, plan.Applied, desired)
+	return plan, nil
+}
+
+// Apply carries out plan. Changes that cannot be made in place delete the
+// server and create it again from the desired resource, if AllowReplace is
+// set.
+func (r *Reconciler) Apply(ctx context.Context, plan *Plan) (*automation.ResourceResults, error) {
+	if plan.Empty() {
+		return plan.Observed, nil
+	}
+	checked := *plan.Desired
+	checked.Default()
+	if err := checked.Validate(); err != nil {
+		return nil, err
+	}
+	desiredArgs := r.serverArgs(plan.Desired)
+	var result *automation.ResourceResults
+	var err error
+	switch {
+	case plan.ID == "":
+		zap.S().Infow("Creating server", "name", plan.Desired.GetName())
+		result, err = r.createServer(ctx, desiredArgs)
+	case plan.Replace():
+		if !r.AllowReplace {
+			return nil, fmt.Errorf("%w %s (%s), set AllowReplace to apply it", ErrReplaceNotAllowed, plan.Desired.GetName(), plan.ID)
+		}
+		result, err = r.replace(ctx, plan, desiredArgs)
+	default:
+		zap.S().Infow("Updating server", "name", plan.Desired.GetName(), "id", plan.ID)
+		if err = r.updateServer(ctx, plan.ID, desiredArgs); err != nil {
+			return nil, err
+		}
+		result, err = r.getServer(ctx, plan.ID, desiredArgs)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := r.store.Save(plan.Desired); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// replace deletes the server of plan and creates it again from desiredArgs.
+// The volume is moved over to the new server, see AllowReplace.
+func (r *Reconciler) replace(ctx context.Context, plan *Plan, desiredArgs automation.ServerArgs) (*automation.ResourceResults, error) {
+	name := plan.Desired.GetName()
+	current := *plan.Desired
+	if plan.Applied != nil {
+		current = *plan.Applied
+	}
+	keepVolume := current.GetVolumeSize() > 0 && plan.Desired.GetVolumeSize() > 0
+	moveVolume := keepVolume && current.GetRegion() != plan.Desired.GetRegion()
+	var snapshotter automation.VolumeSnapshotter
+	var copier automation.VolumeSnapshotCopier
+	if moveVolume {
+		var ok bool
+		snapshotter, ok = r.automation.(automation.VolumeSnapshotter)
+		if ok {
+			copier, ok = r.automation.(automation.VolumeSnapshotCopier)
+		}
+		if !ok {
+			return nil, fmt.Errorf("reconcile: cannot move the volume of %s from %s to %s, the provider cannot copy volume snapshots", name, current.GetRegion(), plan.Desired.GetRegion())
+		}
+	}
+
+	zap.S().Infow("Replacing server", "name", name, "id", plan.ID)
+	if moveVolume {
+		snapshot, err := snapshotter.CreateVolumeSnapshot(ctx, plan.ID, r.serverArgs(&current))
+		if err != nil {
+			return nil, err
+		}
+		copied, err := copier.CopyVolumeSnapshot(ctx, snapshot.ID, plan.Desired.GetRegion())
+		if err != nil {
+			return nil, fmt.Errorf("reconcile: copying volume snapshot %s of %s to %s: %w", snapshot.ID, name, plan.Desired.GetRegion(), err)
+		}
+		desiredArgs.VolumeSnapshotID = copied.ID
+		// the world is in the snapshot now, the old volume is of no use in
+		// the old region
+		current.Spec.Server.VolumeReclaimPolicy = model.VolumeReclaimDelete
+	} else if keepVolume {
+		current.Spec.Server.VolumeReclaimPolicy = model.VolumeReclaimRetain
+	}
+	if err := r.deleteServer(ctx, plan.ID, r.serverArgs(&current)); err != nil {
+		return nil, err
+	}
+	result, err := r.createServer(ctx, desiredArgs)
+	switch {
+	case err == nil:
+		return result, nil
+	case moveVolume:
+		return nil, fmt.Errorf("reconcile: %s was deleted but creating it again failed, its world is kept in volume snapshot %s in %s: %w", name, desiredArgs.VolumeSnapshotID, plan.Desired.GetRegion(), err)
+	case keepVolume:
+		return nil, fmt.Errorf("reconcile: %s was deleted but creating it again failed, its volume %s is retained: %w", name, retainedVolume(plan), err)
+	default:
+		return nil, err
+	}
+}
+
+// retainedVolume names the volume of the server of plan for an error.
+func retainedVolume(plan *Plan) string {
+	if plan.Observed != nil && len(plan.Observed.VolumeIDs) > 0 {
+		return strings.Join(plan.Observed.VolumeIDs, ", ")
+	}
+	return plan.Desired.GetName() + "-vol"
+}
+
+// diff compares desired with the observed server. The observed value wins
+// over the last applied resource where the provider reports it, so drift is
+// detected too.
+func diff(observed *automation.ResourceResults, applied, desired *model.MinecraftResource) []Change {
+	var changes []Change
+	add := func(action Action, field, from, to string) {
+		if from != to {
+			changes = append(changes, Change{Action: action, Field: field, From: from, To: to})
+		}
+	}
+
+	if applied != nil {
+		add(ActionRecreate, "spec.server.region", applied.GetRegion(), desired.GetRegion())
+		add(ActionRecreate, "spec.server.spot", strconv.FormatBool(applied.IsSpot()), strconv.FormatBool(desired.IsSpot()))
+	}
+	add(ActionRecreate, "spec.minecraft.edition", observedOr(observed.Edition, applied, desired, (*model.MinecraftResource).GetEdition), desired.GetEdition())
+	desiredArch := automation.ArchFromArgs(automation.ServerArgs{MinecraftResource: desired})
+	if observed.Arch != "" {
+		add(ActionRecreate, "spec.server.arm", observed.Arch, desiredArch)
+	}
+	add(ActionResize, "spec.server.size", observedOr(observed.Size, applied, desired, (*model.MinecraftResource).GetSize), desired.GetSize())
+	if applied != nil {
+		changes = append(changes, diffVolume(applied, desired)...)
+		changes = append(changes, diffPorts(applied, desired)...)
+	}
+	// the labels only know the version the server was created with, updates
+	// are recorded in the store
+	version := observed.Version
+	if applied != nil {
+		version = applied.GetVersion()
+	}
+	add(ActionUpdateVersion, "spec.minecraft.version", version, desired.GetVersion())
+	if applied != nil {
+		add(ActionUpdatePlugins, "spec.minecraft.plugins", pluginList(applied.Spec.Minecraft.Plugins), pluginList(desired.Spec.Minecraft.Plugins))
+		add(ActionUpdatePlugins, "spec.minecraft.mods", pluginList(applied.Spec.Minecraft.Mods), pluginList(desired.Spec.Minecraft.Mods))
+	}
+	return changes
+}
+
+// pluginList renders plugins for a change, e.g. [modrinth:luckperms@5.4.102].
+// Plugins with another name or checksum render differently as well, new
+// content of a local jar does not.
+func pluginList(plugins []model.Plugin) string {
+	items := make([]string, 0, len(plugins))
+	for _, plugin := range plugins {
+		var item string
+		switch {
+		case plugin.Modrinth != "":
+			item = "modrinth:" + plugin.Modrinth + "@" + plugin.Version
+		case plugin.Hangar != "":
+			item = "hangar:" + plugin.Hangar + "@" + plugin.Version
+		case plugin.Spigot != "":
+			item = "spigot:" + plugin.Spigot + "@" + plugin.Version
+		case plugin.File != "":
+			item = plugin.File
+		default:
+			item = plugin.URL
+		}
+		if plugin.Name != "" {
+			item += " as " + plugin.Name
+		}
+		if plugin.Checksum != "" {
+			item += " (" + plugin.Checksum + ")"
+		}
+		items = append(items, item)
+	}
+	return "[" + strings.Join(items, ", ") + "]"
+}
+
+// observedOr returns the observed value, or the value of the last applied
+// resource if the provider did not report it. Without either, the desired
+// value is assumed so no change is planned.
+func observedOr(observed string, applied, desired *model.MinecraftResource, get func(*model.MinecraftResource) string) string {
+	if observed != "" {
+		return observed
+	}
+	if applied != nil {
+		return get(applied)
+	}
+	return get(desired)
+}
+
+func diffVolume(applied, desired *model.MinecraftResource) []Change {
+	from, to := applied.GetVolumeSize(), desired.GetVolumeSize()
+	change := Change{Field: "spec.server.volumeSize", From: strconv.Itoa(from), To: strconv.Itoa(to)}
+	switch {
+	case from == to:
+		return nil
+	case from == 0:
+		change.Action = ActionAddVolume
+	case to == 0:
+		change.Action = ActionRemoveVolume
+	default:
+		change.Action = ActionResizeVolume
+	}
+	return []Change{change}
+}
+
+func diffPorts(applied, desired *model.MinecraftResource) []Change {
+	var changes []Change
+	port := func(field string, from, to int) {
+		switch {
+		case from == to:
+		case to == 0:
+			changes = append(changes, Change{Action: ActionClosePort, Field: field, From: strconv.Itoa(from)})
+		default:
+			changes = append(changes, Change{Action: ActionOpenPort, Field: field, From: strconv.Itoa(from), To: strconv.Itoa(to)})
+		}
+	}
+	port("spec.server.port", applied.GetPort(), desired.GetPort())
+	port("spec.server.ssh.port", applied.GetSSHPort(), desired.GetSSHPort())
+	port("spec.minecraft.java.rcon", rconPort(applied), rconPort(desired))
+	port("spec.monitoring.enabled", monitoringPort(applied), monitoringPort(desired))
+	return changes
+}
+
+func rconPort(m *model.MinecraftResource) int {
+	if !m.HasRCON() {
+		return 0
+	}
+	return m.GetRCONPort()
+}
+
+func monitoringPort(m *model.MinecraftResource) int {
+	if !m.HasMonitoring() {
+		return 0
+	}
+	return prometheusPort
+}
+
+func (r *Reconciler) serverArgs(m *model.MinecraftResource) automation.ServerArgs {
+	args := r.args
+	args.MinecraftResource = m
+	return args
+}
+
+func (r *Reconciler) listServer(ctx context.Context) ([]automation.ResourceResults, error) {
+	if a, ok := r.automation.(automation.ContextAutomation); ok {
+		return a.ListServerContext(ctx)
+	}
+	return r.automation.ListServer()
+}
+
+func (r *Reconciler) getServer(ctx context.Context, id string, args automation.ServerArgs) (*automation.ResourceResults, error) {
+	if a, ok := r.automation.(automation.ContextAutomation); ok {
+		return a.GetServerContext(ctx, id, args)
+	}
+	return r.automation.GetServer(id, args)
+}
+
+func (r *Reconciler) createServer(ctx context.Context, args automation.ServerArgs) (*automation.ResourceResults, error) {
+	if a, ok := r.automation.(automation.ContextAutomation); ok {
+		return a.CreateServerContext(ctx, args)
+	}
+	return r.automation.CreateServer(args)
+}
+
+func (r *Reconciler) deleteServer(ctx context.Context, id string, args automation.ServerArgs) error {
+	if a, ok := r.automation.(automation.ContextAutomation); ok {
+		return a.DeleteServerContext(ctx, id, args)
+	}
+	return r.automation.DeleteServer(id, args)
+}
+
+func (r *Reconciler) updateServer(ctx context.Context, id string, args automation.ServerArgs) error {
+	if a, ok := r.automation.(automation.ContextAutomation); ok {
+		return a.UpdateServerContext(ctx, id, args)
+	}
+	return r.automation.UpdateServer(id, args)
+}
@@ -0,0 +1,292 @@
+package reconcile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dirien/minectl-sdk/automation"
+	"github.com/dirien/minectl-sdk/cloud/fake"
+	"github.com/dirien/minectl-sdk/internal/testutil"
+	"github.com/dirien/minectl-sdk/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeResource(t *testing.T) *model.MinecraftResource {
+	t.Helper()
+	resource := testutil.Resource(t, fake.ProviderFake, "local", "small", "java")
+	resource.Spec.Minecraft.Java.Rcon = model.Rcon{}
+	return resource
+}
+
+func methods(f *fake.Fake) []string {
+	var result []string
+	for _, call := range f.Calls() {
+		result = append(result, call.Method)
+	}
+	return result
+}
+
+func TestReconcile(t *testing.T) {
+	f, err := fake.NewFake()
+	require.NoError(t, err)
+	r := NewReconciler(f, automation.ServerArgs{}, NewFileStore(t.TempDir()))
+	r.AllowReplace = true
+	ctx := context.Background()
+	desired := makeResource(t)
+
+	plan, res, err := r.Reconcile(ctx, desired)
+	require.NoError(t, err)
+	assert.Equal(t, []Change{{Action: ActionCreate, Field: "metadata.name", To: "minecraft-server"}}, plan.Changes)
+	assert.Equal(t, "minecraft-server", res.Name)
+
+	plan, _, err = r.Reconcile(ctx, desired)
+	require.NoError(t, err)
+	assert.True(t, plan.Empty())
+
+	desired.Spec.Minecraft.Version = "1.21"
+	plan, res, err = r.Reconcile(ctx, desired)
+	require.NoError(t, err)
+	assert.False(t, plan.Replace())
+	assert.Equal(t, "1.21", res.Version)
+
+	desired.Spec.Minecraft.Java.Rcon = model.Rcon{Enabled: true, Port: 25575, Password: "test"}
+	desired.Spec.Server.VolumeSize = 10
+	plan, _, err = r.Reconcile(ctx, desired)
+	require.NoError(t, err)
+	assert.True(t, plan.Replace())
+	assert.Equal(t, []Change{
+		{Action: ActionAddVolume, Field: "spec.server.volumeSize", From: "0", To: "10"},
+		{Action: ActionOpenPort, Field: "spec.minecraft.java.rcon", From: "0", To: "25575"},
+	}, plan.Changes)
+
+	assert.Equal(t, []string{
+		fake.MethodListServer, fake.MethodCreateServer,
+		fake.MethodListServer,
+		fake.MethodListServer, fake.MethodUpdateServer, fake.MethodGetServer,
+		fake.MethodListServer, fake.MethodDeleteServer, fake.MethodCreateServer,
+	}, methods(f))
+	_, ok := f.Volume("minecraft-server-vol")
+	assert.True(t, ok)
+}
+
+func TestDryRun(t *testing.T) {
+	f, err := fake.NewFake()
+	require.NoError(t, err)
+	desired := makeResource(t)
+	_, err = f.CreateServer(automation.ServerArgs{MinecraftResource: desired})
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	r := NewReconciler(f, automation.ServerArgs{}, NewFileStore(t.TempDir()))
+	r.DryRun = true
+	r.Out = &out
+	desired.Spec.Server.Size = "large"
+	desired.Spec.Minecraft.Version = "1.21"
+
+	plan, _, err := r.Reconcile(context.Background(), desired)
+	require.NoError(t, err)
+	assert.True(t, plan.Replace())
+	assert.Equal(t, `minecraft-server (fake-1) will be replaced
+  -/+ spec.server.size: small -> large (resize, replaces server)
+  ~ spec.minecraft.version: 1.20.4 -> 1.21 (update-version)
+`, out.String())
+	server, ok := f.Server("fake-1")
+	require.True(t, ok)
+	assert.Equal(t, "small", server.Size)
+}
+
+func TestReconcilePlugins(t *testing.T) {
+	f, err := fake.NewFake()
+	require.NoError(t, err)
+	r := NewReconciler(f, automation.ServerArgs{}, NewFileStore(t.TempDir()))
+	ctx := context.Background()
+	desired := makeResource(t)
+	_, _, err = r.Reconcile(ctx, desired)
+	require.NoError(t, err)
+
+	desired.Spec.Minecraft.Plugins = []model.Plugin{{Hangar: "ViaVersion", Version: "4.9.2"}}
+	plan, err := r.Plan(ctx, desired)
+	require.NoError(t, err)
+	assert.False(t, plan.Replace())
+	assert.Equal(t, []Change{
+		{Action: ActionUpdatePlugins, Field: "spec.minecraft.plugins", From: "[]", To: "[hangar:ViaVersion@4.9.2]"},
+	}, plan.Changes)
+	assert.Equal(t, "~ spec.minecraft.plugins: [] -> [hangar:ViaVersion@4.9.2] (update-plugins)", plan.Changes[0].String())
+}
+
+func TestPlanNeedsStore(t *testing.T) {
+	f, err := fake.NewFake()
+	require.NoError(t, err)
+	_, err = NewReconciler(f, automation.ServerArgs{}, nil).Plan(context.Background(), makeResource(t))
+	require.Error(t, err)
+}
+
+func TestReplaceKeepsVolume(t *testing.T) {
+	f, err := fake.NewFake()
+	require.NoError(t, err)
+	r := NewReconciler(f, automation.ServerArgs{}, NewFileStore(t.TempDir()))
+	ctx := context.Background()
+	desired := makeResource(t)
+	desired.Spec.Server.VolumeSize = 10
+	_, _, err = r.Reconcile(ctx, desired)
+	require.NoError(t, err)
+
+	desired.Spec.Server.Size = "large"
+	_, _, err = r.Reconcile(ctx, desired)
+	require.ErrorIs(t, err, ErrReplaceNotAllowed)
+	assert.NotContains(t, methods(f), fake.MethodDeleteServer)
+
+	r.AllowReplace = true
+	_, res, err := r.Reconcile(ctx, desired)
+	require.NoError(t, err)
+	assert.Equal(t, "large", res.Size)
+	volume, ok := f.Volume("minecraft-server-vol")
+	require.True(t, ok)
+	assert.Equal(t, res.ID, volume.ServerID)
+}
+
+func TestPlanVersion(t *testing.T) {
+	f, err := fake.NewFake()
+	require.NoError(t, err)
+	store := NewFileStore(t.TempDir())
+	r := NewReconciler(f, automation.ServerArgs{}, store)
+	ctx := context.Background()
+	desired := makeResource(t)
+	_, err = f.CreateServer(automation.ServerArgs{MinecraftResource: desired})
+	require.NoError(t, err)
+	applied := *desired
+	applied.Spec.Minecraft.Version = "1.20.6"
+	require.NoError(t, store.Save(&applied))
+
+	// the store knows about updates the labels of the server do not
+	plan, err := r.Plan(ctx, desired)
+	require.NoError(t, err)
+	assert.Equal(t, []Change{
+		{Action: ActionUpdateVersion, Field: "spec.minecraft.version", From: "1.20.6", To: "1.20.4"},
+	}, plan.Changes)
+}
+
+func TestReplaceValidatesFirst(t *testing.T) {
+	f, err := fake.NewFake()
+	require.NoError(t, err)
+	r := NewReconciler(f, automation.ServerArgs{}, NewFileStore(t.TempDir()))
+	r.AllowReplace = true
+	ctx := context.Background()
+	desired := makeResource(t)
+	_, _, err = r.Reconcile(ctx, desired)
+	require.NoError(t, err)
+
+	desired.Spec.Server.Size = "large"
+	desired.Spec.Server.SSH.Port = desired.GetPort()
+	_, _, err = r.Reconcile(ctx, desired)
+	var validationErr model.ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	assert.NotContains(t, methods(f), fake.MethodDeleteServer)
+}
+
+func TestReplaceRemovesVolume(t *testing.T) {
+	f, err := fake.NewFake()
+	require.NoError(t, err)
+	r := NewReconciler(f, automation.ServerArgs{}, NewFileStore(t.TempDir()))
+	r.AllowReplace = true
+	ctx := context.Background()
+	desired := makeResource(t)
+	desired.Spec.Server.VolumeSize = 10
+	_, _, err = r.Reconcile(ctx, desired)
+	require.NoError(t, err)
+
+	desired.Spec.Server.VolumeSize = 0
+	_, _, err = r.Reconcile(ctx, desired)
+	require.NoError(t, err)
+	_, ok := f.Volume("minecraft-server-vol")
+	assert.False(t, ok)
+}
+
+func TestReplaceMovesVolume(t *testing.T) {
+	f, err := fake.NewFake()
+	require.NoError(t, err)
+	r := NewReconciler(f, automation.ServerArgs{}, NewFileStore(t.TempDir()))
+	r.AllowReplace = true
+	ctx := context.Background()
+	desired := makeResource(t)
+	desired.Spec.Server.VolumeSize = 10
+	_, _, err = r.Reconcile(ctx, desired)
+	require.NoError(t, err)
+
+	desired.Spec.Server.Region = "remote"
+	_, res, err := r.Reconcile(ctx, desired)
+	require.NoError(t, err)
+	assert.Equal(t, "remote", res.Region)
+	volume, ok := f.Volume("minecraft-server-vol")
+	require.True(t, ok)
+	assert.Equal(t, res.ID, volume.ServerID)
+	snapshots, err := f.ListVolumeSnapshots(ctx)
+	require.NoError(t, err)
+	require.Len(t, snapshots, 2)
+	assert.Equal(t, "remote", snapshots[1].Region)
+	assert.Equal(t, snapshots[1].ID, volume.SnapshotID)
+}
+
+func TestReplaceRegionNeedsSnapshotCopy(t *testing.T) {
+	f, err := fake.NewFake()
+	require.NoError(t, err)
+	// hide the snapshot methods of the fake
+	r := NewReconciler(struct{ automation.Automation }{f}, automation.ServerArgs{}, NewFileStore(t.TempDir()))
+	r.AllowReplace = true
+	ctx := context.Background()
+	desired := makeResource(t)
+	desired.Spec.Server.VolumeSize = 10
+	_, _, err = r.Reconcile(ctx, desired)
+	require.NoError(t, err)
+
+	desired.Spec.Server.Region = "remote"
+	_, _, err = r.Reconcile(ctx, desired)
+	require.ErrorContains(t, err, "cannot move the volume")
+	assert.NotContains(t, methods(f), fake.MethodDeleteServer)
+}
+
+func TestReplaceCreateFails(t *testing.T) {
+	f, err := fake.NewFake()
+	require.NoError(t, err)
+	r := NewReconciler(f, automation.ServerArgs{}, NewFileStore(t.TempDir()))
+	r.AllowReplace = true
+	ctx := context.Background()
+	desired := makeResource(t)
+	desired.Spec.Server.VolumeSize = 10
+	_, _, err = r.Reconcile(ctx, desired)
+	require.NoError(t, err)
+
+	quota := errors.New("quota exceeded")
+	f.InjectError(fake.MethodCreateServer, quota)
+	desired.Spec.Server.Size = "large"
+	_, _, err = r.Reconcile(ctx, desired)
+	require.ErrorIs(t, err, quota)
+	assert.ErrorContains(t, err, "its volume minecraft-server-vol is retained")
+	_, ok := f.Volume("minecraft-server-vol")
+	assert.True(t, ok)
+}
+
+func TestServerArgsTemplate(t *testing.T) {
+	f, err := fake.NewFake()
+	require.NoError(t, err)
+	r := NewReconciler(f, automation.ServerArgs{SSHAgent: true, InsecureIgnoreHostKey: true}, NewFileStore(t.TempDir()))
+	desired := makeResource(t)
+	_, _, err = r.Reconcile(context.Background(), desired)
+	require.NoError(t, err)
+
+	calls := f.Calls()
+	create := calls[len(calls)-1]
+	require.Equal(t, fake.MethodCreateServer, create.Method)
+	assert.True(t, create.Args.SSHAgent)
+	assert.True(t, create.Args.InsecureIgnoreHostKey)
+	assert.Same(t, desired, create.Args.MinecraftResource)
+}
+
+func TestPortChangeString(t *testing.T) {
+	change := Change{Action: ActionOpenPort, Field: "spec.minecraft.java.rcon", From: "0", To: "25575"}
+	assert.True(t, change.Replace())
+	assert.Equal(t, "-/+ spec.minecraft.java.rcon: 0 -> 25575 (open-port, replaces server to change the firewall)", change.String())
+}
@@ -0,0 +1,63 @@
+package reconcile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/dirien/minectl-sdk/model"
+	"gopkg.in/yaml.v3"
+)
+
+// Store keeps the last applied MinecraftResource of each server.
+type Store interface {
+	// Load returns the last applied resource for name, or nil if there is none.
+	Load(name string) (*model.MinecraftResource, error)
+	Save(resource *model.MinecraftResource) error
+}
+
+// FileStore is a Store that keeps one YAML manifest per server in a directory,
+// in the same format as the config generated by template.NewTemplateConfig.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore creates a FileStore in dir.
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{dir: dir}
+}
+
+func (f *FileStore) path(name string) string {
+	return filepath.Join(f.dir, fmt.Sprintf("%s.yaml", name))
+}
+
+// Load reads the manifest for name.
+func (f *FileStore) Load(name string) (*model.MinecraftResource, error) {
+	data, err := os.ReadFile(f.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var resource model.MinecraftResource
+	err = yaml.Unmarshal(data, &resource)
+	if err != nil {
+		return nil, err
+	}
+	return &resource, nil
+}
+
+// Save writes the manifest for resource.
+func (f *FileStore) Save(resource *model.MinecraftResource) error {
+	data, err := yaml.Marshal(resource)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(f.dir, 0o700)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(f.path(resource.GetName()), data, 0o600)
+}
@@ -0,0 +1,104 @@
+package template
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+)
+
+// Option customizes the templates created by NewTemplateBash and
+// NewTemplateCloudConfig. The cloud providers take them from cloud.New and
+// their constructors, and apply the overrides of bash templates when servers
+// are updated as well.
+type Option func(*options)
+
+type options struct {
+	overrides []fs.FS
+	extra     Extra
+}
+
+// WithOverrides parses the *.tmpl files at the root of fsys after the
+// embedded templates. A {{ define }} of a name the SDK uses, like
+// "java-binary" or "cloud-config", replaces the embedded one, and new
+// names can be included by the overridden templates. Overrides of bash
+// templates are also used when servers are updated.
+func WithOverrides(fsys fs.FS) Option {
+	return func(o *options) {
+		o.overrides = append(o.overrides, fsys)
+	}
+}
+
+// WithOverrideDir is WithOverrides for the directory dir.
+func WithOverrideDir(dir string) Option {
+	return WithOverrides(os.DirFS(dir))
+}
+
+// WithExtra adds users, packages, files and commands to the cloud-config or
+// bash script of the servers. Extras of several options add up.
+func WithExtra(extra Extra) Option {
+	return func(o *options) {
+		o.extra.Users = append(o.extra.Users, extra.Users...)
+		o.extra.Packages = append(o.extra.Packages, extra.Packages...)
+		o.extra.WriteFiles = append(o.extra.WriteFiles, extra.WriteFiles...)
+		o.extra.RunCmd = append(o.extra.RunCmd, extra.RunCmd...)
+	}
+}
+
+// Extra are pieces added to the cloud-config of the servers, or the
+// equivalent steps of the bash script. Users, packages and files are set up
+// before the server is installed, the commands run once it is started.
+type Extra struct {
+	Users      []User
+	Packages   []string
+	WriteFiles []File
+	RunCmd     []string
+}
+
+// User is a user created on the server.
+type User struct {
+	Name string
+	// Shell defaults to the one of the distribution.
+	Shell string
+	// Groups must exist on the server.
+	Groups []string
+}
+
+// File is a file written to the server.
+type File struct {
+	// Path is absolute, missing directories are created.
+	Path    string
+	Content string
+	// Permissions are octal, like 0644.
+	Permissions string
+	// Owner is user or user:group.
+	Owner string
+}
+
+var (
+	userRegex        = regexp.MustCompile(`^[a-z_][a-z0-9_-]*$`)
+	permissionsRegex = regexp.MustCompile(`^0?[0-7]{3}$`)
+)
+
+func (e *Extra) validate() error {
+	for _, user := range e.Users {
+		if !userRegex.MatchString(user.Name) {
+			return fmt.Errorf("template: %q is not a valid user name", user.Name)
+		}
+		for _, group := range user.Groups {
+			if !userRegex.MatchString(group) {
+				return fmt.Errorf("template: %q of user %s is not a valid group name", group, user.Name)
+			}
+		}
+	}
+	for _, file := range e.WriteFiles {
+		if !path.IsAbs(file.Path) || path.Clean(file.Path) != file.Path {
+			return fmt.Errorf("template: file %q needs a clean absolute path", file.Path)
+		}
+		if file.Permissions != "" && !permissionsRegex.MatchString(file.Permissions) {
+			return fmt.Errorf("template: permissions of %s must be octal, got %q", file.Path, file.Permissions)
+		}
+	}
+	return nil
+}
@@ -3,13 +3,20 @@ package template
 
 import (
 	"bytes"
+	"context"
 	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
 	"strings"
 	"text/template"
 
 	"github.com/Masterminds/sprig/v3"
-	"github.com/dirien/minectl-sdk/cloud"
+	"github.com/dirien/minectl-sdk/automation"
+	"github.com/dirien/minectl-sdk/common"
 	"github.com/dirien/minectl-sdk/model"
+	"github.com/dirien/minectl-sdk/modpack"
+	"github.com/dirien/minectl-sdk/plugins"
 )
 
 // Template wraps a text/template for generating scripts.
@@ -22,7 +29,12 @@ type templateValues struct {
 	*model.MinecraftResource
 	Mount        string
 	SSHPublicKey string
+	SSHHostKey   *automation.HostKey
 	Properties   []string
+	Modpack      *modpack.Pack
+	Plugins      []plugins.Artifact
+	Edition      model.Edition
+	Extra        Extra
 }
 
 // Name represents the name of a template.
@@ -37,8 +49,11 @@ const (
 	TemplateSpigotBukkitBinary Name = "spigotbukkit-binary"
 	TemplateFabricBinary       Name = "fabric-binary"
 	TemplateForgeBinary        Name = "forge-binary"
+	TemplateQuiltBinary        Name = "quilt-binary"
+	TemplateNeoForgeBinary     Name = "neoforge-binary"
 	TemplatePaperMCBinary      Name = "papermc-binary"
 	TemplatePurpurBinary       Name = "purpur-binary"
+	TemplateFoliaBinary        Name = "folia-binary"
 	TemplateProxyCloudConfig   Name = "proxy-cloud-config"
 	TemplateProxyBash          Name = "proxy-bash"
 	TemplateBungeeCordBinary   Name = "bungeecord-binary"
@@ -48,38 +63,88 @@ const (
 	TemplatePowerNukkitBinary  Name = "powernukkit-binary"
 )
 
-// GetUpdateTemplate returns a template for update operations.
+// GetUpdateTemplate returns a template for update operations, without
+// options. Use NewTemplateBash to update with overrides.
 func GetUpdateTemplate() *Template {
-	bash := template.Must(template.New("base").Funcs(sprig.TxtFuncMap()).ParseFS(templateFS, "templates/bash/*"))
 	return &Template{
-		Template: bash,
+		Template: template.Must(parse("base", "templates/bash/*")),
 		Values:   &templateValues{},
 	}
 }
 
 // DoUpdate executes the update template.
 func (t *Template) DoUpdate(model *model.MinecraftResource, args *CreateUpdateTemplateArgs) (string, error) {
-	return t.GetTemplate(model, args)
+	return t.DoUpdateContext(context.Background(), model, args)
+}
+
+// DoUpdateContext is like DoUpdate but opens the modpack with ctx.
+func (t *Template) DoUpdateContext(ctx context.Context, model *model.MinecraftResource, args *CreateUpdateTemplateArgs) (string, error) {
+	return t.GetTemplateContext(ctx, model, args)
 }
 
 // CreateUpdateTemplateArgs contains arguments for template execution.
 type CreateUpdateTemplateArgs struct {
 	Mount        string
 	SSHPublicKey string
-	Name         Name
+	// SSHHostKey is installed as the ed25519 host key of the server, if set.
+	SSHHostKey *automation.HostKey
+	// Modpack is installed after the mod loader. If nil, the modpack of
+	// Spec.Minecraft.Modpack is opened, which downloads a pack from a URL
+	// only once as long as it does not change, see modpack.Open.
+	Modpack *modpack.Pack
+	// Plugins are the plugins and mods installed by the bash and
+	// cloud-config templates. If nil, the ones of the spec are resolved.
+	// Local jars are skipped, they are uploaded when the server is updated.
+	Plugins []plugins.Artifact
+	Name    Name
 }
 
 // GetTemplate executes the named template with the given model.
 func (t *Template) GetTemplate(model *model.MinecraftResource, args *CreateUpdateTemplateArgs) (string, error) {
+	return t.GetTemplateContext(context.Background(), model, args)
+}
+
+// GetTemplateContext is like GetTemplate but opens the modpack and resolves
+// the plugins with ctx.
+func (t *Template) GetTemplateContext(ctx context.Context, model *model.MinecraftResource, args *CreateUpdateTemplateArgs) (string, error) {
 	var buff bytes.Buffer
 
 	t.Values.MinecraftResource = model
 	t.Values.Properties = strings.Split(model.GetProperties(), "\n")
 
+	edition, err := model.GetEditionSpec()
+	if err != nil {
+		return "", err
+	}
+	t.Values.Edition = edition
 	t.Values.Mount = args.Mount
 	t.Values.SSHPublicKey = args.SSHPublicKey
+	t.Values.SSHHostKey = args.SSHHostKey
+
+	t.Values.Modpack = args.Modpack
+	if t.Values.Modpack == nil && model.GetModpack() != "" {
+		pack, err := modpack.Open(ctx, model.GetModpack())
+		if err != nil {
+			return "", err
+		}
+		t.Values.Modpack = pack
+	}
+	if t.Values.Modpack != nil {
+		if err := t.Values.Modpack.Check(model.GetEdition(), model.GetVersion()); err != nil {
+			return "", err
+		}
+	}
 
-	err := t.Template.ExecuteTemplate(&buff, string(args.Name), t.Values)
+	t.Values.Plugins = args.Plugins
+	if t.Values.Plugins == nil && (args.Name == TemplateBash || args.Name == TemplateCloudConfig) {
+		artifacts, err := plugins.Resolve(ctx, model)
+		if err != nil {
+			return "", err
+		}
+		t.Values.Plugins = artifacts
+	}
+
+	err = t.Template.ExecuteTemplate(&buff, string(args.Name), t.Values)
 	if err != nil {
 		return "", err
 	}
@@ -105,30 +170,99 @@ func GetTemplateBashName(isProxy bool) Name {
 //go:embed templates
 var templateFS embed.FS
 
-// NewTemplateBash creates a new bash template.
-func NewTemplateBash() (*Template, error) {
-	bash := template.Must(template.New("base").Funcs(sprig.TxtFuncMap()).ParseFS(templateFS, "templates/bash/*"))
-	return &Template{
-		Template: bash,
-		Values:   &templateValues{},
-	}, nil
+// parse parses the templates matching pattern, and then the *.tmpl files of
+// overrides. Besides the functions of sprig, they can look up an edition by
+// name with edition, include a template whose name is only known when
+// executing, like the binary template of the edition, with include, and
+// quote strings for YAML and sh with yamlQuote and shellQuote.
+func parse(name, pattern string, overrides ...fs.FS) (*template.Template, error) {
+	t := template.New(name)
+	funcs := sprig.TxtFuncMap()
+	funcs["edition"] = func(name string) (model.Edition, error) {
+		e, ok := model.LookupEdition(name)
+		if !ok {
+			return model.Edition{}, fmt.Errorf("unknown edition %q", name)
+		}
+		return e, nil
+	}
+	funcs["include"] = func(name string, data any) (string, error) {
+		var buff bytes.Buffer
+		err := t.ExecuteTemplate(&buff, name, data)
+		return buff.String(), err
+	}
+	funcs["yamlQuote"] = yamlQuote
+	funcs["shellQuote"] = common.ShellQuote
+	t, err := t.Funcs(funcs).ParseFS(templateFS, pattern)
+	if err != nil {
+		return nil, err
+	}
+	for _, fsys := range overrides {
+		if t, err = t.ParseFS(fsys, "*.tmpl"); err != nil {
+			return nil, fmt.Errorf("template: overrides: %w", err)
+		}
+	}
+	return t, nil
 }
 
-// NewTemplateCloudConfig creates a new cloud-config template.
-func NewTemplateCloudConfig() (*Template, error) {
-	cloudInit := template.Must(template.New("base").Funcs(sprig.TxtFuncMap()).ParseFS(templateFS, "templates/cloud-init/*"))
+// yamlQuote quotes s as a double-quoted YAML scalar.
+func yamlQuote(s string) (string, error) {
+	var buff bytes.Buffer
+	enc := json.NewEncoder(&buff)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buff.String(), "\n"), nil
+}
+
+func newTemplate(pattern string, opts []Option) (*Template, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := o.extra.validate(); err != nil {
+		return nil, err
+	}
+	t, err := parse("base", pattern, o.overrides...)
+	if err != nil {
+		return nil, err
+	}
 	return &Template{
-		Template: cloudInit,
-		Values:   &templateValues{},
+		Template: t,
+		Values:   &templateValues{Extra: o.extra},
 	}, nil
 }
 
+// NewTemplateBash creates a new bash template, customized by opts.
+func NewTemplateBash(opts ...Option) (*Template, error) {
+	return newTemplate("templates/bash/*", opts)
+}
+
+// NewTemplateCloudConfig creates a new cloud-config template, customized by
+// opts.
+func NewTemplateCloudConfig(opts ...Option) (*Template, error) {
+	return newTemplate("templates/cloud-init/*", opts)
+}
+
+// providerCode returns the code of the built-in provider called fullName.
+func providerCode(fullName string) string {
+	for code, name := range model.ProviderFullNames() {
+		if name == fullName {
+			return code
+		}
+	}
+	return ""
+}
+
 // NewTemplateConfig generates a configuration file from a wizard.
 func NewTemplateConfig(value model.Wizard) (string, error) {
 	var buff bytes.Buffer
-	value.Provider = cloud.GetCloudProviderCode(value.Provider)
-	config := template.Must(template.New("config").Funcs(sprig.TxtFuncMap()).ParseFS(templateFS, "templates/config/*"))
-	err := config.ExecuteTemplate(&buff, "config", value)
+	value.Provider = providerCode(value.Provider)
+	config, err := parse("config", "templates/config/*")
+	if err != nil {
+		return "", err
+	}
+	err = config.ExecuteTemplate(&buff, "config", value)
 	if err != nil {
 		return "", err
 	}
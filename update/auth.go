@@ -0,0 +1,117 @@
+package update
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/dirien/minectl-sdk/automation"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ErrNoAuth is returned if neither a private key nor the ssh-agent is
+// configured.
+var ErrNoAuth = errors.New("ssh: no private key or agent configured")
+
+// WithPrivateKey authenticates with the PEM encoded key instead of the key
+// file.
+func (r *RemoteServer) WithPrivateKey(key []byte) *RemoteServer {
+	r.privateKey = key
+	return r
+}
+
+// WithPassphrase sets the function asked for the passphrase of an encrypted
+// private key.
+func (r *RemoteServer) WithPassphrase(passphrase automation.PassphraseFunc) *RemoteServer {
+	r.passphrase = passphrase
+	return r
+}
+
+// WithAgent authenticates with the keys of the ssh-agent listening on
+// SSH_AUTH_SOCK. If forward is set, the agent is forwarded to the commands
+// run on the server.
+func (r *RemoteServer) WithAgent(forward bool) *RemoteServer {
+	r.useAgent = true
+	r.forwardAgent = forward
+	return r
+}
+
+// authMethods returns the auth methods for a new connection. The agent
+// connection is kept until Close.
+func (r *RemoteServer) authMethods() ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+	if r.useAgent {
+		agentClient, err := r.getAgent()
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, ssh.PublicKeysCallback(agentClient.Signers))
+	}
+	if r.signer == nil && (len(r.privateKey) > 0 || r.privateSSHKey != "") {
+		signer, err := r.parsePrivateKey()
+		if err != nil {
+			return nil, err
+		}
+		r.signer = signer
+	}
+	if r.signer != nil {
+		methods = append(methods, ssh.PublicKeys(r.signer))
+	}
+	if len(methods) == 0 {
+		return nil, ErrNoAuth
+	}
+	return methods, nil
+}
+
+func (r *RemoteServer) parsePrivateKey() (ssh.Signer, error) {
+	key := r.privateKey
+	if len(key) == 0 {
+		var err error
+		key, err = os.ReadFile(r.privateSSHKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		return signer, err
+	}
+	if r.passphrase == nil {
+		return nil, fmt.Errorf("private key is encrypted and no passphrase is configured: %w", err)
+	}
+	passphrase, err := r.passphrase()
+	if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKeyWithPassphrase(key, passphrase)
+}
+
+func (r *RemoteServer) getAgent() (agent.ExtendedAgent, error) {
+	if r.agent != nil {
+		return r.agent, nil
+	}
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, errors.New("ssh: SSH_AUTH_SOCK is not set")
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to ssh agent: %w", err)
+	}
+	r.agentConn = conn
+	r.agent = agent.NewClient(conn)
+	return r.agent, nil
+}
+
+func (r *RemoteServer) closeAgent() error {
+	if r.agentConn == nil {
+		return nil
+	}
+	err := r.agentConn.Close()
+	r.agentConn = nil
+	r.agent = nil
+	return err
+}
@@ -0,0 +1,113 @@
+package update
+
+import (
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dirien/minectl-sdk/automation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func encryptKey(t *testing.T, key []byte, passphrase string) []byte {
+	t.Helper()
+	raw, err := ssh.ParseRawPrivateKey(key)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(raw, "", []byte(passphrase))
+	require.NoError(t, err)
+	return pem.EncodeToMemory(block)
+}
+
+// startAgent serves a keyring holding key on a socket set as SSH_AUTH_SOCK.
+func startAgent(t *testing.T, key []byte) {
+	t.Helper()
+	raw, err := ssh.ParseRawPrivateKey(key)
+	require.NoError(t, err)
+	keyring := agent.NewKeyring()
+	require.NoError(t, keyring.Add(agent.AddedKey{PrivateKey: raw}))
+
+	dir, err := os.MkdirTemp("", "agent")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	listener, err := net.Listen("unix", filepath.Join(dir, "agent.sock"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() { _ = agent.ServeAgent(keyring, conn) }()
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", listener.Addr().String())
+}
+
+func TestRemoteServerAuth(t *testing.T) {
+	server := newTestSSHServer(t, echoHandler)
+	encrypted := encryptKey(t, server.privateKey, "secret")
+	encryptedPath := filepath.Join(t.TempDir(), "id_ed25519")
+	require.NoError(t, os.WriteFile(encryptedPath, encrypted, 0o600))
+	passphrase := func() ([]byte, error) { return []byte("secret"), nil }
+
+	tests := []struct {
+		name    string
+		args    automation.ServerArgs
+		agent   bool
+		wantErr string
+	}{
+		{name: "key file", args: automation.ServerArgs{SSHPrivateKeyPath: server.keyPath}},
+		{name: "raw key", args: automation.ServerArgs{SSHPrivateKey: server.privateKey}},
+		{name: "raw key wins over key file", args: automation.ServerArgs{SSHPrivateKeyPath: "/does/not/exist", SSHPrivateKey: server.privateKey}},
+		{name: "encrypted key file", args: automation.ServerArgs{SSHPrivateKeyPath: encryptedPath, SSHPassphrase: passphrase}},
+		{name: "encrypted raw key", args: automation.ServerArgs{SSHPrivateKey: encrypted, SSHPassphrase: passphrase}},
+		{name: "encrypted key without passphrase", args: automation.ServerArgs{SSHPrivateKey: encrypted}, wantErr: "no passphrase is configured"},
+		{
+			name: "failing passphrase prompt",
+			args: automation.ServerArgs{SSHPrivateKey: encrypted, SSHPassphrase: func() ([]byte, error) {
+				return nil, errors.New("prompt canceled")
+			}},
+			wantErr: "prompt canceled",
+		},
+		{name: "agent", args: automation.ServerArgs{SSHAgent: true}, agent: true},
+		{name: "agent without socket", args: automation.ServerArgs{SSHAgent: true}, wantErr: "SSH_AUTH_SOCK is not set"},
+		{name: "no auth", wantErr: ErrNoAuth.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SSH_AUTH_SOCK", "")
+			if tt.agent {
+				startAgent(t, server.privateKey)
+			}
+			args := tt.args
+			args.InsecureIgnoreHostKey = true
+			remote := NewRemoteServerFromArgs(args, "127.0.0.1", RootLogin)
+			defer func() { _ = remote.Close() }()
+			out, err := remote.ExecuteCommand("uptime", server.port())
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, `ran "uptime"`, out)
+		})
+	}
+}
+
+func TestRemoteServerForwardAgent(t *testing.T) {
+	server := newTestSSHServer(t, echoHandler)
+	startAgent(t, server.privateKey)
+
+	remote := NewRemoteServerFromArgs(automation.ServerArgs{SSHForwardAgent: true, InsecureIgnoreHostKey: true}, "127.0.0.1", RootLogin)
+	defer func() { _ = remote.Close() }()
+	_, err := remote.ExecuteCommand("uptime", server.port())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"auth-agent-req@openssh.com", "exec"}, server.sessionRequests())
+}
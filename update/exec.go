@@ -0,0 +1,181 @@
+package update
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Stream names an output stream of a remote command.
+type Stream string
+
+const (
+	// Stdout is the standard output of a command.
+	Stdout Stream = "stdout"
+	// Stderr is the standard error of a command.
+	Stderr Stream = "stderr"
+)
+
+// OutputLine is a line a remote command wrote, without the line break.
+type OutputLine struct {
+	Stream Stream
+	Text   string
+}
+
+// ExecOptions configures Execute.
+type ExecOptions struct {
+	// Stdin is passed to the command as its standard input.
+	Stdin io.Reader
+	// Stdout and Stderr receive the output of the command as it arrives.
+	Stdout io.Writer
+	Stderr io.Writer
+	// Lines receives the output of the command line by line. The caller must
+	// keep reading until Execute returns, it is not closed.
+	Lines chan<- OutputLine
+	// Timeout kills the command if it runs longer. Zero means no timeout
+	// besides the deadline of the context.
+	Timeout time.Duration
+	// AsRoot runs the command as root, with sudo if the login needs it.
+	AsRoot bool
+}
+
+// ExecResult is the outcome of a remote command.
+type ExecResult struct {
+	// ExitStatus is the exit status of the command, or -1 if it did not exit
+	// normally.
+	ExitStatus int
+	// Signal is the signal that terminated the command, if any.
+	Signal   string
+	Duration time.Duration
+}
+
+// Success reports whether the command exited with status 0.
+func (e *ExecResult) Success() bool {
+	return e.ExitStatus == 0
+}
+
+// Execute runs cmd on the remote server and streams its output to opts as it
+// arrives. If the command exits with a non-zero status, the result is returned
+// together with an *ssh.ExitError. If ctx is done or the timeout passes, the
+// command is killed and the error of the context returned.
+func (r *RemoteServer) Execute(ctx context.Context, cmd string, port int, opts ExecOptions) (*ExecResult, error) {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	session, err := r.newSession(port)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = session.Close() }()
+
+	stdout := newStreamWriter(ctx, Stdout, opts.Stdout, opts.Lines)
+	stderr := newStreamWriter(ctx, Stderr, opts.Stderr, opts.Lines)
+	session.Stdin = opts.Stdin
+	session.Stdout = stdout
+	session.Stderr = stderr
+	if opts.AsRoot {
+		cmd = r.asRoot(cmd)
+	}
+
+	start := time.Now()
+	if err := session.Start(cmd); err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		_ = session.Signal(ssh.SIGKILL)
+		_ = session.Close()
+		<-done
+		return &ExecResult{ExitStatus: -1, Duration: time.Since(start)}, ctx.Err()
+	}
+	stdout.flush()
+	stderr.flush()
+
+	result := &ExecResult{Duration: time.Since(start)}
+	var exitErr *ssh.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		result.ExitStatus = exitErr.ExitStatus()
+		result.Signal = exitErr.Signal()
+		if result.Signal != "" {
+			result.ExitStatus = -1
+		}
+	case err != nil:
+		return nil, err
+	}
+	return result, err
+}
+
+// streamWriter passes the output of a stream on to a writer and splits it
+// into lines for a channel.
+type streamWriter struct {
+	ctx    context.Context
+	stream Stream
+	out    io.Writer
+	lines  chan<- OutputLine
+
+	mu      sync.Mutex
+	partial []byte
+}
+
+func newStreamWriter(ctx context.Context, stream Stream, out io.Writer, lines chan<- OutputLine) *streamWriter {
+	return &streamWriter{ctx: ctx, stream: stream, out: out, lines: lines}
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.out != nil {
+		if _, err := w.out.Write(p); err != nil {
+			return 0, err
+		}
+	}
+	if w.lines == nil {
+		return len(p), nil
+	}
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := bytes.TrimSuffix(w.partial[:i], []byte("\r"))
+		if err := w.send(string(line)); err != nil {
+			return 0, err
+		}
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush sends the last line if it did not end with a line break.
+func (w *streamWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.lines == nil || len(w.partial) == 0 {
+		return
+	}
+	_ = w.send(string(w.partial))
+	w.partial = nil
+}
+
+func (w *streamWriter) send(text string) error {
+	select {
+	case w.lines <- OutputLine{Stream: w.stream, Text: text}:
+		return nil
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	}
+}
@@ -0,0 +1,272 @@
+package update
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec // hashed known_hosts entries use HMAC-SHA1
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dirien/minectl-sdk/automation"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var (
+	// ErrHostKeyUnknown is returned by a strict HostKeyCallback for hosts
+	// without a stored key.
+	ErrHostKeyUnknown = errors.New("ssh: host key is unknown")
+	// ErrHostKeyMismatch is returned if the host presents a key different from
+	// the stored one.
+	ErrHostKeyMismatch = errors.New("ssh: host key mismatch")
+)
+
+// HostKeyStore stores the known host keys. Hosts are given in known_hosts
+// notation: "ip" for port 22 and "[ip]:port" otherwise.
+type HostKeyStore interface {
+	// Lookup returns the keys known for host. An unknown host has no keys.
+	Lookup(host string) ([]ssh.PublicKey, error)
+	// Add stores key for host, replacing a stored key of the same type.
+	Add(host string, key ssh.PublicKey) error
+}
+
+// HostKeyPolicy decides what happens when connecting to an unknown host.
+type HostKeyPolicy int
+
+const (
+	// HostKeyStrict rejects hosts without a stored key.
+	HostKeyStrict HostKeyPolicy = iota
+	// HostKeyTOFU trusts the key of a host on first use and stores it.
+	HostKeyTOFU
+)
+
+// HostKeyCallback returns an ssh.HostKeyCallback that verifies host keys
+// against store. A host presenting a different key than the stored one is
+// always rejected.
+func HostKeyCallback(store HostKeyStore, policy HostKeyPolicy) ssh.HostKeyCallback {
+	return func(hostname string, _ net.Addr, key ssh.PublicKey) error {
+		host := knownhosts.Normalize(hostname)
+		known, err := store.Lookup(host)
+		if err != nil {
+			return err
+		}
+		if len(known) == 0 {
+			if policy == HostKeyTOFU {
+				return store.Add(host, key)
+			}
+			return fmt.Errorf("%w: %s", ErrHostKeyUnknown, host)
+		}
+		for _, k := range known {
+			if k.Type() == key.Type() && bytes.Equal(k.Marshal(), key.Marshal()) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: %s presented %s %s", ErrHostKeyMismatch, host, key.Type(), ssh.FingerprintSHA256(key))
+	}
+}
+
+// GenerateHostKey creates an ed25519 host key to pass in
+// automation.ServerArgs.SSHHostKey when creating a server.
+func GenerateHostKey() (*automation.HostKey, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	block, err := ssh.MarshalPrivateKey(private, "")
+	if err != nil {
+		return nil, err
+	}
+	sshPublic, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return nil, err
+	}
+	return &automation.HostKey{
+		PrivateKey: string(pem.EncodeToMemory(block)),
+		PublicKey:  strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPublic))),
+	}, nil
+}
+
+// AddHostKey stores the public part of hostKey for the server at ip and
+// port, typically right after the server was created with it.
+func AddHostKey(store HostKeyStore, ip string, port int, hostKey *automation.HostKey) error {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hostKey.PublicKey))
+	if err != nil {
+		return err
+	}
+	return store.Add(knownhosts.Normalize(net.JoinHostPort(ip, strconv.Itoa(port))), key)
+}
+
+// MemoryHostKeyStore is a HostKeyStore kept in memory.
+type MemoryHostKeyStore struct {
+	mu   sync.Mutex
+	keys map[string][]ssh.PublicKey
+}
+
+// NewMemoryHostKeyStore creates an empty MemoryHostKeyStore.
+func NewMemoryHostKeyStore() *MemoryHostKeyStore {
+	return &MemoryHostKeyStore{keys: map[string][]ssh.PublicKey{}}
+}
+
+// Lookup returns the keys known for host.
+func (m *MemoryHostKeyStore) Lookup(host string) ([]ssh.PublicKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.keys[host], nil
+}
+
+// Add stores key for host, replacing a stored key of the same type.
+func (m *MemoryHostKeyStore) Add(host string, key ssh.PublicKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := []ssh.PublicKey{key}
+	for _, k := range m.keys[host] {
+		if k.Type() != key.Type() {
+			keys = append(keys, k)
+		}
+	}
+	m.keys[host] = keys
+	return nil
+}
+
+// KnownHostsFile is a HostKeyStore backed by a file in OpenSSH known_hosts
+// format. Plain and hashed host entries are matched, wildcard patterns and
+// @cert-authority or @revoked lines are ignored.
+type KnownHostsFile struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewKnownHostsFile creates a KnownHostsFile for the file at path. The file
+// is created on the first Add.
+func NewKnownHostsFile(path string) *KnownHostsFile {
+	return &KnownHostsFile{path: path}
+}
+
+// DefaultKnownHostsFile returns the KnownHostsFile minectl keeps in the user
+// config directory.
+func DefaultKnownHostsFile() (*KnownHostsFile, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	return NewKnownHostsFile(filepath.Join(dir, "minectl", "known_hosts")), nil
+}
+
+// Lookup returns the keys known for host.
+func (k *KnownHostsFile) Lookup(host string) ([]ssh.PublicKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	lines, err := k.read()
+	if err != nil {
+		return nil, err
+	}
+	var keys []ssh.PublicKey
+	for _, line := range lines {
+		if line.matches(host) {
+			keys = append(keys, line.key)
+		}
+	}
+	return keys, nil
+}
+
+// Add stores key for host, replacing a stored key of the same type. Other
+// lines of the file are kept as they are.
+func (k *KnownHostsFile) Add(host string, key ssh.PublicKey) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	lines, err := k.read()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for _, line := range lines {
+		if len(line.hosts) == 1 && line.key.Type() == key.Type() && line.matches(host) {
+			continue
+		}
+		buf.Write(line.raw)
+		buf.WriteByte('\n')
+	}
+	buf.WriteString(knownhosts.Line([]string{host}, key))
+	buf.WriteByte('\n')
+	err = os.MkdirAll(filepath.Dir(k.path), 0o700)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(k.path, buf.Bytes(), 0o600)
+}
+
+type knownHostsLine struct {
+	raw    []byte
+	marker string
+	hosts  []string
+	key    ssh.PublicKey
+}
+
+func (l knownHostsLine) matches(host string) bool {
+	if l.key == nil || l.marker != "" {
+		return false
+	}
+	for _, pattern := range l.hosts {
+		if matchHost(pattern, host) {
+			return true
+		}
+	}
+	return false
+}
+
+func (k *KnownHostsFile) read() ([]knownHostsLine, error) {
+	data, err := os.ReadFile(k.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var lines []knownHostsLine
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := knownHostsLine{raw: append([]byte(nil), scanner.Bytes()...)}
+		trimmed := bytes.TrimSpace(line.raw)
+		if len(trimmed) > 0 && trimmed[0] != '#' {
+			line.marker, line.hosts, line.key, _, _, err = ssh.ParseKnownHosts(trimmed)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k.path, err)
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// matchHost reports whether a host entry of a known_hosts line matches host.
+func matchHost(pattern, host string) bool {
+	if !strings.HasPrefix(pattern, "|1|") {
+		return pattern == host
+	}
+	salt, hash, ok := strings.Cut(strings.TrimPrefix(pattern, "|1|"), "|")
+	if !ok {
+		return false
+	}
+	saltBytes, err := base64.StdEncoding.DecodeString(salt)
+	if err != nil {
+		return false
+	}
+	hashBytes, err := base64.StdEncoding.DecodeString(hash)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha1.New, saltBytes)
+	mac.Write([]byte(host))
+	return hmac.Equal(mac.Sum(nil), hashBytes)
+}
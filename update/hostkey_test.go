@@ -0,0 +1,78 @@
+package update
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func generatePublicKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	hostKey, err := GenerateHostKey()
+	require.NoError(t, err)
+	signer, err := ssh.ParsePrivateKey([]byte(hostKey.PrivateKey))
+	require.NoError(t, err)
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hostKey.PublicKey))
+	require.NoError(t, err)
+	assert.Equal(t, signer.PublicKey().Marshal(), key.Marshal())
+	return key
+}
+
+func TestHostKeyCallback(t *testing.T) {
+	stores := map[string]HostKeyStore{
+		"memory": NewMemoryHostKeyStore(),
+		"file":   NewKnownHostsFile(filepath.Join(t.TempDir(), "minectl", "known_hosts")),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			key := generatePublicKey(t)
+			other := generatePublicKey(t)
+			addr := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+
+			strict := HostKeyCallback(store, HostKeyStrict)
+			assert.ErrorIs(t, strict("192.0.2.1:22", addr, key), ErrHostKeyUnknown)
+
+			tofu := HostKeyCallback(store, HostKeyTOFU)
+			require.NoError(t, tofu("192.0.2.1:22", addr, key))
+			require.NoError(t, strict("192.0.2.1:22", addr, key))
+			assert.ErrorIs(t, tofu("192.0.2.1:22", addr, other), ErrHostKeyMismatch)
+
+			// a recreated server with the same IP gets a new pre-generated key
+			require.NoError(t, store.Add("192.0.2.1", other))
+			require.NoError(t, strict("192.0.2.1:22", addr, other))
+			assert.ErrorIs(t, strict("192.0.2.1:22", addr, key), ErrHostKeyMismatch)
+
+			require.NoError(t, tofu("192.0.2.1:2222", addr, key))
+			keys, err := store.Lookup("[192.0.2.1]:2222")
+			require.NoError(t, err)
+			assert.Len(t, keys, 1)
+		})
+	}
+}
+
+func TestKnownHostsFileHashedAndForeignEntries(t *testing.T) {
+	key := generatePublicKey(t)
+	other := generatePublicKey(t)
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	content := "# managed by hand\n" +
+		knownhosts.Line([]string{knownhosts.HashHostname("192.0.2.7")}, key) + "\n" +
+		knownhosts.Line([]string{"192.0.2.8", "192.0.2.9"}, other) + "\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	store := NewKnownHostsFile(path)
+
+	keys, err := store.Lookup("192.0.2.7")
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, key.Marshal(), keys[0].Marshal())
+
+	require.NoError(t, store.Add("192.0.2.10", key))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, content+knownhosts.Line([]string{"192.0.2.10"}, key)+"\n", string(data))
+}
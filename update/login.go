@@ -0,0 +1,84 @@
+package update
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"path/filepath"
+
+	"github.com/dirien/minectl-sdk/common"
+)
+
+// Login is the user minectl logs in as on the servers of a provider.
+type Login struct {
+	User string
+	// Sudo is set if User is not root, so commands and transfers into
+	// root-owned paths like /minecraft need sudo.
+	Sudo bool
+}
+
+// Logins used by the providers.
+var (
+	// RootLogin logs in as root.
+	RootLogin = Login{User: "root"}
+	// UbuntuLogin logs in as the default user of the Ubuntu cloud images.
+	UbuntuLogin = Login{User: "ubuntu", Sudo: true}
+)
+
+// LoginProvider is implemented by the providers whose servers are reached
+// over SSH, to tell the user minectl logs in as.
+type LoginProvider interface {
+	SSHLogin() Login
+}
+
+// WithSudo sets whether commands and transfers into root-owned paths need
+// sudo. NewRemoteServer enables it for all users but root.
+func (r *RemoteServer) WithSudo(sudo bool) *RemoteServer {
+	r.sudo = sudo
+	return r
+}
+
+// UploadPlugin copies plugin into the destination folder on the server and
+// restarts the Minecraft server.
+func (r *RemoteServer) UploadPlugin(plugin, destination string, port int) error {
+	return r.UploadPluginContext(context.Background(), plugin, destination, port)
+}
+
+// UploadPluginContext is like UploadPlugin but aborts the upload and the
+// restart if ctx is done.
+func (r *RemoteServer) UploadPluginContext(ctx context.Context, plugin, destination string, port int) error {
+	err := r.TransferFileContext(ctx, plugin, path.Join(destination, filepath.Base(plugin)), port)
+	if err != nil {
+		return err
+	}
+	_, err = r.run(ctx, "systemctl restart minecraft.service", port)
+	return err
+}
+
+// transferWithSudo uploads src to a temporary file the user may write and
+// moves it to dstPath with sudo.
+func (r *RemoteServer) transferWithSudo(ctx context.Context, src, dstPath string, port int) error {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	tmp := fmt.Sprintf("/tmp/minectl-%s-%s", hex.EncodeToString(suffix), path.Base(dstPath))
+	if err := r.upload(ctx, src, tmp, port); err != nil {
+		return err
+	}
+	cmd := fmt.Sprintf("install -m 0644 %s %s; status=$?; rm -f %s; exit $status", common.ShellQuote(tmp), common.ShellQuote(dstPath), common.ShellQuote(tmp))
+	if _, err := r.run(ctx, cmd, port); err != nil {
+		return fmt.Errorf("moving %s to %s: %w", tmp, dstPath, err)
+	}
+	return nil
+}
+
+// asRoot wraps cmd in sudo if the user is not root.
+func (r *RemoteServer) asRoot(cmd string) string {
+	if !r.sudo {
+		return cmd
+	}
+	return "sudo -n sh -c " + common.ShellQuote(cmd)
+}
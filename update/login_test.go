@@ -0,0 +1,91 @@
+package update
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// shellHandler runs commands with the local shell, dropping the sudo a
+// non-root login adds. systemctl commands are only recorded.
+func shellHandler(command string, stdout, stderr io.Writer) uint32 {
+	if strings.Contains(command, "systemctl") {
+		return 0
+	}
+	cmd := exec.Command("sh", "-c", strings.TrimPrefix(command, "sudo -n ")) //nolint:gosec // test commands
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	var exitErr *exec.ExitError
+	if err := cmd.Run(); errors.As(err, &exitErr) {
+		return uint32(exitErr.ExitCode()) //nolint:gosec // exit codes are small
+	} else if err != nil {
+		return 127
+	}
+	return 0
+}
+
+func TestRemoteServerUploadPlugin(t *testing.T) {
+	tests := []struct {
+		name  string
+		login Login
+		sudo  bool
+	}{
+		{name: "root", login: RootLogin},
+		{name: "ubuntu", login: UbuntuLogin, sudo: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestSSHServer(t, shellHandler)
+			plugin := filepath.Join(t.TempDir(), "it's-a-plugin.jar")
+			require.NoError(t, os.WriteFile(plugin, []byte("plugin"), 0o600))
+			destination := t.TempDir()
+
+			remote := server.remote().WithSudo(tt.login.Sudo)
+			defer func() { _ = remote.Close() }()
+			require.NoError(t, remote.UploadPlugin(plugin, destination, server.port()))
+
+			data, err := os.ReadFile(filepath.Join(destination, "it's-a-plugin.jar"))
+			require.NoError(t, err)
+			assert.Equal(t, "plugin", string(data))
+
+			commands := server.commands()
+			require.NotEmpty(t, commands)
+			assert.Equal(t, tt.sudo, strings.HasPrefix(commands[len(commands)-1], "sudo -n sh -c "))
+			assert.Contains(t, commands[len(commands)-1], "systemctl restart minecraft.service")
+			// the temporary upload is removed
+			leftovers, err := filepath.Glob("/tmp/minectl-*-it's-a-plugin.jar")
+			require.NoError(t, err)
+			assert.Empty(t, leftovers)
+		})
+	}
+}
+
+func TestRemoteServerUploadPluginCanceled(t *testing.T) {
+	server := newTestSSHServer(t, shellHandler)
+	plugin := filepath.Join(t.TempDir(), "plugin.jar")
+	require.NoError(t, os.WriteFile(plugin, []byte("plugin"), 0o600))
+	destination := t.TempDir()
+
+	remote := server.remote()
+	defer func() { _ = remote.Close() }()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := remote.UploadPluginContext(ctx, plugin, destination, server.port())
+	require.ErrorIs(t, err, context.Canceled)
+	assert.NoFileExists(t, filepath.Join(destination, "plugin.jar"))
+	assert.Empty(t, server.commands())
+}
+
+func TestAsRoot(t *testing.T) {
+	remote := NewRemoteServer("", "127.0.0.1", "ubuntu")
+	assert.Equal(t, `sudo -n sh -c 'echo '\''hi'\'''`, remote.asRoot("echo 'hi'"))
+	assert.Equal(t, "echo hi", NewRemoteServer("", "127.0.0.1", "root").asRoot("echo hi"))
+}
@@ -0,0 +1,87 @@
+package update
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/dirien/minectl-sdk/common"
+	"github.com/dirien/minectl-sdk/model"
+	"github.com/dirien/minectl-sdk/plugins"
+	"go.uber.org/zap"
+)
+
+// serverDir is the directory of the Minecraft server.
+var serverDir = "/minecraft"
+
+// SyncPlugins makes the plugins and mods on the server match the ones of
+// args: it installs the missing jars, upgrades the ones whose checksum
+// changed and removes the ones minectl installed that are no longer listed.
+// Jars installed by other means are left alone. The Minecraft server is
+// restarted if anything changed.
+func (r *RemoteServer) SyncPlugins(ctx context.Context, args *model.MinecraftResource) error {
+	changed, err := r.syncPlugins(ctx, args)
+	if err != nil || !changed {
+		return err
+	}
+	_, err = r.run(ctx, "systemctl restart minecraft.service", args.GetSSHPort())
+	return err
+}
+
+// syncPlugins is SyncPlugins without the restart. It reports whether it
+// changed anything.
+func (r *RemoteServer) syncPlugins(ctx context.Context, args *model.MinecraftResource) (bool, error) {
+	artifacts, err := plugins.Resolve(ctx, args)
+	if err != nil {
+		return false, err
+	}
+	port := args.GetSSHPort()
+	manifest := path.Join(serverDir, plugins.ManifestFile)
+	out, err := r.run(ctx, fmt.Sprintf("cat %s 2>/dev/null || true", common.ShellQuote(manifest)), port)
+	if err != nil {
+		return false, err
+	}
+	install, remove := plugins.Diff(plugins.ParseManifest(out), artifacts)
+	if len(install) == 0 && len(remove) == 0 {
+		return false, nil
+	}
+
+	for _, artifact := range install {
+		zap.S().Infow("Installing plugin", "path", artifact.Path)
+		if artifact.URL != "" {
+			if _, err := r.run(ctx, artifact.InstallCommand(serverDir), port); err != nil {
+				return false, err
+			}
+			continue
+		}
+		dst := path.Join(serverDir, artifact.Path)
+		if _, err := r.run(ctx, "mkdir -p "+common.ShellQuote(path.Dir(dst)), port); err != nil {
+			return false, err
+		}
+		if err := r.TransferFileContext(ctx, artifact.File, dst, port); err != nil {
+			return false, err
+		}
+	}
+	var cmds []string
+	for _, p := range remove {
+		zap.S().Infow("Removing plugin", "path", p)
+		cmds = append(cmds, "rm -f "+common.ShellQuote(path.Join(serverDir, p)))
+	}
+	cmds = append(cmds, fmt.Sprintf("printf %%s %s > %s", common.ShellQuote(plugins.Manifest(artifacts)), common.ShellQuote(manifest)))
+	if _, err := r.run(ctx, strings.Join(cmds, " && "), port); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// run runs cmd as root and returns its combined output, which is added to
+// the error if it fails. The command is killed if ctx is done.
+func (r *RemoteServer) run(ctx context.Context, cmd string, port int) (string, error) {
+	var out bytes.Buffer
+	if _, err := r.Execute(ctx, cmd, port, ExecOptions{Stdout: &out, Stderr: &out, AsRoot: true}); err != nil {
+		return out.String(), fmt.Errorf("%s: %w: %s", cmd, err, strings.TrimSpace(out.String()))
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,101 @@
+package update
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dirien/minectl-sdk/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteServerSyncPlugins(t *testing.T) {
+	dir := serverDir
+	serverDir = t.TempDir()
+	t.Cleanup(func() { serverDir = dir })
+	server := newTestSSHServer(t, shellHandler)
+	remote := server.remote()
+	defer func() { _ = remote.Close() }()
+
+	jars := t.TempDir()
+	jar := func(name, content string) string {
+		p := filepath.Join(jars, name)
+		require.NoError(t, os.WriteFile(p, []byte(content), 0o600))
+		return p
+	}
+	checksum := func(content string) string {
+		sum := sha256.Sum256([]byte(content))
+		return "sha256:" + hex.EncodeToString(sum[:])
+	}
+	installed := func(p string) string {
+		data, err := os.ReadFile(filepath.Join(serverDir, p))
+		if os.IsNotExist(err) {
+			return ""
+		}
+		require.NoError(t, err)
+		return string(data)
+	}
+	args := &model.MinecraftResource{Spec: model.Spec{
+		Server:    model.Server{SSH: model.SSH{Port: server.port()}},
+		Minecraft: model.Minecraft{Edition: "papermc"},
+	}}
+
+	args.Spec.Minecraft.Plugins = []model.Plugin{{File: jar("a.jar", "a1")}, {File: jar("b.jar", "b1")}}
+	require.NoError(t, remote.SyncPlugins(context.Background(), args))
+	assert.Equal(t, "a1", installed("plugins/a.jar"))
+	assert.Equal(t, "b1", installed("plugins/b.jar"))
+	assert.Equal(t, "plugins/a.jar "+checksum("a1")+"\nplugins/b.jar "+checksum("b1")+"\n", installed(".minectl-plugins"))
+	assert.Contains(t, server.commands(), "systemctl restart minecraft.service")
+
+	// a jar that minectl did not install is left alone
+	require.NoError(t, os.WriteFile(filepath.Join(serverDir, "plugins", "foreign.jar"), []byte("foreign"), 0o600))
+	args.Spec.Minecraft.Plugins = []model.Plugin{{File: jar("b.jar", "b2")}}
+	require.NoError(t, remote.SyncPlugins(context.Background(), args))
+	assert.Empty(t, installed("plugins/a.jar"))
+	assert.Equal(t, "b2", installed("plugins/b.jar"))
+	assert.Equal(t, "foreign", installed("plugins/foreign.jar"))
+	assert.Equal(t, "plugins/b.jar "+checksum("b2")+"\n", installed(".minectl-plugins"))
+
+	// nothing changed, so the server is not restarted
+	commands := len(server.commands())
+	require.NoError(t, remote.SyncPlugins(context.Background(), args))
+	assert.Len(t, server.commands(), commands+1)
+}
+
+func TestRemoteServerUpdateSyncsPlugins(t *testing.T) {
+	dir := serverDir
+	serverDir = t.TempDir()
+	t.Cleanup(func() { serverDir = dir })
+	server := newTestSSHServer(t, shellHandler)
+	remote := server.remote()
+	defer func() { _ = remote.Close() }()
+
+	jar := filepath.Join(t.TempDir(), "a.jar")
+	require.NoError(t, os.WriteFile(jar, []byte("a1"), 0o600))
+	args := &model.MinecraftResource{Spec: model.Spec{
+		Server: model.Server{SSH: model.SSH{Port: server.port()}},
+		Minecraft: model.Minecraft{
+			Edition: "papermc",
+			Version: "1.20.4-496",
+			Java:    model.Java{OpenJDK: 17},
+			Plugins: []model.Plugin{{File: jar}},
+		},
+	}}
+	_, err := remote.UpdateServerStream(context.Background(), args, ExecOptions{})
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(serverDir, "plugins", "a.jar"))
+	require.NoError(t, err)
+
+	// the plugins are installed while the server is stopped, it is only
+	// started once afterwards
+	commands := server.commands()
+	assert.Equal(t, "systemctl start minecraft.service", commands[len(commands)-1])
+	for _, command := range commands[:len(commands)-1] {
+		assert.NotContains(t, command, "systemctl start")
+		assert.NotContains(t, command, "systemctl restart")
+	}
+}
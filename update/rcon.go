@@ -0,0 +1,263 @@
+package update
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dirien/minectl-sdk/model"
+)
+
+// Packet types of the Source RCON protocol.
+const (
+	rconTypeResponseValue = 0
+	rconTypeExecCommand   = 2
+	rconTypeAuthResponse  = 2
+	rconTypeAuth          = 3
+)
+
+const (
+	// rconMaxBody is the largest body a Minecraft server accepts in a request.
+	rconMaxBody = 1446
+	// rconMaxPacket is the largest packet a Minecraft server sends.
+	rconMaxPacket = 4096 + 10
+	// rconHeaderSize is the size of the request id, the type and the two
+	// terminating null bytes.
+	rconHeaderSize = 10
+)
+
+// DefaultRconTimeout is used for dialing and every request if the context has
+// no deadline.
+const DefaultRconTimeout = 10 * time.Second
+
+// ErrRconAuth is returned if the server rejects the RCON password.
+var ErrRconAuth = errors.New("rcon: authentication failed")
+
+// RconClient is a client for the Source RCON protocol spoken by Minecraft
+// servers. It connects lazily and reconnects once if the connection is lost.
+// An RconClient is safe for concurrent use.
+type RconClient struct {
+	address  string
+	password string
+	timeout  time.Duration
+
+	mu     sync.Mutex
+	conn   net.Conn
+	nextID int32
+}
+
+// NewRconClient creates a client for the RCON server at address (host:port).
+func NewRconClient(address, password string) *RconClient {
+	return &RconClient{
+		address:  address,
+		password: password,
+		timeout:  DefaultRconTimeout,
+	}
+}
+
+// Rcon creates an RCON client for the Minecraft server of args on this host.
+func (r *RemoteServer) Rcon(args *model.MinecraftResource) (*RconClient, error) {
+	if !args.HasRCON() {
+		return nil, fmt.Errorf("rcon is not enabled for %s", args.GetName())
+	}
+	return NewRconClient(net.JoinHostPort(r.ip, strconv.Itoa(args.GetRCONPort())), args.GetRCONPassword()), nil
+}
+
+// Connect opens and authenticates the connection. Calling it is optional, as
+// Execute connects on first use.
+func (c *RconClient) Connect(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connect(ctx)
+}
+
+// Execute runs command and returns its output. Responses split over several
+// packets are joined. If the connection was lost, Execute reconnects once.
+func (c *RconClient) Execute(ctx context.Context, command string) (string, error) {
+	if len(command) > rconMaxBody {
+		return "", fmt.Errorf("rcon: command is %d bytes, the limit is %d", len(command), rconMaxBody)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fresh := c.conn == nil
+	if err := c.connect(ctx); err != nil {
+		return "", err
+	}
+	response, err := c.execute(ctx, command)
+	if err != nil && !fresh && isConnectionError(err) {
+		c.closeConn()
+		if err := c.connect(ctx); err != nil {
+			return "", err
+		}
+		response, err = c.execute(ctx, command)
+	}
+	if err != nil {
+		c.closeConn()
+		return "", err
+	}
+	return response, nil
+}
+
+// Say broadcasts message to all players.
+func (c *RconClient) Say(ctx context.Context, message string) error {
+	_, err := c.Execute(ctx, fmt.Sprintf("say %s", message))
+	return err
+}
+
+// SaveAll flushes the world to disk.
+func (c *RconClient) SaveAll(ctx context.Context) (string, error) {
+	return c.Execute(ctx, "save-all")
+}
+
+// WhitelistAdd adds player to the whitelist.
+func (c *RconClient) WhitelistAdd(ctx context.Context, player string) (string, error) {
+	return c.Execute(ctx, fmt.Sprintf("whitelist add %s", player))
+}
+
+// Close closes the connection.
+func (c *RconClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+func (c *RconClient) closeConn() {
+	if c.conn != nil {
+		_ = c.conn.Close()
+		c.conn = nil
+	}
+}
+
+func (c *RconClient) connect(ctx context.Context) error {
+	if c.conn != nil {
+		return nil
+	}
+	dialer := &net.Dialer{Timeout: c.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", c.address)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	if err := c.setDeadline(ctx); err != nil {
+		c.closeConn()
+		return err
+	}
+	id := c.newID()
+	if err := c.write(id, rconTypeAuth, c.password); err != nil {
+		c.closeConn()
+		return err
+	}
+	// Source servers send an empty response value before the auth response,
+	// Minecraft only sends the auth response.
+	for {
+		responseID, responseType, _, err := c.read()
+		if err != nil {
+			c.closeConn()
+			return err
+		}
+		if responseType != rconTypeAuthResponse {
+			continue
+		}
+		if responseID != id {
+			c.closeConn()
+			return ErrRconAuth
+		}
+		return nil
+	}
+}
+
+func (c *RconClient) execute(ctx context.Context, command string) (string, error) {
+	if err := c.setDeadline(ctx); err != nil {
+		return "", err
+	}
+	id := c.newID()
+	if err := c.write(id, rconTypeExecCommand, command); err != nil {
+		return "", err
+	}
+	// The protocol does not mark the last packet of a response. Minecraft
+	// answers requests in order, so a second request with an unknown type
+	// marks the end of the first response once its answer arrives.
+	endID := c.newID()
+	if err := c.write(endID, rconTypeResponseValue, ""); err != nil {
+		return "", err
+	}
+	var response bytes.Buffer
+	for {
+		responseID, _, body, err := c.read()
+		if err != nil {
+			return "", err
+		}
+		switch responseID {
+		case id:
+			response.Write(body)
+		case endID:
+			return response.String(), nil
+		case -1:
+			return "", ErrRconAuth
+		}
+	}
+}
+
+func (c *RconClient) newID() int32 {
+	c.nextID++
+	if c.nextID <= 0 {
+		c.nextID = 1
+	}
+	return c.nextID
+}
+
+func (c *RconClient) setDeadline(ctx context.Context) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(c.timeout)
+	}
+	return c.conn.SetDeadline(deadline)
+}
+
+func (c *RconClient) write(id, packetType int32, body string) error {
+	packet := make([]byte, 0, 4+rconHeaderSize+len(body))
+	packet = binary.LittleEndian.AppendUint32(packet, uint32(rconHeaderSize+len(body))) //nolint:gosec // body is limited to rconMaxBody
+	packet = binary.LittleEndian.AppendUint32(packet, uint32(id))                       //nolint:gosec // ids are positive
+	packet = binary.LittleEndian.AppendUint32(packet, uint32(packetType))               //nolint:gosec // packet types are constants
+	packet = append(packet, body...)
+	packet = append(packet, 0, 0)
+	_, err := c.conn.Write(packet)
+	return err
+}
+
+func (c *RconClient) read() (id, packetType int32, body []byte, err error) {
+	var size int32
+	if err := binary.Read(c.conn, binary.LittleEndian, &size); err != nil {
+		return 0, 0, nil, err
+	}
+	if size < rconHeaderSize || size > rconMaxPacket {
+		return 0, 0, nil, fmt.Errorf("rcon: invalid packet size %d", size)
+	}
+	packet := make([]byte, size)
+	if _, err := io.ReadFull(c.conn, packet); err != nil {
+		return 0, 0, nil, err
+	}
+	id = int32(binary.LittleEndian.Uint32(packet[0:4]))         //nolint:gosec // wire format is signed
+	packetType = int32(binary.LittleEndian.Uint32(packet[4:8])) //nolint:gosec // wire format is signed
+	return id, packetType, packet[8 : size-2], nil
+}
+
+func isConnectionError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return false
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) || errors.As(err, new(*net.OpError))
+}
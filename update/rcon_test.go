@@ -0,0 +1,177 @@
+package update
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dirien/minectl-sdk/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRconServer speaks the RCON dialect of a Minecraft server.
+type fakeRconServer struct {
+	listener net.Listener
+	password string
+
+	mu       sync.Mutex
+	conns    []net.Conn
+	accepted int
+	commands []string
+}
+
+func newFakeRconServer(t *testing.T, password string) *fakeRconServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &fakeRconServer{listener: listener, password: password}
+	t.Cleanup(func() {
+		_ = listener.Close()
+		s.dropConnections()
+	})
+	go s.serve()
+	return s
+}
+
+func (s *fakeRconServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.accepted++
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeRconServer) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *fakeRconServer) handle(conn net.Conn) {
+	authenticated := false
+	for {
+		var size int32
+		if err := binary.Read(conn, binary.LittleEndian, &size); err != nil {
+			return
+		}
+		packet := make([]byte, size)
+		if _, err := io.ReadFull(conn, packet); err != nil {
+			return
+		}
+		id := int32(binary.LittleEndian.Uint32(packet[0:4]))
+		packetType := int32(binary.LittleEndian.Uint32(packet[4:8]))
+		body := string(packet[8 : size-2])
+		switch {
+		case packetType == rconTypeAuth && body == s.password:
+			authenticated = true
+			writeRconPacket(conn, id, rconTypeAuthResponse, "")
+		case packetType == rconTypeAuth:
+			writeRconPacket(conn, -1, rconTypeAuthResponse, "")
+		case !authenticated:
+			return
+		case packetType == rconTypeExecCommand:
+			s.mu.Lock()
+			s.commands = append(s.commands, body)
+			s.mu.Unlock()
+			if body == "list" {
+				// split like a Minecraft server does for long responses
+				for _, part := range []string{"There are 3 players: ", "alice, bob, ", "carol"} {
+					writeRconPacket(conn, id, rconTypeResponseValue, part)
+				}
+				continue
+			}
+			writeRconPacket(conn, id, rconTypeResponseValue, "ok: "+body)
+		default:
+			writeRconPacket(conn, id, rconTypeResponseValue, "Unknown request 0")
+		}
+	}
+}
+
+func writeRconPacket(conn net.Conn, id, packetType int32, body string) {
+	packet := binary.LittleEndian.AppendUint32(nil, uint32(len(body)+rconHeaderSize))
+	packet = binary.LittleEndian.AppendUint32(packet, uint32(id))
+	packet = binary.LittleEndian.AppendUint32(packet, uint32(packetType))
+	packet = append(packet, body...)
+	packet = append(packet, 0, 0)
+	_, _ = conn.Write(packet)
+}
+
+func TestRconExecute(t *testing.T) {
+	server := newFakeRconServer(t, "secret")
+	client := NewRconClient(server.listener.Addr().String(), "secret")
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	out, err := client.SaveAll(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "ok: save-all", out)
+
+	out, err = client.Execute(ctx, "list")
+	require.NoError(t, err)
+	assert.Equal(t, "There are 3 players: alice, bob, carol", out)
+
+	out, err = client.WhitelistAdd(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "ok: whitelist add alice", out)
+	require.NoError(t, client.Say(ctx, "hello"))
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	assert.Equal(t, []string{"save-all", "list", "whitelist add alice", "say hello"}, server.commands)
+	assert.Equal(t, 1, server.accepted)
+}
+
+func TestRconReconnect(t *testing.T) {
+	server := newFakeRconServer(t, "secret")
+	client := NewRconClient(server.listener.Addr().String(), "secret")
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	require.NoError(t, client.Connect(ctx))
+	server.dropConnections()
+
+	out, err := client.Execute(ctx, "save-all")
+	require.NoError(t, err)
+	assert.Equal(t, "ok: save-all", out)
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	assert.Equal(t, 2, server.accepted)
+}
+
+func TestRconAuthFailure(t *testing.T) {
+	server := newFakeRconServer(t, "secret")
+	client := NewRconClient(server.listener.Addr().String(), "wrong")
+	_, err := client.Execute(context.Background(), "save-all")
+	assert.ErrorIs(t, err, ErrRconAuth)
+}
+
+func TestRemoteServerRcon(t *testing.T) {
+	resource := &model.MinecraftResource{Spec: model.Spec{Minecraft: model.Minecraft{
+		Edition: "java",
+		Java:    model.Java{Rcon: model.Rcon{Enabled: true, Port: 25575, Password: "secret"}},
+	}}}
+	client, err := NewRemoteServer("", "192.0.2.1", "root").Rcon(resource)
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.1:25575", client.address)
+
+	resource.Spec.Minecraft.Java.Rcon.Enabled = false
+	_, err = NewRemoteServer("", "192.0.2.1", "root").Rcon(resource)
+	assert.Error(t, err)
+
+	_, err = NewRconClient("127.0.0.1:1", "").Execute(context.Background(), strings.Repeat("a", rconMaxBody+1))
+	assert.Error(t, err)
+}
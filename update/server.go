@@ -2,12 +2,20 @@
 package update
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/dirien/minectl-sdk/automation"
+	"github.com/dirien/minectl-sdk/common"
 	minctlTemplate "github.com/dirien/minectl-sdk/template"
 	"go.uber.org/zap"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 
 	"github.com/dirien/minectl-sdk/model"
 	"github.com/melbahja/goph"
@@ -18,125 +26,334 @@ type ServerOperations interface {
 	UpdateServer(*model.MinecraftResource) error
 }
 
-// RemoteServer represents a remote server connection.
+// KeepAliveInterval is how often an idle SSH connection is kept alive.
+var KeepAliveInterval = 30 * time.Second
+
+// RemoteServer represents a remote server connection. Commands and transfers
+// share one SSH connection, which is dialed on first use and redialed if it
+// is lost. Call Close when done with the server. A RemoteServer is safe for
+// concurrent use.
 type RemoteServer struct {
-	ip            string
-	privateSSHKey string
-	user          string
+	ip              string
+	privateSSHKey   string
+	user            string
+	sudo            bool
+	hostKeyCallback ssh.HostKeyCallback
+	insecureHostKey bool
+	privateKey      []byte
+	passphrase      automation.PassphraseFunc
+	useAgent        bool
+	forwardAgent    bool
+	templateOptions []minctlTemplate.Option
+
+	mu            sync.Mutex
+	signer        ssh.Signer
+	agent         agent.ExtendedAgent
+	agentConn     net.Conn
+	client        *goph.Client
+	port          int
+	stopKeepAlive chan struct{}
 }
 
-// NewRemoteServer creates a new RemoteServer instance.
+// NewRemoteServer creates a new RemoteServer instance. Users other than root
+// escalate with sudo, see WithSudo.
 func NewRemoteServer(privateKey, ip, user string) *RemoteServer {
 	ssh := &RemoteServer{
 		ip:            ip,
 		privateSSHKey: privateKey,
 		user:          user,
+		sudo:          user != "root",
 	}
 	return ssh
 }
 
-// UpdateServer updates the Minecraft server software.
+// NewRemoteServerFromArgs creates a new RemoteServer instance that logs in
+// with login and the SSH settings of args.
+func NewRemoteServerFromArgs(args automation.ServerArgs, ip string, login Login) *RemoteServer {
+	remote := NewRemoteServer(args.SSHPrivateKeyPath, ip, login.User)
+	remote.sudo = login.Sudo
+	remote.hostKeyCallback = args.HostKeyCallback
+	remote.insecureHostKey = args.InsecureIgnoreHostKey
+	remote.privateKey = args.SSHPrivateKey
+	remote.passphrase = args.SSHPassphrase
+	remote.useAgent = args.SSHAgent || args.SSHForwardAgent
+	remote.forwardAgent = args.SSHForwardAgent
+	return remote
+}
+
+// WithHostKeyCallback sets the callback that verifies the host key of the
+// server, see HostKeyCallback. Without one, the key is trusted on first use
+// and checked against DefaultKnownHostsFile afterwards.
+func (r *RemoteServer) WithHostKeyCallback(callback ssh.HostKeyCallback) *RemoteServer {
+	r.hostKeyCallback = callback
+	return r
+}
+
+// WithTemplateOptions sets the options of the bash templates that update the
+// server software, so overrides given to the provider apply to updates too.
+func (r *RemoteServer) WithTemplateOptions(opts ...minctlTemplate.Option) *RemoteServer {
+	r.templateOptions = opts
+	return r
+}
+
+// WithInsecureIgnoreHostKey turns off the verification of the host key. It
+// is meant for tests and throwaway servers only.
+func (r *RemoteServer) WithInsecureIgnoreHostKey() *RemoteServer {
+	r.insecureHostKey = true
+	return r
+}
+
+func (r *RemoteServer) getHostKeyCallback() (ssh.HostKeyCallback, error) {
+	switch {
+	case r.hostKeyCallback != nil:
+		return r.hostKeyCallback, nil
+	case r.insecureHostKey:
+		return ssh.InsecureIgnoreHostKey(), nil //nolint:gosec // explicitly requested by the caller
+	}
+	store, err := DefaultKnownHostsFile()
+	if err != nil {
+		return nil, fmt.Errorf("host key verification: %w", err)
+	}
+	return HostKeyCallback(store, HostKeyTOFU), nil
+}
+
+// UpdateServer updates the Minecraft server software and syncs the plugins
+// and mods.
 func (r *RemoteServer) UpdateServer(args *model.MinecraftResource) error {
-	tmpl := minctlTemplate.GetUpdateTemplate()
-	var update string
-	var err error
-
-	switch args.GetEdition() {
-	case "java":
-		update, err = tmpl.DoUpdate(args, &minctlTemplate.CreateUpdateTemplateArgs{Name: minctlTemplate.TemplateJavaBinary})
-	case "bedrock":
-		update, err = tmpl.DoUpdate(args, &minctlTemplate.CreateUpdateTemplateArgs{Name: minctlTemplate.TemplateBedrockBinary})
-	case "craftbukkit":
-		update, err = tmpl.DoUpdate(args, &minctlTemplate.CreateUpdateTemplateArgs{Name: minctlTemplate.TemplateSpigotBukkitBinary})
-	case "spigot":
-		update, err = tmpl.DoUpdate(args, &minctlTemplate.CreateUpdateTemplateArgs{Name: minctlTemplate.TemplateSpigotBukkitBinary})
-	case "fabric":
-		update, err = tmpl.DoUpdate(args, &minctlTemplate.CreateUpdateTemplateArgs{Name: minctlTemplate.TemplateFabricBinary})
-		update = fmt.Sprintf("\nrm -rf /minecraft/minecraft-server.jar%s", update)
-	case "forge":
-		update, err = tmpl.DoUpdate(args, &minctlTemplate.CreateUpdateTemplateArgs{Name: minctlTemplate.TemplateForgeBinary})
-	case "papermc":
-		update, err = tmpl.DoUpdate(args, &minctlTemplate.CreateUpdateTemplateArgs{Name: minctlTemplate.TemplatePaperMCBinary})
-	case "purpur":
-		update, err = tmpl.DoUpdate(args, &minctlTemplate.CreateUpdateTemplateArgs{Name: minctlTemplate.TemplatePurpurBinary})
-	case "bungeecord":
-		update, err = tmpl.DoUpdate(args, &minctlTemplate.CreateUpdateTemplateArgs{Name: minctlTemplate.TemplateBungeeCordBinary})
-	case "waterfall":
-		update, err = tmpl.DoUpdate(args, &minctlTemplate.CreateUpdateTemplateArgs{Name: minctlTemplate.TemplateWaterfallBinary})
-	case "nukkit":
-		update, err = tmpl.DoUpdate(args, &minctlTemplate.CreateUpdateTemplateArgs{Name: minctlTemplate.TemplateNukkitBinary})
-	case "powernukkit":
-		update, err = tmpl.DoUpdate(args, &minctlTemplate.CreateUpdateTemplateArgs{Name: minctlTemplate.TemplatePowerNukkitBinary})
-	case "velocity":
-		update, err = tmpl.DoUpdate(args, &minctlTemplate.CreateUpdateTemplateArgs{Name: minctlTemplate.TemplateVelocityBinary})
-	}
-
-	if args.GetEdition() != "bedrock" {
-		update = fmt.Sprintf("%s\napt-get install -y openjdk-%d-jre-headless\n", update, args.GetJDKVersion())
+	_, err := r.UpdateServerStream(context.Background(), args, ExecOptions{})
+	return err
+}
+
+// UpdateServerStream is like UpdateServer but streams the output of the
+// update to opts, which helps to follow long running builds like the one of
+// Spigot BuildTools. The plugins and mods are synced while the server is
+// stopped for the update, see SyncPlugins, so it is only started once.
+func (r *RemoteServer) UpdateServerStream(ctx context.Context, args *model.MinecraftResource, opts ExecOptions) (*ExecResult, error) {
+	cmd, err := updateCommand(ctx, args, r.templateOptions)
+	if err != nil {
+		return nil, err
+	}
+	zap.S().Infof("server updated cmd %s", cmd)
+	port := args.GetSSHPort()
+	result, err := r.Execute(ctx, cmd, port, opts)
+	if err == nil {
+		_, err = r.syncPlugins(ctx, args)
 	}
+	// start the server again, even if the update failed
+	if _, startErr := r.run(ctx, "systemctl start minecraft.service", port); startErr != nil {
+		err = errors.Join(err, startErr)
+	}
+	return result, err
+}
+
+func updateCommand(ctx context.Context, args *model.MinecraftResource, opts []minctlTemplate.Option) (string, error) {
+	edition, err := args.GetEditionSpec()
 	if err != nil {
-		return err
+		return "", err
+	}
+	tmpl, err := minctlTemplate.NewTemplateBash(opts...)
+	if err != nil {
+		return "", err
+	}
+	update, err := tmpl.DoUpdateContext(ctx, args, &minctlTemplate.CreateUpdateTemplateArgs{Name: minctlTemplate.Name(edition.Binary)})
+	if err != nil {
+		return "", err
+	}
+	if edition.PreUpdate != "" {
+		update = fmt.Sprintf("\n%s%s", edition.PreUpdate, update)
+	}
+	if edition.JVM {
+		update = fmt.Sprintf("%s\napt-get install -y openjdk-%d-jre-headless\n", update, args.GetJDKVersion())
 	}
 
 	cmd := `
 cd /minecraft
 sudo systemctl stop minecraft.service
-sudo bash -c '` + update + `'
+sudo bash -c ` + common.ShellQuote(update) + `
 ls -la
-sudo systemctl start minecraft.service
 	`
-	zap.S().Infof("server updated cmd %s", cmd)
-	_, err = r.ExecuteCommand(strings.TrimSpace(cmd), args.GetSSHPort())
-	if err != nil {
+	return strings.TrimSpace(cmd), nil
+}
+
+// TransferFile uploads a file to the remote server. If the user needs sudo,
+// the file is uploaded to /tmp first and moved to dstPath as root.
+func (r *RemoteServer) TransferFile(src, dstPath string, port int) error {
+	return r.TransferFileContext(context.Background(), src, dstPath, port)
+}
+
+// TransferFileContext is like TransferFile but aborts the transfer if ctx is
+// done, by closing the connection.
+func (r *RemoteServer) TransferFileContext(ctx context.Context, src, dstPath string, port int) error {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
-	return nil
+	if r.sudo {
+		return r.transferWithSudo(ctx, src, dstPath, port)
+	}
+	return r.upload(ctx, src, dstPath, port)
 }
 
-// TransferFile uploads a file to the remote server.
-func (r *RemoteServer) TransferFile(src, dstPath string, port int) error {
-	auth, err := goph.Key(r.privateSSHKey, "")
-	if err != nil {
+// upload copies src to dstPath over SFTP. The connection is closed if ctx is
+// done, as SFTP transfers cannot be cancelled otherwise.
+func (r *RemoteServer) upload(ctx context.Context, src, dstPath string, port int) error {
+	return r.withClient(port, func(client *goph.Client) error {
+		stop := context.AfterFunc(ctx, func() { r.dropClient(client) })
+		err := client.Upload(src, dstPath)
+		if !stop() {
+			return ctx.Err()
+		}
 		return err
+	})
+}
+
+// ExecuteCommand runs a command on the remote server.
+func (r *RemoteServer) ExecuteCommand(cmd string, port int) (string, error) {
+	session, err := r.newSession(port)
+	if err != nil {
+		return "", err
 	}
+	defer func() { _ = session.Close() }()
+	out, err := session.CombinedOutput(cmd)
+	return string(out), err
+}
 
-	client, err := goph.NewConn(&goph.Config{
-		User:     r.user,
-		Addr:     r.ip,
-		Port:     uint(port), //nolint:gosec // port is validated
-		Auth:     auth,
-		Callback: ssh.InsecureIgnoreHostKey(), //nolint:gosec
+// Close closes the SSH connection and the connection to the ssh-agent. The
+// RemoteServer can still be used afterwards, it dials a new connection when
+// needed.
+func (r *RemoteServer) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return errors.Join(r.closeClient(), r.closeAgent())
+}
+
+// newSession opens a session on the shared connection. A started command is
+// never retried, as it may have already changed the server.
+func (r *RemoteServer) newSession(port int) (*ssh.Session, error) {
+	var session *ssh.Session
+	err := r.withClient(port, func(client *goph.Client) error {
+		var err error
+		session, err = client.NewSession()
+		if err != nil || !r.forwardAgent {
+			return err
+		}
+		if err = agent.RequestAgentForwarding(session); err != nil {
+			_ = session.Close()
+		}
+		return err
 	})
+	return session, err
+}
+
+// withClient runs fn with the shared connection, dialing it if needed. If fn
+// fails because the connection was lost, it is dialed again and fn retried
+// once.
+func (r *RemoteServer) withClient(port int, fn func(client *goph.Client) error) error {
+	client, fresh, err := r.getClient(port)
 	if err != nil {
 		return err
 	}
-
-	defer func() { _ = client.Close() }()
-	err = client.Upload(src, dstPath)
+	err = fn(client)
+	if err == nil || fresh || isContextError(err) || (!isConnectionError(err) && isAlive(client)) {
+		return err
+	}
+	zap.S().Infow("SSH connection lost, reconnecting", "ip", r.ip, "error", err)
+	r.dropClient(client)
+	client, _, err = r.getClient(port)
 	if err != nil {
 		return err
 	}
-	return nil
+	return fn(client)
 }
 
-// ExecuteCommand runs a command on the remote server.
-func (r *RemoteServer) ExecuteCommand(cmd string, port int) (string, error) {
-	auth, err := goph.Key(r.privateSSHKey, "")
+// getClient returns the shared connection for port and whether it was just
+// dialed.
+func (r *RemoteServer) getClient(port int) (*goph.Client, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.client != nil && r.port == port {
+		return r.client, false, nil
+	}
+	if err := r.closeClient(); err != nil {
+		zap.S().Debugw("Closing SSH connection failed", "ip", r.ip, "error", err)
+	}
+	auth, err := r.authMethods()
 	if err != nil {
-		return "", err
+		return nil, false, err
+	}
+	callback, err := r.getHostKeyCallback()
+	if err != nil {
+		return nil, false, err
 	}
 	client, err := goph.NewConn(&goph.Config{
 		User:     r.user,
 		Addr:     r.ip,
 		Port:     uint(port), //nolint:gosec // port is validated
 		Auth:     auth,
-		Callback: ssh.InsecureIgnoreHostKey(), //nolint:gosec
+		Timeout:  goph.DefaultTimeout,
+		Callback: callback,
 	})
 	if err != nil {
-		return "", err
+		return nil, false, err
+	}
+	if r.forwardAgent {
+		if err := agent.ForwardToAgent(client.Client, r.agent); err != nil {
+			_ = client.Close()
+			return nil, false, err
+		}
 	}
+	r.client = client
+	r.port = port
+	r.stopKeepAlive = make(chan struct{})
+	go keepAlive(client, r.stopKeepAlive)
+	return client, true, nil
+}
 
-	defer func() { _ = client.Close() }()
-	out, err := client.Run(cmd)
-	return string(out), err
+// dropClient closes client if it is still the shared connection.
+func (r *RemoteServer) dropClient(client *goph.Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.client == client {
+		_ = r.closeClient()
+	}
+}
+
+func (r *RemoteServer) closeClient() error {
+	if r.client == nil {
+		return nil
+	}
+	close(r.stopKeepAlive)
+	err := r.client.Close()
+	r.client = nil
+	return err
+}
+
+// isContextError reports whether err comes from a done context, which must
+// not be retried.
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// isAlive reports whether the server still answers on client.
+func isAlive(client *goph.Client) bool {
+	_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+	return err == nil
+}
+
+// keepAlive sends keepalive requests on client until stop is closed or the
+// connection is gone, so idle connections are not dropped by NAT gateways and
+// dead ones are noticed.
+func keepAlive(client *goph.Client, stop <-chan struct{}) {
+	ticker := time.NewTicker(KeepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if !isAlive(client) {
+				_ = client.Close()
+				return
+			}
+		}
+	}
 }
@@ -0,0 +1,185 @@
+package update
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/dirien/minectl-sdk/model"
+	minctlTemplate "github.com/dirien/minectl-sdk/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestRemoteServerReusesConnection(t *testing.T) {
+	server := newTestSSHServer(t, echoHandler)
+	remote := server.remote()
+	defer func() { _ = remote.Close() }()
+
+	out, err := remote.ExecuteCommand("uptime", server.port())
+	require.NoError(t, err)
+	assert.Equal(t, `ran "uptime"`, out)
+
+	src := filepath.Join(t.TempDir(), "plugin.jar")
+	require.NoError(t, os.WriteFile(src, []byte("plugin"), 0o600))
+	dst := filepath.Join(t.TempDir(), "plugin.jar")
+	require.NoError(t, remote.TransferFile(src, dst, server.port()))
+	data, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, "plugin", string(data))
+
+	_, err = remote.ExecuteCommand("false", server.port())
+	var exitErr *ssh.ExitError
+	require.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, 1, exitErr.ExitStatus())
+
+	assert.Equal(t, 1, server.dialCount())
+	assert.Equal(t, []string{"uptime", "false"}, server.commands())
+}
+
+func TestRemoteServerReconnects(t *testing.T) {
+	server := newTestSSHServer(t, echoHandler)
+	remote := server.remote()
+	defer func() { _ = remote.Close() }()
+
+	_, err := remote.ExecuteCommand("uptime", server.port())
+	require.NoError(t, err)
+
+	server.dropConnections()
+	out, err := remote.ExecuteCommand("uptime", server.port())
+	require.NoError(t, err)
+	assert.Equal(t, `ran "uptime"`, out)
+	assert.Equal(t, 2, server.dialCount())
+
+	require.NoError(t, remote.Close())
+	_, err = remote.ExecuteCommand("uptime", server.port())
+	require.NoError(t, err)
+	assert.Equal(t, 3, server.dialCount())
+}
+
+func TestRemoteServerVerifiesHostKeyByDefault(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	server := newTestSSHServer(t, echoHandler)
+	host := fmt.Sprintf("[127.0.0.1]:%d", server.port())
+
+	remote := NewRemoteServer(server.keyPath, "127.0.0.1", "root")
+	defer func() { _ = remote.Close() }()
+	_, err := remote.ExecuteCommand("uptime", server.port())
+	require.NoError(t, err)
+	store, err := DefaultKnownHostsFile()
+	require.NoError(t, err)
+	keys, err := store.Lookup(host)
+	require.NoError(t, err)
+	assert.Len(t, keys, 1, "the key is trusted on first use")
+
+	// the server at the address is replaced by one with another key
+	require.NoError(t, store.Add(host, generatePublicKey(t)))
+	require.NoError(t, remote.Close())
+	_, err = remote.ExecuteCommand("uptime", server.port())
+	assert.ErrorContains(t, err, ErrHostKeyMismatch.Error())
+
+	insecure := NewRemoteServer(server.keyPath, "127.0.0.1", "root").WithInsecureIgnoreHostKey()
+	defer func() { _ = insecure.Close() }()
+	_, err = insecure.ExecuteCommand("uptime", server.port())
+	require.NoError(t, err)
+}
+
+func TestRemoteServerExecute(t *testing.T) {
+	server := newTestSSHServer(t, func(command string, stdout, stderr io.Writer) uint32 {
+		switch command {
+		case "build":
+			_, _ = io.WriteString(stdout, "Downloading BuildTools\r\nCompiling")
+			_, _ = io.WriteString(stdout, " Spigot\n")
+			_, _ = io.WriteString(stderr, "warning: deprecated API")
+			return 0
+		case "fail":
+			_, _ = io.WriteString(stderr, "error\n")
+			return 3
+		default:
+			// run until the client goes away
+			for {
+				if _, err := io.WriteString(stdout, "tick\n"); err != nil {
+					return 0
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+	})
+	remote := server.remote()
+	defer func() { _ = remote.Close() }()
+
+	var stdout bytes.Buffer
+	lines := make(chan OutputLine, 10)
+	result, err := remote.Execute(context.Background(), "build", server.port(), ExecOptions{Stdout: &stdout, Lines: lines})
+	require.NoError(t, err)
+	assert.True(t, result.Success())
+	assert.Equal(t, "Downloading BuildTools\r\nCompiling Spigot\n", stdout.String())
+	close(lines)
+	var got []OutputLine
+	for line := range lines {
+		got = append(got, line)
+	}
+	assert.ElementsMatch(t, []OutputLine{
+		{Stream: Stdout, Text: "Downloading BuildTools"},
+		{Stream: Stdout, Text: "Compiling Spigot"},
+		{Stream: Stderr, Text: "warning: deprecated API"},
+	}, got)
+
+	var stderr bytes.Buffer
+	result, err = remote.Execute(context.Background(), "fail", server.port(), ExecOptions{Stderr: &stderr})
+	var exitErr *ssh.ExitError
+	require.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, 3, result.ExitStatus)
+	assert.Equal(t, "error\n", stderr.String())
+
+	result, err = remote.Execute(context.Background(), "run", server.port(), ExecOptions{Timeout: 100 * time.Millisecond})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, -1, result.ExitStatus)
+
+	// the connection is still usable after a timeout
+	out, err := remote.ExecuteCommand("build", server.port())
+	require.NoError(t, err)
+	assert.Contains(t, out, "Compiling Spigot")
+	assert.Equal(t, 1, server.dialCount())
+}
+
+func TestUpdateCommand(t *testing.T) {
+	fabric := &model.MinecraftResource{Spec: model.Spec{Minecraft: model.Minecraft{
+		Edition: "fabric",
+		Version: "1.20.4",
+		Java:    model.Java{OpenJDK: 17},
+	}}}
+	cmd, err := updateCommand(context.Background(), fabric, nil)
+	require.NoError(t, err)
+	assert.Contains(t, cmd, "sudo bash -c '\nrm -rf /minecraft/minecraft-server.jar\nURL=\"https://maven.fabricmc.net/")
+	assert.Contains(t, cmd, "apt-get install -y openjdk-17-jre-headless")
+
+	bedrock := &model.MinecraftResource{Spec: model.Spec{Minecraft: model.Minecraft{Edition: "bedrock", Version: "1.20.51.01"}}}
+	cmd, err = updateCommand(context.Background(), bedrock, nil)
+	require.NoError(t, err)
+	assert.Contains(t, cmd, "unzip -o /tmp/bedrock-server.zip -d /minecraft")
+	assert.NotContains(t, cmd, "openjdk")
+
+	overrides := fstest.MapFS{"bedrock.tmpl": {Data: []byte(`{{ define "bedrock-binary" }}echo custom bedrock{{ end }}`)}}
+	cmd, err = updateCommand(context.Background(), bedrock, []minctlTemplate.Option{minctlTemplate.WithOverrides(overrides)})
+	require.NoError(t, err)
+	assert.Contains(t, cmd, "echo custom bedrock")
+
+	// single quotes in the script do not end the argument of bash -c
+	overrides = fstest.MapFS{"bedrock.tmpl": {Data: []byte(`{{ define "bedrock-binary" }}echo 'it'"'"'s'; touch /tmp/quoted{{ end }}`)}}
+	cmd, err = updateCommand(context.Background(), bedrock, []minctlTemplate.Option{minctlTemplate.WithOverrides(overrides)})
+	require.NoError(t, err)
+	assert.Contains(t, cmd, `sudo bash -c 'echo '\''it'\''"'\''"'\''s'\''; touch /tmp/quoted'`)
+
+	vanilla := &model.MinecraftResource{Spec: model.Spec{Minecraft: model.Minecraft{Edition: "vanilla", Version: "1.20.4"}}}
+	_, err = updateCommand(context.Background(), vanilla, nil)
+	require.ErrorContains(t, err, `unknown edition "vanilla"`)
+}
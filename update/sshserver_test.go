@@ -0,0 +1,193 @@
+package update
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// testSSHServer is an in-process SSH server that runs commands with handler
+// and serves sftp from the local filesystem.
+type testSSHServer struct {
+	listener net.Listener
+	keyPath  string
+	// privateKey is the PEM encoded client key the server accepts.
+	privateKey []byte
+	handler    func(command string, stdout, stderr io.Writer) uint32
+
+	mu       sync.Mutex
+	dials    int
+	conns    []net.Conn
+	command  []string
+	requests []string
+}
+
+func newTestSSHServer(t *testing.T, handler func(command string, stdout, stderr io.Writer) uint32) *testSSHServer {
+	t.Helper()
+	hostKey, err := GenerateHostKey()
+	require.NoError(t, err)
+	hostSigner, err := ssh.ParsePrivateKey([]byte(hostKey.PrivateKey))
+	require.NoError(t, err)
+	clientKey, err := GenerateHostKey()
+	require.NoError(t, err)
+	clientPublic, _, _, _, err := ssh.ParseAuthorizedKey([]byte(clientKey.PublicKey))
+	require.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	require.NoError(t, os.WriteFile(keyPath, []byte(clientKey.PrivateKey), 0o600))
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(clientPublic.Marshal()) {
+				return nil, fmt.Errorf("unknown key")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &testSSHServer{
+		listener:   listener,
+		keyPath:    keyPath,
+		privateKey: []byte(clientKey.PrivateKey),
+		handler:    handler,
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+		s.dropConnections()
+	})
+	go s.serve(config)
+	return s
+}
+
+func (s *testSSHServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *testSSHServer) remote() *RemoteServer {
+	return NewRemoteServer(s.keyPath, "127.0.0.1", "root").WithInsecureIgnoreHostKey()
+}
+
+func (s *testSSHServer) dialCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dials
+}
+
+func (s *testSSHServer) sessionRequests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func (s *testSSHServer) commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.command...)
+}
+
+// dropConnections closes all open connections, like a rebooted server.
+func (s *testSSHServer) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *testSSHServer) serve(config *ssh.ServerConfig) {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.dials++
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+		go s.handleConn(conn, config)
+	}
+}
+
+func (s *testSSHServer) handleConn(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go func() {
+		for request := range requests {
+			if request.WantReply {
+				_ = request.Reply(request.Type == "keepalive@openssh.com", nil)
+			}
+		}
+	}()
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go s.handleSession(channel, requests)
+	}
+}
+
+func (s *testSSHServer) handleSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer func() { _ = channel.Close() }()
+	for request := range requests {
+		var payload struct{ Value string }
+		_ = ssh.Unmarshal(request.Payload, &payload)
+		s.mu.Lock()
+		s.requests = append(s.requests, request.Type)
+		s.mu.Unlock()
+		switch request.Type {
+		case "auth-agent-req@openssh.com":
+			_ = request.Reply(true, nil)
+		case "exec":
+			_ = request.Reply(true, nil)
+			s.mu.Lock()
+			s.command = append(s.command, payload.Value)
+			s.mu.Unlock()
+			status := s.handler(payload.Value, channel, channel.Stderr())
+			_, _ = channel.SendRequest("exit-status", false, binary.BigEndian.AppendUint32(nil, status))
+			return
+		case "subsystem":
+			if payload.Value != "sftp" {
+				_ = request.Reply(false, nil)
+				continue
+			}
+			_ = request.Reply(true, nil)
+			server, err := sftp.NewServer(channel)
+			if err != nil {
+				return
+			}
+			_ = server.Serve()
+			return
+		default:
+			if request.WantReply {
+				_ = request.Reply(false, nil)
+			}
+		}
+	}
+}
+
+func echoHandler(command string, stdout, _ io.Writer) uint32 {
+	if command == "false" {
+		return 1
+	}
+	_, _ = fmt.Fprintf(stdout, "ran %q", command)
+	return 0
+}